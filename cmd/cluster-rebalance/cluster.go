@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+const clusterSlots = 16384
+
+// node is a node of the cluster, with a connection to it.
+type node struct {
+	id     string
+	addr   string
+	slots  []int
+	conn   net.Conn
+	reader *resp.Reader
+}
+
+// call runs a command on the node, returning the errors it replies as errors.
+func (n *node) call(args ...string) (interface{}, error) {
+	n.conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := n.conn.Write(resp.AppendCommand(nil, args...)); err != nil {
+		return nil, err
+	}
+	reply, err := n.reader.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := reply.(resp.Error); ok {
+		return nil, fmt.Errorf("%s: %s: %s", n.addr, strings.Join(args[:2], " "), string(e))
+	}
+	return reply, nil
+}
+
+func dial(addr string) (*node, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &node{addr: addr, conn: conn, reader: resp.NewReader(bufio.NewReader(conn))}, nil
+}
+
+// cluster is the nodes of a cluster, sorted by ID.
+type cluster struct {
+	nodes []*node
+}
+
+// loadCluster connects to every node of the cluster of the node at addr, as listed by its CLUSTER
+// NODES. It fails when a node is failing or a slot is being moved.
+func loadCluster(addr string) (*cluster, error) {
+	first, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer first.conn.Close()
+	reply, err := first.call("CLUSTER", "NODES")
+	if err != nil {
+		return nil, err
+	}
+	lines, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply to CLUSTER NODES: %v", reply)
+	}
+	c := &cluster{}
+	for _, line := range strings.Split(strings.TrimSpace(lines), "\n") {
+		n, err := parseNodeLine(line)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		if n == nil {
+			continue
+		}
+		conn, err := dial(n.addr)
+		if err != nil {
+			c.close()
+			return nil, err
+		}
+		n.conn, n.reader = conn.conn, conn.reader
+		c.nodes = append(c.nodes, n)
+	}
+	sort.Slice(c.nodes, func(i, j int) bool { return c.nodes[i].id < c.nodes[j].id })
+	return c, nil
+}
+
+// parseNodeLine parses a line of CLUSTER NODES, returning nil for the nodes still in a handshake and
+// for the replicas, which serve no slots.
+func parseNodeLine(line string) (*node, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("invalid line of CLUSTER NODES: %q", line)
+	}
+	flags := "," + fields[2] + ","
+	switch {
+	case strings.Contains(flags, ",handshake,") || strings.Contains(flags, ",slave,"):
+		return nil, nil
+	case strings.Contains(flags, ",fail,") || strings.Contains(flags, ",fail?,"):
+		return nil, fmt.Errorf("node %s is failing", fields[0])
+	}
+	n := &node{id: fields[0], addr: fields[1]}
+	if i := strings.IndexByte(n.addr, '@'); i >= 0 {
+		n.addr = n.addr[:i]
+	}
+	for _, s := range fields[8:] {
+		if strings.HasPrefix(s, "[") {
+			return nil, fmt.Errorf("a slot is being moved by node %s (%s), fix it with CLUSTER SETSLOT <slot> STABLE", n.id, s)
+		}
+		slots, err := parseSlots(s)
+		if err != nil {
+			return nil, err
+		}
+		n.slots = append(n.slots, slots...)
+	}
+	return n, nil
+}
+
+// parseSlots parses slots and ranges of slots separated by commas, such as 0-99,200.
+func parseSlots(s string) ([]int, error) {
+	slots := []int{}
+	for _, rg := range strings.Split(s, ",") {
+		bounds := strings.SplitN(rg, "-", 2)
+		start, err1 := strconv.Atoi(bounds[0])
+		end, err2 := start, error(nil)
+		if len(bounds) == 2 {
+			end, err2 = strconv.Atoi(bounds[1])
+		}
+		if err1 != nil || err2 != nil || start < 0 || end >= clusterSlots || start > end {
+			return nil, fmt.Errorf("invalid slots %q", rg)
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+func (c *cluster) close() {
+	for _, n := range c.nodes {
+		n.conn.Close()
+	}
+}
+
+// slotsByNode returns the slots of each node, by ID.
+func (c *cluster) slotsByNode() map[string][]int {
+	slots := map[string][]int{}
+	for _, n := range c.nodes {
+		slots[n.id] = n.slots
+	}
+	return slots
+}
+
+// find returns the node of the given ID or address.
+func (c *cluster) find(name string) (*node, error) {
+	for _, n := range c.nodes {
+		if n.id == name || n.addr == name {
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("no node %s in the cluster", name)
+}
+
+// movePlan returns the moves of the slots given by ranges, or of the last count slots of from.
+func (c *cluster) movePlan(fromName, toName, ranges string, count int) ([]move, error) {
+	from, err := c.find(fromName)
+	if err != nil {
+		return nil, err
+	}
+	to, err := c.find(toName)
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return nil, fmt.Errorf("the source and the target are the same node")
+	}
+	var slots []int
+	switch {
+	case ranges != "" && count == 0:
+		if slots, err = parseSlots(ranges); err != nil {
+			return nil, err
+		}
+		owned := map[int]bool{}
+		for _, slot := range from.slots {
+			owned[slot] = true
+		}
+		for _, slot := range slots {
+			if !owned[slot] {
+				return nil, fmt.Errorf("slot %d is not served by %s", slot, from.addr)
+			}
+		}
+	case ranges == "" && count > 0:
+		if count > len(from.slots) {
+			return nil, fmt.Errorf("%s only serves %d slots", from.addr, len(from.slots))
+		}
+		slots = from.slots[len(from.slots)-count:]
+	default:
+		return nil, fmt.Errorf("give either -slots or -count")
+	}
+	moves := make([]move, len(slots))
+	for i, slot := range slots {
+		moves[i] = move{slot: slot, from: from.id, to: to.id}
+	}
+	return moves, nil
+}
+
+// apply moves the slots, moving the keys of each slot in batches of batch keys.
+func (c *cluster) apply(moves []move, timeout time.Duration, batch int) error {
+	for _, m := range moves {
+		from, _ := c.find(m.from)
+		to, _ := c.find(m.to)
+		keys, err := c.moveSlot(m.slot, from, to, timeout, batch)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Moved slot %d from %s to %s (%d keys)\n", m.slot, from.addr, to.addr, keys)
+	}
+	return nil
+}
+
+// moveSlot moves slot from the node from to the node to while the cluster serves it, and returns the
+// number of keys moved.
+func (c *cluster) moveSlot(slot int, from, to *node, timeout time.Duration, batch int) (int, error) {
+	s := strconv.Itoa(slot)
+	if _, err := to.call("CLUSTER", "SETSLOT", s, "IMPORTING", from.id); err != nil {
+		return 0, err
+	}
+	if _, err := from.call("CLUSTER", "SETSLOT", s, "MIGRATING", to.id); err != nil {
+		return 0, err
+	}
+	host, port, err := net.SplitHostPort(to.addr)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for {
+		reply, err := from.call("CLUSTER", "GETKEYSINSLOT", s, strconv.Itoa(batch))
+		if err != nil {
+			return moved, err
+		}
+		keys := []string{}
+		for _, key := range reply.([]interface{}) {
+			keys = append(keys, key.(string))
+		}
+		if len(keys) == 0 {
+			break
+		}
+		command := append([]string{"MIGRATE", host, port, "", "0", strconv.FormatInt(timeout.Milliseconds(), 10), "KEYS"}, keys...)
+		if _, err := from.call(command...); err != nil {
+			return moved, err
+		}
+		moved += len(keys)
+	}
+	// The target claims the slot first, so that it keeps serving it whatever the other nodes hear
+	// first.
+	if _, err := to.call("CLUSTER", "SETSLOT", s, "NODE", to.id); err != nil {
+		return moved, err
+	}
+	if _, err := from.call("CLUSTER", "SETSLOT", s, "NODE", to.id); err != nil {
+		return moved, err
+	}
+	for _, n := range c.nodes {
+		if n != from && n != to {
+			// The other nodes learn about the new owner from the cluster bus anyway.
+			n.call("CLUSTER", "SETSLOT", s, "NODE", to.id)
+		}
+	}
+	from.slots = removeSlot(from.slots, slot)
+	to.slots = append(to.slots, slot)
+	return moved, nil
+}
+
+func removeSlot(slots []int, slot int) []int {
+	for i, s := range slots {
+		if s == slot {
+			return append(slots[:i:i], slots[i+1:]...)
+		}
+	}
+	return slots
+}
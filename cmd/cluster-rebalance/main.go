@@ -0,0 +1,84 @@
+// Command cluster-rebalance moves hash slots between the nodes of a cluster while it serves clients,
+// given the address of any of its nodes:
+//
+//	cluster-rebalance rebalance [-threshold 2] host:port                   gives each node an equal share of the slots
+//	cluster-rebalance move -from <node> -to <node> -slots 0-99 host:port   moves the given slots
+//	cluster-rebalance move -from <node> -to <node> -count 100 host:port    moves that many slots of the source
+//
+// Nodes are given by ID or by address. Each slot is moved the way redis-cli --cluster does: the target
+// is set IMPORTING the slot and the source MIGRATING it, the keys of the slot are sent to the target
+// with MIGRATE in batches, then every node is told the new owner of the slot with CLUSTER SETSLOT NODE.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	commandLine := flag.NewFlagSet(flag.Arg(0), flag.ExitOnError)
+	timeout := commandLine.Duration("timeout", 5*time.Second, "timeout of each MIGRATE command")
+	batch := commandLine.Int("pipeline", 10, "number of keys moved by each MIGRATE command")
+	var threshold *float64
+	var from, to, slots *string
+	var count *int
+	switch flag.Arg(0) {
+	case "rebalance":
+		threshold = commandLine.Float64("threshold", 2, "percentage of unbalance under which no slot is moved")
+	case "move":
+		from = commandLine.String("from", "", "ID or address of the node the slots are taken from")
+		to = commandLine.String("to", "", "ID or address of the node the slots are moved to")
+		slots = commandLine.String("slots", "", "slots to move, such as 0-99,200")
+		count = commandLine.Int("count", 0, "number of slots to move, taken from the end of the slots of the source")
+	default:
+		usage()
+		os.Exit(1)
+	}
+	commandLine.Parse(flag.Args()[1:])
+	if commandLine.NArg() != 1 || *batch < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	c, err := loadCluster(commandLine.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+	defer c.close()
+	var moves []move
+	if threshold != nil {
+		moves = balancePlan(c.slotsByNode(), *threshold)
+		if len(moves) == 0 {
+			fmt.Println("The cluster is balanced, no slot to move")
+		}
+	} else {
+		moves, err = c.movePlan(*from, *to, *slots, *count)
+	}
+	if err == nil {
+		err = c.apply(moves, *timeout, *batch)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(flag.CommandLine.Output(), `Usage:
+  cluster-rebalance rebalance [-threshold 2] <host:port>
+      give each node of the cluster, empty ones included, an equal share of the slots
+  cluster-rebalance move -from <node> -to <node> (-slots <ranges> | -count <n>) <host:port>
+      move slots between two nodes, given by ID or by address
+
+Both take -timeout (5s), the timeout of each MIGRATE, and -pipeline (10), the number of keys moved by each MIGRATE.`)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// move is the move of a slot between two nodes, given by ID.
+type move struct {
+	slot     int
+	from, to string
+}
+
+// balancePlan returns the moves giving each node an equal share of the slots, the nodes serving the
+// most slots keeping the remainder. No slot is moved when no node is more than threshold percent away
+// from its share.
+func balancePlan(slots map[string][]int, threshold float64) []move {
+	ids := make([]string, 0, len(slots))
+	total := 0
+	for id, s := range slots {
+		ids = append(ids, id)
+		total += len(s)
+	}
+	if len(ids) < 2 || total == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if len(slots[ids[i]]) != len(slots[ids[j]]) {
+			return len(slots[ids[i]]) > len(slots[ids[j]])
+		}
+		return ids[i] < ids[j]
+	})
+
+	want := map[string]int{}
+	unbalanced := false
+	for i, id := range ids {
+		want[id] = total / len(ids)
+		if i < total%len(ids) {
+			want[id]++
+		}
+		if want[id] == 0 {
+			continue
+		}
+		if math.Abs(float64(len(slots[id])-want[id]))*100/float64(want[id]) >= threshold {
+			unbalanced = true
+		}
+	}
+	if !unbalanced {
+		return nil
+	}
+
+	// The donors give their last slots, the nodes they serve the longest staying in place.
+	var moves []move
+	var donors []string
+	given := map[string]int{}
+	for _, id := range ids {
+		if len(slots[id]) > want[id] {
+			donors = append(donors, id)
+		}
+	}
+	for _, id := range ids {
+		for missing := want[id] - len(slots[id]); missing > 0; missing-- {
+			donor := donors[0]
+			s := slots[donor]
+			moves = append(moves, move{slot: s[len(s)-1-given[donor]], from: donor, to: id})
+			if given[donor]++; len(s)-given[donor] == want[donor] {
+				donors = donors[1:]
+			}
+		}
+	}
+	return moves
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func slotRange(start, end int) []int {
+	slots := []int{}
+	for slot := start; slot <= end; slot++ {
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+func TestBalancePlan(t *testing.T) {
+	// A new node gets a third of the slots, the last ones of each node.
+	slots := map[string][]int{"a": slotRange(0, 8191), "b": slotRange(8192, 16383), "c": nil}
+	moves := balancePlan(slots, 2)
+	got := map[string]int{"a": 8192, "b": 8192, "c": 0}
+	for _, m := range moves {
+		got[m.from]--
+		got[m.to]++
+	}
+	if !reflect.DeepEqual(got, map[string]int{"a": 5462, "b": 5461, "c": 5461}) {
+		t.Fatalf("Slots after the moves = %v", got)
+	}
+	if moves[0] != (move{slot: 8191, from: "a", to: "c"}) {
+		t.Fatalf("First move = %+v", moves[0])
+	}
+
+	slots = map[string][]int{"a": slotRange(0, 8200), "b": slotRange(8201, 16383)}
+	if moves := balancePlan(slots, 2); moves != nil {
+		t.Fatalf("Moves under the threshold = %v", moves)
+	}
+	if moves := balancePlan(slots, 0.1); len(moves) != 9 {
+		t.Fatalf("Moves over the threshold = %v", moves)
+	}
+}
+
+func TestParseNodeLine(t *testing.T) {
+	n, err := parseNodeLine("07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 myself,master - 0 0 1 connected 0-2 10")
+	if err != nil || n.addr != "127.0.0.1:30004" || !reflect.DeepEqual(n.slots, []int{0, 1, 2, 10}) {
+		t.Fatalf("parseNodeLine = %+v, %v", n, err)
+	}
+	if _, err := parseNodeLine("07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 myself,master - 0 0 1 connected 0-2 [3->-e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca]"); err == nil {
+		t.Fatal("A node moving a slot was accepted")
+	}
+	if n, err := parseNodeLine("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30005@31005 handshake - 0 0 0 connected"); n != nil || err != nil {
+		t.Fatalf("parseNodeLine of a node in a handshake = %+v, %v", n, err)
+	}
+	if n, err := parseNodeLine("e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30005@31005 slave 07c37dfeb235213a872192d90877d0cd55635b91 0 0 1 connected"); n != nil || err != nil {
+		t.Fatalf("parseNodeLine of a replica = %+v, %v", n, err)
+	}
+}
+
+func TestMovePlan(t *testing.T) {
+	c := &cluster{nodes: []*node{{id: "a", addr: "127.0.0.1:7001", slots: slotRange(0, 99)}, {id: "b", addr: "127.0.0.1:7002"}}}
+	moves, err := c.movePlan("127.0.0.1:7001", "b", "", 2)
+	if err != nil || !reflect.DeepEqual(moves, []move{{98, "a", "b"}, {99, "a", "b"}}) {
+		t.Fatalf("movePlan -count = %v, %v", moves, err)
+	}
+	if moves, err := c.movePlan("a", "b", "5,7-8", 0); err != nil || len(moves) != 3 || moves[2].slot != 8 {
+		t.Fatalf("movePlan -slots = %v, %v", moves, err)
+	}
+	for _, args := range [][]string{{"a", "b", "100", ""}, {"a", "a", "1", ""}, {"a", "c", "1", ""}, {"a", "b", "", ""}} {
+		if _, err := c.movePlan(args[0], args[1], args[2], 0); err == nil {
+			t.Errorf("movePlan%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+)
+
+// record is a key of a JSON line.
+type record struct {
+	DB       int             `json:"db"`
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	ExpireAt int64           `json:"expire_at,omitempty"`
+	Encoding string          `json:"encoding,omitempty"` // "base64" when the key and the strings of the value are base64
+	Value    json.RawMessage `json:"value"`
+}
+
+type zsetMember struct {
+	Member string `json:"member"`
+	Score  score  `json:"score"`
+}
+
+// score is the score of a member of a sorted set, which JSON numbers can't hold when it is infinite.
+type score float64
+
+func (s score) MarshalJSON() ([]byte, error) {
+	switch {
+	case math.IsInf(float64(s), 1):
+		return []byte(`"inf"`), nil
+	case math.IsInf(float64(s), -1):
+		return []byte(`"-inf"`), nil
+	}
+	return strconv.AppendFloat(nil, float64(s), 'g', -1, 64), nil
+}
+
+func (s *score) UnmarshalJSON(b []byte) error {
+	var name string
+	if json.Unmarshal(b, &name) == nil {
+		switch name {
+		case "inf", "+inf":
+			*s = score(math.Inf(1))
+		case "-inf":
+			*s = score(math.Inf(-1))
+		default:
+			return fmt.Errorf("invalid score %s", b)
+		}
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("invalid score %s", b)
+	}
+	*s = score(f)
+	return nil
+}
+
+// isBinary reports whether the key or a string of the value of entry is not valid UTF-8, such as
+// the value of a HyperLogLog or of a bitmap, which JSON strings can't hold.
+func isBinary(entry *rdb.Entry) bool {
+	if !utf8.Valid(entry.Key) {
+		return true
+	}
+	switch v := entry.Value.(type) {
+	case []byte:
+		return !utf8.Valid(v)
+	case [][]byte:
+		for _, element := range v {
+			if !utf8.Valid(element) {
+				return true
+			}
+		}
+	case []rdb.ZMember:
+		for _, m := range v {
+			if !utf8.Valid(m.Member) {
+				return true
+			}
+		}
+	case []rdb.HashField:
+		for _, f := range v {
+			if !utf8.Valid(f.Field) || !utf8.Valid(f.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toRecord converts a key of an RDB file to its JSON line. The key and the strings of the value are
+// written in base64 when one of them is not valid UTF-8.
+func toRecord(entry *rdb.Entry) (*record, error) {
+	rec := &record{DB: entry.DB, Type: entry.Type.String(), ExpireAt: entry.Expiry}
+	text := func(b []byte) string { return string(b) }
+	if isBinary(entry) {
+		rec.Encoding = "base64"
+		text = base64.StdEncoding.EncodeToString
+	}
+	rec.Key = text(entry.Key)
+
+	var value interface{}
+	switch v := entry.Value.(type) {
+	case []byte:
+		value = text(v)
+	case [][]byte:
+		elements := make([]string, len(v))
+		for i, element := range v {
+			elements[i] = text(element)
+		}
+		value = elements
+	case []rdb.ZMember:
+		members := make([]zsetMember, len(v))
+		for i, m := range v {
+			members[i] = zsetMember{Member: text(m.Member), Score: score(m.Score)}
+		}
+		value = members
+	case []rdb.HashField:
+		fields := make(map[string]string, len(v))
+		for _, f := range v {
+			fields[text(f.Field)] = text(f.Value)
+		}
+		value = fields
+	default:
+		return nil, fmt.Errorf("unsupported value of key '%s'", entry.Key)
+	}
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	rec.Value = bytes.TrimSuffix(b.Bytes(), []byte("\n"))
+	return rec, nil
+}
+
+// toEntry converts a JSON line to a key of an RDB file.
+func toEntry(rec *record) (*rdb.Entry, error) {
+	valueType, err := rdb.ParseValueType(rec.Type)
+	if err != nil {
+		return nil, err
+	}
+	if rec.DB < 0 {
+		return nil, fmt.Errorf("invalid db %d", rec.DB)
+	}
+	// The strings of a base64 record are decoded as they are read, the first error is reported.
+	var decodeErr error
+	data := func(s string) []byte { return []byte(s) }
+	switch rec.Encoding {
+	case "":
+	case "base64":
+		data = func(s string) []byte {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil && decodeErr == nil {
+				decodeErr = err
+			}
+			return b
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding '%s'", rec.Encoding)
+	}
+	entry := &rdb.Entry{DB: rec.DB, Key: data(rec.Key), Type: valueType, Expiry: rec.ExpireAt, Idle: -1, Freq: -1}
+	switch valueType {
+	case rdb.String:
+		var s string
+		err = json.Unmarshal(rec.Value, &s)
+		entry.Value = data(s)
+	case rdb.List, rdb.Set:
+		var elements []string
+		err = json.Unmarshal(rec.Value, &elements)
+		value := make([][]byte, len(elements))
+		for i, element := range elements {
+			value[i] = data(element)
+		}
+		entry.Value = value
+	case rdb.ZSet:
+		var members []zsetMember
+		err = json.Unmarshal(rec.Value, &members)
+		value := make([]rdb.ZMember, len(members))
+		for i, m := range members {
+			value[i] = rdb.ZMember{Member: data(m.Member), Score: float64(m.Score)}
+		}
+		entry.Value = value
+	case rdb.Hash:
+		var fields map[string]string
+		err = json.Unmarshal(rec.Value, &fields)
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		value := make([]rdb.HashField, len(names))
+		for i, name := range names {
+			value[i] = rdb.HashField{Field: data(name), Value: data(fields[name])}
+		}
+		entry.Value = value
+	}
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %v", rec.Type, err)
+	}
+	return entry, nil
+}
+
+// exportFile writes the keys of the RDB file at path to w, one JSON line per key.
+func exportFile(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return export(f, w)
+}
+
+func export(r io.Reader, w io.Writer) error {
+	d := rdb.NewDecoder(r)
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading the rdb file at offset %d: %v", d.Offset(), err)
+		}
+		rec, err := toRecord(entry)
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// importFile writes the keys of the JSON lines at path, or of the standard input for "-", to the
+// RDB file at output. The file is written next to output then renamed, so output is either replaced
+// as a whole or left untouched.
+func importFile(path, output string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(output), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if err := importJSON(r, temp); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), output)
+}
+
+func importJSON(r io.Reader, w io.Writer) error {
+	// The keys of a database are written together, after its size.
+	dbs := map[int][]*rdb.Entry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 512*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		entry, err := toEntry(&rec)
+		if err != nil {
+			return fmt.Errorf("line %d: key '%s': %v", line, rec.Key, err)
+		}
+		dbs[entry.DB] = append(dbs[entry.DB], entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	indexes := make([]int, 0, len(dbs))
+	for db := range dbs {
+		indexes = append(indexes, db)
+	}
+	sort.Ints(indexes)
+	e := rdb.NewEncoder(w)
+	e.WriteHeader()
+	e.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	for _, db := range indexes {
+		expires := 0
+		for _, entry := range dbs[db] {
+			if entry.Expiry != 0 {
+				expires++
+			}
+		}
+		e.SelectDB(db, len(dbs[db]), expires)
+		for _, entry := range dbs[db] {
+			if err := e.WriteEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return e.WriteFooter()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+)
+
+func testEntries() []*rdb.Entry {
+	return []*rdb.Entry{
+		{DB: 0, Key: []byte("string"), Type: rdb.String, Value: []byte(`a "quoted" <value>`), Expiry: 1700000000000},
+		{DB: 0, Key: []byte("list"), Type: rdb.List, Value: [][]byte{[]byte("head"), []byte("tail")}},
+		{DB: 0, Key: []byte("set"), Type: rdb.Set, Value: [][]byte{[]byte("member")}},
+		{DB: 2, Key: []byte("zset"), Type: rdb.ZSet, Value: []rdb.ZMember{
+			{Member: []byte("low"), Score: math.Inf(-1)},
+			{Member: []byte("mid"), Score: 1.5},
+			{Member: []byte("high"), Score: math.Inf(1)},
+		}},
+		{DB: 2, Key: []byte("hash"), Type: rdb.Hash, Value: []rdb.HashField{
+			{Field: []byte("a"), Value: []byte("1")},
+			{Field: []byte("b"), Value: []byte("2")},
+		}},
+	}
+}
+
+func writeTestRDB(t *testing.T, entries []*rdb.Entry) string {
+	var buf bytes.Buffer
+	e := rdb.NewEncoder(&buf)
+	e.WriteHeader()
+	db := -1
+	for _, entry := range entries {
+		if entry.DB != db {
+			db = entry.DB
+			e.SelectDB(db, 0, 0)
+		}
+		entry.Idle, entry.Freq = -1, -1
+		if err := e.WriteEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.WriteFooter(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExportImport(t *testing.T) {
+	entries := testEntries()
+	path := writeTestRDB(t, entries)
+
+	var lines bytes.Buffer
+	if err := exportFile(path, &lines); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"db":0,"key":"string","type":"string","expire_at":1700000000000,"value":"a \"quoted\" <value>"}` + "\n" +
+		`{"db":0,"key":"list","type":"list","value":["head","tail"]}` + "\n" +
+		`{"db":0,"key":"set","type":"set","value":["member"]}` + "\n" +
+		`{"db":2,"key":"zset","type":"zset","value":[{"member":"low","score":"-inf"},{"member":"mid","score":1.5},{"member":"high","score":"inf"}]}` + "\n" +
+		`{"db":2,"key":"hash","type":"hash","value":{"a":"1","b":"2"}}` + "\n"
+	if lines.String() != expected {
+		t.Errorf("Expected the JSON lines\n%s\ngot\n%s", expected, lines.String())
+	}
+
+	jsonPath := filepath.Join(t.TempDir(), "keys.jsonl")
+	os.WriteFile(jsonPath, lines.Bytes(), 0644)
+	output := filepath.Join(t.TempDir(), "imported.rdb")
+	if err := importFile(jsonPath, output); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := rdb.NewDecoder(f)
+	for _, want := range entries {
+		got, err := d.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("Expected the end of the file, got %v", err)
+	}
+}
+
+func TestExportImportBinary(t *testing.T) {
+	// The values written by PFADD h a b c and SETBIT bits 0 1, which are not valid UTF-8.
+	entries := []*rdb.Entry{
+		{DB: 0, Key: []byte("h"), Type: rdb.String, Value: []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80`\xf3\x80P\xb1\x84K\xfb\x80BZ")},
+		{DB: 0, Key: []byte("bits"), Type: rdb.String, Value: []byte{0x80}},
+		{DB: 0, Key: []byte("\xff"), Type: rdb.List, Value: [][]byte{[]byte("text"), {0xc3}}},
+	}
+	path := writeTestRDB(t, entries)
+
+	var lines bytes.Buffer
+	if err := exportFile(path, &lines); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(lines.String(), `{"db":0,"key":"Yml0cw==","type":"string","encoding":"base64","value":"gA=="}`) {
+		t.Errorf("Expected the bitmap in base64, got\n%s", lines.String())
+	}
+	var imported bytes.Buffer
+	if err := importJSON(&lines, &imported); err != nil {
+		t.Fatal(err)
+	}
+	d := rdb.NewDecoder(&imported)
+	for _, want := range entries {
+		got, err := d.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+	}
+
+	if err := importJSON(strings.NewReader(`{"db":0,"key":"k","type":"string","encoding":"base64","value":"not base64"}`), io.Discard); err == nil {
+		t.Error("Expected an error for a value that is not base64")
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for _, line := range []string{
+		`{"db":0,"key":"k","type":"stream","value":"v"}`,
+		`{"db":0,"key":"k","type":"list","value":"v"}`,
+		`{"db":-1,"key":"k","type":"string","value":"v"}`,
+		`{"db":0,"key":"k","type":"zset","value":[{"member":"m","score":"high"}]}`,
+		`{"db":0,"key":"k","type":"string","encoding":"hex","value":"v"}`,
+		`not json`,
+	} {
+		if err := importJSON(strings.NewReader(line), io.Discard); err == nil {
+			t.Errorf("Expected an error for %s", line)
+		}
+	}
+}
+
+func TestMemoryReport(t *testing.T) {
+	path := writeTestRDB(t, append(testEntries(),
+		&rdb.Entry{DB: 1, Key: []byte("big"), Type: rdb.String, Value: bytes.Repeat([]byte("x"), 100)},
+	))
+	var out bytes.Buffer
+	if err := memoryReport(path, 1, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# string: 2 keys, 127 bytes\n" +
+		"db=1 key=\"big\" bytes=103 elements=1\n" +
+		"# list: 1 keys, 12 bytes\n" +
+		"db=0 key=\"list\" bytes=12 elements=2\n" +
+		"# set: 1 keys, 9 bytes\n" +
+		"db=0 key=\"set\" bytes=9 elements=1\n" +
+		"# zset: 1 keys, 38 bytes\n" +
+		"db=2 key=\"zset\" bytes=38 elements=3\n" +
+		"# hash: 1 keys, 8 bytes\n" +
+		"db=2 key=\"hash\" bytes=8 elements=2\n"
+	if out.String() != expected {
+		t.Errorf("Expected the report\n%s\ngot\n%s", expected, out.String())
+	}
+}
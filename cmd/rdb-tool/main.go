@@ -0,0 +1,70 @@
+// Command rdb-tool reads and writes RDB files without running the server:
+//
+//	rdb-tool export dump.rdb > keys.jsonl     writes the keys as JSON lines
+//	rdb-tool import keys.jsonl dump.rdb       writes an RDB file from JSON lines
+//	rdb-tool memory [-top 10] dump.rdb        lists the biggest keys of each type
+//
+// Each JSON line holds a key:
+//
+//	{"db":0,"key":"k","type":"string","expire_at":1700000000000,"value":"v"}
+//
+// expire_at is the expiration as a unix time in milliseconds, omitted when the key never expires. The value
+// is a string, an array of strings for lists (from head to tail) and sets, an array of {"member","score"}
+// objects for sorted sets and an object for hashes. Scores that are infinite are written "inf" and "-inf".
+// When the key or a string of the value is not valid UTF-8, such as the value of a HyperLogLog or of a
+// bitmap, the record has "encoding":"base64" and the key and all the strings of the value are base64.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args := flag.Args()[1:]; flag.Arg(0) {
+	case "export":
+		if len(args) != 1 {
+			usage()
+			os.Exit(1)
+		}
+		err = exportFile(args[0], os.Stdout)
+	case "import":
+		if len(args) != 2 {
+			usage()
+			os.Exit(1)
+		}
+		err = importFile(args[0], args[1])
+	case "memory":
+		commandLine := flag.NewFlagSet("memory", flag.ExitOnError)
+		top := commandLine.Int("top", 10, "number of keys listed for each type")
+		commandLine.Parse(args)
+		if commandLine.NArg() != 1 {
+			usage()
+			os.Exit(1)
+		}
+		err = memoryReport(commandLine.Arg(0), *top, os.Stdout)
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(flag.CommandLine.Output(), `Usage:
+  rdb-tool export <rdb-file>               write the keys of the file as JSON lines to the standard output
+  rdb-tool import <json-file> <rdb-file>   write the keys of the JSON lines to an RDB file, "-" reads the standard input
+  rdb-tool memory [-top N] <rdb-file>      list the biggest keys of each type`)
+}
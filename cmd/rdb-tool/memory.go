@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+)
+
+// keySize is the size of a key of the memory report.
+type keySize struct {
+	db       int
+	key      string
+	bytes    int64 // bytes of the key and of its elements, without the overhead of their encoding
+	elements int
+}
+
+// typeReport sums the keys of a type and keeps the biggest ones.
+type typeReport struct {
+	keys    int
+	bytes   int64
+	biggest []keySize // by decreasing size
+}
+
+func (t *typeReport) add(size keySize, top int) {
+	t.keys++
+	t.bytes += size.bytes
+	i := sort.Search(len(t.biggest), func(i int) bool { return t.biggest[i].bytes < size.bytes })
+	if i >= top {
+		return
+	}
+	t.biggest = append(t.biggest, keySize{})
+	copy(t.biggest[i+1:], t.biggest[i:])
+	t.biggest[i] = size
+	if len(t.biggest) > top {
+		t.biggest = t.biggest[:top]
+	}
+}
+
+// entrySize returns the size of a key of an RDB file.
+func entrySize(entry *rdb.Entry) keySize {
+	size := keySize{db: entry.DB, key: string(entry.Key), bytes: int64(len(entry.Key))}
+	switch v := entry.Value.(type) {
+	case []byte:
+		size.bytes += int64(len(v))
+		size.elements = 1
+	case [][]byte:
+		for _, element := range v {
+			size.bytes += int64(len(element))
+		}
+		size.elements = len(v)
+	case []rdb.ZMember:
+		for _, m := range v {
+			size.bytes += int64(len(m.Member)) + 8
+		}
+		size.elements = len(v)
+	case []rdb.HashField:
+		for _, f := range v {
+			size.bytes += int64(len(f.Field) + len(f.Value))
+		}
+		size.elements = len(v)
+	}
+	return size
+}
+
+// memoryReport writes to w the number and size of the keys of each type of the RDB file at path,
+// with the top biggest keys of each type.
+func memoryReport(path string, top int, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reports := map[rdb.ValueType]*typeReport{}
+	d := rdb.NewDecoder(f)
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading the rdb file at offset %d: %v", d.Offset(), err)
+		}
+		report := reports[entry.Type]
+		if report == nil {
+			report = &typeReport{}
+			reports[entry.Type] = report
+		}
+		report.add(entrySize(entry), top)
+	}
+
+	for t := rdb.String; t <= rdb.Hash; t++ {
+		report := reports[t]
+		if report == nil {
+			continue
+		}
+		fmt.Fprintf(w, "# %s: %d keys, %d bytes\n", t, report.keys, report.bytes)
+		for _, size := range report.biggest {
+			fmt.Fprintf(w, "db=%d key=%q bytes=%d elements=%d\n", size.db, size.key, size.bytes, size.elements)
+		}
+	}
+	return nil
+}
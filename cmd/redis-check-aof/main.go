@@ -0,0 +1,124 @@
+// Command redis-check-aof checks an append only file with the readers of the server and reports the
+// offset of the first corruption. It takes the manifest of a multi part AOF, whose files are checked
+// in order, or a single file. With -fix, the last file is truncated to its last valid command.
+//
+//	redis-check-aof [-fix] appendonlydir/appendonly.aof.manifest
+//	redis-check-aof [-fix] appendonly.aof
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/MinhNHHH/redis/pkg/aof"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "truncate the last file to its last valid command")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: redis-check-aof [-fix] <file.manifest|file.aof>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	paths, err := files(flag.Arg(0))
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	for i, path := range paths {
+		if !check(path, i == len(paths)-1, *fix) {
+			os.Exit(1)
+		}
+	}
+}
+
+// files returns the paths of the files of the AOF at path, in the order they are loaded.
+func files(path string) ([]string, error) {
+	if !strings.HasSuffix(path, ".manifest") {
+		return []string{path}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m, err := aof.Read(f)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Start checking the multi part AOF of the manifest %s\n", path)
+	paths := []string{}
+	for _, file := range m.Files() {
+		paths = append(paths, filepath.Join(filepath.Dir(path), file.Name))
+	}
+	return paths, nil
+}
+
+// check checks a file of the AOF and reports whether it is valid, or was fixed. Only the last file
+// may be fixed, the files after a damaged one would be applied to missing commands.
+func check(path string, last, fix bool) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	result, err := aof.Check(f)
+	f.Close()
+	kind := "AOF"
+	switch {
+	case result.Preamble && strings.HasSuffix(path, ".rdb"):
+		kind = "RDB base file"
+	case result.Preamble:
+		kind = "AOF with an RDB preamble"
+	}
+	if err == nil {
+		content := fmt.Sprintf("%d commands", result.Commands)
+		if result.Preamble {
+			content = fmt.Sprintf("%d keys, %s", result.Keys, content)
+		}
+		fmt.Printf("%s %s is valid: %s, %d bytes\n", kind, path, content, result.Valid)
+		return true
+	}
+
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		fmt.Println("Error:", statErr)
+		return false
+	}
+	fmt.Printf("%s %s is not valid: %v\n", kind, path, err)
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, diff=%d\n", path, info.Size(), result.Valid, info.Size()-result.Valid)
+	switch {
+	case !fix:
+		fmt.Println("Use -fix to truncate the file to its last valid command")
+		return false
+	case !last:
+		fmt.Println("The file is not the last file of the AOF, it can't be fixed")
+		return false
+	case result.Preamble && result.Valid == 0:
+		fmt.Println("The RDB preamble is not valid, the file can't be fixed")
+		return false
+	}
+
+	fmt.Printf("This will shrink the AOF %s from %d bytes, with %d bytes, to %d bytes\n", path, info.Size(), info.Size()-result.Valid, result.Valid)
+	fmt.Print("Continue? [y/N]: ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+		fmt.Println("Aborting...")
+		return false
+	}
+	if err := os.Truncate(path, result.Valid); err != nil {
+		fmt.Println("Failed to truncate the AOF:", err)
+		return false
+	}
+	fmt.Println("Successfully truncated the AOF", path)
+	return true
+}
@@ -0,0 +1,83 @@
+// Command redis-check-rdb checks an RDB file with the decoder of the server and reports the offset
+// of the first corruption.
+//
+//	redis-check-rdb dump.rdb
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: redis-check-rdb <rdb-file>")
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !check(flag.Arg(0)) {
+		os.Exit(1)
+	}
+}
+
+// check reads every key of the file, printing what it finds, and reports whether the file is valid.
+func check(path string) bool {
+	fmt.Printf("[offset 0] Checking RDB file %s\n", path)
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+	defer f.Close()
+
+	d := rdb.NewDecoder(f)
+	types := map[rdb.ValueType]int{}
+	keys, expires, aux := 0, 0, 0
+	var last *rdb.Entry
+	for {
+		entry, err := d.Next()
+		for ; aux < len(d.Aux); aux++ {
+			fmt.Printf("[offset %d] AUX FIELD %s = '%s'\n", d.Offset(), d.Aux[aux].Key, d.Aux[aux].Value)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("--- RDB ERROR DETECTED ---")
+			fmt.Printf("[offset %d] %v\n", d.Offset(), err)
+			if last != nil {
+				fmt.Printf("[additional info] Last key read: '%s' in db %d\n", last.Key, last.DB)
+			}
+			fmt.Printf("[info] %d keys read\n", keys)
+			return false
+		}
+		keys++
+		types[entry.Type]++
+		if entry.Expiry != 0 {
+			expires++
+		}
+		last = entry
+	}
+	fmt.Printf("[offset %d] Checksum OK\n", d.Offset())
+	fmt.Printf("[offset %d] \\o/ RDB looks OK! \\o/\n", d.Offset())
+	fmt.Printf("[info] RDB version %d\n", d.Version)
+	fmt.Printf("[info] %d keys read\n", keys)
+	fmt.Printf("[info] %d expires\n", expires)
+	sorted := []rdb.ValueType{}
+	for t := range types {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	for _, t := range sorted {
+		fmt.Printf("[info] %d %s keys\n", types[t], t)
+	}
+	return true
+}
@@ -2,4 +2,4 @@ module github.com/MinhNHHH/redis
 
 go 1.17
 
-require github.com/google/uuid v1.5.0 // indirect
+require github.com/google/uuid v1.5.0
@@ -1,9 +1,98 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
 	"github.com/MinhNHHH/redis/pkg/redis"
+	"github.com/MinhNHHH/redis/pkg/sentinel"
 )
 
 func main() {
-	redis.Start()
+	config := redis.DefaultConfig()
+	flag.StringVar(&config.Addr, "addr", config.Addr, "address the server listens on")
+	flag.IntVar(&config.Databases, "databases", config.Databases, "number of logical databases")
+	flag.Func("maxmemory", "memory limit of the databases, such as 100mb (default no limit)", func(s string) error {
+		memory, err := redis.ParseMemory(s)
+		config.MaxMemory = memory
+		return err
+	})
+	maxMemoryPolicy := flag.String("maxmemory-policy", string(config.MaxMemoryPolicy), "how keys are evicted once maxmemory is reached")
+	flag.IntVar(&config.MaxMemorySamples, "maxmemory-samples", config.MaxMemorySamples, "number of keys sampled to find the key to evict")
+	flag.StringVar(&config.Dir, "dir", config.Dir, "directory of the rdb and append only files")
+	flag.StringVar(&config.DBFilename, "dbfilename", config.DBFilename, "name of the rdb file")
+	save := flag.String("save", redis.FormatSaveRules(config.Save), `background save rules as "<seconds> <changes>" pairs, "" to disable`)
+	flag.BoolVar(&config.AppendOnly, "appendonly", config.AppendOnly, "log the write commands to the append only file")
+	flag.StringVar(&config.AppendFilename, "appendfilename", config.AppendFilename, "base name of the append only files")
+	flag.StringVar(&config.AppendDirname, "appenddirname", config.AppendDirname, "directory of the append only files, in dir")
+	appendFsync := flag.String("appendfsync", string(config.AppendFsync), "when the append only file is flushed to disk: always, everysec or no")
+	flag.BoolVar(&config.AofLoadTruncated, "aof-load-truncated", config.AofLoadTruncated, "load an append only file whose last command is truncated")
+	flag.IntVar(&config.AutoAofRewritePercentage, "auto-aof-rewrite-percentage", config.AutoAofRewritePercentage, "growth of the append only file that starts a rewrite, 0 to disable")
+	flag.Func("auto-aof-rewrite-min-size", "size under which the append only file is not rewritten automatically (default 64mb)", func(s string) error {
+		size, err := redis.ParseMemory(s)
+		config.AutoAofRewriteMinSize = size
+		return err
+	})
+	flag.StringVar(&config.ReplicaOf, "replicaof", config.ReplicaOf, `replicate the primary at "<host> <port>"`)
+	flag.BoolVar(&config.ReplicaReadOnly, "replica-read-only", config.ReplicaReadOnly, "refuse the writes of the clients of a replica")
+	flag.IntVar(&config.ReplPingReplicaPeriod, "repl-ping-replica-period", config.ReplPingReplicaPeriod, "seconds between the pings sent to the replicas")
+	flag.IntVar(&config.ReplTimeout, "repl-timeout", config.ReplTimeout, "seconds without data after which a replication link is closed")
+	flag.Func("repl-backlog-size", "size of the end of the replication stream kept for partial resyncs (default 1mb)", func(s string) error {
+		size, err := redis.ParseMemory(s)
+		config.ReplBacklogSize = size
+		return err
+	})
+	flag.BoolVar(&config.ClusterEnabled, "cluster-enabled", config.ClusterEnabled, "run the server as a node of a cluster")
+	flag.StringVar(&config.ClusterConfigFile, "cluster-config-file", config.ClusterConfigFile, "name of the file in dir keeping the configuration of the cluster")
+	flag.IntVar(&config.ClusterNodeTimeout, "cluster-node-timeout", config.ClusterNodeTimeout, "milliseconds without a reply after which a node is possibly failing")
+	flag.IntVar(&config.ClusterPort, "cluster-port", config.ClusterPort, "port of the cluster bus (default the port plus 10000)")
+	flag.Func("client-output-buffer-limit", `limits of the output buffers, such as "pubsub 32mb 8mb 60"`, func(s string) error {
+		return redis.ParseClientOutputBufferLimit(s, &config.ClientOutputBufferLimitPubSub)
+	})
+	flag.StringVar(&config.NotifyKeyspaceEvents, "notify-keyspace-events", config.NotifyKeyspaceEvents, `classes of the keyspace events published, such as "KEA", none when empty`)
+	sentinelMode := flag.Bool("sentinel", false, "run as a sentinel monitoring the primaries of -sentinel-monitor")
+	sentinelConfig := sentinel.DefaultConfig()
+	flag.Func("sentinel-monitor", `monitor the primary "<name> <host> <port> <quorum>", repeatable`, func(s string) error {
+		monitor, err := sentinel.ParseMonitor(s)
+		sentinelConfig.Monitors = append(sentinelConfig.Monitors, monitor)
+		return err
+	})
+	flag.Func("sentinel-known-sentinel", "address host:port of another sentinel, repeatable", func(s string) error {
+		sentinelConfig.KnownSentinels = append(sentinelConfig.KnownSentinels, s)
+		return nil
+	})
+	downAfter := flag.Int("sentinel-down-after-milliseconds", int(sentinelConfig.DownAfter/time.Millisecond), "time without a valid reply after which an instance is down")
+	failoverTimeout := flag.Int("sentinel-failover-timeout", int(sentinelConfig.FailoverTimeout/time.Millisecond), "milliseconds a failover may take")
+	flag.Parse()
+	if *sentinelMode {
+		// A sentinel listens on 26379 unless -addr is given.
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "addr" {
+				sentinelConfig.Addr = config.Addr
+			}
+		})
+		sentinelConfig.DownAfter = time.Duration(*downAfter) * time.Millisecond
+		sentinelConfig.FailoverTimeout = time.Duration(*failoverTimeout) * time.Millisecond
+		if err := sentinelConfig.Validate(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		sentinel.Start(sentinelConfig)
+		return
+	}
+	config.AppendFsync = redis.AppendFsync(*appendFsync)
+	config.MaxMemoryPolicy = redis.MaxMemoryPolicy(*maxMemoryPolicy)
+	rules, err := redis.ParseSaveRules(*save)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	config.Save = rules
+	if err := config.Validate(); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	redis.Start(config)
 }
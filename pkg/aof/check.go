@@ -0,0 +1,91 @@
+package aof
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// CheckResult describes a file of an AOF read by Check.
+type CheckResult struct {
+	Preamble bool  // the file starts with an RDB file
+	Keys     int   // keys of the RDB preamble
+	Commands int   // commands read, up to the first problem
+	Valid    int64 // size of the file up to the last complete command outside of a transaction
+	Offset   int64 // offset of the problem, when there is one
+
+	// Truncated is set when the file ends in the middle of a command or of a transaction, which
+	// happens when the server stops while writing. Truncating the file to Valid fixes it.
+	Truncated bool
+}
+
+// Check reads a file of an AOF, the base file or an incremental file, and returns the first problem
+// found: an invalid RDB preamble, a command that cannot be parsed, a transaction that is not properly
+// nested, or the end of the file in the middle of a command or of a transaction.
+func Check(r io.Reader) (CheckResult, error) {
+	var result CheckResult
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		result.Preamble = true
+		d := rdb.NewDecoder(br)
+		for {
+			_, err := d.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				result.Offset = d.Offset()
+				return result, fmt.Errorf("invalid rdb preamble at offset %d: %v", d.Offset(), err)
+			}
+			result.Keys++
+		}
+		result.Valid = d.Offset()
+	}
+
+	preamble := result.Valid
+	reader := resp.NewReader(br)
+	multi := int64(-1)
+	for {
+		start := preamble + reader.Offset()
+		args, err := reader.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Offset = start
+			if err == io.ErrUnexpectedEOF {
+				result.Truncated = true
+				return result, fmt.Errorf("unexpected end of file at offset %d, in the middle of a command", result.Offset)
+			}
+			return result, fmt.Errorf("bad file format at offset %d: %v", result.Offset, err)
+		}
+		switch strings.ToLower(args[0]) {
+		case "multi":
+			if multi >= 0 {
+				result.Offset = start
+				return result, fmt.Errorf("unexpected MULTI at offset %d, inside a transaction", start)
+			}
+			multi = start
+		case "exec":
+			if multi < 0 {
+				result.Offset = start
+				return result, fmt.Errorf("unexpected EXEC at offset %d, outside of a transaction", start)
+			}
+			multi = -1
+		}
+		result.Commands++
+		if multi < 0 {
+			result.Valid = preamble + reader.Offset()
+		}
+	}
+	if multi >= 0 {
+		result.Offset = multi
+		result.Truncated = true
+		return result, fmt.Errorf("unexpected end of file, the transaction starting at offset %d has no EXEC", multi)
+	}
+	return result, nil
+}
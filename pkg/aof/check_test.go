@@ -0,0 +1,60 @@
+package aof
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+func TestCheck(t *testing.T) {
+	var preamble bytes.Buffer
+	e := rdb.NewEncoder(&preamble)
+	e.WriteHeader()
+	e.SelectDB(0, 1, 0)
+	e.WriteEntry(&rdb.Entry{Key: []byte("key"), Type: rdb.String, Value: []byte("value")})
+	e.WriteFooter()
+
+	commands := resp.AppendCommand(nil, "SELECT", "0")
+	commands = resp.AppendCommand(commands, "SET", "a", "1")
+	transaction := resp.AppendCommand(nil, "MULTI")
+	transaction = resp.AppendCommand(transaction, "INCR", "a")
+	exec := resp.AppendCommand(nil, "EXEC")
+	valid := string(commands) + string(transaction) + string(exec)
+
+	result, err := Check(strings.NewReader(preamble.String() + valid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CheckResult{Preamble: true, Keys: 1, Commands: 5, Valid: int64(preamble.Len() + len(valid))}
+	if result != expected {
+		t.Errorf("Expected %+v, got %+v", expected, result)
+	}
+
+	for _, test := range []struct {
+		name      string
+		file      string
+		valid     int
+		offset    int
+		truncated bool
+	}{
+		{"a truncated command", string(commands) + "*2\r\n$3\r\nGET", len(commands), len(commands), true},
+		{"a transaction without EXEC", string(commands) + string(transaction), len(commands), len(commands), true},
+		{"a bad command", string(commands) + "+OK\r\n" + string(commands), len(commands), len(commands), false},
+		{"a nested MULTI", string(transaction) + string(transaction), 0, len(transaction), false},
+		{"an EXEC without MULTI", string(commands) + string(exec), len(commands), len(commands), false},
+		// The checksum ending the preamble is cut.
+		{"a corrupted preamble", preamble.String()[:preamble.Len()-3], 0, preamble.Len() - 8, false},
+	} {
+		result, err := Check(strings.NewReader(test.file))
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if result.Valid != int64(test.valid) || result.Offset != int64(test.offset) || result.Truncated != test.truncated {
+			t.Errorf("%s: expected valid=%d offset=%d truncated=%v, got %+v (%v)", test.name, test.valid, test.offset, test.truncated, result, err)
+		}
+	}
+}
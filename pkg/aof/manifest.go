@@ -0,0 +1,192 @@
+// Package aof implements the manifest of the multi part append only files of Redis 7. The AOF is
+// made of a base file, an RDB file or commands, followed by incremental files of commands applied
+// in sequence order. The manifest lists them, one file per line:
+//
+//	file appendonly.aof.1.base.rdb seq 1 type b
+//	file appendonly.aof.1.incr.aof seq 1 type i
+//	file appendonly.aof.2.incr.aof seq 2 type i
+//
+// A rewrite produces a new base file from the content of the databases, the files it replaces are
+// marked as history until they are deleted. Check validates a file of the AOF without loading it.
+package aof
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FileType is the role of a file of the AOF.
+type FileType byte
+
+const (
+	Base    FileType = 'b' // the starting point, an RDB file or commands
+	History FileType = 'h' // a file replaced by a rewrite, to be deleted
+	Incr    FileType = 'i' // commands applied after the base
+)
+
+// File is an entry of the manifest.
+type File struct {
+	Name string
+	Seq  int64
+	Type FileType
+}
+
+// Manifest lists the files of an AOF.
+type Manifest struct {
+	Base    *File  // nil when the AOF has no base file yet
+	Incrs   []File // sorted by sequence
+	History []File
+
+	baseSeq int64 // highest sequence of a base file, including the history
+	incrSeq int64 // highest sequence of an incremental file, including the history
+}
+
+// ManifestName returns the name of the manifest of the AOF named filename.
+func ManifestName(filename string) string {
+	return filename + ".manifest"
+}
+
+// Files returns the files to load, in order: the base file then the incremental files.
+func (m *Manifest) Files() []File {
+	files := []File{}
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+	return append(files, m.Incrs...)
+}
+
+// NextBase returns the base file written by the next rewrite of the AOF named filename.
+// Its sequence follows the ones of the previous base files.
+func (m *Manifest) NextBase(filename string) File {
+	m.baseSeq++
+	return File{Name: fmt.Sprintf("%s.%d.base.rdb", filename, m.baseSeq), Seq: m.baseSeq, Type: Base}
+}
+
+// NextIncr adds a new incremental file to the AOF named filename and returns it.
+func (m *Manifest) NextIncr(filename string) File {
+	m.incrSeq++
+	file := File{Name: fmt.Sprintf("%s.%d.incr.aof", filename, m.incrSeq), Seq: m.incrSeq, Type: Incr}
+	m.Incrs = append(m.Incrs, file)
+	return file
+}
+
+// Rewritten replaces the base file with base, and the incremental files older than incrSeq,
+// which base includes, become history.
+func (m *Manifest) Rewritten(base File, incrSeq int64) {
+	if m.Base != nil {
+		m.History = append(m.History, File{Name: m.Base.Name, Seq: m.Base.Seq, Type: History})
+	}
+	m.Base = &base
+	incrs := []File{}
+	for _, file := range m.Incrs {
+		if file.Seq < incrSeq {
+			m.History = append(m.History, File{Name: file.Name, Seq: file.Seq, Type: History})
+		} else {
+			incrs = append(incrs, file)
+		}
+	}
+	m.Incrs = incrs
+}
+
+// Read parses a manifest. Lines starting with # are comments.
+func Read(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		file, err := parseLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid aof manifest file format at line %d: %v", line, err)
+		}
+		switch file.Type {
+		case Base:
+			if m.Base != nil {
+				return nil, fmt.Errorf("invalid aof manifest file format at line %d: duplicated base file", line)
+			}
+			m.Base = &file
+			m.baseSeq = max(m.baseSeq, file.Seq)
+		case Incr:
+			m.Incrs = append(m.Incrs, file)
+			m.incrSeq = max(m.incrSeq, file.Seq)
+		case History:
+			m.History = append(m.History, file)
+			if strings.Contains(file.Name, ".base.") {
+				m.baseSeq = max(m.baseSeq, file.Seq)
+			} else {
+				m.incrSeq = max(m.incrSeq, file.Seq)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(m.Incrs, func(i, j int) bool { return m.Incrs[i].Seq < m.Incrs[j].Seq })
+	return m, nil
+}
+
+// parseLine parses the "file <name> seq <seq> type <type>" pairs of a line, in any order.
+func parseLine(line string) (File, error) {
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return File{}, fmt.Errorf("odd number of fields")
+	}
+	var file File
+	seen := 0
+	for i := 0; i < len(fields); i += 2 {
+		switch value := fields[i+1]; fields[i] {
+		case "file":
+			if strings.ContainsAny(value, "/\\") {
+				return file, fmt.Errorf("file name %q is a path", value)
+			}
+			file.Name = value
+			seen |= 1
+		case "seq":
+			seq, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seq < 1 {
+				return file, fmt.Errorf("invalid sequence %q", value)
+			}
+			file.Seq = seq
+			seen |= 2
+		case "type":
+			if len(value) != 1 || !strings.Contains("bhi", value) {
+				return file, fmt.Errorf("unknown type %q", value)
+			}
+			file.Type = FileType(value[0])
+			seen |= 4
+		}
+		// Unknown keys are skipped, for the manifests of later versions.
+	}
+	if seen != 7 {
+		return file, fmt.Errorf("missing file, seq or type")
+	}
+	return file, nil
+}
+
+// Encode writes the manifest in the format Read parses: the base file, the history then the
+// incremental files.
+func (m *Manifest) Encode(w io.Writer) error {
+	files := []File{}
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+	files = append(append(files, m.History...), m.Incrs...)
+	bw := bufio.NewWriter(w)
+	for _, file := range files {
+		fmt.Fprintf(bw, "file %s seq %d type %c\n", file.Name, file.Seq, file.Type)
+	}
+	return bw.Flush()
+}
+
+func max(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,69 @@
+package aof
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	// A manifest written by Redis 7.
+	text := "file appendonly.aof.1.base.rdb seq 1 type b\n" +
+		"# a comment\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+		"file appendonly.aof.1.incr.aof seq 1 type i\n"
+	m, err := Read(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{
+		{"appendonly.aof.1.base.rdb", 1, Base},
+		{"appendonly.aof.1.incr.aof", 1, Incr},
+		{"appendonly.aof.2.incr.aof", 2, Incr},
+	}
+	if !reflect.DeepEqual(m.Files(), want) {
+		t.Errorf("Files = %v, want %v", m.Files(), want)
+	}
+
+	// A rewrite opens a new incremental file, then replaces the files before it.
+	incr := m.NextIncr("appendonly.aof")
+	if incr != (File{"appendonly.aof.3.incr.aof", 3, Incr}) {
+		t.Errorf("NextIncr = %v", incr)
+	}
+	m.Rewritten(m.NextBase("appendonly.aof"), incr.Seq)
+	var b bytes.Buffer
+	if err := m.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "file appendonly.aof.2.base.rdb seq 2 type b\n" +
+		"file appendonly.aof.1.base.rdb seq 1 type h\n" +
+		"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+		"file appendonly.aof.2.incr.aof seq 2 type h\n" +
+		"file appendonly.aof.3.incr.aof seq 3 type i\n"
+	if b.String() != expected {
+		t.Errorf("Encode =\n%s\nwant\n%s", b.String(), expected)
+	}
+
+	// The sequences keep increasing once the history is gone.
+	m, _ = Read(&b)
+	m.History = nil
+	if next := m.NextIncr("appendonly.aof"); next.Seq != 4 {
+		t.Errorf("expected the sequence 4, got %d", next.Seq)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	for _, text := range []string{
+		"file a seq 1\n",
+		"file a seq 1 type x\n",
+		"file a seq 0 type i\n",
+		"file ../a seq 1 type i\n",
+		"file a seq 1 type b\nfile b seq 2 type b\n",
+		"file a seq\n",
+	} {
+		if _, err := Read(strings.NewReader(text)); err == nil {
+			t.Errorf("expected an error for %q", text)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package rdb
+
+import "hash/crc64"
+
+// Redis checksums the files with the Jones CRC-64, whose reflected polynomial is below.
+// Unlike the variants of hash/crc64, it starts from 0 and does not invert the result.
+const jonesPolynomial = 0x95ac9329ac4bc9b5
+
+var jonesTable = crc64.MakeTable(jonesPolynomial)
+
+// crc64Update returns the checksum crc updated with p. hash/crc64 inverts the checksum before
+// and after the update, inverting it on both sides too cancels that out.
+func crc64Update(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, jonesTable, p)
+}
+
+// Checksum returns the CRC64 Redis uses for p, for example for the payload of DUMP.
+func Checksum(p []byte) uint64 {
+	return crc64Update(0, p)
+}
@@ -0,0 +1,468 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// ErrChecksum is returned when the CRC64 at the end of a file does not match its content.
+var ErrChecksum = errors.New("wrong rdb checksum")
+
+// Decoder reads the keys of an RDB file one at a time.
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	offset  int64
+	started bool
+	done    bool
+
+	Version int   // version of the file, known once the first entry is read
+	Aux     []Aux // metadata fields read so far
+	db      int
+}
+
+// NewDecoder returns a decoder reading the file from r. When r is a *bufio.Reader it is used as is,
+// so the data following the file, such as the commands after the RDB preamble of an AOF, can be read from it.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Offset returns the number of bytes read so far, to locate errors in the file.
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Next returns the next key of the file. At the end of the file it checks the checksum
+// and returns io.EOF, or ErrChecksum when the file is corrupted.
+func (d *Decoder) Next() (*Entry, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	if !d.started {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+		d.started = true
+	}
+
+	entry := &Entry{Idle: -1, Freq: -1}
+	for {
+		opcode, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case opcodeEOF:
+			d.done = true
+			return nil, d.readChecksum()
+		case opcodeSelectDB:
+			db, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			d.db = int(db)
+		case opcodeResizeDB:
+			// The sizes are only hints to preallocate the databases.
+			if _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+			if _, err := d.readLength(); err != nil {
+				return nil, err
+			}
+		case opcodeAux:
+			key, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			d.Aux = append(d.Aux, Aux{Key: string(key), Value: string(value)})
+		case opcodeExpireTimeMs:
+			b, err := d.read(8)
+			if err != nil {
+				return nil, err
+			}
+			entry.Expiry = int64(binary.LittleEndian.Uint64(b))
+		case opcodeExpireTime:
+			b, err := d.read(4)
+			if err != nil {
+				return nil, err
+			}
+			entry.Expiry = int64(binary.LittleEndian.Uint32(b)) * 1000
+		case opcodeIdle:
+			idle, err := d.readLength()
+			if err != nil {
+				return nil, err
+			}
+			entry.Idle = int64(idle)
+		case opcodeFreq:
+			freq, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			entry.Freq = int(freq)
+		case opcodeFunction2:
+			// Libraries of functions are skipped, they are not keys.
+			if _, err := d.readString(); err != nil {
+				return nil, err
+			}
+		case opcodeFunctionPre, opcodeModuleAux:
+			return nil, fmt.Errorf("unsupported opcode %d at offset %d", opcode, d.offset-1)
+		default:
+			entry.DB = d.db
+			if entry.Key, err = d.readString(); err != nil {
+				return nil, err
+			}
+			if err := d.readValue(opcode, entry); err != nil {
+				return nil, err
+			}
+			return entry, nil
+		}
+	}
+}
+
+func (d *Decoder) readHeader() error {
+	header, err := d.read(9)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(header, []byte("REDIS")) {
+		return fmt.Errorf("wrong signature, not an rdb file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > MaxVersion {
+		return fmt.Errorf("can't handle rdb format version %s", header[5:])
+	}
+	d.Version = version
+	return nil
+}
+
+// readChecksum checks the CRC64 ending the file. Files written before version 5, or with
+// checksums disabled (a checksum of 0), are not checked.
+func (d *Decoder) readChecksum() error {
+	if d.Version < 5 {
+		return io.EOF
+	}
+	expected := d.crc
+	b, err := d.read(8)
+	if err != nil {
+		return err
+	}
+	if checksum := binary.LittleEndian.Uint64(b); checksum != 0 && checksum != expected {
+		return ErrChecksum
+	}
+	return io.EOF
+}
+
+// read returns the next n bytes of the file, updating the checksum.
+func (d *Decoder) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d.crc = crc64Update(d.crc, b)
+	d.offset += int64(n)
+	return b, nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readLengthEncoding reads a length. Its two most significant bits tell how it is encoded:
+// 00 for 6 bits, 01 for 14 bits, 10 followed by 0 for 32 bits or by 1 for 64 bits, both big endian.
+// When they are 11, the 6 other bits are the special encoding of a string and encoded is true.
+func (d *Decoder) readLengthEncoding() (length uint64, encoded bool, err error) {
+	first, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case 0:
+		return uint64(first & 0x3f), false, nil
+	case 1:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case 3:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case 0x80:
+		b, err := d.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), false, nil
+	case 0x81:
+		b, err := d.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(b), false, nil
+	}
+	return 0, false, fmt.Errorf("unknown length encoding %d at offset %d", first, d.offset-1)
+}
+
+// maxLength bounds the lengths read, so that a corrupted file cannot make the decoder allocate without limit.
+const maxLength = 1 << 32
+
+func (d *Decoder) readLength() (uint64, error) {
+	length, encoded, err := d.readLengthEncoding()
+	if err == nil && (encoded || length > maxLength) {
+		err = fmt.Errorf("invalid length at offset %d", d.offset)
+	}
+	return length, err
+}
+
+// readString reads a string, which may be an integer or LZF compressed.
+func (d *Decoder) readString() ([]byte, error) {
+	length, encoded, err := d.readLengthEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if length > maxLength {
+			return nil, fmt.Errorf("invalid string length at offset %d", d.offset)
+		}
+		return d.read(int(length))
+	}
+	switch length {
+	case encInt8, encInt16, encInt32:
+		b, err := d.read(1 << length)
+		if err != nil {
+			return nil, err
+		}
+		return formatInt(littleEndianInt(b)), nil
+	case encLZF:
+		compressedLen, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		length, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := d.read(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(length))
+	}
+	return nil, fmt.Errorf("unknown string encoding %d at offset %d", length, d.offset)
+}
+
+// readStrings reads a length followed by as many strings.
+func (d *Decoder) readStrings(perElement int) ([][]byte, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	elements := [][]byte{}
+	for i := uint64(0); i < length*uint64(perElement); i++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, s)
+	}
+	return elements, nil
+}
+
+// readStringDouble reads a score saved as a string: a length byte followed by the decimal form,
+// the lengths 253, 254 and 255 standing for nan, +inf and -inf.
+func (d *Decoder) readStringDouble() (float64, error) {
+	length, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	b, err := d.read(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(b), 64)
+}
+
+// readValue reads the value of a key of the given type into entry.
+func (d *Decoder) readValue(valueType byte, entry *Entry) error {
+	var err error
+	switch valueType {
+	case typeString:
+		entry.Type = String
+		entry.Value, err = d.readString()
+	case typeList:
+		entry.Type = List
+		entry.Value, err = d.readStrings(1)
+	case typeSet:
+		entry.Type = Set
+		entry.Value, err = d.readStrings(1)
+	case typeHash:
+		entry.Type = Hash
+		var elements [][]byte
+		if elements, err = d.readStrings(2); err == nil {
+			entry.Value = hashFields(elements)
+		}
+	case typeZSet, typeZSet2:
+		entry.Type = ZSet
+		entry.Value, err = d.readZSet(valueType == typeZSet2)
+	case typeListQuicklist, typeListQuicklist2:
+		entry.Type = List
+		entry.Value, err = d.readQuicklist(valueType == typeListQuicklist2)
+	case typeHashZipmap, typeListZiplist, typeSetIntset, typeZSetZiplist, typeHashZiplist,
+		typeHashListpack, typeZSetListpack, typeSetListpack:
+		err = d.readCompact(valueType, entry)
+	case typeModulePreGA, typeModule2, typeStreamListpacks:
+		err = fmt.Errorf("unsupported value type %d for key '%s'", valueType, entry.Key)
+	default:
+		err = fmt.Errorf("unknown value type %d at offset %d", valueType, d.offset-1)
+	}
+	return err
+}
+
+func (d *Decoder) readZSet(binaryScores bool) ([]ZMember, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	members := []ZMember{}
+	for i := uint64(0); i < length; i++ {
+		member, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScores {
+			b, err := d.read(8)
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		} else if score, err = d.readStringDouble(); err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Member: member, Score: score})
+	}
+	return members, nil
+}
+
+// readQuicklist reads a list saved as a sequence of nodes. The nodes are ziplists before version 10,
+// then listpacks, or single elements when their container is plain.
+func (d *Decoder) readQuicklist(listpacks bool) ([][]byte, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	elements := [][]byte{}
+	for i := uint64(0); i < length; i++ {
+		container := uint64(quicklistNodePacked)
+		if listpacks {
+			if container, err = d.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		node, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case container == quicklistNodePlain:
+			elements = append(elements, node)
+			continue
+		case container != quicklistNodePacked:
+			return nil, fmt.Errorf("unknown quicklist container %d at offset %d", container, d.offset)
+		}
+		var nodeElements [][]byte
+		if listpacks {
+			nodeElements, err = parseListpack(node)
+		} else {
+			nodeElements, err = parseZiplist(node)
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, nodeElements...)
+	}
+	return elements, nil
+}
+
+// readCompact reads a value saved in one of the compact encodings, stored as a single string.
+func (d *Decoder) readCompact(valueType byte, entry *Entry) error {
+	blob, err := d.readString()
+	if err != nil {
+		return err
+	}
+	var elements [][]byte
+	switch valueType {
+	case typeHashZipmap:
+		elements, err = parseZipmap(blob)
+	case typeSetIntset:
+		elements, err = parseIntset(blob)
+	case typeListZiplist, typeZSetZiplist, typeHashZiplist:
+		elements, err = parseZiplist(blob)
+	default:
+		elements, err = parseListpack(blob)
+	}
+	if err != nil {
+		return fmt.Errorf("%v for key '%s'", err, entry.Key)
+	}
+
+	switch valueType {
+	case typeListZiplist:
+		entry.Type, entry.Value = List, elements
+	case typeSetIntset, typeSetListpack:
+		entry.Type, entry.Value = Set, elements
+	case typeZSetZiplist, typeZSetListpack:
+		if len(elements)%2 != 0 {
+			return fmt.Errorf("%v for key '%s'", errCorrupted, entry.Key)
+		}
+		members := make([]ZMember, 0, len(elements)/2)
+		for i := 0; i < len(elements); i += 2 {
+			score, err := strconv.ParseFloat(string(elements[i+1]), 64)
+			if err != nil {
+				return fmt.Errorf("%v for key '%s'", errCorrupted, entry.Key)
+			}
+			members = append(members, ZMember{Member: elements[i], Score: score})
+		}
+		entry.Type, entry.Value = ZSet, members
+	default:
+		if len(elements)%2 != 0 {
+			return fmt.Errorf("%v for key '%s'", errCorrupted, entry.Key)
+		}
+		entry.Type, entry.Value = Hash, hashFields(elements)
+	}
+	return nil
+}
+
+// hashFields pairs the fields and the values of a hash.
+func hashFields(elements [][]byte) []HashField {
+	fields := make([]HashField, 0, len(elements)/2)
+	for i := 0; i+1 < len(elements); i += 2 {
+		fields = append(fields, HashField{Field: elements[i], Value: elements[i+1]})
+	}
+	return fields
+}
@@ -0,0 +1,58 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// The payload of DUMP, which RESTORE reads and MIGRATE sends to another server, is a value in the
+// format of the RDB file: its type and the value, without the key, followed by the version of the
+// format on 2 bytes and the CRC64 of everything before on 8 bytes, both little endian.
+
+// Dump returns the DUMP payload of the value of entry.
+func Dump(entry *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.writeObject(entry, false)
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	payload := buf.Bytes()
+	var footer [10]byte
+	binary.LittleEndian.PutUint16(footer[:2], Version)
+	payload = append(payload, footer[:2]...)
+	binary.LittleEndian.PutUint64(footer[2:], Checksum(payload))
+	return append(payload, footer[2:]...), nil
+}
+
+// ParseDump returns the entry of the value of a DUMP payload, without its key. Payloads of a
+// version the Decoder cannot read, or whose checksum does not match, are refused.
+func ParseDump(payload []byte) (*Entry, error) {
+	if len(payload) < 10 {
+		return nil, fmt.Errorf("dump payload version or checksum are wrong")
+	}
+	body, footer := payload[:len(payload)-10], payload[len(payload)-10:]
+	version := int(binary.LittleEndian.Uint16(footer[:2]))
+	checksum := binary.LittleEndian.Uint64(footer[2:])
+	if version > MaxVersion || (checksum != 0 && checksum != Checksum(payload[:len(payload)-8])) {
+		return nil, fmt.Errorf("dump payload version or checksum are wrong")
+	}
+	d := NewDecoder(bytes.NewReader(body))
+	d.Version = version
+	entry := &Entry{Idle: -1, Freq: -1}
+	valueType, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.readValue(valueType, entry); err != nil {
+		return nil, err
+	}
+	if d.offset != int64(len(body)) {
+		return nil, fmt.Errorf("bad data format")
+	}
+	return entry, nil
+}
@@ -0,0 +1,207 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Encoder writes an RDB file: WriteHeader, then the metadata fields, databases and keys,
+// then WriteFooter.
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error // first write error, returned by every following call
+
+	// Compress enables the LZF compression of the strings longer than 20 bytes,
+	// like the rdbcompression setting of Redis.
+	Compress bool
+}
+
+// NewEncoder returns an encoder writing the file to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), Compress: true}
+}
+
+// WriteHeader writes the magic and the version of the file.
+func (e *Encoder) WriteHeader() error {
+	return e.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+}
+
+// WriteAux writes a metadata field.
+func (e *Encoder) WriteAux(key, value string) error {
+	e.writeByte(opcodeAux)
+	e.writeString([]byte(key))
+	return e.writeString([]byte(value))
+}
+
+// SelectDB starts the keys of the database db, which holds keys keys, expires of them with a time to live.
+func (e *Encoder) SelectDB(db, keys, expires int) error {
+	e.writeByte(opcodeSelectDB)
+	e.writeLength(uint64(db))
+	e.writeByte(opcodeResizeDB)
+	e.writeLength(uint64(keys))
+	return e.writeLength(uint64(expires))
+}
+
+// WriteEntry writes a key of the current database with its value, expiration and access data.
+func (e *Encoder) WriteEntry(entry *Entry) error {
+	if entry.Expiry != 0 {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], uint64(entry.Expiry))
+		e.writeByte(opcodeExpireTimeMs)
+		e.write(b[:])
+	}
+	if entry.Idle >= 0 {
+		e.writeByte(opcodeIdle)
+		e.writeLength(uint64(entry.Idle))
+	}
+	if entry.Freq >= 0 {
+		e.writeByte(opcodeFreq)
+		e.writeByte(byte(entry.Freq))
+	}
+
+	return e.writeObject(entry, true)
+}
+
+// writeObject writes the type of the value of entry, its key when withKey is set, and its value.
+func (e *Encoder) writeObject(entry *Entry, withKey bool) error {
+	switch entry.Type {
+	case String:
+		value, ok := entry.Value.([]byte)
+		if !ok {
+			return fmt.Errorf("invalid string value for key '%s'", entry.Key)
+		}
+		e.writeByte(typeString)
+		e.writeKey(entry.Key, withKey)
+		e.writeString(value)
+	case List, Set:
+		elements, ok := entry.Value.([][]byte)
+		if !ok {
+			return fmt.Errorf("invalid %s value for key '%s'", entry.Type, entry.Key)
+		}
+		if entry.Type == List {
+			e.writeByte(typeList)
+		} else {
+			e.writeByte(typeSet)
+		}
+		e.writeKey(entry.Key, withKey)
+		e.writeLength(uint64(len(elements)))
+		for _, element := range elements {
+			e.writeString(element)
+		}
+	case ZSet:
+		members, ok := entry.Value.([]ZMember)
+		if !ok {
+			return fmt.Errorf("invalid zset value for key '%s'", entry.Key)
+		}
+		e.writeByte(typeZSet2)
+		e.writeKey(entry.Key, withKey)
+		e.writeLength(uint64(len(members)))
+		for _, m := range members {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(m.Score))
+			e.writeString(m.Member)
+			e.write(b[:])
+		}
+	case Hash:
+		fields, ok := entry.Value.([]HashField)
+		if !ok {
+			return fmt.Errorf("invalid hash value for key '%s'", entry.Key)
+		}
+		e.writeByte(typeHash)
+		e.writeKey(entry.Key, withKey)
+		e.writeLength(uint64(len(fields)))
+		for _, f := range fields {
+			e.writeString(f.Field)
+			e.writeString(f.Value)
+		}
+	default:
+		return fmt.Errorf("unknown value type %d for key '%s'", entry.Type, entry.Key)
+	}
+	return e.err
+}
+
+func (e *Encoder) writeKey(key []byte, withKey bool) {
+	if withKey {
+		e.writeString(key)
+	}
+}
+
+// WriteFooter ends the file with its checksum and flushes it to the underlying writer.
+func (e *Encoder) WriteFooter() error {
+	e.writeByte(opcodeEOF)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], e.crc)
+	if err := e.write(b[:]); err != nil {
+		return err
+	}
+	e.err = e.w.Flush()
+	return e.err
+}
+
+// write writes b and updates the checksum. After an error nothing more is written and
+// every call returns the error, so the callers only check the last one.
+func (e *Encoder) write(b []byte) error {
+	if e.err != nil {
+		return e.err
+	}
+	e.crc = crc64Update(e.crc, b)
+	_, e.err = e.w.Write(b)
+	return e.err
+}
+
+func (e *Encoder) writeByte(b byte) error {
+	return e.write([]byte{b})
+}
+
+// writeLength writes a length with the encodings described by Decoder.readLengthEncoding.
+func (e *Encoder) writeLength(length uint64) error {
+	switch {
+	case length < 1<<6:
+		return e.writeByte(byte(length))
+	case length < 1<<14:
+		return e.write([]byte{byte(length>>8) | 0x40, byte(length)})
+	case length <= math.MaxUint32:
+		b := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(length))
+		return e.write(b)
+	}
+	b := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(b[1:], length)
+	return e.write(b)
+}
+
+// writeString writes s as an integer when it is the decimal form of a 32 bits integer, compressed
+// when it is long enough and compressing saves at least 4 bytes, as is otherwise.
+func (e *Encoder) writeString(s []byte) error {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(n, 10) == string(s) {
+			switch {
+			case n >= math.MinInt8 && n <= math.MaxInt8:
+				return e.write([]byte{0xC0 | encInt8, byte(n)})
+			case n >= math.MinInt16 && n <= math.MaxInt16:
+				b := []byte{0xC0 | encInt16, 0, 0}
+				binary.LittleEndian.PutUint16(b[1:], uint16(n))
+				return e.write(b)
+			default:
+				b := []byte{0xC0 | encInt32, 0, 0, 0, 0}
+				binary.LittleEndian.PutUint32(b[1:], uint32(n))
+				return e.write(b)
+			}
+		}
+	}
+	if e.Compress && len(s) > 20 {
+		if compressed := lzfCompress(s); len(compressed)+4 <= len(s) {
+			e.writeByte(0xC0 | encLZF)
+			e.writeLength(uint64(len(compressed)))
+			e.writeLength(uint64(len(s)))
+			return e.write(compressed)
+		}
+	}
+	e.writeLength(uint64(len(s)))
+	return e.write(s)
+}
@@ -0,0 +1,114 @@
+package rdb
+
+import "fmt"
+
+// Long strings are compressed with LZF. A compressed string is a sequence of chunks
+// whose first byte tells the kind:
+//
+//	000LLLLL                     - a literal run of LLLLL+1 bytes copied as is
+//	LLLooooo oooooooo            - a back reference of LLL+2 bytes, LLL < 7
+//	111ooooo LLLLLLLL oooooooo   - a back reference of LLLLLLLL+9 bytes
+//
+// A back reference copies bytes already decompressed, starting ooooooooooooo+1 bytes back.
+const (
+	lzfMaxLiteral = 1 << 5
+	lzfMaxOffset  = 1 << 13
+	lzfMaxRef     = 2 + 7 + 255
+	lzfHashLog    = 14
+)
+
+var errLZF = fmt.Errorf("invalid lzf compressed string")
+
+// lzfDecompress decompresses in, which must expand to exactly length bytes.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < lzfMaxLiteral {
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > length {
+				return nil, errLZF
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errLZF
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 || len(out)+n > length {
+			return nil, errLZF
+		}
+		// The reference may overlap the bytes being copied, they are copied one at a time.
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, errLZF
+	}
+	return out, nil
+}
+
+// lzfCompress compresses in, finding repeated sequences of 3 bytes with a hash table
+// like liblzf does. The output may be longer than the input when it does not compress.
+func lzfCompress(in []byte) []byte {
+	var table [1 << lzfHashLog]int // position + 1 of the last sequence with the hash
+	out := make([]byte, 0, len(in))
+	literal := 0
+	flushLiteral := func(end int) {
+		for literal < end {
+			n := end - literal
+			if n > lzfMaxLiteral {
+				n = lzfMaxLiteral
+			}
+			out = append(out, byte(n-1))
+			out = append(out, in[literal:literal+n]...)
+			literal += n
+		}
+	}
+
+	for i := 0; i+2 < len(in); {
+		h := (uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])) * 2654435761 >> (32 - lzfHashLog)
+		ref := table[h] - 1
+		table[h] = i + 1
+		if ref < 0 || i-ref > lzfMaxOffset || in[ref] != in[i] || in[ref+1] != in[i+1] || in[ref+2] != in[i+2] {
+			i++
+			continue
+		}
+
+		maxLen := len(in) - i
+		if maxLen > lzfMaxRef {
+			maxLen = lzfMaxRef
+		}
+		n := 3
+		for n < maxLen && in[ref+n] == in[i+n] {
+			n++
+		}
+		flushLiteral(i)
+		offset := i - ref - 1
+		if n-2 < 7 {
+			out = append(out, byte((n-2)<<5|offset>>8))
+		} else {
+			out = append(out, byte(7<<5|offset>>8), byte(n-2-7))
+		}
+		out = append(out, byte(offset))
+		i += n
+		literal = i
+	}
+	flushLiteral(len(in))
+	return out
+}
@@ -0,0 +1,161 @@
+// Package rdb reads and writes snapshots in the RDB format of Redis, so that the files are
+// interchangeable with redis-server and the existing RDB tooling.
+//
+// A file starts with the "REDIS" magic and a 4 digits version, followed by opcodes and key
+// value pairs:
+//
+//	AUX          0xFA  name and value of a metadata field
+//	SELECTDB     0xFE  index of the database the following keys belong to
+//	RESIZEDB     0xFB  number of keys and of keys with a time to live in the database
+//	EXPIRETIME   0xFD  expiration of the next key, in seconds
+//	EXPIRETIMEMS 0xFC  expiration of the next key, in milliseconds
+//	IDLE         0xF8  seconds since the last access of the next key
+//	FREQ         0xF9  access frequency of the next key
+//	EOF          0xFF  end of the file, followed by the CRC64 of the whole file
+//
+// Any other byte is the type of a key value pair, followed by the key and the value.
+// The Decoder reads every encoding of strings, lists, sets, sorted sets and hashes written
+// by Redis up to version 7.2 (ziplists, listpacks, intsets, quicklists...), streams and
+// module values are not supported. The Encoder writes the plain encodings of each type.
+package rdb
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Version is the version of the files written by the Encoder. The plain encodings it uses
+// appeared in version 9, so the files can be loaded by any Redis server from 5.0 on.
+const Version = 9
+
+// MaxVersion is the most recent version the Decoder can read, the one of Redis 7.2.
+const MaxVersion = 11
+
+const (
+	opcodeFunction2    = 0xF5
+	opcodeFunctionPre  = 0xF6
+	opcodeModuleAux    = 0xF7
+	opcodeIdle         = 0xF8
+	opcodeFreq         = 0xF9
+	opcodeAux          = 0xFA
+	opcodeResizeDB     = 0xFB
+	opcodeExpireTimeMs = 0xFC
+	opcodeExpireTime   = 0xFD
+	opcodeSelectDB     = 0xFE
+	opcodeEOF          = 0xFF
+)
+
+// Types of the key value pairs. The type gives both the kind of value and its encoding.
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZSet            = 3
+	typeHash            = 4
+	typeZSet2           = 5
+	typeModulePreGA     = 6
+	typeModule2         = 7
+	typeHashZipmap      = 9
+	typeListZiplist     = 10
+	typeSetIntset       = 11
+	typeZSetZiplist     = 12
+	typeHashZiplist     = 13
+	typeListQuicklist   = 14
+	typeStreamListpacks = 15
+	typeHashListpack    = 16
+	typeZSetListpack    = 17
+	typeListQuicklist2  = 18
+	typeSetListpack     = 20
+)
+
+// Special encodings of strings, given by the length when its two most significant bits are set.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Containers of the nodes of a quicklist.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// ValueType is the kind of value stored at a key.
+type ValueType int
+
+const (
+	String ValueType = iota
+	List
+	Set
+	ZSet
+	Hash
+)
+
+// String returns the name of the type, as reported by the TYPE command.
+func (t ValueType) String() string {
+	switch t {
+	case String:
+		return "string"
+	case List:
+		return "list"
+	case Set:
+		return "set"
+	case ZSet:
+		return "zset"
+	case Hash:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// ParseValueType returns the type of the given name, as reported by the TYPE command.
+func ParseValueType(name string) (ValueType, error) {
+	for t := String; t <= Hash; t++ {
+		if t.String() == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown value type '%s'", name)
+}
+
+// ZMember is a member of a sorted set.
+type ZMember struct {
+	Member []byte
+	Score  float64
+}
+
+// HashField is a field of a hash.
+type HashField struct {
+	Field []byte
+	Value []byte
+}
+
+// Entry is a key with its value. Value depends on Type:
+//
+//	String  []byte
+//	List    [][]byte, from head to tail
+//	Set     [][]byte
+//	ZSet    []ZMember
+//	Hash    []HashField
+type Entry struct {
+	DB     int
+	Key    []byte
+	Type   ValueType
+	Value  interface{}
+	Expiry int64 // expiration as a unix time in milliseconds, 0 when the key never expires
+	Idle   int64 // seconds since the last access, -1 when unknown
+	Freq   int   // logarithmic access frequency, -1 when unknown
+}
+
+// Aux is a metadata field of a file, such as "redis-ver" or "ctime".
+type Aux struct {
+	Key   string
+	Value string
+}
+
+// formatInt returns the decimal form of n, the way integer encoded strings are loaded.
+func formatInt(n int64) []byte {
+	return strconv.AppendInt(nil, n, 10)
+}
@@ -0,0 +1,231 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	// Test vector of the crc64 implementation of Redis.
+	if got := Checksum([]byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Checksum = %x, want e9c6d914c4b8d9ca", got)
+	}
+	if got := crc64Update(Checksum([]byte("1234")), []byte("56789")); got != 0xe9c6d914c4b8d9ca {
+		t.Errorf("incremental checksum = %x, want e9c6d914c4b8d9ca", got)
+	}
+}
+
+func TestLZF(t *testing.T) {
+	random := make([]byte, 1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{
+		[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+		bytes.Repeat([]byte("hello world "), 100),
+		bytes.Repeat([]byte("x"), 1000),
+		random,
+		[]byte("ab"),
+	}
+	for _, in := range inputs {
+		compressed := lzfCompress(in)
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Errorf("round trip of %q failed: %v", in[:2], err)
+		}
+	}
+	if compressed := lzfCompress(bytes.Repeat([]byte("x"), 1000)); len(compressed) > 20 {
+		t.Errorf("repeated bytes compressed to %d bytes", len(compressed))
+	}
+	// A literal run of 2 bytes, then a back reference of 4 bytes at offset 2: "ababab".
+	out, err := lzfDecompress([]byte{0x01, 'a', 'b', 0x40, 0x01}, 6)
+	if err != nil || string(out) != "ababab" {
+		t.Errorf("lzfDecompress = %q, %v", out, err)
+	}
+	if _, err := lzfDecompress([]byte{0x40, 0x05}, 4); err == nil {
+		t.Error("expected an error for a reference before the start")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	long := bytes.Repeat([]byte("compressible "), 10)
+	entries := []*Entry{
+		{DB: 0, Key: []byte("str"), Type: String, Value: []byte("hello"), Idle: -1, Freq: -1},
+		{DB: 0, Key: []byte("int"), Type: String, Value: []byte("-12345678"), Idle: 10, Freq: -1},
+		{DB: 0, Key: []byte("notint"), Type: String, Value: []byte("007"), Idle: -1, Freq: 5},
+		{DB: 0, Key: []byte("long"), Type: String, Value: long, Expiry: 1700000000123, Idle: -1, Freq: -1},
+		{DB: 2, Key: []byte("list"), Type: List, Value: [][]byte{[]byte("a"), []byte("1"), {}}, Idle: -1, Freq: -1},
+		{DB: 2, Key: []byte("set"), Type: Set, Value: [][]byte{[]byte("x"), []byte("y")}, Idle: -1, Freq: -1},
+		{DB: 2, Key: []byte("zset"), Type: ZSet, Value: []ZMember{{[]byte("m"), 1.5}, {[]byte("n"), math.Inf(-1)}}, Idle: -1, Freq: -1},
+		{DB: 2, Key: []byte("hash"), Type: Hash, Value: []HashField{{[]byte("f"), []byte("v")}}, Idle: -1, Freq: -1},
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WriteHeader()
+	e.WriteAux("redis-bits", "64")
+	db := -1
+	for _, entry := range entries {
+		if entry.DB != db {
+			db = entry.DB
+			e.SelectDB(db, 4, 1)
+		}
+		if err := e.WriteEntry(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.WriteFooter(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0009")) {
+		t.Fatalf("unexpected header %q", buf.Bytes()[:9])
+	}
+	if bytes.Contains(buf.Bytes(), long) {
+		t.Error("the long string was not compressed")
+	}
+
+	d := NewDecoder(bytes.NewReader(buf.Bytes()))
+	for _, want := range entries {
+		got, err := d.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decoded %+v, want %+v", got, want)
+		}
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	if d.Version != Version || !reflect.DeepEqual(d.Aux, []Aux{{"redis-bits", "64"}}) {
+		t.Errorf("unexpected version %d or aux fields %v", d.Version, d.Aux)
+	}
+
+	// Any flipped byte in the keys is detected by the checksum.
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	i := bytes.Index(corrupted, []byte("hello"))
+	corrupted[i] = 'j'
+	d = NewDecoder(bytes.NewReader(corrupted))
+	var err error
+	for err == nil {
+		_, err = d.Next()
+	}
+	if err != ErrChecksum {
+		t.Errorf("expected ErrChecksum, got %v", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	for _, entry := range []*Entry{
+		{Type: String, Value: []byte("hello"), Idle: -1, Freq: -1},
+		{Type: List, Value: strs("a", "1", ""), Idle: -1, Freq: -1},
+		{Type: ZSet, Value: []ZMember{{[]byte("m"), 1.5}}, Idle: -1, Freq: -1},
+	} {
+		payload, err := Dump(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ParseDump(payload); err != nil || !reflect.DeepEqual(got, entry) {
+			t.Errorf("ParseDump(Dump(%+v)) = %+v, %v", entry, got, err)
+		}
+		payload[0] ^= 1
+		if _, err := ParseDump(payload); err == nil {
+			t.Error("ParseDump accepted a corrupted payload")
+		}
+	}
+	// The payload of DUMP for the integer 10 in the documentation of Redis.
+	entry, err := ParseDump([]byte("\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"))
+	if err != nil || string(entry.Value.([]byte)) != "10" {
+		t.Errorf("ParseDump of a payload of Redis = %+v, %v", entry, err)
+	}
+}
+
+// compactFile returns a file holding a single key of the given type, whose value is blob.
+func compactFile(valueType byte, blob []byte) []byte {
+	file := []byte("REDIS0011")
+	file = append(file, opcodeSelectDB, 0, valueType, 1, 'k', byte(len(blob)))
+	file = append(file, blob...)
+	file = append(file, opcodeEOF)
+	var checksum [8]byte
+	binary.LittleEndian.PutUint64(checksum[:], Checksum(file))
+	return append(file, checksum[:]...)
+}
+
+func strs(elements ...string) [][]byte {
+	b := [][]byte{}
+	for _, s := range elements {
+		b = append(b, []byte(s))
+	}
+	return b
+}
+
+func TestCompactEncodings(t *testing.T) {
+	// "a", 12, -1000 (13 bits), 30000 (int16)
+	listpack := []byte{19, 0, 0, 0, 4, 0, 0x81, 'a', 2, 12, 1, 0xDC, 0x18, 2, 0xF1, 0x30, 0x75, 3, 0xFF}
+	// "hi", 5 (immediate), 300 (int16)
+	ziplist := []byte{18, 0, 0, 0, 13, 0, 0, 0, 3, 0, 0, 2, 'h', 'i', 4, 0xF6, 2, 0xC0, 0x2C, 0x01, 0xFF}
+	// 1, -2 as int16
+	intset := []byte{2, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0xFE, 0xFF}
+	// "m" 1.5, "n" 2
+	zsetListpack := []byte{19, 0, 0, 0, 4, 0, 0x81, 'm', 2, 0x83, '1', '.', '5', 4, 0x81, 'n', 2, 2, 1, 0xFF}
+	// a quicklist of two nodes: the listpack above and a plain element
+	quicklist := append([]byte{2, quicklistNodePacked, byte(len(listpack))}, listpack...)
+	quicklist = append(quicklist, quicklistNodePlain, 3, 'b', 'i', 'g')
+	// field "f" value "v" with one free byte
+	zipmap := []byte{1, 1, 'f', 1, 1, 'v', 0, 0xFF}
+
+	tests := []struct {
+		name      string
+		file      []byte
+		valueType ValueType
+		value     interface{}
+	}{
+		{"listpack", compactFile(typeSetListpack, listpack), Set, strs("a", "12", "-1000", "30000")},
+		{"ziplist", compactFile(typeListZiplist, ziplist), List, strs("hi", "5", "300")},
+		{"intset", compactFile(typeSetIntset, intset), Set, strs("1", "-2")},
+		{"zset listpack", compactFile(typeZSetListpack, zsetListpack), ZSet, []ZMember{{[]byte("m"), 1.5}, {[]byte("n"), 2}}},
+		{"hash zipmap", compactFile(typeHashZipmap, zipmap), Hash, []HashField{{[]byte("f"), []byte("v")}}},
+	}
+	// The quicklist is not a single string, it is written after the key.
+	qfile := []byte("REDIS0011")
+	qfile = append(qfile, opcodeSelectDB, 0, typeListQuicklist2, 1, 'k')
+	qfile = append(qfile, quicklist...)
+	qfile = append(qfile, opcodeEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+	tests = append(tests, struct {
+		name      string
+		file      []byte
+		valueType ValueType
+		value     interface{}
+	}{"quicklist", qfile, List, strs("a", "12", "-1000", "30000", "big")})
+
+	for _, test := range tests {
+		d := NewDecoder(bytes.NewReader(test.file))
+		entry, err := d.Next()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if entry.Type != test.valueType || !reflect.DeepEqual(entry.Value, test.value) {
+			t.Errorf("%s: decoded %s %q, want %s %q", test.name, entry.Type, entry.Value, test.valueType, test.value)
+		}
+		if _, err := d.Next(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF, got %v", test.name, err)
+		}
+	}
+
+	truncated := compactFile(typeSetListpack, listpack[:len(listpack)-3])
+	if _, err := NewDecoder(bytes.NewReader(truncated)).Next(); err == nil {
+		t.Error("expected an error for a truncated listpack")
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	for _, file := range []string{"", "REDIX0009", "REDIS0099", "REDIS0009\xfe"} {
+		if _, err := NewDecoder(bytes.NewReader([]byte(file))).Next(); err == nil || err == io.EOF {
+			t.Errorf("expected an error for %q, got %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Small values are saved by Redis as the serialized form of their compact encoding:
+// a ziplist (up to Redis 6.2), a listpack (from Redis 7.0), an intset or a zipmap (up to Redis 2.4).
+// The functions below return their elements as strings, integers being formatted in decimal.
+
+var errCorrupted = fmt.Errorf("corrupted compact encoding")
+
+// parseZiplist returns the elements of a ziplist. After a 10 bytes header, each entry holds
+// the length of the previous entry (1 byte, or 0xFE and 4 bytes), its encoding and its data:
+//
+//	00pppppp                     - a string of pppppp bytes
+//	01pppppp qqqqqqqq            - a string of ppppppqqqqqqqq bytes
+//	10000000 + 4 bytes           - a string whose length is big endian
+//	11000000 / 11010000 / 11100000 - an int16 / int32 / int64
+//	11110000 / 11111110          - an int24 / int8
+//	1111xxxx                     - an integer xxxx-1 between 0 and 12
+//
+// The ziplist ends with 0xFF.
+func parseZiplist(b []byte) ([][]byte, error) {
+	if len(b) < 11 {
+		return nil, errCorrupted
+	}
+	elements := [][]byte{}
+	p := b[10:]
+	for {
+		if len(p) == 0 {
+			return nil, errCorrupted
+		}
+		if p[0] == 0xFF {
+			return elements, nil
+		}
+		if p[0] < 0xFE {
+			p = p[1:]
+		} else if len(p) >= 5 {
+			p = p[5:]
+		} else {
+			return nil, errCorrupted
+		}
+		if len(p) == 0 {
+			return nil, errCorrupted
+		}
+
+		enc := p[0]
+		var header, length int
+		switch enc >> 6 {
+		case 0:
+			header, length = 1, int(enc&0x3f)
+		case 1:
+			if len(p) < 2 {
+				return nil, errCorrupted
+			}
+			header, length = 2, int(enc&0x3f)<<8|int(p[1])
+		case 2:
+			if len(p) < 5 {
+				return nil, errCorrupted
+			}
+			header, length = 5, int(binary.BigEndian.Uint32(p[1:5]))
+		default:
+			n, size, err := ziplistInt(p)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, formatInt(n))
+			p = p[1+size:]
+			continue
+		}
+		if length < 0 || len(p) < header+length {
+			return nil, errCorrupted
+		}
+		elements = append(elements, p[header:header+length])
+		p = p[header+length:]
+	}
+}
+
+// ziplistInt decodes the integer entry starting at p, returning it with the size of its data.
+func ziplistInt(p []byte) (int64, int, error) {
+	enc := p[0]
+	size := 0
+	switch enc {
+	case 0xC0:
+		size = 2
+	case 0xD0:
+		size = 4
+	case 0xE0:
+		size = 8
+	case 0xF0:
+		size = 3
+	case 0xFE:
+		size = 1
+	default:
+		if enc >= 0xF1 && enc <= 0xFD {
+			return int64(enc&0x0f) - 1, 0, nil
+		}
+		return 0, 0, errCorrupted
+	}
+	if len(p) < 1+size {
+		return 0, 0, errCorrupted
+	}
+	return littleEndianInt(p[1 : 1+size]), size, nil
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes.
+func littleEndianInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := uint(64 - 8*len(b))
+	return int64(u<<shift) >> shift
+}
+
+// parseListpack returns the elements of a listpack. After a 6 bytes header, each entry holds
+// its encoding, its data and its length encoded backwards (the backlen):
+//
+//	0xxxxxxx                     - an integer between 0 and 127
+//	10pppppp                     - a string of pppppp bytes
+//	110xxxxx yyyyyyyy            - a 13 bits signed integer
+//	1110pppp qqqqqqqq            - a string of ppppqqqqqqqq bytes
+//	11110000 + 4 bytes           - a string whose length is little endian
+//	11110001 / 0010 / 0011 / 0100 - an int16 / int24 / int32 / int64
+//
+// The listpack ends with 0xFF.
+func parseListpack(b []byte) ([][]byte, error) {
+	if len(b) < 7 {
+		return nil, errCorrupted
+	}
+	elements := [][]byte{}
+	p := b[6:]
+	for {
+		if len(p) == 0 {
+			return nil, errCorrupted
+		}
+		enc := p[0]
+		if enc == 0xFF {
+			return elements, nil
+		}
+
+		var entryLen int
+		var element []byte
+		switch {
+		case enc&0x80 == 0:
+			entryLen, element = 1, formatInt(int64(enc))
+		case enc&0xC0 == 0x80:
+			entryLen = 1 + int(enc&0x3f)
+			if len(p) >= entryLen {
+				element = p[1:entryLen]
+			}
+		case enc&0xE0 == 0xC0:
+			entryLen = 2
+			if len(p) >= entryLen {
+				n := int64(enc&0x1f)<<8 | int64(p[1])
+				if n >= 1<<12 {
+					n -= 1 << 13
+				}
+				element = formatInt(n)
+			}
+		case enc&0xF0 == 0xE0:
+			if len(p) >= 2 {
+				entryLen = 2 + (int(enc&0x0f)<<8 | int(p[1]))
+				if len(p) >= entryLen {
+					element = p[2:entryLen]
+				}
+			}
+		case enc == 0xF0:
+			if len(p) >= 5 {
+				entryLen = 5 + int(binary.LittleEndian.Uint32(p[1:5]))
+				if entryLen >= 5 && len(p) >= entryLen {
+					element = p[5:entryLen]
+				}
+			}
+		case enc >= 0xF1 && enc <= 0xF4:
+			size := []int{2, 3, 4, 8}[enc-0xF1]
+			entryLen = 1 + size
+			if len(p) >= entryLen {
+				element = formatInt(littleEndianInt(p[1:entryLen]))
+			}
+		}
+		if element == nil {
+			return nil, errCorrupted
+		}
+		entryLen += listpackBacklenSize(entryLen)
+		if len(p) < entryLen {
+			return nil, errCorrupted
+		}
+		elements = append(elements, element)
+		p = p[entryLen:]
+	}
+}
+
+// listpackBacklenSize returns the number of bytes used by the backlen of an entry of l bytes.
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseIntset returns the members of an intset: the size of the integers and their count
+// as 32 bits little endian integers, then the sorted integers.
+func parseIntset(b []byte) ([][]byte, error) {
+	if len(b) < 8 {
+		return nil, errCorrupted
+	}
+	size := int(binary.LittleEndian.Uint32(b[0:4]))
+	count := int(binary.LittleEndian.Uint32(b[4:8]))
+	if (size != 2 && size != 4 && size != 8) || count < 0 || len(b) != 8+size*count {
+		return nil, errCorrupted
+	}
+	members := make([][]byte, count)
+	for i := range members {
+		members[i] = formatInt(littleEndianInt(b[8+i*size : 8+(i+1)*size]))
+	}
+	return members, nil
+}
+
+// parseZipmap returns the fields and values of a zipmap, the encoding of small hashes before
+// ziplists. After the number of pairs, each pair holds the length of the field, the field,
+// the length of the value, the number of free bytes after the value, and the value.
+// Lengths take 1 byte, or 0xFE and 4 bytes. The zipmap ends with 0xFF.
+func parseZipmap(b []byte) ([][]byte, error) {
+	if len(b) < 2 {
+		return nil, errCorrupted
+	}
+	elements := [][]byte{}
+	p := b[1:]
+	readLen := func() (int, bool) {
+		if len(p) == 0 || p[0] == 0xFF {
+			return 0, false
+		}
+		if p[0] < 0xFE {
+			l := int(p[0])
+			p = p[1:]
+			return l, true
+		}
+		if len(p) < 5 {
+			return 0, false
+		}
+		l := int(binary.LittleEndian.Uint32(p[1:5]))
+		p = p[5:]
+		return l, l >= 0
+	}
+	for {
+		if len(p) == 0 {
+			return nil, errCorrupted
+		}
+		if p[0] == 0xFF {
+			return elements, nil
+		}
+		l, ok := readLen()
+		if !ok || len(p) < l {
+			return nil, errCorrupted
+		}
+		elements = append(elements, p[:l])
+		p = p[l:]
+		if l, ok = readLen(); !ok || len(p) < 1+l {
+			return nil, errCorrupted
+		}
+		free := int(p[0])
+		if len(p) < 1+l+free {
+			return nil, errCorrupted
+		}
+		elements = append(elements, p[1:1+l])
+		p = p[1+l+free:]
+	}
+}
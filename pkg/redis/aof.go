@@ -0,0 +1,610 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/aof"
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// The write commands are logged to the append only file (AOF) once they ran, in the protocol of Redis,
+// so that replaying the file rebuilds the databases. Commands whose effect depends on the time they
+// run are logged in a deterministic form: expirations become an absolute PEXPIREAT or SET ... PXAT,
+// and INCRBYFLOAT becomes a SET of its result. The commands of a transaction are logged between MULTI
+// and EXEC once it is executed, so that they are replayed all at once.
+
+// AppendFsync is when the writes to the AOF are flushed to disk.
+type AppendFsync string
+
+const (
+	FsyncAlways   AppendFsync = "always"   // before replying to the write commands
+	FsyncEverySec AppendFsync = "everysec" // once per second, up to a second of writes may be lost
+	FsyncNo       AppendFsync = "no"       // when the operating system decides to
+)
+
+func parseAppendFsync(s string) (AppendFsync, error) {
+	switch fsync := AppendFsync(strings.ToLower(s)); fsync {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return fsync, nil
+	}
+	return "", fmt.Errorf("invalid appendfsync %q, must be always, everysec or no", s)
+}
+
+// aofState tracks the writes to the AOF.
+type aofState struct {
+	mutex    sync.Mutex
+	manifest *aof.Manifest // files of the AOF, nil until it is loaded or created
+	file     *os.File      // last incremental file, nil while the AOF is off
+	buf      []byte        // commands not written to the file yet
+	db       int           // database selected by the last SELECT of the file, -1 before the first one
+	size     int64         // size of the files of the AOF
+	unsynced bool          // the file was written since it was last flushed to disk
+	writeErr error         // error of the last write, the write commands are refused until the file is written again
+	offset   int64         // replication offset of the last command logged
+	fsynced  int64         // replication offset of the last command flushed to disk, waited for by WAITAOF
+
+	baseSize   int64 // size of the AOF after the last rewrite, the automatic rewrites measure the growth from it
+	rewriting  bool  // a rewrite is in progress
+	enabling   bool  // the rewrite in progress writes the first base file of an AOF turned on with CONFIG SET
+	rewriteErr error // nil when the last rewrite succeeded
+	rewrites   int64 // number of successful rewrites
+	background sync.WaitGroup
+}
+
+// aofDir returns the directory of the files of the AOF.
+func (c *Config) aofDir() string {
+	return filepath.Join(c.Dir, c.AppendDirname)
+}
+
+// aofPath returns the path of a file of the AOF.
+func (c *Config) aofPath(name string) string {
+	return filepath.Join(c.aofDir(), name)
+}
+
+// heldConn holds the replies written to the connection of a client until its write command is logged.
+type heldConn struct {
+	net.Conn
+	replies []byte
+}
+
+func (c *heldConn) Write(b []byte) (int, error) {
+	c.replies = append(c.replies, b...)
+	return len(b), nil
+}
+
+// discardConn is the connection of the client applying the stream of the primary, its replies are dropped.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// execConn collects the replies of the commands of a transaction run on EXEC, sent back together
+// as an array, and the ones of the commands replayed from the AOF, checked for errors.
+type execConn struct {
+	net.Conn
+	replies []interface{}
+}
+
+// beginWrite is called before a write command, which runs alone until the returned function is called.
+// The function logs the command when it changed the databases, then sends the replies the command
+// wrote: with appendfsync always, the write is on disk before the client is acknowledged.
+func (client *ClientDetail) beginWrite(commandType CommandType, args []string) func() {
+	server := client.server
+	server.writeMutex.Lock()
+	dirty := server.dirty()
+	var held *heldConn
+	conn := client.conn
+	if conn != nil {
+		switch c := conn.conn.(type) {
+		case *execConn:
+			// The replies are collected, none is written to a connection.
+		case *respConn:
+			// The replies to a client speaking RESP are translated before they are held.
+			held = &heldConn{Conn: c.Conn}
+			conn.conn = &respConn{Conn: held}
+		default:
+			held = &heldConn{Conn: conn.conn}
+			conn.conn = held
+		}
+	}
+	return func() {
+		switch {
+		case commandType == execCommand:
+			// The commands of the transaction were logged by runQueued.
+		case server.dirty() != dirty:
+			client.woff = server.propagate(client.db, propagatedCommand(commandType, args, currentStore(client.redis)))
+		}
+		server.writeMutex.Unlock()
+		if held != nil {
+			if c, ok := conn.conn.(*respConn); ok {
+				c.Conn = held.Conn
+			} else {
+				conn.conn = held.Conn
+			}
+			held.Conn.Write(held.replies)
+		}
+	}
+}
+
+// propagatedCommand returns the command logged for a write command that changed the store r,
+// rewriting the commands that would not have the same effect when replayed later.
+func propagatedCommand(commandType CommandType, args []string, r *Store) []string {
+	switch commandType {
+	case setCommand:
+		command := args[:3:3]
+		expires := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "ex", "px", "exat", "pxat":
+				expires = true
+				i++
+			default:
+				command = append(command, args[i])
+			}
+		}
+		if expires {
+			return withExpireTime(command, r)
+		}
+	case setAndExpireCommand:
+		return withExpireTime([]string{"SET", args[1], args[2]}, r)
+	case increByFloatCommand:
+		if value, err := r.Get(args[1]); err == nil {
+			return []string{"SET", args[1], value, "KEEPTTL"}
+		}
+	case expireCommand, pExpireCommand, expireAtCommand, pExpireAtCommand:
+		if at, exist := r.expireTimeMillis(args[1]); exist {
+			return []string{"PEXPIREAT", args[1], strconv.FormatInt(at, 10)}
+		}
+		// The time was in the past, the key was deleted.
+		return []string{"DEL", args[1]}
+	case restoreCommand, restoreAskingCommand:
+		if r.Exists(args[1]) == 0 {
+			return []string{"DEL", args[1]}
+		}
+		at, _ := r.expireTimeMillis(args[1])
+		return []string{"RESTORE", args[1], strconv.FormatInt(at, 10), args[3], "REPLACE", "ABSTTL"}
+	case migrateCommand:
+		// The keys sent to the target were deleted, the ones it refused are still there.
+		command := []string{"DEL"}
+		for _, key := range migrateKeys(args) {
+			if r.Exists(key) == 0 {
+				command = append(command, key)
+			}
+		}
+		return command
+	}
+	return args
+}
+
+// withExpireTime appends the absolute expiration of the key set by command, which is replaced by
+// a DEL when the key already expired.
+func withExpireTime(command []string, r *Store) []string {
+	at, exist := r.expireTimeMillis(command[1])
+	if !exist {
+		return []string{"DEL", command[1]}
+	}
+	return append(command, "PXAT", strconv.FormatInt(at, 10))
+}
+
+// expireTimeMillis returns the expiration of key as a unix time in milliseconds, reporting false
+// when key does not exist or never expires. It does not count as an access to the key.
+func (r *Store) expireTimeMillis(key string) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.peek(key)
+	if !exist || item.expiration.IsZero() {
+		return 0, false
+	}
+	return item.expiration.UnixNano() / int64(time.Millisecond), true
+}
+
+// feedAppendOnly logs commands that ran on the database at index db to the AOF, when it is on. offset
+// is the replication offset of the commands. The caller must hold r.writeMutex so the commands are
+// logged in the order they ran.
+func (r *RedisServer) feedAppendOnly(db int, offset int64, commands ...[]string) {
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	r.aof.offset = offset
+	if r.aof.file == nil {
+		return
+	}
+	if db != r.aof.db {
+		r.aof.buf = resp.AppendCommand(r.aof.buf, "SELECT", strconv.Itoa(db))
+		r.aof.db = db
+	}
+	for _, command := range commands {
+		r.aof.buf = resp.AppendCommand(r.aof.buf, command...)
+	}
+	r.flushAppendOnly(r.getConfig().AppendFsync == FsyncAlways)
+}
+
+// flushAppendOnly writes the logged commands to the file, and flushes it to disk when sync is set.
+// What could not be written is kept to be written again by the cron. The caller must hold r.aof.mutex.
+func (r *RedisServer) flushAppendOnly(sync bool) {
+	if len(r.aof.buf) > 0 {
+		n, err := r.aof.file.Write(r.aof.buf)
+		r.aof.size += int64(n)
+		r.aof.buf = append(r.aof.buf[:0], r.aof.buf[n:]...)
+		if n > 0 {
+			r.aof.unsynced = true
+		}
+		if err != nil {
+			if r.aof.writeErr == nil {
+				fmt.Println("Error writing to the AOF file:", err)
+			}
+			r.aof.writeErr = err
+			return
+		}
+		if r.aof.writeErr != nil {
+			fmt.Println("AOF write error looks solved, can write again")
+		}
+		r.aof.writeErr = nil
+	}
+	if sync && r.aof.unsynced {
+		if err := r.aof.file.Sync(); err != nil {
+			fmt.Println("Error flushing the AOF file to disk:", err)
+			r.aof.writeErr = err
+			return
+		}
+		r.aof.unsynced = false
+	}
+	if len(r.aof.buf) == 0 && !r.aof.unsynced {
+		r.appendOnlyFsynced(r.aof.offset)
+	}
+}
+
+// appendOnlyFsynced records that the commands up to the replication offset are on disk, waking the
+// clients blocked in WAITAOF. The caller must hold r.aof.mutex.
+func (r *RedisServer) appendOnlyFsynced(offset int64) {
+	if offset > r.aof.fsynced {
+		r.aof.fsynced = offset
+		r.signalBlocked()
+	}
+}
+
+// appendOnlyFsyncedOffset returns the replication offset of the last command flushed to disk, and
+// reports whether the AOF is on.
+func (r *RedisServer) appendOnlyFsyncedOffset() (int64, bool) {
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	return r.aof.fsynced, r.aof.file != nil
+}
+
+// aofCron writes again the commands a failed write left behind, flushes the file to disk once per
+// second with appendfsync everysec, and starts a rewrite once the AOF grew enough.
+func (r *RedisServer) aofCron() {
+	config := r.getConfig()
+	r.aof.mutex.Lock()
+	if r.aof.file == nil {
+		r.aof.mutex.Unlock()
+		return
+	}
+	r.flushAppendOnly(false)
+	growth, rewrite := r.autoRewriteNeeded(config)
+	var file *os.File
+	offset := r.aof.offset
+	if config.AppendFsync == FsyncEverySec && r.aof.unsynced && r.aof.writeErr == nil {
+		file = r.aof.file
+		r.aof.unsynced = false
+	}
+	r.aof.mutex.Unlock()
+
+	// Flushing takes a while, the write commands keep being logged meanwhile.
+	if file != nil {
+		err := file.Sync()
+		r.aof.mutex.Lock()
+		if err != nil && !errors.Is(err, os.ErrClosed) {
+			fmt.Println("Error flushing the AOF file to disk:", err)
+			r.aof.unsynced = true
+		} else if err == nil {
+			r.appendOnlyFsynced(offset)
+		}
+		r.aof.mutex.Unlock()
+	}
+	if rewrite {
+		fmt.Printf("Starting automatic rewriting of AOF on %d%% growth\n", growth)
+		r.BackgroundRewriteAppendOnly()
+	}
+}
+
+// appendOnlyError returns the error of the last write to the AOF, nil when it succeeded.
+func (r *RedisServer) appendOnlyError() error {
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	return r.aof.writeErr
+}
+
+// openAppendOnly opens the AOF to log the write commands, appending to its last incremental file.
+// Without an AOF yet, a first base file is written from the content of the databases so the keys loaded
+// from the RDB file are kept, and the single file AOF of Redis before 7.0 becomes the base file.
+func (r *RedisServer) openAppendOnly() error {
+	config := r.getConfig()
+	if err := os.MkdirAll(config.aofDir(), 0755); err != nil {
+		return fmt.Errorf("creating the append only directory: %v", err)
+	}
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	m := r.aof.manifest
+	changed := false
+	if m == nil {
+		m = &aof.Manifest{}
+		base := m.NextBase(config.AppendFilename)
+		dbs, _ := r.snapshot()
+		err := writeFileAtomic(config.aofPath(base.Name), func(w io.Writer) error {
+			return encodeRDB(w, dbs, config.MaxMemoryPolicy, true)
+		})
+		r.releaseSnapshot()
+		if err != nil {
+			return fmt.Errorf("creating the append only file: %v", err)
+		}
+		m.Base = &base
+		changed = true
+	}
+	var f *os.File
+	var err error
+	if len(m.Incrs) == 0 {
+		f, err = r.createIncr(config, m)
+		changed = true
+	} else {
+		f, err = os.OpenFile(config.aofPath(m.Incrs[len(m.Incrs)-1].Name), os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := persistManifest(config, m); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	// The manifest lists the file of Redis before 7.0 as the base file, it is moved to the directory
+	// once the manifest is written so that a crash in between loses nothing.
+	if m.Base != nil && m.Base.Name == config.AppendFilename {
+		if legacy := filepath.Join(config.Dir, config.AppendFilename); fileExists(legacy) {
+			if err := os.Rename(legacy, config.aofPath(m.Base.Name)); err != nil {
+				f.Close()
+				return fmt.Errorf("moving the append only file to its directory: %v", err)
+			}
+		}
+	}
+	r.aof.manifest = m
+	r.aof.file = f
+	r.aof.db = -1
+	r.aof.size = appendOnlySize(config, m)
+	r.aof.baseSize = r.aof.size
+	return nil
+}
+
+// createIncr adds a new incremental file to the manifest and creates it.
+func (r *RedisServer) createIncr(config Config, m *aof.Manifest) (*os.File, error) {
+	incr := m.NextIncr(config.AppendFilename)
+	f, err := os.OpenFile(config.aofPath(incr.Name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		m.Incrs = m.Incrs[:len(m.Incrs)-1]
+		return nil, fmt.Errorf("creating the append only file: %v", err)
+	}
+	return f, nil
+}
+
+// appendOnlyFilePath returns the path of a file listed in the manifest. The file of Redis before 7.0
+// is found next to the RDB file until it is moved, see openAppendOnly.
+func appendOnlyFilePath(config Config, file aof.File) string {
+	path := config.aofPath(file.Name)
+	if file.Name == config.AppendFilename && !fileExists(path) {
+		return filepath.Join(config.Dir, file.Name)
+	}
+	return path
+}
+
+// appendOnlySize returns the size of the files of the AOF.
+func appendOnlySize(config Config, m *aof.Manifest) int64 {
+	var size int64
+	for _, file := range m.Files() {
+		if info, err := os.Stat(appendOnlyFilePath(config, file)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readManifest reads the manifest of the AOF, returning nil when there is none.
+func readManifest(config Config) (*aof.Manifest, error) {
+	f, err := os.Open(config.aofPath(aof.ManifestName(config.AppendFilename)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return aof.Read(f)
+}
+
+// persistManifest replaces the manifest of the AOF, so the switch to the files it lists is atomic.
+func persistManifest(config Config, m *aof.Manifest) error {
+	err := writeFileAtomic(config.aofPath(aof.ManifestName(config.AppendFilename)), m.Encode)
+	if err == nil {
+		err = syncDir(config.aofDir())
+	}
+	if err != nil {
+		return fmt.Errorf("persisting the append only manifest: %v", err)
+	}
+	return nil
+}
+
+// syncDir flushes the entries of a directory to disk, so the files renamed in it survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// LoadAppendOnly replaces the content of the databases with the AOF: the files listed by its manifest,
+// or the single file of Redis before 7.0. It returns an error satisfying os.IsNotExist when there is no AOF.
+func (r *RedisServer) LoadAppendOnly() error {
+	config := r.getConfig()
+	m, err := readManifest(config)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		if _, err := os.Stat(filepath.Join(config.Dir, config.AppendFilename)); err != nil {
+			return err
+		}
+		m = &aof.Manifest{Base: &aof.File{Name: config.AppendFilename, Seq: 1, Type: aof.Base}}
+	}
+
+	r.FlushAll()
+	client := &ClientDetail{
+		conn:    &RedisClient{ID: "aof", conn: &execConn{}},
+		server:  r,
+		redis:   []*Store{r.dbs[0]},
+		loading: true,
+	}
+	files := m.Files()
+	for i, file := range files {
+		if err := r.loadAppendOnlyFile(client, appendOnlyFilePath(config, file), i == len(files)-1); err != nil {
+			return err
+		}
+	}
+
+	r.aof.mutex.Lock()
+	r.aof.manifest = m
+	r.aof.mutex.Unlock()
+	r.rdb.mutex.Lock()
+	defer r.rdb.mutex.Unlock()
+	r.rdb.savedDirty = r.dirty()
+	return nil
+}
+
+// loadAppendOnlyFile runs the commands of a file of the AOF with client, the file may start with an
+// RDB preamble. When the last file ends in the middle of a command or of a transaction, the commands
+// before are loaded and the file is truncated to them if aof-load-truncated is set.
+func (r *RedisServer) loadAppendOnlyFile(client *ClientDetail, path string, last bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening the append only file: %v", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var preamble int64
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		d := rdb.NewDecoder(br)
+		skipped, err := r.loadRDB(d)
+		if err != nil {
+			return err
+		}
+		if skipped > 0 {
+			fmt.Printf("%d keys of unsupported types were skipped\n", skipped)
+		}
+		preamble = d.Offset()
+	}
+
+	reader := resp.NewReader(br)
+	replies := client.conn.conn.(*execConn)
+	valid, multi := preamble, int64(-1)
+	truncated := false
+	for {
+		args, err := reader.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			truncated = true
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("bad file format reading the append only file %s at offset %d: %v", path, preamble+reader.Offset(), err)
+		}
+		switch CommandType(strings.ToLower(args[0])) {
+		case multiCommand:
+			multi = valid
+		case execCommand:
+			multi = -1
+		}
+		client.execute(args)
+		if err := replayError(replies.replies); err != nil {
+			return fmt.Errorf("error replaying %s from the append only file %s at offset %d: %v", strings.ToUpper(args[0]), path, valid, err)
+		}
+		replies.replies = nil
+		valid = preamble + reader.Offset()
+	}
+	if multi >= 0 {
+		// The transaction was not executed by the replay, it is dropped as a whole.
+		client.execute([]string{"discard"})
+		truncated = true
+		valid = multi
+	}
+
+	if truncated {
+		if !last {
+			return fmt.Errorf("unexpected end of the append only file %s at offset %d, which is not the last file", path, valid)
+		}
+		if !r.getConfig().AofLoadTruncated {
+			return fmt.Errorf("unexpected end of the append only file %s at offset %d, "+
+				"set aof-load-truncated to load the commands before it", path, valid)
+		}
+		fmt.Printf("!!! Warning: short read while loading the AOF file %s, truncating it to %d bytes\n", path, valid)
+		if err := os.Truncate(path, valid); err != nil {
+			return fmt.Errorf("truncating the append only file: %v", err)
+		}
+	}
+	return nil
+}
+
+// replayError returns the first error replied to a command replayed from the AOF, looking into the
+// replies of the commands of a transaction returned by EXEC.
+func replayError(replies []interface{}) error {
+	for _, reply := range replies {
+		switch reply := reply.(type) {
+		case error:
+			return reply
+		case []interface{}:
+			if err := replayError(reply); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadAppendOnlyOnStart loads the AOF when the server starts, or the RDB file when there is no AOF
+// yet, then opens the AOF to log the write commands.
+func (r *RedisServer) loadAppendOnlyOnStart() error {
+	start := time.Now()
+	err := r.LoadAppendOnly()
+	switch {
+	case os.IsNotExist(err):
+		if err := r.loadRDBOnStart(); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		fmt.Printf("DB loaded from append only file: %.3f seconds\n", time.Since(start).Seconds())
+	}
+	return r.openAppendOnly()
+}
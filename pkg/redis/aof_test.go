@@ -0,0 +1,493 @@
+package redis
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/redis/pkg/aof"
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+func newTestAppendOnlyServer(t *testing.T) *RedisServer {
+	config := DefaultConfig()
+	config.Databases = 4
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	config.AppendFsync = FsyncAlways
+	server := New(config)
+	if err := server.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+// newTestConnClient returns a client running commands through execute, like a connected client.
+func newTestConnClient(server *RedisServer, conn net.Conn) *ClientDetail {
+	return &ClientDetail{conn: &RedisClient{ID: "test", conn: conn}, server: server, redis: []*Store{server.dbs[0]}}
+}
+
+// lastIncrPath returns the path of the incremental file the server logs the commands to.
+func lastIncrPath(server *RedisServer) string {
+	config := server.getConfig()
+	server.aof.mutex.Lock()
+	defer server.aof.mutex.Unlock()
+	incrs := server.aof.manifest.Incrs
+	return config.aofPath(incrs[len(incrs)-1].Name)
+}
+
+// readAppendOnly reads the commands of a file of the AOF, after its RDB preamble.
+func readAppendOnly(t *testing.T, path string) [][]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(5); string(magic) == "REDIS" {
+		d := rdb.NewDecoder(br)
+		for err == nil {
+			_, err = d.Next()
+		}
+		if err != io.EOF {
+			t.Fatal(err)
+		}
+	}
+	r := resp.NewReader(br)
+	commands := [][]string{}
+	for {
+		command, err := r.ReadCommand()
+		if err != nil {
+			break
+		}
+		commands = append(commands, command)
+	}
+	return commands
+}
+
+func TestAppendOnlyLogAndReplay(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	client := newTestConnClient(server, discardConn{})
+	for _, args := range [][]string{
+		{"set", "a", "1"},
+		{"set", "b", "with spaces", "ex", "100", "nx"},
+		{"setex", "c", "value", "100"},
+		{"incrbyfloat", "f", "1.5"},
+		{"expire", "a", "100"},
+		{"get", "a"},
+		{"set", "b", "ignored", "nx"},
+		{"select", "2"},
+		{"lpush", "l", "x"},
+		{"multi"},
+		{"set", "t", "1"},
+		{"multi"},
+		{"lpush", "l", "y"},
+		{"discard"},
+		{"zadd", "z", "1", "m"},
+		{"exec"},
+		{"expire", "t", "-1"},
+	} {
+		client.execute(args)
+	}
+
+	commands := readAppendOnly(t, lastIncrPath(server))
+	got := []string{}
+	for _, command := range commands {
+		got = append(got, strings.Join(command, " "))
+	}
+	a, _ := server.dbs[0].expireTimeMillis("a")
+	b, _ := server.dbs[0].expireTimeMillis("b")
+	c, _ := server.dbs[0].expireTimeMillis("c")
+	expected := []string{
+		"SELECT 0",
+		"set a 1",
+		"set b with spaces nx PXAT " + strconv.FormatInt(b, 10),
+		"SET c value PXAT " + strconv.FormatInt(c, 10),
+		"SET f 1.5 KEEPTTL",
+		"PEXPIREAT a " + strconv.FormatInt(a, 10),
+		"SELECT 2",
+		"lpush l x",
+		"MULTI",
+		"set t 1",
+		"zadd z 1 m",
+		"EXEC",
+		"DEL t",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the commands\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	config := server.getConfig()
+	loaded := New(config)
+	if err := loaded.LoadAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := loaded.dbs[0].Get("b"); value != "with spaces" {
+		t.Errorf("Expected b to be replayed, got %q", value)
+	}
+	if at, _ := loaded.dbs[0].expireTimeMillis("b"); at != b {
+		t.Errorf("Expected the expiration of b to be replayed exactly, got %d instead of %d", at, b)
+	}
+	if list, _ := loaded.dbs[2].LRange("l", 0, -1); list != "[x]" {
+		t.Errorf("Expected the discarded push not to be replayed, got %s", list)
+	}
+	if entries, _ := loaded.dbs[2].ZRange("z", 0, -1); len(entries) != 1 {
+		t.Errorf("Expected the transaction to be replayed, got %v", entries)
+	}
+	if loaded.dbs[2].Exists("t") != 0 {
+		t.Errorf("Expected t to be deleted")
+	}
+}
+
+func TestAppendOnlyTransactionInterleaved(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	replies := &heldConn{}
+	a, b := newTestConnClient(server, replies), newTestConnClient(server, discardConn{})
+	b.execute([]string{"set", "counter", "10"})
+
+	// b writes while a transaction of a is open, the commands of the transaction run on EXEC.
+	for _, step := range []struct {
+		client *ClientDetail
+		args   []string
+	}{
+		{a, []string{"multi"}},
+		{b, []string{"set", "x", "fromB"}},
+		{b, []string{"incr", "counter"}},
+		{a, []string{"set", "y", "1"}},
+		{a, []string{"incr", "counter"}},
+		{b, []string{"del", "gone"}},
+		{a, []string{"exec"}},
+	} {
+		step.client.execute(step.args)
+	}
+	if got := string(replies.replies); got != "started transaction\nQUEUED\nQUEUED\n[OK 12]\n" {
+		t.Errorf("Expected the replies of the transaction to be sent on EXEC, got %q", got)
+	}
+	expected := map[string]string{"counter": "12", "x": "fromB", "y": "1"}
+	for key, value := range expected {
+		if got, _ := server.dbs[0].Get(key); got != value {
+			t.Errorf("Expected %s to be %q, got %q", key, value, got)
+		}
+	}
+
+	loaded := New(server.getConfig())
+	if err := loaded.LoadAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	if keys := loaded.dbs[0].Keys("*"); len(keys) != len(expected) {
+		t.Errorf("Expected the keys %v once the AOF is loaded, got %v", expected, keys)
+	}
+	for key, value := range expected {
+		if got, _ := loaded.dbs[0].Get(key); got != value {
+			t.Errorf("Expected %s to be %q once the AOF is loaded, got %q", key, value, got)
+		}
+	}
+}
+
+func TestAppendOnlyTruncated(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	client := newTestConnClient(server, discardConn{})
+	client.execute([]string{"set", "a", "1"})
+	config := server.getConfig()
+	path := lastIncrPath(server)
+	complete, _ := os.Stat(path)
+
+	tails := map[string]string{
+		"a truncated command":        "*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1",
+		"a transaction without EXEC": "*1\r\n$5\r\nMULTI\r\n*3\r\n$3\r\nSET\r\n$1\r\nb\r\n$1\r\n2\r\n",
+	}
+	for name, tail := range tails {
+		os.Truncate(path, complete.Size())
+		f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+		f.WriteString(tail)
+		f.Close()
+
+		config.AofLoadTruncated = false
+		if err := New(config).LoadAppendOnly(); err == nil {
+			t.Errorf("%s: expected an error without aof-load-truncated", name)
+		}
+		config.AofLoadTruncated = true
+		loaded := New(config)
+		if err := loaded.LoadAppendOnly(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if value, _ := loaded.dbs[0].Get("a"); value != "1" || loaded.dbs[0].Exists("b") != 0 {
+			t.Errorf("%s: expected only the complete commands to be loaded", name)
+		}
+		if info, _ := os.Stat(path); info.Size() != complete.Size() {
+			t.Errorf("%s: expected the file to be truncated to %d bytes, got %d", name, complete.Size(), info.Size())
+		}
+	}
+
+	os.WriteFile(path, []byte("*1\r\n+OK\r\n"), 0644)
+	if err := New(config).LoadAppendOnly(); err == nil || !strings.Contains(err.Error(), "bad file format") {
+		t.Errorf("Expected a corrupted file to be refused, got %v", err)
+	}
+
+	os.WriteFile(path, resp.AppendCommand(resp.AppendCommand(nil, "SET", "a", "1"), "LPUSH", "a", "x"), 0644)
+	if err := New(config).LoadAppendOnly(); err == nil || !strings.Contains(err.Error(), "error replaying LPUSH") {
+		t.Errorf("Expected a command failing on replay to be refused, got %v", err)
+	}
+}
+
+func TestAppendOnlyPreamble(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	server := New(config)
+	server.dbs[0].Set("saved", "value", 0)
+	if err := server.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Turning the AOF on keeps the keys of the RDB file.
+	config.AppendOnly = true
+	server = New(config)
+	if err := server.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	newTestConnClient(server, discardConn{}).execute([]string{"set", "logged", "value"})
+	os.Remove(config.rdbPath())
+
+	loaded := New(config)
+	if err := loaded.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.dbs[0].Exists("saved", "logged") != 2 {
+		t.Errorf("Expected the keys of the preamble and of the commands to be loaded")
+	}
+	if !strings.Contains(loaded.Info("persistence"), "aof_enabled:1") {
+		t.Errorf("Expected INFO to report the AOF, got %s", loaded.Info("persistence"))
+	}
+}
+
+func TestAppendOnlyLoadOverMaxMemory(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	client := newTestConnClient(server, discardConn{})
+	client.execute([]string{"set", "first", "value"})
+	client.execute([]string{"set", "logged", "value"})
+
+	// The commands of the AOF are loaded even when they do not fit in maxmemory anymore.
+	config := server.getConfig()
+	config.MaxMemory = 1
+	config.MaxMemoryPolicy = NoEviction
+	loaded := New(config)
+	if err := loaded.LoadAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := loaded.dbs[0].Get("logged"); value != "value" {
+		t.Errorf("Expected the commands of the AOF to be loaded over maxmemory, got %q", value)
+	}
+}
+
+func TestAppendOnlyLoadInClusterMode(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	server := New(config)
+	if err := server.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	newTestConnClient(server, discardConn{}).execute([]string{"set", "logged", "value"})
+
+	// The AOF is loaded before the node knows the slots it serves, its commands are not redirected.
+	config.ClusterEnabled = true
+	loaded := New(config)
+	if err := loaded.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := loaded.dbs[0].Get("logged"); value != "value" {
+		t.Errorf("Expected the commands of the AOF to be loaded in cluster mode, got %q", value)
+	}
+}
+
+// replyConn records the size of the AOF when the replies are written.
+type replyConn struct {
+	net.Conn
+	server *RedisServer
+	sizes  []int64
+}
+
+func (c *replyConn) Write(b []byte) (int, error) {
+	c.server.aof.mutex.Lock()
+	c.sizes = append(c.sizes, c.server.aof.size)
+	c.server.aof.mutex.Unlock()
+	return len(b), nil
+}
+
+func TestAppendOnlyReplyAfterLog(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	conn := &replyConn{server: server}
+	client := newTestConnClient(server, conn)
+	client.execute([]string{"set", "key", "value"})
+	client.execute([]string{"get", "key"})
+	config := server.getConfig()
+	size := appendOnlySize(config, server.aof.manifest)
+	if len(conn.sizes) != 2 || conn.sizes[0] != size {
+		t.Errorf("Expected the reply to be sent once the command is logged, got sizes %v for files of %d bytes", conn.sizes, size)
+	}
+	if client.conn.conn != conn {
+		t.Errorf("Expected the connection of the client to be restored")
+	}
+
+	server.aof.writeErr = os.ErrClosed
+	client.execute([]string{"set", "key", "other"})
+	if value, _ := server.dbs[0].Get("key"); value != "value" {
+		t.Errorf("Expected the writes to be refused after a write error")
+	}
+}
+
+func TestAppendOnlyRewrite(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	client := newTestConnClient(server, discardConn{})
+	for i := 0; i < 10; i++ {
+		client.execute([]string{"incr", "counter"})
+	}
+	config := server.getConfig()
+	old := server.aof.manifest.Files()
+
+	if reply, err := handleBgRewriteAof([]string{"bgrewriteaof"}, client); err != nil {
+		t.Fatal(err)
+	} else if reply != resp.SimpleString("Background append only file rewriting started") {
+		t.Errorf("Unexpected reply %q", reply)
+	}
+	// The writes during the rewrite go to the new incremental file.
+	client.execute([]string{"set", "during", "rewrite"})
+	server.aof.background.Wait()
+
+	f, err := os.Open(config.aofPath(aof.ManifestName(config.AppendFilename)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := aof.Read(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []aof.File{
+		{Name: "appendonly.aof.2.base.rdb", Seq: 2, Type: aof.Base},
+		{Name: "appendonly.aof.2.incr.aof", Seq: 2, Type: aof.Incr},
+	}
+	if !reflect.DeepEqual(m.Files(), expected) || len(m.History) != 0 {
+		t.Errorf("Expected the manifest to list %v, got %v and the history %v", expected, m.Files(), m.History)
+	}
+	for _, file := range old {
+		if fileExists(config.aofPath(file.Name)) {
+			t.Errorf("Expected %s to be deleted", file.Name)
+		}
+	}
+	commands := readAppendOnly(t, config.aofPath("appendonly.aof.2.incr.aof"))
+	if len(commands) != 2 || strings.Join(commands[1], " ") != "set during rewrite" {
+		t.Errorf("Expected the new incremental file to log the write, got %v", commands)
+	}
+	if info := server.Info("persistence"); !strings.Contains(info, "aof_rewrites:1") || !strings.Contains(info, "aof_rewrite_in_progress:0") {
+		t.Errorf("Expected INFO to report the rewrite, got %s", info)
+	}
+
+	loaded := New(config)
+	if err := loaded.LoadAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := loaded.dbs[0].Get("counter"); value != "10" {
+		t.Errorf("Expected the base file to hold the counter, got %q", value)
+	}
+	if value, _ := loaded.dbs[0].Get("during"); value != "rewrite" {
+		t.Errorf("Expected the write during the rewrite to be loaded, got %q", value)
+	}
+}
+
+func TestAppendOnlyAutoRewrite(t *testing.T) {
+	server := newTestAppendOnlyServer(t)
+	client := newTestConnClient(server, discardConn{})
+	config := server.getConfig()
+	if err := server.setConfig("auto-aof-rewrite-min-size", "1kb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.setConfig("auto-aof-rewrite-percentage", "100"); err != nil {
+		t.Fatal(err)
+	}
+	server.aofCron()
+	if server.aof.rewrites != 0 || server.aof.rewriting {
+		t.Fatalf("Expected no rewrite under auto-aof-rewrite-min-size")
+	}
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 20; i++ {
+		client.execute([]string{"set", "key", value})
+	}
+	server.aofCron()
+	server.aof.background.Wait()
+	if server.aof.rewrites != 1 {
+		t.Fatalf("Expected the AOF to be rewritten once it doubled")
+	}
+	if size := appendOnlySize(config, server.aof.manifest); server.aof.baseSize != size || size > 1024 {
+		t.Errorf("Expected the rewrite to compact the AOF, got %d bytes for a base of %d", size, server.aof.baseSize)
+	}
+}
+
+func TestAppendOnlyLegacyFile(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	config.AppendOnly = true
+	legacy := resp.AppendCommand(nil, "SELECT", "0")
+	legacy = resp.AppendCommand(legacy, "SET", "old", "value")
+	if err := os.WriteFile(filepath.Join(config.Dir, config.AppendFilename), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := New(config)
+	if err := server.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	newTestConnClient(server, discardConn{}).execute([]string{"set", "new", "value"})
+	if fileExists(filepath.Join(config.Dir, config.AppendFilename)) {
+		t.Errorf("Expected the file to be moved to %s", config.aofDir())
+	}
+	expected := []aof.File{
+		{Name: "appendonly.aof", Seq: 1, Type: aof.Base},
+		{Name: "appendonly.aof.1.incr.aof", Seq: 1, Type: aof.Incr},
+	}
+	if !reflect.DeepEqual(server.aof.manifest.Files(), expected) {
+		t.Errorf("Expected the manifest to list %v, got %v", expected, server.aof.manifest.Files())
+	}
+
+	loaded := New(config)
+	if err := loaded.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.dbs[0].Exists("old", "new") != 2 {
+		t.Errorf("Expected the keys of the file and of the incremental file to be loaded")
+	}
+}
+
+func TestAppendOnlyConfigSet(t *testing.T) {
+	config := DefaultConfig()
+	config.Dir = t.TempDir()
+	server := New(config)
+	client := newTestConnClient(server, discardConn{})
+	client.execute([]string{"set", "before", "value"})
+
+	if err := server.setConfig("appendonly", "yes"); err != nil {
+		t.Fatal(err)
+	}
+	client.execute([]string{"set", "after", "value"})
+	server.aof.background.Wait()
+	if err := server.setConfig("appendonly", "no"); err != nil {
+		t.Fatal(err)
+	}
+	client.execute([]string{"set", "ignored", "value"})
+
+	config.AppendOnly = true
+	loaded := New(config)
+	if err := loaded.LoadAppendOnly(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.dbs[0].Exists("before", "after") != 2 || loaded.dbs[0].Exists("ignored") != 0 {
+		t.Errorf("Expected the AOF to hold the keys written before it was turned off")
+	}
+}
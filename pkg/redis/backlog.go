@@ -0,0 +1,41 @@
+package redis
+
+// replBacklog keeps the end of the replication stream in a circular buffer, so that a replica whose
+// link broke for a moment continues the stream from the offset it reached with a partial resync,
+// instead of receiving a whole RDB file again.
+type replBacklog struct {
+	buf     []byte
+	idx     int   // position of the next byte written in buf
+	histlen int64 // number of bytes of the stream held, up to len(buf)
+}
+
+func newReplBacklog(size int64) *replBacklog {
+	return &replBacklog{buf: make([]byte, size)}
+}
+
+// write adds the bytes p of the stream, overwriting the oldest bytes once the buffer is full.
+func (b *replBacklog) write(p []byte) {
+	size := len(b.buf)
+	if len(p) > size {
+		p = p[len(p)-size:]
+	}
+	n := copy(b.buf[b.idx:], p)
+	copy(b.buf, p[n:])
+	b.idx = (b.idx + len(p)) % size
+	b.histlen += int64(len(p))
+	if b.histlen > int64(size) {
+		b.histlen = int64(size)
+	}
+}
+
+// tail returns a copy of the last n bytes of the stream, n being at most histlen.
+func (b *replBacklog) tail(n int64) []byte {
+	size := int64(len(b.buf))
+	start := (int64(b.idx) - n + size) % size
+	out := make([]byte, 0, n)
+	if start+n <= size {
+		return append(out, b.buf[start:start+n]...)
+	}
+	out = append(out, b.buf[start:]...)
+	return append(out, b.buf[:n-(size-start)]...)
+}
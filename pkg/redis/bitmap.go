@@ -0,0 +1,718 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Bitmaps are plain string values addressed bit by bit. Like Redis, bit 0 is the most
+// significant bit of the first byte and strings are zero padded when a write goes past their end.
+
+// maxBitOffset is the highest addressable bit, strings are limited to 512MB like in Redis.
+const maxBitOffset = 512*1024*1024*8 - 1
+
+// BitRange selects a part of a string for BITCOUNT and BITPOS.
+// Negative indexes count from the end of the string.
+type BitRange struct {
+	Start, End       int64
+	HasStart, HasEnd bool
+	Bit              bool // indexes are bit positions instead of byte positions
+}
+
+// BitFieldOverflow controls how BITFIELD SET and INCRBY behave on overflow.
+type BitFieldOverflow int
+
+const (
+	OverflowWrap BitFieldOverflow = iota
+	OverflowSat
+	OverflowFail
+)
+
+// BitFieldOp is a single GET, SET or INCRBY subcommand of BITFIELD.
+type BitFieldOp struct {
+	Kind     string // "get", "set" or "incrby"
+	Signed   bool
+	Bits     uint
+	Offset   uint64
+	Value    int64
+	Overflow BitFieldOverflow
+}
+
+// bytesForWrite returns the string stored at key grown to at least size bytes.
+// The caller must hold r.mu and store the returned slice back.
+func (r *Store) bytesForWrite(key string, size uint64) (ExpirationItem, []byte, error) {
+	r.unshare(key)
+	item, exist := r.lookup(key)
+	var b []byte
+	if exist {
+		str, ok := stringBytes(item.value)
+		if !ok {
+			return item, nil, errWrongType
+		}
+		b = str
+	}
+	return item, growBytes(b, int(size)), nil
+}
+
+// bytesForRead returns the string stored at key, nil if the key does not exist.
+// The caller must hold r.mu.
+func (r *Store) bytesForRead(key string) ([]byte, bool, error) {
+	item, exist := r.lookup(key)
+	if !exist {
+		return nil, false, nil
+	}
+	str, ok := stringBytes(item.value)
+	if !ok {
+		return nil, false, errWrongType
+	}
+	return str, true, nil
+}
+
+// growBytes zero pads b up to size bytes, reusing the spare capacity of b when possible.
+func growBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	if cap(b) >= size {
+		extra := b[len(b):size]
+		for i := range extra {
+			extra[i] = 0
+		}
+		return b[:size]
+	}
+	return append(b, make([]byte, size-len(b))...)
+}
+
+// SetBit sets or clears the bit at offset and returns its original value.
+func (r *Store) SetBit(key string, offset uint64, bit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item, b, err := r.bytesForWrite(key, offset/8+1)
+	if err != nil {
+		return 0, err
+	}
+	index, mask := offset/8, byte(1)<<(7-offset%8)
+	original := 0
+	if b[index]&mask != 0 {
+		original = 1
+	}
+	if bit == 1 {
+		b[index] |= mask
+	} else {
+		b[index] &^= mask
+	}
+	r.setItem(key, ExpirationItem{value: b, expiration: item.expiration})
+	r.notifyEvent(notifyString, "setbit", key)
+	return original, nil
+}
+
+// GetBit returns the bit at offset, bits past the end of the string are 0.
+func (r *Store) GetBit(key string, offset uint64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, _, err := r.bytesForRead(key)
+	if err != nil {
+		return 0, err
+	}
+	if offset/8 >= uint64(len(b)) {
+		return 0, nil
+	}
+	return int(b[offset/8]>>(7-offset%8)) & 1, nil
+}
+
+// BitCount counts the set bits of the string stored at key within the given range.
+func (r *Store) BitCount(key string, rng BitRange) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, exist, err := r.bytesForRead(key)
+	if err != nil || !exist {
+		return 0, err
+	}
+	start, end, ok := normalizeBitRange(rng, len(b))
+	if !ok {
+		return 0, nil
+	}
+	var count int64
+	for i := start; i <= end; {
+		// Count whole bytes at once when the range covers them
+		if i%8 == 0 && i+7 <= end {
+			count += int64(bits.OnesCount8(b[i/8]))
+			i += 8
+			continue
+		}
+		count += int64(b[i/8]>>(7-i%8)) & 1
+		i++
+	}
+	return count, nil
+}
+
+// BitPos returns the position of the first bit set to bit within the given range.
+// When looking for a clear bit without an explicit end, the string is considered
+// padded with zeros on the right so the first bit after the string is returned.
+func (r *Store) BitPos(key string, bit int, rng BitRange) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, exist, err := r.bytesForRead(key)
+	if err != nil {
+		return 0, err
+	}
+	if !exist {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	start, end, ok := normalizeBitRange(rng, len(b))
+	if !ok {
+		return -1, nil
+	}
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := start; i <= end; {
+		// Skip whole bytes that can not contain the bit we are looking for
+		if i%8 == 0 && i+7 <= end && b[i/8] == skip {
+			i += 8
+			continue
+		}
+		if int(b[i/8]>>(7-i%8))&1 == bit {
+			return i, nil
+		}
+		i++
+	}
+	if bit == 0 && !rng.HasEnd {
+		return int64(len(b)) * 8, nil
+	}
+	return -1, nil
+}
+
+// normalizeBitRange converts rng to an inclusive range of bit positions within a string of length size.
+// It reports false when the range is empty.
+func normalizeBitRange(rng BitRange, size int) (int64, int64, bool) {
+	total := int64(size)
+	if rng.Bit {
+		total *= 8
+	}
+	start, end := int64(0), total-1
+	if rng.HasStart {
+		start = rng.Start
+	}
+	if rng.HasEnd {
+		end = rng.End
+	}
+	if start < 0 {
+		start = total + start
+	}
+	if end < 0 {
+		end = total + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	if !rng.Bit {
+		start, end = start*8, end*8+7
+	}
+	return start, end, true
+}
+
+// BitOp performs a bitwise operation between the source keys and stores the result in destKey.
+// It returns the length of the resulting string; destKey is deleted when the result is empty.
+func (r *Store) BitOp(op, destKey string, keys []string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sources := make([][]byte, len(keys))
+	maxLen := 0
+	for i, key := range keys {
+		b, _, err := r.bytesForRead(key)
+		if err != nil {
+			return 0, err
+		}
+		sources[i] = b
+		if len(b) > maxLen {
+			maxLen = len(b)
+		}
+	}
+
+	result := make([]byte, maxLen)
+	for i := range result {
+		// Missing keys and strings shorter than the longest one are treated as zero bytes
+		byteAt := func(src []byte) byte {
+			if i < len(src) {
+				return src[i]
+			}
+			return 0
+		}
+		value := byteAt(sources[0])
+		switch op {
+		case "not":
+			value = ^value
+		case "and":
+			for _, src := range sources[1:] {
+				value &= byteAt(src)
+			}
+		case "or":
+			for _, src := range sources[1:] {
+				value |= byteAt(src)
+			}
+		case "xor":
+			for _, src := range sources[1:] {
+				value ^= byteAt(src)
+			}
+		}
+		result[i] = value
+	}
+
+	if maxLen == 0 {
+		if _, exist := r.items[destKey]; exist {
+			r.deleteItem(destKey)
+			r.notifyEvent(notifyGeneric, "del", destKey)
+		}
+	} else {
+		r.setItem(destKey, ExpirationItem{value: result})
+		r.notifyEvent(notifyString, "set", destKey)
+	}
+	return maxLen, nil
+}
+
+// BitField runs the BITFIELD subcommands against the string stored at key.
+// Results are nil for operations that failed because of OVERFLOW FAIL.
+func (r *Store) BitField(key string, ops []BitFieldOp) ([]*int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Only grow (or create) the string when there is at least one write operation
+	var highest uint64
+	write := false
+	for _, op := range ops {
+		if op.Kind != "get" {
+			write = true
+			if end := op.Offset + uint64(op.Bits); end > highest {
+				highest = end
+			}
+		}
+	}
+
+	var item ExpirationItem
+	var b []byte
+	var err error
+	if write {
+		item, b, err = r.bytesForWrite(key, (highest+7)/8)
+	} else {
+		b, _, err = r.bytesForRead(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*int64, 0, len(ops))
+	for _, op := range ops {
+		var old int64
+		if op.Signed {
+			old = getSignedBitfield(b, op.Offset, op.Bits)
+		} else {
+			old = int64(getUnsignedBitfield(b, op.Offset, op.Bits))
+		}
+
+		switch op.Kind {
+		case "get":
+			results = append(results, &old)
+			continue
+		case "set":
+			value, ok := applyBitfieldOverflow(op, op.Value, 0)
+			if !ok {
+				results = append(results, nil)
+				continue
+			}
+			setUnsignedBitfield(b, op.Offset, op.Bits, uint64(value))
+			results = append(results, &old)
+		case "incrby":
+			value, ok := applyBitfieldOverflow(op, old, op.Value)
+			if !ok {
+				results = append(results, nil)
+				continue
+			}
+			setUnsignedBitfield(b, op.Offset, op.Bits, uint64(value))
+			results = append(results, &value)
+		}
+	}
+
+	if write {
+		r.setItem(key, ExpirationItem{value: b, expiration: item.expiration})
+		r.notifyEvent(notifyString, "setbit", key)
+	}
+	return results, nil
+}
+
+// applyBitfieldOverflow computes value+incr for the integer type of op, applying its overflow policy.
+// It reports false when the operation must fail.
+func applyBitfieldOverflow(op BitFieldOp, value, incr int64) (int64, bool) {
+	if op.Signed {
+		max := int64(math.MaxInt64)
+		if op.Bits < 64 {
+			max = int64(1)<<(op.Bits-1) - 1
+		}
+		min := -max - 1
+		maxIncr := int64(uint64(max) - uint64(value))
+		minIncr := min - value
+
+		overflow, limit := 0, int64(0)
+		if value > max || (op.Bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+			overflow, limit = 1, max
+		} else if value < min || (op.Bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+			overflow, limit = -1, min
+		}
+		if overflow == 0 {
+			return value + incr, true
+		}
+		switch op.Overflow {
+		case OverflowSat:
+			return limit, true
+		case OverflowFail:
+			return 0, false
+		}
+		// Wrap around, propagating the sign bit to the higher order bits
+		c := uint64(value) + uint64(incr)
+		if op.Bits < 64 {
+			mask := ^uint64(0) << op.Bits
+			if c&(uint64(1)<<(op.Bits-1)) != 0 {
+				c |= mask
+			} else {
+				c &^= mask
+			}
+		}
+		return int64(c), true
+	}
+
+	max := uint64(1)<<op.Bits - 1
+	maxIncr := int64(max - uint64(value))
+	minIncr := -value
+	overflow, limit := 0, uint64(0)
+	if uint64(value) > max || (incr > 0 && incr > maxIncr) {
+		overflow, limit = 1, max
+	} else if incr < 0 && incr < minIncr {
+		overflow, limit = -1, 0
+	}
+	if overflow == 0 {
+		return value + incr, true
+	}
+	switch op.Overflow {
+	case OverflowSat:
+		return int64(limit), true
+	case OverflowFail:
+		return 0, false
+	}
+	return int64((uint64(value) + uint64(incr)) &^ (^uint64(0) << op.Bits)), true
+}
+
+// getUnsignedBitfield reads an unsigned integer of width bits starting at bit offset.
+func getUnsignedBitfield(b []byte, offset uint64, width uint) uint64 {
+	var value uint64
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		bit := uint64(0)
+		if pos/8 < uint64(len(b)) {
+			bit = uint64(b[pos/8]>>(7-pos%8)) & 1
+		}
+		value = value<<1 | bit
+	}
+	return value
+}
+
+// getSignedBitfield reads a two's complement integer of width bits starting at bit offset.
+func getSignedBitfield(b []byte, offset uint64, width uint) int64 {
+	value := getUnsignedBitfield(b, offset, width)
+	if width < 64 && value&(uint64(1)<<(width-1)) != 0 {
+		value |= ^uint64(0) << width
+	}
+	return int64(value)
+}
+
+// setUnsignedBitfield writes the low width bits of value starting at bit offset.
+func setUnsignedBitfield(b []byte, offset uint64, width uint, value uint64) {
+	for i := uint64(0); i < uint64(width); i++ {
+		pos := offset + i
+		mask := byte(1) << (7 - pos%8)
+		if value&(uint64(1)<<(uint64(width)-1-i)) != 0 {
+			b[pos/8] |= mask
+		} else {
+			b[pos/8] &^= mask
+		}
+	}
+}
+
+// ===============================================================================
+func handleSetBit(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("setbit command requires exactly three arguments")
+	}
+	key := args[1]
+	offset, err := parseBitOffset(args[2], false, 1)
+	if err != nil {
+		return nil, err
+	}
+	if args[3] != "0" && args[3] != "1" {
+		return nil, fmt.Errorf("bit is not an integer or out of range")
+	}
+	bit, _ := strconv.Atoi(args[3])
+
+	r := currentStore(redis)
+	original, err := r.SetBit(key, offset, bit)
+	if err != nil {
+		return nil, err
+	}
+	return original, nil
+}
+
+func handleGetBit(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("getbit command requires exactly two arguments")
+	}
+	key := args[1]
+	offset, err := parseBitOffset(args[2], false, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	r := currentStore(redis)
+	bit, err := r.GetBit(key, offset)
+	if err != nil {
+		return nil, err
+	}
+	return bit, nil
+}
+
+func handleBitCount(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("bitcount command requires at least one argument")
+	}
+	key := args[1]
+	var rng BitRange
+	switch len(args) {
+	case 2:
+	case 4, 5:
+		var err error
+		if rng, err = parseBitRange(args[2:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("syntax error")
+	}
+
+	r := currentStore(redis)
+	count, err := r.BitCount(key, rng)
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+func handleBitPos(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 3 || len(args) > 6 {
+		return nil, fmt.Errorf("bitpos command requires between two and five arguments")
+	}
+	key := args[1]
+	if args[2] != "0" && args[2] != "1" {
+		return nil, fmt.Errorf("the bit argument must be 1 or 0")
+	}
+	bit, _ := strconv.Atoi(args[2])
+	rng, err := parseBitRange(args[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	r := currentStore(redis)
+	pos, err := r.BitPos(key, bit, rng)
+	if err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+func handleBitOp(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, fmt.Errorf("bitop command requires at least three arguments")
+	}
+	op, destKey, keys := strings.ToLower(args[1]), args[2], args[3:]
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("bitop not must be called with a single source key")
+		}
+	default:
+		return nil, fmt.Errorf("syntax error")
+	}
+
+	r := currentStore(redis)
+	length, err := r.BitOp(op, destKey, keys)
+	if err != nil {
+		return nil, err
+	}
+	return length, nil
+}
+
+func handleBitField(args []string, redis []*Store) (interface{}, error) {
+	return bitField(args, redis, false)
+}
+
+func handleBitFieldRO(args []string, redis []*Store) (interface{}, error) {
+	return bitField(args, redis, true)
+}
+
+func bitField(args []string, redis []*Store, readOnly bool) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("bitfield command requires at least one argument")
+	}
+	key := args[1]
+	ops := []BitFieldOp{}
+	overflow := OverflowWrap
+	for i := 2; i < len(args); i++ {
+		kind := strings.ToLower(args[i])
+		switch kind {
+		case "overflow":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("syntax error")
+			}
+			i++
+			switch strings.ToLower(args[i]) {
+			case "wrap":
+				overflow = OverflowWrap
+			case "sat":
+				overflow = OverflowSat
+			case "fail":
+				overflow = OverflowFail
+			default:
+				return nil, fmt.Errorf("invalid overflow type specified")
+			}
+			continue
+		case "get", "set", "incrby":
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+		if readOnly && kind != "get" {
+			return nil, fmt.Errorf("bitfield_ro only supports the get subcommand")
+		}
+
+		argCount := 2
+		if kind != "get" {
+			argCount = 3
+		}
+		if i+argCount >= len(args) {
+			return nil, fmt.Errorf("syntax error")
+		}
+		op := BitFieldOp{Kind: kind, Overflow: overflow}
+		var err error
+		if op.Signed, op.Bits, err = parseBitFieldType(args[i+1]); err != nil {
+			return nil, err
+		}
+		if op.Offset, err = parseBitOffset(args[i+2], true, op.Bits); err != nil {
+			return nil, err
+		}
+		if kind != "get" {
+			value, ok := parseInt64(args[i+3])
+			if !ok {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			op.Value = value
+		}
+		ops = append(ops, op)
+		i += argCount
+	}
+
+	r := currentStore(redis)
+	results, err := r.BitField(key, ops)
+	if err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(results))
+	for i, result := range results {
+		if result != nil {
+			replies[i] = *result
+		}
+	}
+	return replies, nil
+}
+
+// parseBitOffset parses a bit offset, accepting the "#N" form (N times the type width) for BITFIELD.
+func parseBitOffset(s string, hashAllowed bool, width uint) (uint64, error) {
+	multiply := false
+	if hashAllowed && strings.HasPrefix(s, "#") {
+		multiply = true
+		s = s[1:]
+	}
+	offset, ok := parseInt64(s)
+	if !ok || offset < 0 {
+		return 0, fmt.Errorf("bit offset is not an integer or out of range")
+	}
+	if multiply {
+		if offset > maxBitOffset/int64(width) {
+			return 0, fmt.Errorf("bit offset is not an integer or out of range")
+		}
+		offset *= int64(width)
+	}
+	if offset+int64(width)-1 > maxBitOffset {
+		return 0, fmt.Errorf("bit offset is not an integer or out of range")
+	}
+	return uint64(offset), nil
+}
+
+// parseBitFieldType parses a BITFIELD type such as i16 or u8. u64 is not supported, like in Redis.
+func parseBitFieldType(s string) (bool, uint, error) {
+	invalid := fmt.Errorf("invalid bitfield type. use something like i16 u8. note that u64 is not supported but i64 is")
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u' && s[0] != 'I' && s[0] != 'U') {
+		return false, 0, invalid
+	}
+	signed := s[0] == 'i' || s[0] == 'I'
+	width, err := strconv.Atoi(s[1:])
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, invalid
+	}
+	return signed, uint(width), nil
+}
+
+// parseBitRange parses the optional "[start [end [BYTE|BIT]]]" arguments of BITCOUNT and BITPOS.
+func parseBitRange(args []string) (BitRange, error) {
+	var rng BitRange
+	if len(args) >= 1 {
+		start, ok := parseInt64(args[0])
+		if !ok {
+			return rng, fmt.Errorf("value is not an integer or out of range")
+		}
+		rng.Start, rng.HasStart = start, true
+	}
+	if len(args) >= 2 {
+		end, ok := parseInt64(args[1])
+		if !ok {
+			return rng, fmt.Errorf("value is not an integer or out of range")
+		}
+		rng.End, rng.HasEnd = end, true
+	}
+	if len(args) == 3 {
+		switch strings.ToLower(args[2]) {
+		case "byte":
+		case "bit":
+			rng.Bit = true
+		default:
+			return rng, fmt.Errorf("syntax error")
+		}
+	}
+	return rng, nil
+}
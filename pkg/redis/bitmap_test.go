@@ -0,0 +1,193 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetBitAndGetBit(t *testing.T) {
+	s := NewStore()
+
+	// Test case 1: Setting a bit on a missing key creates a zero padded string
+	original, err := s.SetBit("bits", 7, 1)
+	if err != nil || original != 0 {
+		t.Errorf("Expected original bit 0, got %d (err %v)", original, err)
+	}
+	value, _ := s.Get("bits")
+	if value != "\x01" {
+		t.Errorf("Expected value \\x01, got %q", value)
+	}
+
+	// Test case 2: Growing the string keeps existing bits and the TTL
+	s.items["bits"] = ExpirationItem{value: []byte("\x01"), expiration: time.Now().Add(time.Hour)}
+	s.SetBit("bits", 100, 1)
+	if bit, _ := s.GetBit("bits", 7); bit != 1 {
+		t.Errorf("Expected bit 7 to be kept")
+	}
+	if bit, _ := s.GetBit("bits", 100); bit != 1 {
+		t.Errorf("Expected bit 100 to be set")
+	}
+	if bit, _ := s.GetBit("bits", 1000); bit != 0 {
+		t.Errorf("Expected bits past the end of the string to be 0")
+	}
+	if len(s.items["bits"].value.([]byte)) != 13 || s.items["bits"].expiration.IsZero() {
+		t.Errorf("Expected a 13 bytes string keeping its TTL")
+	}
+
+	// Test case 3: Bitmaps can not be applied to lists
+	s.LPush("list", "a")
+	if _, err := s.SetBit("list", 0, 1); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestBitCount(t *testing.T) {
+	s := NewStore()
+	s.Set("mykey", "foobar", 0)
+	cases := []struct {
+		rng      BitRange
+		expected int64
+	}{
+		{BitRange{}, 26},
+		{BitRange{Start: 0, End: 0, HasStart: true, HasEnd: true}, 4},
+		{BitRange{Start: 1, End: 1, HasStart: true, HasEnd: true}, 6},
+		{BitRange{Start: 5, End: 30, HasStart: true, HasEnd: true, Bit: true}, 17},
+		{BitRange{Start: -2, End: -1, HasStart: true, HasEnd: true}, 7},
+		{BitRange{Start: 3, End: 1, HasStart: true, HasEnd: true}, 0},
+	}
+	for _, c := range cases {
+		count, err := s.BitCount("mykey", c.rng)
+		if err != nil || count != c.expected {
+			t.Errorf("BitCount(%+v) expected %d, got %d (err %v)", c.rng, c.expected, count, err)
+		}
+	}
+}
+
+func TestBitPos(t *testing.T) {
+	s := NewStore()
+	s.Set("a", "\xff\xf0\x00", 0)
+	s.Set("b", "\x00\xff\xf0", 0)
+	s.Set("c", "\x00\x00\x00", 0)
+	s.Set("d", "\xff\xff\xff", 0)
+	cases := []struct {
+		key      string
+		bit      int
+		rng      BitRange
+		expected int64
+	}{
+		{"a", 0, BitRange{}, 12},
+		{"b", 1, BitRange{Start: 0, HasStart: true}, 8},
+		{"b", 1, BitRange{Start: 2, HasStart: true}, 16},
+		{"b", 1, BitRange{Start: 2, End: -1, HasStart: true, HasEnd: true}, 16},
+		{"b", 1, BitRange{Start: 7, End: 15, HasStart: true, HasEnd: true, Bit: true}, 8},
+		{"c", 1, BitRange{}, -1},
+		{"d", 0, BitRange{}, 24},
+		{"d", 0, BitRange{Start: 0, End: -1, HasStart: true, HasEnd: true}, -1},
+		{"missing", 0, BitRange{}, 0},
+		{"missing", 1, BitRange{}, -1},
+	}
+	for _, c := range cases {
+		pos, err := s.BitPos(c.key, c.bit, c.rng)
+		if err != nil || pos != c.expected {
+			t.Errorf("BitPos(%s, %d, %+v) expected %d, got %d (err %v)", c.key, c.bit, c.rng, c.expected, pos, err)
+		}
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	s := NewStore()
+	s.Set("key1", "foobar", 0)
+	s.Set("key2", "abcdef", 0)
+
+	length, err := s.BitOp("and", "dest", []string{"key1", "key2"})
+	if err != nil || length != 6 {
+		t.Errorf("Expected length 6, got %d (err %v)", length, err)
+	}
+	if value, _ := s.Get("dest"); value != "`bc`ab" {
+		t.Errorf("Expected `bc`ab, got %q", value)
+	}
+
+	s.Set("short", "\xff", 0)
+	s.BitOp("or", "dest", []string{"short", "missing", "key2"})
+	if value, _ := s.Get("dest"); value != "\xffbcdef" {
+		t.Errorf("Expected missing keys to be treated as zeros, got %q", value)
+	}
+
+	s.BitOp("not", "dest", []string{"short"})
+	if value, _ := s.Get("dest"); value != "\x00" {
+		t.Errorf("Expected \\x00, got %q", value)
+	}
+
+	s.BitOp("xor", "dest", []string{"missing"})
+	if _, err := s.Get("dest"); err == nil {
+		t.Errorf("Expected an empty result to delete the destination key")
+	}
+}
+
+func TestBitField(t *testing.T) {
+	s := NewStore()
+	run := func(ops ...BitFieldOp) []*int64 {
+		results, err := s.BitField("mykey", ops)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return results
+	}
+
+	// Test case 1: INCRBY then GET, from the Redis documentation
+	results := run(BitFieldOp{Kind: "incrby", Signed: true, Bits: 5, Offset: 100, Value: 1}, BitFieldOp{Kind: "get", Bits: 4, Offset: 0})
+	if *results[0] != 1 || *results[1] != 0 {
+		t.Errorf("Expected [1 0], got [%d %d]", *results[0], *results[1])
+	}
+
+	// Test case 2: WRAP and SAT overflow policies
+	expected := [][2]int64{{1, 1}, {2, 2}, {3, 3}, {0, 3}}
+	for _, e := range expected {
+		results = run(
+			BitFieldOp{Kind: "incrby", Bits: 2, Offset: 200, Value: 1},
+			BitFieldOp{Kind: "incrby", Bits: 2, Offset: 202, Value: 1, Overflow: OverflowSat},
+		)
+		if *results[0] != e[0] || *results[1] != e[1] {
+			t.Errorf("Expected %v, got [%d %d]", e, *results[0], *results[1])
+		}
+	}
+
+	// Test case 3: FAIL overflow returns nil and leaves the value untouched
+	results = run(BitFieldOp{Kind: "incrby", Bits: 2, Offset: 202, Value: 1, Overflow: OverflowFail})
+	if results[0] != nil {
+		t.Errorf("Expected nil, got %d", *results[0])
+	}
+
+	// Test case 4: signed SET returns the old value and wraps with sign extension
+	results = run(BitFieldOp{Kind: "set", Signed: true, Bits: 8, Offset: 0, Value: 200}, BitFieldOp{Kind: "get", Signed: true, Bits: 8, Offset: 0})
+	if *results[0] != 0 || *results[1] != -56 {
+		t.Errorf("Expected [0 -56], got [%d %d]", *results[0], *results[1])
+	}
+	results = run(BitFieldOp{Kind: "incrby", Signed: true, Bits: 64, Offset: 300, Value: 1 << 62}, BitFieldOp{Kind: "incrby", Signed: true, Bits: 64, Offset: 300, Value: 1 << 62, Overflow: OverflowSat})
+	if *results[1] != 1<<63-1 {
+		t.Errorf("Expected i64 saturation, got %d", *results[1])
+	}
+}
+
+func TestParseBitOffset(t *testing.T) {
+	if offset, err := parseBitOffset("#2", true, 8); err != nil || offset != 16 {
+		t.Errorf("Expected offset 16, got %d (err %v)", offset, err)
+	}
+	for _, input := range []string{"-1", "#2", "4294967296", "a"} {
+		if _, err := parseBitOffset(input, false, 1); err == nil {
+			t.Errorf("Expected %q to be rejected", input)
+		}
+	}
+}
+
+func TestDiscardKeepsBitmaps(t *testing.T) {
+	server := New(DefaultConfig())
+	server.dbs[0].Set("bits", "\x00", 0)
+	client := newTestConnClient(server, discardConn{})
+	for _, args := range [][]string{{"multi"}, {"setbit", "bits", "0", "1"}, {"discard"}} {
+		client.execute(args)
+	}
+	if bit, _ := server.dbs[0].GetBit("bits", 0); bit != 0 {
+		t.Errorf("Expected a discarded transaction not to modify the bitmap")
+	}
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"sync"
+	"time"
+)
+
+// Blocking commands, such as WAIT, block the goroutine of their client until the condition they wait
+// for holds or their timeout expires. The events that may unblock a client, such as a replica
+// acknowledging the stream, call signalBlocked, which wakes the blocked clients to check their
+// condition again.
+
+// blockedClient is a client blocked by a command.
+type blockedClient struct {
+	client *ClientDetail
+	wake   chan struct{}
+}
+
+// blockedState is the set of the blocked clients.
+type blockedState struct {
+	mutex   sync.Mutex
+	clients map[*blockedClient]bool
+}
+
+// blockClient blocks the client until ready reports true or the timeout expires, forever when
+// timeout is zero. It returns the last result of ready. A client running the commands of a
+// transaction does not block: it holds the writeMutex until EXEC returns.
+func (r *RedisServer) blockClient(client *ClientDetail, timeout time.Duration, ready func() bool) bool {
+	if client.inTransaction() {
+		return ready()
+	}
+	b := &blockedClient{client: client, wake: make(chan struct{}, 1)}
+	// The client is registered before checking ready, so that no event is missed.
+	r.blocked.mutex.Lock()
+	if r.blocked.clients == nil {
+		r.blocked.clients = make(map[*blockedClient]bool)
+	}
+	r.blocked.clients[b] = true
+	r.blocked.mutex.Unlock()
+	defer func() {
+		r.blocked.mutex.Lock()
+		delete(r.blocked.clients, b)
+		r.blocked.mutex.Unlock()
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for !ready() {
+		select {
+		case <-b.wake:
+		case <-expired:
+			return ready()
+		}
+	}
+	return true
+}
+
+// signalBlocked wakes the blocked clients to check their condition again.
+func (r *RedisServer) signalBlocked() {
+	r.blocked.mutex.Lock()
+	defer r.blocked.mutex.Unlock()
+	for b := range r.blocked.clients {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// blockedClients returns the number of blocked clients.
+func (r *RedisServer) blockedClients() int {
+	r.blocked.mutex.Lock()
+	defer r.blocked.mutex.Unlock()
+	return len(r.blocked.clients)
+}
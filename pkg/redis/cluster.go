@@ -0,0 +1,1065 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// In cluster mode the keys are spread over 16384 hash slots, each one served by a node of the
+// cluster. The slot of a key is the CRC16 of the key, or of its hash tag: the part between the first
+// '{' and the next '}' when it is not empty, so that related keys such as {user1}.name and
+// {user1}.age share a slot. A command whose keys belong to a slot served by another node is refused
+// with a MOVED error giving the address of that node, which cluster-aware clients follow. The nodes
+// tell each other which slots they serve, and which nodes they cannot reach, with the messages of
+// the cluster bus, see clusterbus.go, and keep the configuration of the cluster in nodes.conf.
+//
+// A slot moves to another node while it is served: the source node is MIGRATING it and the target
+// IMPORTING it, see CLUSTER SETSLOT, while its keys are sent to the target with MIGRATE. Meanwhile,
+// the source replies ASK for the keys it no longer holds, and the target runs the commands on the
+// slot sent after ASKING. Once the slot is empty on the source, both nodes assign it to the target.
+
+const clusterSlots = 16384
+
+// crc16Table is the table of the CRC16 variant used by Redis Cluster (XMODEM, polynomial 0x1021).
+var crc16Table = func() [256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		crc := uint16(i) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func crc16(s string) uint16 {
+	crc := uint16(0)
+	for i := 0; i < len(s); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^s[i]]
+	}
+	return crc
+}
+
+// keyHashSlot returns the hash slot of key.
+func keyHashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) & (clusterSlots - 1))
+}
+
+// slotKeys indexes the keys of a database by hash slot, for CLUSTER COUNTKEYSINSLOT and
+// GETKEYSINSLOT.
+type slotKeys struct {
+	slots [clusterSlots]map[string]struct{}
+}
+
+func newSlotKeys() *slotKeys {
+	return &slotKeys{}
+}
+
+func (s *slotKeys) add(key string) {
+	slot := keyHashSlot(key)
+	if s.slots[slot] == nil {
+		s.slots[slot] = map[string]struct{}{}
+	}
+	s.slots[slot][key] = struct{}{}
+}
+
+func (s *slotKeys) remove(key string) {
+	slot := keyHashSlot(key)
+	delete(s.slots[slot], key)
+	if len(s.slots[slot]) == 0 {
+		s.slots[slot] = nil
+	}
+}
+
+// countKeysInSlot returns the number of keys of the database in slot.
+func (r *Store) countKeysInSlot(slot int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.slotKeys.slots[slot])
+}
+
+// keysInSlot returns up to count keys of the database in slot, sorted.
+func (r *Store) keysInSlot(slot, count int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := make([]string, 0, len(r.slotKeys.slots[slot]))
+	for key := range r.slotKeys.slots[slot] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+	return keys
+}
+
+// deleteKeysInSlot deletes the keys of the database in slot and returns their number.
+func (r *Store) deleteKeysInSlot(slot int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deleted := 0
+	for key := range r.slotKeys.slots[slot] {
+		r.deleteItem(key)
+		r.notifyEvent(notifyGeneric, "del", key)
+		deleted++
+	}
+	return deleted
+}
+
+// keySpec tells which arguments of a command are keys: from first to last, -1 meaning the last
+// argument.
+type keySpec struct {
+	first, last int
+}
+
+var commandKeySpecs = map[CommandType]keySpec{
+	getCommand: {1, 1}, setCommand: {1, 1}, getSetCommand: {1, 1}, increCommand: {1, 1},
+	increByCommand: {1, 1}, increByFloatCommand: {1, 1}, decrCommand: {1, 1}, decrByCommand: {1, 1},
+	strLengthCommand: {1, 1}, setAndExpireCommand: {1, 1}, lpushCommand: {1, 1}, lrangeCommand: {1, 1},
+	lpopCommand: {1, 1}, setBitCommand: {1, 1}, getBitCommand: {1, 1}, bitCountCommand: {1, 1},
+	bitPosCommand: {1, 1}, bitFieldCommand: {1, 1}, bitFieldROCommand: {1, 1}, pfAddCommand: {1, 1},
+	pfDebugCommand: {2, 2}, zAddCommand: {1, 1}, zRemCommand: {1, 1}, zScoreCommand: {1, 1},
+	zCardCommand: {1, 1}, zRangeCommand: {1, 1}, geoAddCommand: {1, 1}, geoPosCommand: {1, 1},
+	geoDistCommand: {1, 1}, geoHashCommand: {1, 1}, geoSearchCommand: {1, 1}, typeCommand: {1, 1},
+	expireCommand: {1, 1}, pExpireCommand: {1, 1}, expireAtCommand: {1, 1}, pExpireAtCommand: {1, 1},
+	ttlCommand: {1, 1}, pTTLCommand: {1, 1}, expireTimeCommand: {1, 1}, pExpireTimeCommand: {1, 1},
+	persistCommand: {1, 1}, moveCommand: {1, 1}, objectCommand: {2, 2}, memoryCommand: {2, 2},
+	dumpCommand: {1, 1}, restoreCommand: {1, 1}, restoreAskingCommand: {1, 1}, sPublishCommand: {1, 1},
+
+	deleteCommand: {1, -1}, unlinkCommand: {1, -1}, existsCommand: {1, -1}, touchCommand: {1, -1},
+	pfCountCommand: {1, -1}, pfMergeCommand: {1, -1}, bitOpCommand: {2, -1}, sSubscribeCommand: {1, -1},
+	sUnsubscribeCommand: {1, -1},
+
+	renameCommand: {1, 2}, renameNXCommand: {1, 2}, copyCommand: {1, 2}, geoSearchStoreCommand: {1, 2},
+}
+
+// commandKeys returns the keys of the command made of args.
+func commandKeys(commandType CommandType, args []string) []string {
+	if commandType == migrateCommand {
+		return migrateKeys(args)
+	}
+	spec, exist := commandKeySpecs[commandType]
+	if !exist || spec.first >= len(args) {
+		return nil
+	}
+	last := spec.last
+	if last < 0 || last >= len(args) {
+		last = len(args) - 1
+	}
+	return args[spec.first : last+1]
+}
+
+// clusterNode is a node of the cluster, this server included.
+type clusterNode struct {
+	id          string
+	ip          string
+	port, cport int // ports of the clients and of the cluster bus
+	myself      bool
+	handshake   bool // the node was met but did not reply yet, its ID is a random one until then
+	meet        bool // MEET is sent to the node instead of PING, so that it adds this node
+	pfail       bool // the node did not reply to PING for the node timeout
+	fail        bool // enough nodes agree the node is failing
+	deleted     bool
+	configEpoch int64
+	master      *clusterNode // primary the node replicates, nil for a primary
+
+	pingSent     time.Time // time of the PING waiting for a PONG, zero when none
+	pongReceived time.Time
+	link         *clusterLink // outbound connection to the bus of the node
+	connecting   bool
+	failReports  map[string]time.Time // time each node reported the node failing, by node ID
+	created      time.Time
+}
+
+func newClusterNode(id, ip string, port, cport int) *clusterNode {
+	return &clusterNode{id: id, ip: ip, port: port, cport: cport, failReports: map[string]time.Time{}, created: time.Now()}
+}
+
+// flags returns the flags of the node, as reported by CLUSTER NODES.
+func (n *clusterNode) flags() string {
+	if n.handshake {
+		return "handshake"
+	}
+	flags := "master"
+	if n.master != nil {
+		flags = "slave"
+	}
+	if n.myself {
+		flags = "myself," + flags
+	}
+	if n.fail {
+		flags += ",fail"
+	} else if n.pfail {
+		flags += ",fail?"
+	}
+	return flags
+}
+
+// masterID returns the ID of the primary of the node, "-" for a primary.
+func (n *clusterNode) masterID() string {
+	if n.master == nil {
+		return "-"
+	}
+	return n.master.id
+}
+
+// clusterState is the configuration of the cluster as seen by the server.
+type clusterState struct {
+	mutex        sync.Mutex
+	myself       *clusterNode
+	nodes        map[string]*clusterNode
+	slots        [clusterSlots]*clusterNode
+	currentEpoch int64
+	ok           bool                       // every slot is served by a node that is not failing
+	migrating    [clusterSlots]*clusterNode // target of each slot this node is migrating
+	importing    [clusterSlots]*clusterNode // source of each slot this node is importing
+	forgotten    map[string]time.Time       // nodes removed with CLUSTER FORGET, not added again until then
+	listener     net.Listener               // listener of the cluster bus
+	closed       bool
+	sent         int64 // messages of the cluster bus
+	received     int64
+}
+
+// clusterConfigPath returns the path of the file keeping the configuration of the cluster.
+func (c *Config) clusterConfigPath() string {
+	return filepath.Join(c.Dir, c.ClusterConfigFile)
+}
+
+// clusterPorts returns the port of the clients and the one of the cluster bus: ClusterPort, or the
+// port of the clients plus 10000 like in Redis.
+func (c *Config) clusterPorts() (int, int, error) {
+	_, p, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return 0, 0, err
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port %q", p)
+	}
+	if c.ClusterPort > 0 {
+		return port, c.ClusterPort, nil
+	}
+	return port, port + 10000, nil
+}
+
+// startCluster loads the configuration of the cluster, or creates a new node when there is none,
+// and starts the cluster bus.
+func (r *RedisServer) startCluster() error {
+	config := r.getConfig()
+	port, cport, err := config.clusterPorts()
+	if err != nil {
+		return err
+	}
+	c := r.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	loaded, err := r.loadClusterConfig(config.clusterConfigPath())
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		// The IP of the node is learned from the first node meeting it when it listens on all the
+		// interfaces.
+		ip := ""
+		if host, _, _ := net.SplitHostPort(config.Addr); net.ParseIP(host) != nil && !net.ParseIP(host).IsUnspecified() {
+			ip = host
+		}
+		c.myself = newClusterNode(newReplicationID(), ip, port, cport)
+		c.myself.myself = true
+		c.nodes[c.myself.id] = c.myself
+		fmt.Printf("No cluster configuration found, I'm %s\n", c.myself.id)
+	}
+	c.myself.port, c.myself.cport = port, cport
+	if err := r.saveClusterConfig(); err != nil {
+		return err
+	}
+	if m := c.myself.master; m != nil {
+		r.ReplicaOf(m.ip, m.port)
+	}
+	r.updateClusterState()
+	host, _, _ := net.SplitHostPort(config.Addr)
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(cport)))
+	if err != nil {
+		return err
+	}
+	c.listener = listener
+	go r.acceptClusterBus(listener)
+	go r.clusterCron()
+	return nil
+}
+
+// loadClusterConfig loads the configuration of the cluster from path, in the format of CLUSTER
+// NODES followed by a line of variables. It reports false when the file does not exist. The caller
+// must hold r.cluster.mutex.
+func (r *RedisServer) loadClusterConfig(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	c := r.cluster
+	openSlots := []string{}
+	masters := map[*clusterNode]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					c.currentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return false, fmt.Errorf("invalid line in the cluster configuration: %q", line)
+		}
+		ip, port, cport, err := parseClusterNodeAddr(fields[1])
+		if err != nil {
+			return false, fmt.Errorf("invalid address in the cluster configuration: %q", fields[1])
+		}
+		n := newClusterNode(fields[0], ip, port, cport)
+		n.myself = strings.Contains(fields[2], "myself")
+		n.configEpoch, _ = strconv.ParseInt(fields[6], 10, 64)
+		if fields[3] != "-" {
+			masters[n] = fields[3]
+		}
+		if n.myself {
+			c.myself = n
+		}
+		c.nodes[n.id] = n
+		for _, slots := range fields[8:] {
+			if strings.HasPrefix(slots, "[") {
+				openSlots = append(openSlots, slots)
+				continue
+			}
+			start, end, err := parseSlotRange(slots)
+			if err != nil {
+				return false, fmt.Errorf("invalid slots in the cluster configuration: %q", slots)
+			}
+			for slot := start; slot <= end; slot++ {
+				c.slots[slot] = n
+			}
+		}
+	}
+	if c.myself == nil {
+		return false, fmt.Errorf("the cluster configuration %s does not describe this node", path)
+	}
+	for n, id := range masters {
+		if n.master = c.nodes[id]; n.master == nil {
+			return false, fmt.Errorf("unknown master %s in the cluster configuration", id)
+		}
+	}
+	for _, open := range openSlots {
+		if err := r.loadOpenSlot(open); err != nil {
+			return false, fmt.Errorf("invalid slots in the cluster configuration: %q", open)
+		}
+	}
+	fmt.Printf("Node configuration loaded, I'm %s\n", c.myself.id)
+	return true, nil
+}
+
+// loadOpenSlot loads a slot being migrated, "[slot->-id]", or imported, "[slot-<-id]". The caller must
+// hold r.cluster.mutex.
+func (r *RedisServer) loadOpenSlot(open string) error {
+	open = strings.TrimSuffix(strings.TrimPrefix(open, "["), "]")
+	for _, arrow := range []string{"->-", "-<-"} {
+		if i := strings.Index(open, arrow); i >= 0 {
+			slot, err := parseSlot(open[:i])
+			n := r.cluster.nodes[open[i+len(arrow):]]
+			if err != nil || n == nil {
+				return fmt.Errorf("invalid open slot")
+			}
+			if arrow == "->-" {
+				r.cluster.migrating[slot] = n
+			} else {
+				r.cluster.importing[slot] = n
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid open slot")
+}
+
+// parseClusterNodeAddr parses the address "ip:port@cport" of a node.
+func parseClusterNodeAddr(addr string) (string, int, int, error) {
+	at := strings.LastIndexByte(addr, '@')
+	colon := strings.LastIndexByte(addr, ':')
+	if at < 0 || colon < 0 || colon > at {
+		return "", 0, 0, fmt.Errorf("invalid node address")
+	}
+	port, err1 := strconv.Atoi(addr[colon+1 : at])
+	cport, err2 := strconv.Atoi(addr[at+1:])
+	if err1 != nil || err2 != nil {
+		return "", 0, 0, fmt.Errorf("invalid node address")
+	}
+	return addr[:colon], port, cport, nil
+}
+
+// parseSlotRange parses a slot, or a range of slots "start-end".
+func parseSlotRange(s string) (int, int, error) {
+	startText, endText := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		startText, endText = s[:i], s[i+1:]
+	}
+	start, err := parseSlot(startText)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseSlot(endText)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start > end {
+		return 0, 0, fmt.Errorf("invalid slot range %s", s)
+	}
+	return start, end, nil
+}
+
+func parseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= clusterSlots {
+		return 0, fmt.Errorf("invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// saveClusterConfig writes the configuration of the cluster. The caller must hold
+// r.cluster.mutex.
+func (r *RedisServer) saveClusterConfig() error {
+	c := r.cluster
+	lines := []string{}
+	for _, n := range r.sortedClusterNodes() {
+		if !n.handshake {
+			lines = append(lines, r.clusterNodeLine(n))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("vars currentEpoch %d lastVoteEpoch 0", c.currentEpoch))
+	config := r.getConfig()
+	err := writeFileAtomic(config.clusterConfigPath(), func(w io.Writer) error {
+		_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+		return err
+	})
+	if err != nil {
+		fmt.Println("Error saving the cluster configuration:", err)
+	}
+	return err
+}
+
+// sortedClusterNodes returns the nodes sorted by ID. The caller must hold r.cluster.mutex.
+func (r *RedisServer) sortedClusterNodes() []*clusterNode {
+	nodes := make([]*clusterNode, 0, len(r.cluster.nodes))
+	for _, n := range r.cluster.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].id < nodes[j].id })
+	return nodes
+}
+
+// nodeSlotRanges returns the ranges of the slots served by n, as pairs of the first and last slot
+// of each range. The caller must hold r.cluster.mutex.
+func (r *RedisServer) nodeSlotRanges(n *clusterNode) [][2]int {
+	ranges := [][2]int{}
+	for slot := 0; slot < clusterSlots; slot++ {
+		if r.cluster.slots[slot] != n {
+			continue
+		}
+		if last := len(ranges) - 1; last >= 0 && ranges[last][1] == slot-1 {
+			ranges[last][1] = slot
+		} else {
+			ranges = append(ranges, [2]int{slot, slot})
+		}
+	}
+	return ranges
+}
+
+// formatSlotRanges formats slot ranges the way parseSlotRange parses each of them.
+func formatSlotRanges(ranges [][2]int) []string {
+	formatted := make([]string, 0, len(ranges))
+	for _, rg := range ranges {
+		if rg[0] == rg[1] {
+			formatted = append(formatted, strconv.Itoa(rg[0]))
+		} else {
+			formatted = append(formatted, fmt.Sprintf("%d-%d", rg[0], rg[1]))
+		}
+	}
+	return formatted
+}
+
+// clusterNodeLine returns the line of n reported by CLUSTER NODES. The caller must hold
+// r.cluster.mutex.
+func (r *RedisServer) clusterNodeLine(n *clusterNode) string {
+	pingSent, pongReceived := int64(0), int64(0)
+	if !n.pingSent.IsZero() {
+		pingSent = n.pingSent.UnixNano() / int64(time.Millisecond)
+	}
+	if !n.pongReceived.IsZero() {
+		pongReceived = n.pongReceived.UnixNano() / int64(time.Millisecond)
+	}
+	link := "disconnected"
+	if n.myself || n.link != nil {
+		link = "connected"
+	}
+	fields := []string{n.id, fmt.Sprintf("%s:%d@%d", n.ip, n.port, n.cport), n.flags(), n.masterID(),
+		strconv.FormatInt(pingSent, 10), strconv.FormatInt(pongReceived, 10), strconv.FormatInt(n.configEpoch, 10), link}
+	fields = append(fields, formatSlotRanges(r.nodeSlotRanges(n))...)
+	if n.myself {
+		for slot := 0; slot < clusterSlots; slot++ {
+			if target := r.cluster.migrating[slot]; target != nil {
+				fields = append(fields, fmt.Sprintf("[%d->-%s]", slot, target.id))
+			}
+			if source := r.cluster.importing[slot]; source != nil {
+				fields = append(fields, fmt.Sprintf("[%d-<-%s]", slot, source.id))
+			}
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// updateClusterState computes whether the cluster is ok: every slot is served by a node that is not
+// failing, and drops the shard channels of the slots the node no longer serves. The caller must hold
+// r.cluster.mutex.
+func (r *RedisServer) updateClusterState() {
+	c := r.cluster
+	ok := true
+	for _, n := range c.slots {
+		if n == nil || n.fail {
+			ok = false
+			break
+		}
+	}
+	if ok != c.ok {
+		c.ok = ok
+		state := "fail"
+		if ok {
+			state = "ok"
+		}
+		fmt.Println("Cluster state changed:", state)
+	}
+	r.dropShardChannels()
+}
+
+// clusterSize returns the number of nodes serving slots. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterSize() int {
+	serving := map[*clusterNode]bool{}
+	for _, n := range r.cluster.slots {
+		if n != nil {
+			serving[n] = true
+		}
+	}
+	return len(serving)
+}
+
+// clusterRedirect returns the error refusing the command made of args when its keys do not belong
+// to a single slot, or redirecting it to the node serving their slot. It returns "" when the server
+// runs the command. asking is set when the client sent ASKING before the command.
+func (r *RedisServer) clusterRedirect(commandType CommandType, args []string, asking bool) string {
+	keys := commandKeys(commandType, args)
+	if len(keys) == 0 {
+		return ""
+	}
+	slot := keyHashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if keyHashSlot(key) != slot {
+			return "CROSSSLOT Keys in request don't hash to the same slot"
+		}
+	}
+	c := r.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.ok {
+		return "CLUSTERDOWN The cluster is down"
+	}
+	n := c.slots[slot]
+	if n == nil {
+		return "CLUSTERDOWN Hash slot not served"
+	}
+	// A replica serves the shard channels of the slots of its primary, which streams it their messages.
+	if n == c.myself.master && (commandType == sSubscribeCommand || commandType == sUnsubscribeCommand) {
+		return ""
+	}
+	migrating, importing := c.migrating[slot], c.importing[slot]
+	if n == c.myself && migrating == nil {
+		return ""
+	}
+	if n != c.myself && importing == nil {
+		return fmt.Sprintf("MOVED %d %s:%d", slot, n.ip, n.port)
+	}
+	// MIGRATE runs on the node holding the keys while the slot moves.
+	if commandType == migrateCommand {
+		return ""
+	}
+	// Shard channels are not keys: they stay on the owner of their slot until it moves.
+	missing := 0
+	if commandType != sSubscribeCommand && commandType != sUnsubscribeCommand && commandType != sPublishCommand {
+		missing = len(keys) - r.dbs[0].Exists(keys...)
+	}
+	if n == c.myself {
+		// The keys missing from the slot being migrated may already be on the target.
+		if missing > 0 {
+			return fmt.Sprintf("ASK %d %s:%d", slot, migrating.ip, migrating.port)
+		}
+		return ""
+	}
+	if asking || commandType == restoreAskingCommand {
+		if len(keys) > 1 && missing > 0 {
+			return "TRYAGAIN Multiple keys request during rehashing of slot"
+		}
+		return ""
+	}
+	return fmt.Sprintf("MOVED %d %s:%d", slot, n.ip, n.port)
+}
+
+// infoCluster returns the lines of the cluster section of INFO.
+func (r *RedisServer) infoCluster() []string {
+	return []string{fmt.Sprintf("cluster_enabled:%d", boolToInt(r.cluster != nil))}
+}
+
+// ===============================================================================
+func handleCluster(args []string, client *ClientDetail) (interface{}, error) {
+	r := client.server
+	if r.cluster == nil {
+		return nil, fmt.Errorf("this instance has cluster support disabled")
+	}
+	if len(args) < 2 {
+		return nil, fmt.Errorf("cluster command requires a subcommand")
+	}
+	subcommand, args := strings.ToLower(args[1]), args[2:]
+	switch subcommand {
+	case "keyslot":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cluster keyslot requires a key")
+		}
+		return keyHashSlot(args[0]), nil
+	case "countkeysinslot":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cluster countkeysinslot requires a slot")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return r.dbs[0].countKeysInSlot(slot), nil
+	case "getkeysinslot":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("cluster getkeysinslot requires a slot and a count")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid number of keys")
+		}
+		return r.dbs[0].keysInSlot(slot, count), nil
+	}
+
+	c := r.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	switch subcommand {
+	case "myid":
+		return c.myself.id, nil
+	case "info":
+		return r.clusterInfo(), nil
+	case "nodes":
+		lines := []string{}
+		for _, n := range r.sortedClusterNodes() {
+			lines = append(lines, r.clusterNodeLine(n))
+		}
+		return strings.Join(lines, "\n"), nil
+	case "slots":
+		return r.clusterSlotsReply(), nil
+	case "shards":
+		return r.clusterShardsReply(), nil
+	case "meet":
+		return r.clusterMeet(args)
+	case "setslot":
+		return r.clusterSetSlot(args)
+	case "replicate":
+		return r.clusterReplicate(args)
+	case "addslots", "delslots", "addslotsrange", "delslotsrange":
+		return r.clusterSetSlots(subcommand, args)
+	case "flushslots":
+		if r.dbs[0].DBSize() > 0 {
+			return nil, fmt.Errorf("db must be empty to perform cluster flushslots")
+		}
+		for slot, n := range c.slots {
+			if n == c.myself {
+				c.slots[slot] = nil
+			}
+		}
+	case "set-config-epoch":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cluster set-config-epoch requires an epoch")
+		}
+		epoch, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || epoch < 0 {
+			return nil, fmt.Errorf("invalid config epoch specified: %s", args[0])
+		}
+		if len(c.nodes) > 1 {
+			return nil, fmt.Errorf("the user can assign a config epoch only when the node does not know any other node")
+		}
+		if c.myself.configEpoch != 0 {
+			return nil, fmt.Errorf("node config epoch is already non-zero")
+		}
+		c.myself.configEpoch = epoch
+		if c.currentEpoch < epoch {
+			c.currentEpoch = epoch
+		}
+	case "bumpepoch":
+		if bumped := r.bumpConfigEpoch(); !bumped {
+			return resp.SimpleString(fmt.Sprintf("STILL %d", c.myself.configEpoch)), r.saveClusterConfig()
+		}
+		return resp.SimpleString(fmt.Sprintf("BUMPED %d", c.myself.configEpoch)), r.saveClusterConfig()
+	case "forget":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cluster forget requires a node ID")
+		}
+		n, exist := c.nodes[args[0]]
+		if !exist {
+			return nil, fmt.Errorf("unknown node %s", args[0])
+		}
+		if n.myself {
+			return nil, fmt.Errorf("i tried hard but i can't forget myself")
+		}
+		if n == c.myself.master {
+			return nil, fmt.Errorf("can't forget my master")
+		}
+		r.deleteClusterNode(n)
+		c.forgotten[n.id] = time.Now().Add(time.Minute)
+	default:
+		return nil, fmt.Errorf("unknown subcommand '%s'", subcommand)
+	}
+	r.updateClusterState()
+	if err := r.saveClusterConfig(); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// bumpConfigEpoch gives this node a new config epoch, greater than the ones of the other nodes,
+// unless it already has the greatest one. The caller must hold r.cluster.mutex.
+func (r *RedisServer) bumpConfigEpoch() bool {
+	c := r.cluster
+	if c.myself.configEpoch != 0 && c.myself.configEpoch == c.currentEpoch {
+		return false
+	}
+	c.currentEpoch++
+	c.myself.configEpoch = c.currentEpoch
+	return true
+}
+
+// clusterMeet adds the node at the address of args, "ip port [cport]", to the cluster. The caller
+// must hold r.cluster.mutex.
+func (r *RedisServer) clusterMeet(args []string) (interface{}, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, fmt.Errorf("cluster meet requires an ip and a port")
+	}
+	port, err := strconv.Atoi(args[1])
+	cport := port + 10000
+	if len(args) == 3 && err == nil {
+		cport, err = strconv.Atoi(args[2])
+	}
+	if err != nil || net.ParseIP(args[0]) == nil || port < 1 || port > 65535 || cport < 1 || cport > 65535 {
+		return nil, fmt.Errorf("invalid node address specified: %s:%s", args[0], args[1])
+	}
+	r.clusterStartHandshake(args[0], port, cport)
+	return resp.SimpleString("OK"), nil
+}
+
+// clusterReplicate makes this node a replica of the node of args, a primary: it gets the keys of the
+// primary and the messages published on its shard channels through the replication. The caller must
+// hold r.cluster.mutex.
+func (r *RedisServer) clusterReplicate(args []string) (interface{}, error) {
+	c := r.cluster
+	if len(args) != 1 {
+		return nil, fmt.Errorf("cluster replicate requires a node ID")
+	}
+	n := c.nodes[args[0]]
+	if n == nil || n.handshake {
+		return nil, fmt.Errorf("unknown node %s", args[0])
+	}
+	if n.myself {
+		return nil, fmt.Errorf("can't replicate myself")
+	}
+	if n.master != nil {
+		return nil, fmt.Errorf("i can only replicate a master, not a replica")
+	}
+	if c.myself.master == nil && (len(r.nodeSlotRanges(c.myself)) > 0 || r.dbs[0].DBSize() > 0) {
+		return nil, fmt.Errorf("to set a master the node must be empty and without assigned slots")
+	}
+	c.myself.master = n
+	r.ReplicaOf(n.ip, n.port)
+	r.updateClusterState()
+	if err := r.saveClusterConfig(); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// clusterSetSlots assigns slots to this node, or unassigns them, for the subcommands ADDSLOTS,
+// DELSLOTS, ADDSLOTSRANGE and DELSLOTSRANGE. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterSetSlots(subcommand string, args []string) (interface{}, error) {
+	c := r.cluster
+	slots := []int{}
+	if strings.HasSuffix(subcommand, "range") {
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, fmt.Errorf("cluster %s requires pairs of a start and an end slot", subcommand)
+		}
+		for i := 0; i < len(args); i += 2 {
+			start, end, err := parseSlotRange(args[i] + "-" + args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			for slot := start; slot <= end; slot++ {
+				slots = append(slots, slot)
+			}
+		}
+	} else {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("cluster %s requires slots", subcommand)
+		}
+		for _, arg := range args {
+			slot, err := parseSlot(arg)
+			if err != nil {
+				return nil, err
+			}
+			slots = append(slots, slot)
+		}
+	}
+	add := strings.HasPrefix(subcommand, "add")
+	seen := map[int]bool{}
+	for _, slot := range slots {
+		if seen[slot] {
+			return nil, fmt.Errorf("slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+		if add && c.slots[slot] != nil {
+			return nil, fmt.Errorf("slot %d is already busy", slot)
+		}
+		if !add && c.slots[slot] == nil {
+			return nil, fmt.Errorf("slot %d is already unassigned", slot)
+		}
+	}
+	for _, slot := range slots {
+		if add {
+			c.slots[slot] = c.myself
+		} else {
+			c.slots[slot] = nil
+		}
+	}
+	r.updateClusterState()
+	if err := r.saveClusterConfig(); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// clusterSetSlot changes the state of a slot being moved to another node, for CLUSTER SETSLOT slot
+// MIGRATING|IMPORTING|NODE id or STABLE. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterSetSlot(args []string) (interface{}, error) {
+	c := r.cluster
+	if len(args) < 2 {
+		return nil, fmt.Errorf("cluster setslot requires a slot and a state")
+	}
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return nil, err
+	}
+	state := strings.ToLower(args[1])
+	var n *clusterNode
+	if state == "stable" {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("syntax error")
+		}
+	} else {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		if n = c.nodes[args[2]]; n == nil || n.handshake {
+			return nil, fmt.Errorf("i don't know about node %s", args[2])
+		}
+	}
+	switch state {
+	case "migrating":
+		if c.slots[slot] != c.myself {
+			return nil, fmt.Errorf("i'm not the owner of hash slot %d", slot)
+		}
+		if n == c.myself {
+			return nil, fmt.Errorf("can't migrate hash slot %d to myself", slot)
+		}
+		c.migrating[slot] = n
+	case "importing":
+		if c.slots[slot] == c.myself {
+			return nil, fmt.Errorf("i'm already the owner of hash slot %d", slot)
+		}
+		if n == c.myself {
+			return nil, fmt.Errorf("can't import hash slot %d from myself", slot)
+		}
+		c.importing[slot] = n
+	case "stable":
+		c.migrating[slot], c.importing[slot] = nil, nil
+	case "node":
+		keys := r.dbs[0].countKeysInSlot(slot)
+		if c.slots[slot] == c.myself && n != c.myself && keys > 0 {
+			return nil, fmt.Errorf("can't assign hash slot %d to a different node while i still hold keys for this hash slot", slot)
+		}
+		if keys == 0 {
+			c.migrating[slot] = nil
+		}
+		if n == c.myself && c.importing[slot] != nil {
+			// The imported slot is claimed with a new config epoch, so that the other nodes give it
+			// to this node rather than to its former owner.
+			c.importing[slot] = nil
+			if r.bumpConfigEpoch() {
+				fmt.Printf("configEpoch updated after importing slot %d: %d\n", slot, c.myself.configEpoch)
+			}
+		}
+		c.slots[slot] = n
+	default:
+		return nil, fmt.Errorf("invalid cluster setslot action or number of arguments")
+	}
+	r.updateClusterState()
+	if err := r.saveClusterConfig(); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// clusterInfo returns the report of CLUSTER INFO. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterInfo() string {
+	c := r.cluster
+	assigned, pfail, fail := 0, 0, 0
+	for _, n := range c.slots {
+		switch {
+		case n == nil:
+		case n.fail:
+			fail++
+		case n.pfail:
+			pfail++
+		}
+		if n != nil {
+			assigned++
+		}
+	}
+	state := "fail"
+	if c.ok {
+		state = "ok"
+	}
+	return strings.Join([]string{
+		"cluster_state:" + state,
+		fmt.Sprintf("cluster_slots_assigned:%d", assigned),
+		fmt.Sprintf("cluster_slots_ok:%d", assigned-pfail-fail),
+		fmt.Sprintf("cluster_slots_pfail:%d", pfail),
+		fmt.Sprintf("cluster_slots_fail:%d", fail),
+		fmt.Sprintf("cluster_known_nodes:%d", len(c.nodes)),
+		fmt.Sprintf("cluster_size:%d", r.clusterSize()),
+		fmt.Sprintf("cluster_current_epoch:%d", c.currentEpoch),
+		fmt.Sprintf("cluster_my_epoch:%d", c.myself.configEpoch),
+		fmt.Sprintf("cluster_stats_messages_sent:%d", c.sent),
+		fmt.Sprintf("cluster_stats_messages_received:%d", c.received),
+	}, "\n")
+}
+
+// clusterSlotsReply returns the reply of CLUSTER SLOTS: the ranges of slots with the address and
+// the ID of the node serving them, followed by the ones of its replicas. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterSlotsReply() []interface{} {
+	c := r.cluster
+	reply := []interface{}{}
+	for start := 0; start < clusterSlots; {
+		n := c.slots[start]
+		end := start
+		for end+1 < clusterSlots && c.slots[end+1] == n {
+			end++
+		}
+		if n != nil {
+			entry := []interface{}{start, end, []interface{}{n.ip, n.port, n.id}}
+			for _, replica := range r.clusterReplicas(n) {
+				entry = append(entry, []interface{}{replica.ip, replica.port, replica.id})
+			}
+			reply = append(reply, entry)
+		}
+		start = end + 1
+	}
+	return reply
+}
+
+// clusterReplicas returns the replicas of the node n sorted by ID. The caller must hold
+// r.cluster.mutex.
+func (r *RedisServer) clusterReplicas(n *clusterNode) []*clusterNode {
+	replicas := []*clusterNode{}
+	for _, other := range r.sortedClusterNodes() {
+		if other.master == n && !other.handshake {
+			replicas = append(replicas, other)
+		}
+	}
+	return replicas
+}
+
+// clusterShardsReply returns the reply of CLUSTER SHARDS: the slots of each primary and the state of
+// the primary and of its replicas. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterShardsReply() []interface{} {
+	reply := []interface{}{}
+	for _, n := range r.sortedClusterNodes() {
+		if n.handshake || n.master != nil {
+			continue
+		}
+		slots := []interface{}{}
+		for _, rg := range r.nodeSlotRanges(n) {
+			slots = append(slots, rg[0], rg[1])
+		}
+		nodes := []interface{}{r.clusterShardNode(n)}
+		for _, replica := range r.clusterReplicas(n) {
+			nodes = append(nodes, r.clusterShardNode(replica))
+		}
+		reply = append(reply, []interface{}{"slots", slots, "nodes", nodes})
+	}
+	return reply
+}
+
+// clusterShardNode returns the description of the node n in the reply of CLUSTER SHARDS. The caller
+// must hold r.cluster.mutex.
+func (r *RedisServer) clusterShardNode(n *clusterNode) []interface{} {
+	health := "online"
+	if n.fail || n.pfail {
+		health = "failed"
+	}
+	role := "master"
+	if n.master != nil {
+		role = "replica"
+	}
+	offset := int64(0)
+	if n.myself {
+		r.repl.mutex.Lock()
+		offset = r.repl.offset
+		r.repl.mutex.Unlock()
+	}
+	return []interface{}{"id", n.id, "port", n.port, "ip", n.ip, "endpoint", n.ip, "role", role,
+		"replication-offset", offset, "health", health}
+}
@@ -0,0 +1,324 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// startTestClusterNode runs a server in cluster mode, its cluster bus on a free port.
+func startTestClusterNode(t *testing.T) *RedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	busPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	server := startTestServer(t, func(c *Config) {
+		c.ClusterEnabled = true
+		c.ClusterPort = busPort
+		c.ClusterNodeTimeout = 1000
+	})
+	if err := server.startCluster(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.cluster.listener.Close() })
+	return server
+}
+
+// call sends a command in RESP and returns its reply.
+func (c *testConn) call(args ...string) interface{} {
+	c.t.Helper()
+	if _, err := c.conn.Write(resp.AppendCommand(nil, args...)); err != nil {
+		c.t.Fatal(err)
+	}
+	reply, err := resp.NewReader(c.br).ReadReply()
+	if err != nil {
+		c.t.Fatalf("%v: %v", args, err)
+	}
+	return reply
+}
+
+func clusterOK(server *RedisServer) bool {
+	server.cluster.mutex.Lock()
+	defer server.cluster.mutex.Unlock()
+	return server.cluster.ok && len(server.cluster.nodes) == 2
+}
+
+// meetTestNodes has a meet b through client, a connection to a, and waits for both to see the cluster
+// ok.
+func meetTestNodes(t *testing.T, client *testConn, a, b *RedisServer) {
+	t.Helper()
+	_, port, _ := net.SplitHostPort(b.getConfig().Addr)
+	if reply := client.call("CLUSTER", "MEET", "127.0.0.1", port, fmt.Sprint(b.getConfig().ClusterPort)); reply != "OK" {
+		t.Fatalf("CLUSTER MEET = %v", reply)
+	}
+	waitFor(t, "the nodes to meet", func() bool { return clusterOK(a) && clusterOK(b) })
+}
+
+func TestKeyHashSlot(t *testing.T) {
+	// An empty hash tag is not a hash tag: the whole key is hashed.
+	for key, slot := range map[string]int{"foo": 12182, "123456789": 12739, "": 0, "{user1}.name": keyHashSlot("user1"),
+		"{}foo": int(crc16("{}foo") % clusterSlots), "foo{}{bar}": int(crc16("foo{}{bar}") % clusterSlots), "foo{{bar}}": keyHashSlot("{bar")} {
+		if got := keyHashSlot(key); got != slot {
+			t.Errorf("keyHashSlot(%q) = %d, want %d", key, got, slot)
+		}
+	}
+	if keyHashSlot("{user1}.name") != keyHashSlot("{user1}.age") {
+		t.Error("Keys with the same hash tag hash to different slots")
+	}
+}
+
+func TestClusterRedirects(t *testing.T) {
+	a, b := startTestClusterNode(t), startTestClusterNode(t)
+	clientA, clientB := dialTestServer(t, a), dialTestServer(t, b)
+	if reply := clientA.call("SET", "foo", "bar"); reply != resp.Error("CLUSTERDOWN The cluster is down") {
+		t.Fatalf("SET before the slots are assigned = %v", reply)
+	}
+	clientA.call("CLUSTER", "ADDSLOTSRANGE", "0", "8191")
+	clientB.call("CLUSTER", "ADDSLOTSRANGE", "8192", "16383")
+	meetTestNodes(t, clientA, a, b)
+
+	if reply := clientA.call("SET", "foo", "bar"); reply != resp.Error("MOVED 12182 "+b.getConfig().Addr) {
+		t.Fatalf("SET on the wrong node = %v", reply)
+	}
+	if reply := clientA.call("DEL", "foo", "bar"); !strings.HasPrefix(fmt.Sprint(reply), "CROSSSLOT") {
+		t.Fatalf("DEL of keys of different slots = %v", reply)
+	}
+	for _, key := range []string{"foo", "{foo}.a", "{foo}.b"} {
+		if reply := clientB.call("SET", key, "bar"); reply != "OK" {
+			t.Fatalf("SET %s = %v", key, reply)
+		}
+	}
+	if reply := clientB.call("DEL", "{foo}.a", "{foo}.b"); reply != int64(2) {
+		t.Fatalf("DEL of keys with a hash tag = %v", reply)
+	}
+	if reply := clientB.call("CLUSTER", "COUNTKEYSINSLOT", "12182"); reply != int64(1) {
+		t.Fatalf("CLUSTER COUNTKEYSINSLOT = %v", reply)
+	}
+	if reply := fmt.Sprint(clientB.call("CLUSTER", "GETKEYSINSLOT", "12182", "10")); reply != "[foo]" {
+		t.Fatalf("CLUSTER GETKEYSINSLOT = %v", reply)
+	}
+
+	// A message published on a node reaches the subscribers of the others.
+	subscriber := dialTestServer(t, b)
+	subscriber.call("SUBSCRIBE", "news")
+	clientA.call("PUBLISH", "news", "hello")
+	if reply := fmt.Sprint(subscriber.read()); reply != "[message news hello]" {
+		t.Fatalf("Message published on another node = %v", reply)
+	}
+
+	slots := clientA.call("CLUSTER", "SLOTS").([]interface{})
+	if len(slots) != 2 || fmt.Sprint(slots[1].([]interface{})[:2]) != "[8192 16383]" {
+		t.Fatalf("CLUSTER SLOTS = %v", slots)
+	}
+	if id := clientB.call("CLUSTER", "MYID"); !strings.Contains(clientA.call("CLUSTER", "NODES").(string), id.(string)+" "+b.getConfig().Addr) {
+		t.Fatalf("CLUSTER NODES of a does not list b")
+	}
+
+	// A restarted node loads the configuration of the cluster.
+	restarted := New(a.getConfig())
+	config := a.getConfig()
+	restarted.cluster.mutex.Lock()
+	defer restarted.cluster.mutex.Unlock()
+	if loaded, err := restarted.loadClusterConfig(config.clusterConfigPath()); !loaded || err != nil {
+		t.Fatalf("loadClusterConfig = %v, %v", loaded, err)
+	}
+	if restarted.cluster.myself.id != a.cluster.myself.id || len(restarted.cluster.nodes) != 2 ||
+		restarted.cluster.slots[0] != restarted.cluster.myself || restarted.cluster.slots[16383] == restarted.cluster.myself {
+		t.Fatalf("The configuration of the cluster was not restored")
+	}
+}
+
+func TestClusterMigrateSlot(t *testing.T) {
+	a, b := startTestClusterNode(t), startTestClusterNode(t)
+	clientA, clientB := dialTestServer(t, a), dialTestServer(t, b)
+	clientA.call("CLUSTER", "ADDSLOTSRANGE", "0", "8191")
+	clientB.call("CLUSTER", "ADDSLOTSRANGE", "8192", "16383")
+	meetTestNodes(t, clientA, a, b)
+	idA, idB := clientA.call("CLUSTER", "MYID").(string), clientB.call("CLUSTER", "MYID").(string)
+	_, portA, _ := net.SplitHostPort(a.getConfig().Addr)
+	clientB.call("SET", "foo", "bar")
+	clientB.call("SET", "{foo}.x", "1")
+
+	if reply := clientB.call("CLUSTER", "SETSLOT", "12182", "IMPORTING", idA); !strings.HasPrefix(fmt.Sprint(reply), "ERR") {
+		t.Fatalf("SETSLOT IMPORTING of an owned slot = %v", reply)
+	}
+	if reply := clientA.call("CLUSTER", "SETSLOT", "12182", "IMPORTING", idB); reply != "OK" {
+		t.Fatalf("SETSLOT IMPORTING = %v", reply)
+	}
+	if reply := clientB.call("CLUSTER", "SETSLOT", "12182", "MIGRATING", idA); reply != "OK" {
+		t.Fatalf("SETSLOT MIGRATING = %v", reply)
+	}
+	if !strings.Contains(clientB.call("CLUSTER", "NODES").(string), "[12182->-"+idA+"]") {
+		t.Fatal("CLUSTER NODES does not show the migrating slot")
+	}
+
+	// The source serves the keys it still has and sends the clients to the target for the others.
+	ask := resp.Error("ASK 12182 " + a.getConfig().Addr)
+	if reply := clientB.call("GET", "{foo}.y"); reply != ask {
+		t.Fatalf("GET of a missing key of a migrating slot = %v", reply)
+	}
+	if reply := clientB.call("MIGRATE", "127.0.0.1", portA, "", "0", "1000", "KEYS", "foo"); reply != "OK" {
+		t.Fatalf("MIGRATE = %v", reply)
+	}
+	if reply := clientB.call("GET", "foo"); reply != ask {
+		t.Fatalf("GET of a migrated key = %v", reply)
+	}
+	if reply := clientB.call("GET", "{foo}.x"); reply != "1" {
+		t.Fatalf("GET of a key not migrated yet = %v", reply)
+	}
+
+	// The target only serves the slot it imports to the clients sending ASKING first.
+	if reply := clientA.call("GET", "foo"); reply != resp.Error("MOVED 12182 "+b.getConfig().Addr) {
+		t.Fatalf("GET on the target without ASKING = %v", reply)
+	}
+	clientA.call("ASKING")
+	if reply := clientA.call("GET", "foo"); reply != "bar" {
+		t.Fatalf("GET on the target after ASKING = %v", reply)
+	}
+	clientA.call("ASKING")
+	if reply := clientA.call("EXISTS", "foo", "{foo}.x"); !strings.HasPrefix(fmt.Sprint(reply), "TRYAGAIN") {
+		t.Fatalf("EXISTS of keys partly migrated = %v", reply)
+	}
+
+	if reply := clientB.call("MIGRATE", "127.0.0.1", portA, "{foo}.x", "0", "1000"); reply != "OK" {
+		t.Fatalf("MIGRATE of a single key = %v", reply)
+	}
+	if reply := clientB.call("MIGRATE", "127.0.0.1", portA, "{foo}.x", "0", "1000"); reply != "NOKEY" {
+		t.Fatalf("MIGRATE of a missing key = %v", reply)
+	}
+	for _, client := range []*testConn{clientA, clientB} {
+		if reply := client.call("CLUSTER", "SETSLOT", "12182", "NODE", idA); reply != "OK" {
+			t.Fatalf("SETSLOT NODE = %v", reply)
+		}
+	}
+	if reply := clientB.call("GET", "foo"); reply != resp.Error("MOVED 12182 "+a.getConfig().Addr) {
+		t.Fatalf("GET on the source after the migration = %v", reply)
+	}
+	if reply := clientA.call("EXISTS", "foo", "{foo}.x"); reply != int64(2) {
+		t.Fatalf("EXISTS on the target after the migration = %v", reply)
+	}
+
+	payload := clientA.call("DUMP", "foo").(string)
+	if reply := clientA.call("RESTORE", "foo", "0", payload); reply != resp.Error("BUSYKEY Target key name already exists.") {
+		t.Fatalf("RESTORE of an existing key = %v", reply)
+	}
+	if reply := clientA.call("RESTORE", "foo", "0", payload, "REPLACE"); reply != "OK" {
+		t.Fatalf("RESTORE REPLACE = %v", reply)
+	}
+}
+
+func TestClusterShardedPubSub(t *testing.T) {
+	a, b := startTestClusterNode(t), startTestClusterNode(t)
+	clientA, clientB := dialTestServer(t, a), dialTestServer(t, b)
+	clientA.call("CLUSTER", "ADDSLOTSRANGE", "0", "8191")
+	clientB.call("CLUSTER", "ADDSLOTSRANGE", "8192", "16383")
+	meetTestNodes(t, clientA, a, b)
+
+	if reply := clientA.call("SSUBSCRIBE", "foo"); reply != resp.Error("MOVED 12182 "+b.getConfig().Addr) {
+		t.Fatalf("SSUBSCRIBE on the wrong node = %v", reply)
+	}
+	if reply := clientA.call("SSUBSCRIBE", "foo", "bar"); !strings.HasPrefix(fmt.Sprint(reply), "CROSSSLOT") {
+		t.Fatalf("SSUBSCRIBE of shard channels of different slots = %v", reply)
+	}
+	if reply := clientA.call("SPUBLISH", "foo", "hello"); reply != resp.Error("MOVED 12182 "+b.getConfig().Addr) {
+		t.Fatalf("SPUBLISH on the wrong node = %v", reply)
+	}
+	subscriber := dialTestServer(t, b)
+	subscriber.call("SSUBSCRIBE", "foo", "{foo}.x")
+	subscriber.read()
+	if reply := clientB.call("SPUBLISH", "foo", "hello"); reply != int64(1) {
+		t.Fatalf("SPUBLISH = %v", reply)
+	}
+	if reply := fmt.Sprint(subscriber.read()); reply != "[smessage foo hello]" {
+		t.Fatalf("Message = %v", reply)
+	}
+
+	// The subscribers of the shard channels of a slot moving to another node are unsubscribed.
+	idA := clientA.call("CLUSTER", "MYID").(string)
+	for _, client := range []*testConn{clientA, clientB} {
+		if reply := client.call("CLUSTER", "SETSLOT", "12182", "NODE", idA); reply != "OK" {
+			t.Fatalf("SETSLOT NODE = %v", reply)
+		}
+	}
+	unsubscribed := []string{fmt.Sprint(subscriber.read()), fmt.Sprint(subscriber.read())}
+	sort.Strings(unsubscribed)
+	// The count of the first message depends on the channel unsubscribed first.
+	if got := fmt.Sprint(unsubscribed); got != "[[sunsubscribe foo 1] [sunsubscribe {foo}.x 0]]" &&
+		got != "[[sunsubscribe foo 0] [sunsubscribe {foo}.x 1]]" {
+		t.Fatalf("Messages once the slot moved = %v", unsubscribed)
+	}
+	if reply := clientB.call("PUBSUB", "SHARDCHANNELS"); fmt.Sprint(reply) != "[]" {
+		t.Fatalf("PUBSUB SHARDCHANNELS = %v", reply)
+	}
+}
+
+func TestClusterReplica(t *testing.T) {
+	a, b := startTestClusterNode(t), startTestClusterNode(t)
+	clientA, clientB := dialTestServer(t, a), dialTestServer(t, b)
+	clientA.call("CLUSTER", "ADDSLOTSRANGE", "0", "16383")
+	meetTestNodes(t, clientA, a, b)
+	idA, idB := clientA.call("CLUSTER", "MYID").(string), clientB.call("CLUSTER", "MYID").(string)
+
+	if reply := clientA.call("CLUSTER", "REPLICATE", idB); reply != resp.Error("ERR to set a master the node must be empty and without assigned slots") {
+		t.Fatalf("CLUSTER REPLICATE of a node serving slots = %v", reply)
+	}
+	if reply := clientB.call("CLUSTER", "REPLICATE", idA); reply != "OK" {
+		t.Fatalf("CLUSTER REPLICATE = %v", reply)
+	}
+	waitFor(t, "the full sync", func() bool { return linkState(b) == linkConnected })
+	waitFor(t, "the primary to learn its replica", func() bool {
+		return strings.Contains(clientA.call("CLUSTER", "NODES").(string), idB+" "+b.getConfig().Addr+"@"+fmt.Sprint(b.getConfig().ClusterPort)+" slave "+idA)
+	})
+	if reply := clientA.call("CLUSTER", "REPLICATE", idB); reply != resp.Error("ERR i can only replicate a master, not a replica") {
+		t.Fatalf("CLUSTER REPLICATE of a replica = %v", reply)
+	}
+
+	// The replica gets the keys of its primary and redirects the commands on them.
+	clientA.call("SET", "foo", "bar")
+	waitFor(t, "the key on the replica", func() bool { value, _ := b.dbs[0].Get("foo"); return value == "bar" })
+	if reply := clientB.call("GET", "foo"); reply != resp.Error("MOVED 12182 "+a.getConfig().Addr) {
+		t.Fatalf("GET on the replica = %v", reply)
+	}
+	slots := clientA.call("CLUSTER", "SLOTS").([]interface{})
+	if len(slots) != 1 || fmt.Sprint(slots[0].([]interface{})[3]) != fmt.Sprintf("[127.0.0.1 %d %s]", b.cluster.myself.port, idB) {
+		t.Fatalf("CLUSTER SLOTS = %v", slots)
+	}
+	shards := clientA.call("CLUSTER", "SHARDS").([]interface{})
+	if len(shards) != 1 || len(shards[0].([]interface{})[3].([]interface{})) != 2 {
+		t.Fatalf("CLUSTER SHARDS = %v", shards)
+	}
+
+	// The messages of the shard channels of the primary reach the subscribers of the replica, and the
+	// messages of the other channels reach them once.
+	subscriber := dialTestServer(t, b)
+	if reply := fmt.Sprint(subscriber.call("SSUBSCRIBE", "foo")); reply != "[ssubscribe foo 1]" {
+		t.Fatalf("SSUBSCRIBE on the replica = %v", reply)
+	}
+	subscriber.call("SUBSCRIBE", "news")
+	clientA.call("SPUBLISH", "foo", "hello")
+	clientA.call("PUBLISH", "news", "first")
+	clientA.call("PUBLISH", "news", "second")
+	for _, expected := range []string{"[smessage foo hello]", "[message news first]", "[message news second]"} {
+		if reply := fmt.Sprint(subscriber.read()); reply != expected {
+			t.Fatalf("Message on the replica = %v, want %v", reply, expected)
+		}
+	}
+
+	// A restarted replica loads its primary from the configuration of the cluster.
+	config := b.getConfig()
+	restarted := New(config)
+	restarted.cluster.mutex.Lock()
+	defer restarted.cluster.mutex.Unlock()
+	if loaded, err := restarted.loadClusterConfig(config.clusterConfigPath()); !loaded || err != nil {
+		t.Fatalf("loadClusterConfig = %v, %v", loaded, err)
+	}
+	if master := restarted.cluster.myself.master; master == nil || master.id != idA {
+		t.Fatalf("The primary of the replica was not restored")
+	}
+}
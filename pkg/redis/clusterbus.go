@@ -0,0 +1,571 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// The nodes of a cluster talk over the cluster bus, a second port of each node. Every node keeps a
+// connection to the bus of each other node and sends it a PING about once per second, which the
+// node answers with a PONG on the same connection. Both messages carry the state of the sender: its
+// ID, its epochs, its ports, its primary, the slots it serves and a few words about the other nodes
+// it knows, called gossip, so that the nodes learn about each other from the ones they already know,
+// and agree on the owners of the slots. A node meets another with MEET, a PING asking it to add the
+// sender.
+//
+// The messages are RESP commands:
+//
+//	PING|PONG|MEET <id> <currentEpoch> <configEpoch> <port> <cport> <flags> <master> <slots> [<gossip> ...]
+//	FAIL <id> <failing id>
+//	PUBLISH <id> <channel> <message>
+//
+// where master is the ID of the primary of the sender, "-" for a primary, slots are the ranges of
+// slots served by the sender separated by commas, and each gossip is
+// "<id> <ip> <port> <cport> <flags>". PUBLISH delivers a message published on the sender to the
+// subscribers of the other nodes. A node not answering PING for the node timeout is possibly
+// failing (PFAIL). A node is failing (FAIL) once the majority of the nodes serving slots report it
+// possibly failing in their gossip, which the node noticing it broadcasts with FAIL.
+//
+// Slots are claimed with the config epoch of their owner: a node claiming a slot with a greater config
+// epoch than its owner takes it over, and two nodes with the same config epoch are told apart by the
+// one with the smaller ID taking a new one.
+
+var (
+	clusterCronPeriod = 100 * time.Millisecond
+	clusterPingPeriod = time.Second
+)
+
+// clusterLink is an outbound connection to the bus of a node. The messages are written by a goroutine
+// so that a slow node does not block the others.
+type clusterLink struct {
+	conn    net.Conn
+	out     chan []byte
+	created time.Time
+	pinged  bool // a PING or MEET was sent on the link
+	closed  bool
+}
+
+func newClusterLink(conn net.Conn) *clusterLink {
+	l := &clusterLink{conn: conn, out: make(chan []byte, 64), created: time.Now()}
+	go l.write()
+	return l
+}
+
+func (l *clusterLink) write() {
+	for msg := range l.out {
+		l.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err := l.conn.Write(msg); err != nil {
+			l.conn.Close()
+		}
+	}
+}
+
+// send queues msg, dropping it when the link is too far behind. The caller must hold
+// r.cluster.mutex, like for close.
+func (l *clusterLink) send(msg []byte) {
+	if l.closed {
+		return
+	}
+	select {
+	case l.out <- msg:
+	default:
+	}
+}
+
+func (l *clusterLink) close() {
+	if !l.closed {
+		l.closed = true
+		close(l.out)
+		l.conn.Close()
+	}
+}
+
+// acceptClusterBus serves the connections of the other nodes to the bus until listener is closed,
+// which stops the cluster.
+func (r *RedisServer) acceptClusterBus(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			c := r.cluster
+			c.mutex.Lock()
+			c.closed = true
+			for _, n := range c.nodes {
+				if n.link != nil {
+					n.link.close()
+					n.link = nil
+				}
+			}
+			c.mutex.Unlock()
+			return
+		}
+		go r.serveClusterBus(conn)
+	}
+}
+
+// serveClusterBus reads the messages of a node over an inbound connection and answers them.
+func (r *RedisServer) serveClusterBus(conn net.Conn) {
+	defer conn.Close()
+	reader := resp.NewReader(conn)
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			return
+		}
+		if reply := r.processClusterMessage(args, conn, nil); reply != nil {
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// connectClusterNode opens the link to the bus of n.
+func (r *RedisServer) connectClusterNode(n *clusterNode, timeout time.Duration) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(n.ip, strconv.Itoa(n.cport)), timeout)
+	c := r.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n.connecting = false
+	if err != nil || c.closed || n.deleted {
+		if conn != nil {
+			conn.Close()
+		}
+		// A node that cannot be reached is handled like a node not answering PING.
+		if err != nil && n.pingSent.IsZero() {
+			n.pingSent = time.Now()
+		}
+		return
+	}
+	link := newClusterLink(conn)
+	n.link = link
+	go r.readClusterLink(link, n)
+}
+
+// readClusterLink reads the replies of n on its link, until the link breaks.
+func (r *RedisServer) readClusterLink(link *clusterLink, n *clusterNode) {
+	reader := resp.NewReader(link.conn)
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			break
+		}
+		r.processClusterMessage(args, link.conn, n)
+	}
+	c := r.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if n.link == link {
+		n.link = nil
+	}
+	link.close()
+}
+
+// clusterMessage returns a PING, PONG or MEET message for the node to, nil for the reply to an
+// unknown node. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterMessage(kind string, to *clusterNode) []byte {
+	c := r.cluster
+	me := c.myself
+	args := []string{kind, me.id, strconv.FormatInt(c.currentEpoch, 10), strconv.FormatInt(me.configEpoch, 10),
+		strconv.Itoa(me.port), strconv.Itoa(me.cport), me.flags(), me.masterID(), strings.Join(formatSlotRanges(r.nodeSlotRanges(me)), ",")}
+	for _, n := range c.nodes {
+		if n == me || n == to || n.handshake {
+			continue
+		}
+		args = append(args, fmt.Sprintf("%s %s %d %d %s", n.id, n.ip, n.port, n.cport, n.flags()))
+	}
+	c.sent++
+	return resp.AppendCommand(nil, args...)
+}
+
+// broadcastClusterMessage sends msg to every node with a link. The caller must hold
+// r.cluster.mutex.
+func (r *RedisServer) broadcastClusterMessage(args ...string) {
+	msg := resp.AppendCommand(nil, args...)
+	for _, n := range r.cluster.nodes {
+		if n.link != nil && !n.handshake {
+			n.link.send(msg)
+			r.cluster.sent++
+		}
+	}
+}
+
+// processClusterMessage handles a message received over conn, the link to linkNode or an inbound
+// connection when linkNode is nil, and returns the reply to send back, if any.
+func (r *RedisServer) processClusterMessage(args []string, conn net.Conn, linkNode *clusterNode) []byte {
+	c := r.cluster
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.received++
+	kind := strings.ToUpper(args[0])
+	if kind == "FAIL" {
+		if len(args) == 3 && c.nodes[args[1]] != nil {
+			if n := c.nodes[args[2]]; n != nil && !n.myself && !n.fail {
+				n.fail, n.pfail = true, false
+				fmt.Printf("FAIL message received from %s about %s\n", args[1], n.id)
+				r.clusterStateChanged()
+			}
+		}
+		return nil
+	}
+	if kind == "PUBLISH" {
+		if len(args) == 4 && c.nodes[args[1]] != nil {
+			r.publish(args[2], args[3])
+		}
+		return nil
+	}
+	if len(args) < 9 || (kind != "PING" && kind != "PONG" && kind != "MEET") {
+		return nil
+	}
+	id := args[1]
+	currentEpoch, err1 := strconv.ParseInt(args[2], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(args[3], 10, 64)
+	port, err3 := strconv.Atoi(args[4])
+	cport, err4 := strconv.Atoi(args[5])
+	claimed, err5 := parseClaimedSlots(args[8])
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+		return nil
+	}
+	now := time.Now()
+	changed := false
+
+	sender := c.nodes[id]
+	if sender != nil && sender.handshake {
+		sender = nil
+	}
+	if kind == "MEET" && sender == nil && !c.isForgotten(id, now) {
+		// The node learns its IP from the first node meeting it, and adds the sender.
+		if c.myself.ip == "" {
+			c.myself.ip = addrIP(conn.LocalAddr())
+			changed = true
+		}
+		sender = newClusterNode(id, addrIP(conn.RemoteAddr()), port, cport)
+		c.nodes[id] = sender
+		fmt.Printf("Node %s met us\n", id)
+		changed = true
+	}
+	if kind == "PONG" && linkNode != nil && linkNode.handshake {
+		// The handshake completes: the node is known by its ID from now on.
+		delete(c.nodes, linkNode.id)
+		if sender == nil && id != c.myself.id {
+			linkNode.id, linkNode.handshake, linkNode.meet = id, false, false
+			c.nodes[id] = linkNode
+			sender = linkNode
+			fmt.Printf("Handshake with node %s completed\n", id)
+		} else {
+			r.deleteClusterNode(linkNode)
+		}
+		changed = true
+	}
+	if kind == "PONG" && linkNode != nil && linkNode == sender {
+		sender.pingSent, sender.pongReceived = time.Time{}, now
+		sender.pfail = false
+		if sender.fail {
+			sender.fail = false
+			fmt.Printf("Clear FAIL state for node %s: it is reachable again\n", sender.id)
+			changed = true
+		}
+	}
+
+	if sender != nil {
+		if sender.ip == "" || sender.port != port || sender.cport != cport {
+			if linkNode == nil {
+				sender.ip = addrIP(conn.RemoteAddr())
+			}
+			sender.port, sender.cport = port, cport
+			changed = true
+		}
+		if currentEpoch > c.currentEpoch {
+			c.currentEpoch = currentEpoch
+			changed = true
+		}
+		if configEpoch != sender.configEpoch {
+			sender.configEpoch = configEpoch
+			changed = true
+		}
+		if r.updateSlotsWith(sender, claimed) {
+			changed = true
+		}
+		if sender.configEpoch == c.myself.configEpoch && c.myself.id < sender.id {
+			// Unlike bumpConfigEpoch, a collision always takes a new epoch, even when it already has the
+			// greatest one.
+			c.currentEpoch++
+			c.myself.configEpoch = c.currentEpoch
+			fmt.Printf("Config epoch collision with node %s, my config epoch is now %d\n", sender.id, c.myself.configEpoch)
+			changed = true
+		}
+		if r.updateMasterWith(sender, args[7]) {
+			changed = true
+		}
+		if r.processGossip(sender, args[9:], now) {
+			changed = true
+		}
+	}
+	if changed {
+		r.clusterStateChanged()
+	}
+	if kind == "PING" || kind == "MEET" {
+		return r.clusterMessage("PONG", sender)
+	}
+	return nil
+}
+
+// parseClaimedSlots parses the slots of a message, ranges separated by commas.
+func parseClaimedSlots(s string) ([]int, error) {
+	slots := []int{}
+	if s == "" {
+		return slots, nil
+	}
+	for _, rg := range strings.Split(s, ",") {
+		start, end, err := parseSlotRange(rg)
+		if err != nil {
+			return nil, err
+		}
+		for slot := start; slot <= end; slot++ {
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+func addrIP(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.String()
+	}
+	host, _, _ := net.SplitHostPort(addr.String())
+	return host
+}
+
+// updateSlotsWith gives sender the slots it claims whose owner has a smaller config epoch, and
+// deletes the keys of the slots this node loses. The slots this node imports are left alone until
+// it claims them. The caller must hold r.cluster.mutex.
+func (r *RedisServer) updateSlotsWith(sender *clusterNode, claimed []int) bool {
+	c := r.cluster
+	changed := false
+	for _, slot := range claimed {
+		owner := c.slots[slot]
+		if owner == sender || (owner != nil && owner.configEpoch >= sender.configEpoch) || c.importing[slot] != nil {
+			continue
+		}
+		if owner == c.myself {
+			c.migrating[slot] = nil
+			if deleted := r.dbs[0].deleteKeysInSlot(slot); deleted > 0 {
+				fmt.Printf("Slot %d moved to node %s, %d keys deleted\n", slot, sender.id, deleted)
+			}
+		}
+		c.slots[slot] = sender
+		changed = true
+	}
+	return changed
+}
+
+// updateMasterWith records the primary the sender replicates, once this node knows it. The caller
+// must hold r.cluster.mutex.
+func (r *RedisServer) updateMasterWith(sender *clusterNode, id string) bool {
+	master := r.cluster.nodes[id]
+	if id == "-" || (master != nil && master.handshake) {
+		master = nil
+	}
+	if sender.master == master || (master == nil && id != "-") {
+		return false
+	}
+	sender.master = master
+	return true
+}
+
+// processGossip records the failure reports of sender about the nodes it knows, and starts a
+// handshake with the ones this node does not know. The caller must hold r.cluster.mutex.
+func (r *RedisServer) processGossip(sender *clusterNode, gossip []string, now time.Time) bool {
+	c := r.cluster
+	changed := false
+	for _, entry := range gossip {
+		fields := strings.Fields(entry)
+		if len(fields) != 5 || fields[0] == c.myself.id {
+			continue
+		}
+		port, err1 := strconv.Atoi(fields[2])
+		cport, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		failing := strings.Contains(fields[4], "fail")
+		if n := c.nodes[fields[0]]; n != nil && !n.handshake {
+			if failing {
+				n.failReports[sender.id] = now
+			} else {
+				delete(n.failReports, sender.id)
+			}
+			continue
+		}
+		if !failing && fields[1] != "" && !c.isForgotten(fields[0], now) && r.clusterStartHandshake(fields[1], port, cport) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// clusterStartHandshake adds the node at ip:port, unless a handshake with it is in progress, and
+// meets it. The caller must hold r.cluster.mutex.
+func (r *RedisServer) clusterStartHandshake(ip string, port, cport int) bool {
+	c := r.cluster
+	for _, n := range c.nodes {
+		if n.handshake && n.ip == ip && n.port == port && n.cport == cport {
+			return false
+		}
+	}
+	n := newClusterNode(newReplicationID(), ip, port, cport)
+	n.handshake, n.meet = true, true
+	c.nodes[n.id] = n
+	return true
+}
+
+// deleteClusterNode removes n from the cluster, with the slots it serves. The caller must hold
+// r.cluster.mutex.
+func (r *RedisServer) deleteClusterNode(n *clusterNode) {
+	c := r.cluster
+	for slot, owner := range c.slots {
+		if owner == n {
+			c.slots[slot] = nil
+		}
+		if c.migrating[slot] == n {
+			c.migrating[slot] = nil
+		}
+		if c.importing[slot] == n {
+			c.importing[slot] = nil
+		}
+	}
+	for _, other := range c.nodes {
+		delete(other.failReports, n.id)
+		if other.master == n {
+			other.master = nil
+		}
+	}
+	if c.nodes[n.id] == n {
+		delete(c.nodes, n.id)
+	}
+	if n.link != nil {
+		n.link.close()
+		n.link = nil
+	}
+	n.deleted = true
+}
+
+// isForgotten reports whether the node id was removed with CLUSTER FORGET less than a minute ago.
+// The caller must hold c.mutex.
+func (c *clusterState) isForgotten(id string, now time.Time) bool {
+	until, exist := c.forgotten[id]
+	if exist && now.After(until) {
+		delete(c.forgotten, id)
+		return false
+	}
+	return exist
+}
+
+// clusterStateChanged updates the state of the cluster and saves its configuration. The caller must
+// hold r.cluster.mutex.
+func (r *RedisServer) clusterStateChanged() {
+	r.updateClusterState()
+	r.saveClusterConfig()
+}
+
+// clusterCron runs the periodic tasks of the cluster until it is stopped.
+func (r *RedisServer) clusterCron() {
+	ticker := time.NewTicker(clusterCronPeriod)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if !r.clusterCronStep(now) {
+			return
+		}
+	}
+}
+
+// clusterCronStep connects to the nodes, pings them, and detects the failing ones. It reports false
+// once the cluster is stopped.
+func (r *RedisServer) clusterCronStep(now time.Time) bool {
+	c := r.cluster
+	timeout := time.Duration(r.getConfig().ClusterNodeTimeout) * time.Millisecond
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false
+	}
+	handshakeTimeout := timeout
+	if handshakeTimeout < time.Second {
+		handshakeTimeout = time.Second
+	}
+	changed := false
+	for _, n := range c.nodes {
+		if n.myself {
+			continue
+		}
+		if n.handshake && now.Sub(n.created) > handshakeTimeout {
+			r.deleteClusterNode(n)
+			continue
+		}
+		// A link waiting too long for a PONG is connected again, in case only the link broke.
+		if l := n.link; l != nil && !n.pingSent.IsZero() && now.Sub(n.pingSent) > timeout/2 && now.Sub(l.created) > timeout/2 {
+			l.close()
+			n.link = nil
+		}
+		if n.link == nil && !n.connecting {
+			n.connecting = true
+			go r.connectClusterNode(n, timeout)
+		}
+		if l := n.link; l != nil && (!l.pinged || (n.pingSent.IsZero() && now.Sub(n.pongReceived) >= clusterPingPeriod)) {
+			kind := "PING"
+			if n.meet {
+				kind = "MEET"
+			}
+			l.send(r.clusterMessage(kind, n))
+			l.pinged = true
+			if n.pingSent.IsZero() {
+				n.pingSent = now
+			}
+		}
+		if !n.handshake && !n.pingSent.IsZero() && now.Sub(n.pingSent) > timeout && !n.pfail && !n.fail {
+			n.pfail = true
+			fmt.Printf("*** NODE %s possibly failing\n", n.id)
+			changed = true
+		}
+	}
+	if r.markFailingNodes(now, timeout) {
+		changed = true
+	}
+	if changed {
+		r.clusterStateChanged()
+	}
+	return true
+}
+
+// markFailingNodes marks FAIL the nodes possibly failing according to the majority of the nodes
+// serving slots, and tells the other nodes. The caller must hold r.cluster.mutex.
+func (r *RedisServer) markFailingNodes(now time.Time, timeout time.Duration) bool {
+	c := r.cluster
+	needed := r.clusterSize()/2 + 1
+	changed := false
+	for _, n := range c.nodes {
+		for id, reported := range n.failReports {
+			if now.Sub(reported) > 2*timeout {
+				delete(n.failReports, id)
+			}
+		}
+		if !n.pfail || n.fail {
+			continue
+		}
+		if len(n.failReports)+1 >= needed {
+			n.fail, n.pfail = true, false
+			fmt.Printf("Marking node %s as failing (quorum reached)\n", n.id)
+			r.broadcastClusterMessage("FAIL", c.myself.id, n.id)
+			changed = true
+		}
+	}
+	return changed
+}
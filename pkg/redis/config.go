@@ -0,0 +1,545 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// Config holds the settings of a RedisServer.
+type Config struct {
+	Addr             string          // address the server listens on
+	Databases        int             // number of logical databases clients can SELECT
+	MaxMemory        int64           // memory limit of the databases in bytes, 0 means no limit
+	MaxMemoryPolicy  MaxMemoryPolicy // how keys are evicted once MaxMemory is reached
+	MaxMemorySamples int             // number of keys sampled to find the key to evict
+
+	ListMaxListpackSize    int // entries (positive) or size (-1 for 4KB to -5 for 64KB) of the lists kept as a listpack
+	ZsetMaxListpackEntries int // members of the sorted sets kept as a listpack
+	ZsetMaxListpackValue   int // length of the members of the sorted sets kept as a listpack
+
+	Dir        string     // directory of the RDB file
+	DBFilename string     // name of the RDB file, loaded by Start and written by SAVE and BGSAVE
+	Save       []SaveRule // when the background saves are triggered, none to disable them
+
+	AppendOnly       bool        // log the write commands to the AOF, which Start loads instead of the RDB file
+	AppendFilename   string      // base name of the files of the AOF
+	AppendDirname    string      // directory of the files of the AOF, in Dir
+	AppendFsync      AppendFsync // when the writes to the AOF are flushed to disk
+	AofLoadTruncated bool        // load an AOF whose last command is truncated rather than refusing to start
+
+	AutoAofRewritePercentage int   // growth of the AOF since the last rewrite that starts a rewrite, 0 to disable
+	AutoAofRewriteMinSize    int64 // size of the AOF under which it is not rewritten automatically
+
+	ReplicaOf             string // "host port" of the primary replicated by the server, empty for a primary
+	ReplicaReadOnly       bool   // refuse the write commands of the clients of a replica
+	ReplPingReplicaPeriod int    // seconds between the PINGs sent to the replicas
+	ReplTimeout           int    // seconds without data from the other end before a replication link is dropped
+	ReplBacklogSize       int64  // size of the end of the replication stream kept for partial resyncs
+
+	ClusterEnabled     bool   // run the server as a node of a cluster
+	ClusterConfigFile  string // name of the file in Dir keeping the configuration of the cluster
+	ClusterNodeTimeout int    // milliseconds without a reply after which a node is possibly failing
+	ClusterPort        int    // port of the cluster bus, 0 for the port of the clients plus 10000
+
+	ClientOutputBufferLimitPubSub OutputBufferLimit // limit of the replies and messages waiting to be sent to a subscriber
+	NotifyKeyspaceEvents          string            // classes of the keyspace events published, such as "KEA", none when empty
+}
+
+// SaveRule triggers a background save once Changes changes were made and Seconds seconds
+// elapsed since the last save.
+type SaveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+// DefaultConfig returns the settings used when none are given.
+func DefaultConfig() Config {
+	return Config{
+		Addr:             "localhost:6789",
+		Databases:        16,
+		MaxMemoryPolicy:  NoEviction,
+		MaxMemorySamples: 5,
+
+		ListMaxListpackSize:    defaultEncodingLimits.listMaxListpackSize,
+		ZsetMaxListpackEntries: defaultEncodingLimits.zsetMaxListpackEntries,
+		ZsetMaxListpackValue:   defaultEncodingLimits.zsetMaxListpackValue,
+
+		Dir:        ".",
+		DBFilename: "dump.rdb",
+		Save:       []SaveRule{{3600, 1}, {300, 100}, {60, 10000}},
+
+		AppendFilename:   "appendonly.aof",
+		AppendDirname:    "appendonlydir",
+		AppendFsync:      FsyncEverySec,
+		AofLoadTruncated: true,
+
+		AutoAofRewritePercentage: 100,
+		AutoAofRewriteMinSize:    64 * 1024 * 1024,
+
+		ReplicaReadOnly:       true,
+		ReplPingReplicaPeriod: 10,
+		ReplTimeout:           60,
+		ReplBacklogSize:       1024 * 1024,
+
+		ClusterConfigFile:  "nodes.conf",
+		ClusterNodeTimeout: 15000,
+
+		ClientOutputBufferLimitPubSub: OutputBufferLimit{Hard: 32 * 1024 * 1024, Soft: 8 * 1024 * 1024, SoftSeconds: 60},
+	}
+}
+
+// encodingLimits returns the limits of the compact encodings.
+func (c *Config) encodingLimits() encodingLimits {
+	return encodingLimits{
+		listMaxListpackSize:    c.ListMaxListpackSize,
+		zsetMaxListpackEntries: c.ZsetMaxListpackEntries,
+		zsetMaxListpackValue:   c.ZsetMaxListpackValue,
+	}
+}
+
+// notifyFlags returns the classes of the keyspace events published.
+func (c *Config) notifyFlags() int {
+	flags, _ := parseNotifyKeyspaceEvents(c.NotifyKeyspaceEvents)
+	return flags
+}
+
+// Validate checks the settings, normalizing the case of the maxmemory policy and the order of the
+// classes of the keyspace events.
+func (c *Config) Validate() error {
+	if c.Databases < 1 {
+		return fmt.Errorf("databases must be at least 1")
+	}
+	policy, err := parseMaxMemoryPolicy(string(c.MaxMemoryPolicy))
+	if err != nil {
+		return err
+	}
+	c.MaxMemoryPolicy = policy
+	if c.MaxMemorySamples < 1 || c.MaxMemorySamples > 64 {
+		return fmt.Errorf("maxmemory-samples must be between 1 and 64 inclusive")
+	}
+	if c.ListMaxListpackSize < -5 {
+		return fmt.Errorf("list-max-listpack-size must be at least -5")
+	}
+	if c.ZsetMaxListpackEntries < 0 || c.ZsetMaxListpackValue < 0 {
+		return fmt.Errorf("zset-max-listpack-entries and zset-max-listpack-value must be positive")
+	}
+	fsync, err := parseAppendFsync(string(c.AppendFsync))
+	if err != nil {
+		return err
+	}
+	c.AppendFsync = fsync
+	if c.AutoAofRewritePercentage < 0 || c.AutoAofRewriteMinSize < 0 {
+		return fmt.Errorf("auto-aof-rewrite-percentage and auto-aof-rewrite-min-size must be positive")
+	}
+	if err := validateFilename("appendfilename", c.AppendFilename); err != nil {
+		return err
+	}
+	if err := validateFilename("appenddirname", c.AppendDirname); err != nil {
+		return err
+	}
+	if c.ReplicaOf != "" {
+		if _, _, err := parseReplicaOf(c.ReplicaOf); err != nil {
+			return err
+		}
+	}
+	if c.ReplPingReplicaPeriod < 1 || c.ReplTimeout < 1 {
+		return fmt.Errorf("repl-ping-replica-period and repl-timeout must be at least 1")
+	}
+	if c.ReplBacklogSize < 1 {
+		return fmt.Errorf("repl-backlog-size must be at least 1")
+	}
+	if c.ClusterEnabled {
+		if err := validateFilename("cluster-config-file", c.ClusterConfigFile); err != nil {
+			return err
+		}
+		if c.ClusterNodeTimeout < 1 {
+			return fmt.Errorf("cluster-node-timeout must be at least 1")
+		}
+		if c.ClusterPort < 0 || c.ClusterPort > 65535 {
+			return fmt.Errorf("cluster-port must be between 0 and 65535")
+		}
+		if c.ReplicaOf != "" {
+			return fmt.Errorf("replicaof is not supported in cluster mode, use cluster replicate")
+		}
+	}
+	if limit := c.ClientOutputBufferLimitPubSub; limit.Hard < 0 || limit.Soft < 0 || limit.SoftSeconds < 0 {
+		return fmt.Errorf("client-output-buffer-limit must be positive")
+	}
+	flags, err := parseNotifyKeyspaceEvents(c.NotifyKeyspaceEvents)
+	if err != nil {
+		return err
+	}
+	c.NotifyKeyspaceEvents = formatNotifyKeyspaceEvents(flags)
+	return validateFilename("dbfilename", c.DBFilename)
+}
+
+// validateFilename checks that the name of a file of the setting is not a path, like Redis does.
+func validateFilename(setting, name string) error {
+	if name == "" || strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("%s can't be a path, just a filename", setting)
+	}
+	if strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("%s can't contain spaces", setting)
+	}
+	return nil
+}
+
+// configParameter describes a setting read with CONFIG GET and changed with CONFIG SET.
+type configParameter struct {
+	get func(c *Config) string
+	set func(c *Config, value string) error // nil when the setting cannot change at runtime
+}
+
+var configParameters = map[string]configParameter{
+	"databases": {
+		get: func(c *Config) string { return strconv.Itoa(c.Databases) },
+	},
+	"maxmemory": {
+		get: func(c *Config) string { return strconv.FormatInt(c.MaxMemory, 10) },
+		set: func(c *Config, value string) error {
+			memory, err := ParseMemory(value)
+			c.MaxMemory = memory
+			return err
+		},
+	},
+	"maxmemory-policy": {
+		get: func(c *Config) string { return string(c.MaxMemoryPolicy) },
+		set: func(c *Config, value string) error {
+			policy, err := parseMaxMemoryPolicy(value)
+			c.MaxMemoryPolicy = policy
+			return err
+		},
+	},
+	"maxmemory-samples": {
+		get: func(c *Config) string { return strconv.Itoa(c.MaxMemorySamples) },
+		set: func(c *Config, value string) error {
+			samples, err := strconv.Atoi(value)
+			if err != nil || samples < 1 || samples > 64 {
+				return fmt.Errorf("argument must be between 1 and 64 inclusive")
+			}
+			c.MaxMemorySamples = samples
+			return nil
+		},
+	},
+	"list-max-listpack-size": {
+		get: func(c *Config) string { return strconv.Itoa(c.ListMaxListpackSize) },
+		set: func(c *Config, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < -5 {
+				return fmt.Errorf("argument must be between -5 and 2147483647 inclusive")
+			}
+			c.ListMaxListpackSize = n
+			return nil
+		},
+	},
+	"zset-max-listpack-entries": {
+		get: func(c *Config) string { return strconv.Itoa(c.ZsetMaxListpackEntries) },
+		set: func(c *Config, value string) error { return setNonNegative(&c.ZsetMaxListpackEntries, value) },
+	},
+	"zset-max-listpack-value": {
+		get: func(c *Config) string { return strconv.Itoa(c.ZsetMaxListpackValue) },
+		set: func(c *Config, value string) error { return setNonNegative(&c.ZsetMaxListpackValue, value) },
+	},
+	"dir": {
+		get: func(c *Config) string { return c.Dir },
+		set: func(c *Config, value string) error {
+			info, err := os.Stat(value)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("not a directory")
+			}
+			c.Dir = value
+			return nil
+		},
+	},
+	"dbfilename": {
+		get: func(c *Config) string { return c.DBFilename },
+		set: func(c *Config, value string) error {
+			c.DBFilename = value
+			return validateFilename("dbfilename", value)
+		},
+	},
+	"save": {
+		get: func(c *Config) string { return FormatSaveRules(c.Save) },
+		set: func(c *Config, value string) error {
+			rules, err := ParseSaveRules(value)
+			c.Save = rules
+			return err
+		},
+	},
+	"appendonly": {
+		get: func(c *Config) string { return formatYesNo(c.AppendOnly) },
+		set: func(c *Config, value string) error { return setYesNo(&c.AppendOnly, value) },
+	},
+	"appendfilename": {
+		get: func(c *Config) string { return c.AppendFilename },
+	},
+	"appenddirname": {
+		get: func(c *Config) string { return c.AppendDirname },
+	},
+	"appendfsync": {
+		get: func(c *Config) string { return string(c.AppendFsync) },
+		set: func(c *Config, value string) error {
+			fsync, err := parseAppendFsync(value)
+			c.AppendFsync = fsync
+			return err
+		},
+	},
+	"aof-load-truncated": {
+		get: func(c *Config) string { return formatYesNo(c.AofLoadTruncated) },
+		set: func(c *Config, value string) error { return setYesNo(&c.AofLoadTruncated, value) },
+	},
+	"auto-aof-rewrite-percentage": {
+		get: func(c *Config) string { return strconv.Itoa(c.AutoAofRewritePercentage) },
+		set: func(c *Config, value string) error { return setNonNegative(&c.AutoAofRewritePercentage, value) },
+	},
+	"auto-aof-rewrite-min-size": {
+		get: func(c *Config) string { return strconv.FormatInt(c.AutoAofRewriteMinSize, 10) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			c.AutoAofRewriteMinSize = size
+			return err
+		},
+	},
+	"replicaof": {
+		get: func(c *Config) string { return c.ReplicaOf },
+	},
+	"replica-read-only": {
+		get: func(c *Config) string { return formatYesNo(c.ReplicaReadOnly) },
+		set: func(c *Config, value string) error { return setYesNo(&c.ReplicaReadOnly, value) },
+	},
+	"repl-ping-replica-period": {
+		get: func(c *Config) string { return strconv.Itoa(c.ReplPingReplicaPeriod) },
+		set: func(c *Config, value string) error { return setPositive(&c.ReplPingReplicaPeriod, value) },
+	},
+	"repl-timeout": {
+		get: func(c *Config) string { return strconv.Itoa(c.ReplTimeout) },
+		set: func(c *Config, value string) error { return setPositive(&c.ReplTimeout, value) },
+	},
+	"repl-backlog-size": {
+		get: func(c *Config) string { return strconv.FormatInt(c.ReplBacklogSize, 10) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			if err == nil && size < 1 {
+				err = fmt.Errorf("argument must be a memory value of at least 1 byte")
+			}
+			c.ReplBacklogSize = size
+			return err
+		},
+	},
+	"cluster-enabled": {
+		get: func(c *Config) string { return formatYesNo(c.ClusterEnabled) },
+	},
+	"cluster-config-file": {
+		get: func(c *Config) string { return c.ClusterConfigFile },
+	},
+	"cluster-node-timeout": {
+		get: func(c *Config) string { return strconv.Itoa(c.ClusterNodeTimeout) },
+		set: func(c *Config, value string) error { return setPositive(&c.ClusterNodeTimeout, value) },
+	},
+	"cluster-port": {
+		get: func(c *Config) string { return strconv.Itoa(c.ClusterPort) },
+	},
+	"client-output-buffer-limit": {
+		get: func(c *Config) string { return formatClientOutputBufferLimit(c.ClientOutputBufferLimitPubSub) },
+		set: func(c *Config, value string) error {
+			return ParseClientOutputBufferLimit(value, &c.ClientOutputBufferLimitPubSub)
+		},
+	},
+	"notify-keyspace-events": {
+		get: func(c *Config) string { return c.NotifyKeyspaceEvents },
+		set: func(c *Config, value string) error {
+			flags, err := parseNotifyKeyspaceEvents(value)
+			c.NotifyKeyspaceEvents = formatNotifyKeyspaceEvents(flags)
+			return err
+		},
+	},
+}
+
+// setNonNegative parses value into a setting that cannot be negative.
+func setNonNegative(setting *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("argument must be between 0 and 2147483647 inclusive")
+	}
+	*setting = n
+	return nil
+}
+
+// setPositive parses value into an int setting that must be at least 1.
+func setPositive(setting *int, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("argument must be between 1 and 2147483647 inclusive")
+	}
+	*setting = n
+	return nil
+}
+
+// setYesNo parses value into a boolean setting, written yes or no.
+func setYesNo(setting *bool, value string) error {
+	switch strings.ToLower(value) {
+	case "yes":
+		*setting = true
+	case "no":
+		*setting = false
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no'")
+	}
+	return nil
+}
+
+// formatYesNo formats a boolean setting the way setYesNo parses it.
+func formatYesNo(setting bool) string {
+	if setting {
+		return "yes"
+	}
+	return "no"
+}
+
+// ParseMemory parses a memory amount the way the Redis configuration does: a number of bytes
+// optionally followed by a unit, k, m and g being powers of 1000 while kb, mb and gb are powers of 1024.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	lower := strings.ToLower(s)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return n * multiplier, nil
+}
+
+// ParseSaveRules parses the save rules the way the Redis configuration does: pairs of seconds and
+// changes separated by spaces, such as "3600 1 300 100". An empty string disables the saves.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters")
+	}
+	rules := []SaveRule{}
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid save parameters")
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters")
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// FormatSaveRules formats the save rules the way ParseSaveRules parses them.
+func FormatSaveRules(rules []SaveRule) string {
+	fields := []string{}
+	for _, rule := range rules {
+		fields = append(fields, strconv.FormatInt(rule.Seconds, 10), strconv.FormatInt(rule.Changes, 10))
+	}
+	return strings.Join(fields, " ")
+}
+
+// getConfig returns a copy of the current settings of the server.
+func (r *RedisServer) getConfig() Config {
+	r.configMutex.RLock()
+	defer r.configMutex.RUnlock()
+	return r.config
+}
+
+// setConfig changes a setting of the server at runtime.
+func (r *RedisServer) setConfig(name, value string) error {
+	parameter, exist := configParameters[strings.ToLower(name)]
+	if !exist || parameter.set == nil {
+		return fmt.Errorf("unsupported config parameter: %s", name)
+	}
+	r.configMutex.Lock()
+	config := r.config
+	previous := r.config
+	if err := parameter.set(&config, value); err != nil {
+		r.configMutex.Unlock()
+		return fmt.Errorf("invalid argument '%s' for config set '%s' - %v", value, name, err)
+	}
+	r.config = config
+	r.configMutex.Unlock()
+
+	switch {
+	case config.AppendOnly && !previous.AppendOnly:
+		if err := r.rewriteAppendOnly(true); err != nil {
+			r.configMutex.Lock()
+			r.config.AppendOnly = false
+			r.configMutex.Unlock()
+			return fmt.Errorf("failed turning the append only file on: %v", err)
+		}
+	case !config.AppendOnly && previous.AppendOnly:
+		r.stopAppendOnly()
+	}
+	if config.ReplBacklogSize != previous.ReplBacklogSize {
+		r.resetBacklog(config.ReplBacklogSize)
+	}
+
+	atomic.StoreInt32(&r.notifyFlags, int32(config.notifyFlags()))
+	for _, db := range r.dbs {
+		db.setLFU(config.MaxMemoryPolicy.lfu())
+		db.setEncodingLimits(config.encodingLimits())
+	}
+	return nil
+}
+
+// ===============================================================================
+func handleConfig(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("config command requires a subcommand")
+	}
+	switch strings.ToLower(args[1]) {
+	case "get":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("config get command requires exactly one argument")
+		}
+		config := client.server.getConfig()
+		names := []string{}
+		for name := range configParameters {
+			if stringMatch(strings.ToLower(args[2]), name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		result := []string{}
+		for _, name := range names {
+			result = append(result, name, configParameters[name].get(&config))
+		}
+		return result, nil
+	case "set":
+		if len(args) != 4 {
+			return nil, fmt.Errorf("config set command requires exactly two arguments")
+		}
+		if err := client.server.setConfig(args[2], args[3]); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("OK"), nil
+	}
+	return nil, fmt.Errorf("unknown subcommand '%s'", args[1])
+}
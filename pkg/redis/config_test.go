@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseMemory(t *testing.T) {
+	cases := map[string]int64{"0": 0, "100": 100, "1k": 1000, "1kb": 1024, "2MB": 2 * 1024 * 1024, "1g": 1000 * 1000 * 1000, "10b": 10}
+	for s, expected := range cases {
+		if memory, err := ParseMemory(s); err != nil || memory != expected {
+			t.Errorf("Expected %d for %s, got %d (err %v)", expected, s, memory, err)
+		}
+	}
+	for _, s := range []string{"", "mb", "-1", "1tb", "1.5mb"} {
+		if _, err := ParseMemory(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestConfigGetSet(t *testing.T) {
+	server := New(DefaultConfig())
+	client := newTestClient(server)
+
+	if _, err := handleConfig([]string{"config", "set", "maxmemory-policy", "allkeys-lfu"}, client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !server.dbs[3].lfu {
+		t.Errorf("Expected the databases to track the access frequency")
+	}
+	result, _ := handleConfig([]string{"config", "get", "maxmemory*"}, client)
+	if expected := "[maxmemory 0 maxmemory-policy allkeys-lfu maxmemory-samples 5]"; fmt.Sprint(result) != expected {
+		t.Errorf("Expected %s, got %v", expected, result)
+	}
+	if _, err := handleConfig([]string{"config", "set", "databases", "4"}, client); err == nil {
+		t.Errorf("Expected databases to be immutable")
+	}
+	if _, err := handleConfig([]string{"config", "set", "maxmemory-policy", "lru"}, client); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
@@ -0,0 +1,229 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// Flush deletes every key of the database. The old keys are left to the garbage collector,
+// so flushing is always asynchronous and FLUSHDB SYNC behaves like FLUSHDB ASYNC.
+func (r *Store) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	atomic.AddInt64(&r.dirty, int64(len(r.items)))
+	r.items = map[string]ExpirationItem{}
+	r.keys, r.volatileKeys = keyIndex{}, keyIndex{}
+	atomic.StoreInt64(&r.used, 0)
+	if r.slotKeys != nil {
+		r.slotKeys = newSlotKeys()
+	}
+}
+
+// Move moves key, with its time to live, to the target database.
+// It reports false when key does not exist or target already holds it.
+// The caller must hold the dbMutex of the server since both databases are locked.
+func (r *Store) Move(key string, target *Store) (bool, error) {
+	if r == target {
+		return false, fmt.Errorf("source and destination objects are the same")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	item, exist := r.lookup(key)
+	if !exist {
+		return false, nil
+	}
+	if _, exist := target.lookup(key); exist {
+		return false, nil
+	}
+	target.setItem(key, item)
+	r.deleteItem(key)
+	r.notifyEvent(notifyGeneric, "move_from", key)
+	target.notifyEvent(notifyGeneric, "move_to", key)
+	return true, nil
+}
+
+// swap exchanges the keys of the two databases.
+// The caller must hold the dbMutex of the server since both databases are locked.
+func (r *Store) swap(other *Store) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	r.items, other.items = other.items, r.items
+	r.slotKeys, other.slotKeys = other.slotKeys, r.slotKeys
+	r.keys, other.keys = other.keys, r.keys
+	r.volatileKeys, other.volatileKeys = other.volatileKeys, r.volatileKeys
+	atomic.AddInt64(&r.dirty, 1)
+	used := atomic.LoadInt64(&r.used)
+	atomic.StoreInt64(&r.used, atomic.SwapInt64(&other.used, used))
+}
+
+// SwapDB exchanges the contents of the databases at index a and b.
+// Clients keep their selected index, so they see the data of the other database right away.
+func (r *RedisServer) SwapDB(a, b int) error {
+	first, err := r.db(a)
+	if err != nil {
+		return err
+	}
+	second, err := r.db(b)
+	if err != nil {
+		return err
+	}
+	if a == b {
+		return nil
+	}
+	r.dbMutex.Lock()
+	defer r.dbMutex.Unlock()
+	first.swap(second)
+	return nil
+}
+
+// FlushAll deletes every key of every database.
+func (r *RedisServer) FlushAll() {
+	for _, db := range r.dbs {
+		db.Flush()
+	}
+}
+
+// inTransaction reports whether the client has an open MULTI.
+func (client *ClientDetail) inTransaction() bool {
+	return len(client.multiMarks) > 0
+}
+
+// storeAt returns the database at index. A transaction only covers the selected database, other
+// databases cannot be reached by its commands.
+func (client *ClientDetail) storeAt(index int) (*Store, error) {
+	if index == client.db {
+		return currentStore(client.redis), nil
+	}
+	db, err := client.server.db(index)
+	if err != nil {
+		return nil, err
+	}
+	if client.inTransaction() {
+		return nil, fmt.Errorf("other databases cannot be accessed inside a transaction")
+	}
+	return db, nil
+}
+
+// parseDBIndex parses the index of a database given to SELECT, MOVE, SWAPDB or COPY.
+func parseDBIndex(s string) (int, error) {
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer or out of range")
+	}
+	return index, nil
+}
+
+// ===============================================================================
+func handleSelect(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("select command requires exactly one argument")
+	}
+	index, err := parseDBIndex(args[1])
+	if err != nil {
+		return nil, err
+	}
+	db, err := client.server.db(index)
+	if err != nil {
+		return nil, err
+	}
+	if client.inTransaction() {
+		return nil, fmt.Errorf("select is not allowed inside a transaction")
+	}
+	if index != 0 && client.server.cluster != nil {
+		return nil, fmt.Errorf("select is not allowed in cluster mode")
+	}
+	client.db = index
+	client.redis = []*Store{db}
+	return resp.SimpleString("OK"), nil
+}
+
+func handleMove(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("move command requires exactly two arguments")
+	}
+	if client.server.cluster != nil {
+		return nil, fmt.Errorf("move is not allowed in cluster mode")
+	}
+	index, err := parseDBIndex(args[2])
+	if err != nil {
+		return nil, err
+	}
+	target, err := client.storeAt(index)
+	if err != nil {
+		return nil, err
+	}
+	client.server.dbMutex.Lock()
+	defer client.server.dbMutex.Unlock()
+	moved, err := currentStore(client.redis).Move(args[1], target)
+	if err != nil {
+		return nil, err
+	}
+	if moved {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func handleSwapDB(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("swapdb command requires exactly two arguments")
+	}
+	if client.server.cluster != nil {
+		return nil, fmt.Errorf("swapdb is not allowed in cluster mode")
+	}
+	a, err := parseDBIndex(args[1])
+	if err != nil {
+		return nil, err
+	}
+	b, err := parseDBIndex(args[2])
+	if err != nil {
+		return nil, err
+	}
+	if client.inTransaction() {
+		return nil, fmt.Errorf("swapdb is not allowed inside a transaction")
+	}
+	if err := client.server.SwapDB(a, b); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// parseFlushMode checks the optional ASYNC or SYNC argument of FLUSHDB and FLUSHALL.
+func parseFlushMode(args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("syntax error")
+	}
+	if len(args) == 2 && !strings.EqualFold(args[1], "async") && !strings.EqualFold(args[1], "sync") {
+		return fmt.Errorf("syntax error")
+	}
+	return nil
+}
+
+func handleFlushDB(args []string, redis []*Store) (interface{}, error) {
+	if err := parseFlushMode(args); err != nil {
+		return nil, err
+	}
+	r := currentStore(redis)
+	r.Flush()
+	return resp.SimpleString("OK"), nil
+}
+
+func handleFlushAll(args []string, client *ClientDetail) (interface{}, error) {
+	if err := parseFlushMode(args); err != nil {
+		return nil, err
+	}
+	if client.inTransaction() {
+		return nil, fmt.Errorf("flushall is not allowed inside a transaction")
+	}
+	client.server.FlushAll()
+	return resp.SimpleString("OK"), nil
+}
@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestClient(server *RedisServer) *ClientDetail {
+	return &ClientDetail{server: server, redis: []*Store{server.dbs[0]}}
+}
+
+func TestSelect(t *testing.T) {
+	server := New(Config{Databases: 4})
+	client := newTestClient(server)
+	server.dbs[0].Set("key", "db0", 0)
+
+	if _, err := handleSelect([]string{"select", "2"}, client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := currentStore(client.redis).Get("key"); err == nil {
+		t.Errorf("Expected key not to exist in db 2")
+	}
+	if _, err := handleSelect([]string{"select", "4"}, client); err == nil {
+		t.Errorf("Expected an error for an index out of range")
+	}
+
+	// A transaction only covers the selected database
+	client.conn = &RedisClient{conn: discardConn{}}
+	client.execute([]string{"multi"})
+	if _, err := handleSelect([]string{"select", "0"}, client); err == nil {
+		t.Errorf("Expected SELECT to be rejected inside a transaction")
+	}
+	if _, err := client.storeAt(0); err == nil {
+		t.Errorf("Expected other databases to be unreachable inside a transaction")
+	}
+	client.execute([]string{"set", "key", "db2"})
+	client.execute([]string{"exec"})
+	if value, _ := server.dbs[2].Get("key"); value != "db2" {
+		t.Errorf("Expected EXEC to commit to db 2, got %q", value)
+	}
+}
+
+func TestMove(t *testing.T) {
+	server := New(Config{Databases: 2})
+	source, target := server.dbs[0], server.dbs[1]
+	source.Set("key", "value", time.Minute)
+	source.Set("taken", "value", 0)
+	target.Set("taken", "other", 0)
+
+	if moved, _ := source.Move("key", target); !moved {
+		t.Errorf("Expected key to be moved")
+	}
+	if item, exist := target.items["key"]; !exist || item.expiration.IsZero() {
+		t.Errorf("Expected key to be moved with its time to live")
+	}
+	if moved, _ := source.Move("taken", target); moved {
+		t.Errorf("Expected MOVE not to overwrite an existing key")
+	}
+	if moved, _ := source.Move("missing", target); moved {
+		t.Errorf("Expected MOVE of a missing key to fail")
+	}
+	if _, err := source.Move("taken", source); err == nil {
+		t.Errorf("Expected an error when moving to the same database")
+	}
+}
+
+func TestSwapDBAndFlush(t *testing.T) {
+	server := New(Config{Databases: 3})
+	client := newTestClient(server)
+	server.dbs[0].Set("key", "db0", 0)
+	server.dbs[1].Set("key", "db1", 0)
+
+	if err := server.SwapDB(0, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The client keeps db 0 selected and sees the data of db 1
+	if value, _ := currentStore(client.redis).Get("key"); value != "db1" {
+		t.Errorf("Expected db1, got %q", value)
+	}
+	if err := server.SwapDB(0, 3); err == nil {
+		t.Errorf("Expected an error for an index out of range")
+	}
+
+	if _, err := handleFlushDB([]string{"flushdb", "async"}, client.redis); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.dbs[0].DBSize() != 0 || server.dbs[1].DBSize() != 1 {
+		t.Errorf("Expected FLUSHDB to only flush the selected database")
+	}
+	if _, err := handleFlushAll([]string{"flushall", "now"}, client); err == nil {
+		t.Errorf("Expected a syntax error")
+	}
+	handleFlushAll([]string{"flushall"}, client)
+	if server.dbs[1].DBSize() != 0 {
+		t.Errorf("Expected FLUSHALL to flush every database")
+	}
+}
+
+func TestCopyDB(t *testing.T) {
+	server := New(Config{Databases: 2})
+	client := newTestClient(server)
+	server.dbs[0].Set("key", "value", 0)
+
+	if result, err := handleCopy([]string{"copy", "key", "key", "db", "1"}, client); err != nil || result != 1 {
+		t.Errorf("Expected 1, got %v (err %v)", result, err)
+	}
+	if value, _ := server.dbs[1].Get("key"); value != "value" {
+		t.Errorf("Expected the key to be copied to db 1")
+	}
+	if _, err := handleCopy([]string{"copy", "key", "key", "db", "0"}, client); err == nil {
+		t.Errorf("Expected an error when source and destination are the same")
+	}
+}
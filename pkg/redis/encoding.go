@@ -0,0 +1,255 @@
+package redis
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Values are stored with a compact encoding while they are small, and converted to a
+// structure giving faster access once they grow past the limits of encodingLimits:
+//
+// strings are int64 when they hold the canonical form of a 64-bit integer, []byte otherwise;
+// lists are a *listpack, then a []string;
+// sorted sets are a *sortedSet without scores map, then with a scores map.
+//
+// The limits follow the Redis configuration parameters of the same name.
+type encodingLimits struct {
+	listMaxListpackSize    int // entries when positive, 4KB << (-n - 1) bytes when negative
+	zsetMaxListpackEntries int
+	zsetMaxListpackValue   int
+}
+
+// defaultEncodingLimits are the defaults of the Redis configuration.
+var defaultEncodingLimits = encodingLimits{
+	listMaxListpackSize:    -2,
+	zsetMaxListpackEntries: 128,
+	zsetMaxListpackValue:   64,
+}
+
+// setEncodingLimits changes the limits used for the values written from now on.
+func (r *Store) setEncodingLimits(limits encodingLimits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = limits
+}
+
+// maxIntEncodedLength is the length of the longest 64-bit integer, "-9223372036854775808".
+const maxIntEncodedLength = 20
+
+// newStringValue returns the value storing str, integer encoded when possible.
+func newStringValue(str string) interface{} {
+	if len(str) <= maxIntEncodedLength {
+		if n, ok := parseInt64(str); ok {
+			return n
+		}
+	}
+	return []byte(str)
+}
+
+// stringBytes returns the content of a string value whatever its encoding,
+// and false if the value is not a string.
+func stringBytes(value interface{}) ([]byte, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, true
+	case int64:
+		return strconv.AppendInt(nil, v, 10), true
+	}
+	return nil, false
+}
+
+// listpack stores the elements of a small list in a single buffer. Like the Redis listpack,
+// each entry is the length of the element, the element, then the length of the entry encoded
+// so it can be read backwards, which lets elements be appended and popped at the end in place.
+type listpack struct {
+	data  []byte
+	count int
+}
+
+// appendBacklen encodes the length of an entry in 7-bit groups, the least significant group last.
+// The high bit of a group is set when more groups precede it.
+func appendBacklen(b []byte, l int) []byte {
+	var groups [5]byte
+	n := 0
+	for {
+		groups[n] = byte(l & 127)
+		l >>= 7
+		n++
+		if l == 0 {
+			break
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		g := groups[i]
+		if i < n-1 {
+			g |= 128
+		}
+		b = append(b, g)
+	}
+	return b
+}
+
+// backlenSize returns the number of bytes appendBacklen uses for l.
+func backlenSize(l int) int {
+	n := 1
+	for l >= 128 {
+		l >>= 7
+		n++
+	}
+	return n
+}
+
+// readBacklen decodes the entry length that ends b, returning it with its encoded size.
+func readBacklen(b []byte) (l int, size int) {
+	for shift := 0; ; shift += 7 {
+		g := b[len(b)-1-size]
+		l |= int(g&127) << shift
+		size++
+		if g&128 == 0 {
+			return l, size
+		}
+	}
+}
+
+func newListpack() *listpack {
+	return &listpack{}
+}
+
+func (lp *listpack) len() int {
+	return lp.count
+}
+
+// push appends s at the end of the list.
+func (lp *listpack) push(s string) {
+	var header [binary.MaxVarintLen64]byte
+	headerLen := binary.PutUvarint(header[:], uint64(len(s)))
+	lp.data = append(lp.data, header[:headerLen]...)
+	lp.data = append(lp.data, s...)
+	lp.data = appendBacklen(lp.data, headerLen+len(s))
+	lp.count++
+}
+
+// pop removes and returns the last element of the list, which must not be empty.
+func (lp *listpack) pop() string {
+	entryLen, size := readBacklen(lp.data)
+	entry := lp.data[len(lp.data)-size-entryLen : len(lp.data)-size]
+	n, headerLen := binary.Uvarint(entry)
+	s := string(entry[headerLen : headerLen+int(n)])
+	lp.data = lp.data[:len(lp.data)-size-entryLen]
+	lp.count--
+	return s
+}
+
+// elements returns the elements of the list, in order.
+func (lp *listpack) elements() []string {
+	elements := make([]string, 0, lp.count)
+	for b := lp.data; len(b) > 0; {
+		n, headerLen := binary.Uvarint(b)
+		entryLen := headerLen + int(n)
+		elements = append(elements, string(b[headerLen:entryLen]))
+		b = b[entryLen+backlenSize(entryLen):]
+	}
+	return elements
+}
+
+func (lp *listpack) clone() *listpack {
+	return &listpack{data: append([]byte(nil), lp.data...), count: lp.count}
+}
+
+// listpackMaxBytes returns the size limit of a listpack, 0 when the limit is a number of entries.
+func (limits encodingLimits) listpackMaxBytes() int {
+	if limits.listMaxListpackSize >= 0 {
+		return 0
+	}
+	shift := -limits.listMaxListpackSize - 1
+	if shift > 4 {
+		shift = 4
+	}
+	return 4096 << shift
+}
+
+// fits reports whether a listpack can hold count elements taking size bytes.
+func (limits encodingLimits) fits(count, size int) bool {
+	if maxBytes := limits.listpackMaxBytes(); maxBytes > 0 {
+		return size <= maxBytes
+	}
+	return count <= limits.listMaxListpackSize
+}
+
+// fitsHalf reports whether the elements fit in half a listpack.
+func (limits encodingLimits) fitsHalf(elements []string) bool {
+	maxBytes := limits.listpackMaxBytes()
+	if maxBytes == 0 {
+		return len(elements)*2 <= limits.listMaxListpackSize
+	}
+	// Entries take at least 2 bytes, the size is only computed for lists that may fit.
+	if len(elements)*4 > maxBytes {
+		return false
+	}
+	size := 0
+	for _, s := range elements {
+		var header [binary.MaxVarintLen64]byte
+		entryLen := binary.PutUvarint(header[:], uint64(len(s))) + len(s)
+		size += entryLen + backlenSize(entryLen)
+	}
+	return size*2 <= maxBytes
+}
+
+// listElements returns the elements of a list value whatever its encoding, and false if the value is not a list.
+func listElements(value interface{}) ([]string, bool) {
+	switch v := value.(type) {
+	case *listpack:
+		return v.elements(), true
+	case []string:
+		return v, true
+	}
+	return nil, false
+}
+
+// listLen returns the length of a list value, and false if the value is not a list.
+func listLen(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case *listpack:
+		return v.len(), true
+	case []string:
+		return len(v), true
+	}
+	return 0, false
+}
+
+// listPush appends s to a list value, converting it to a []string once it is too big for a listpack.
+func (limits encodingLimits) listPush(value interface{}, s string) interface{} {
+	switch v := value.(type) {
+	case *listpack:
+		v.push(s)
+		if !limits.fits(v.len(), len(v.data)) {
+			return v.elements()
+		}
+		return v
+	case []string:
+		return append(v, s)
+	}
+	lp := newListpack()
+	return limits.listPush(lp, s)
+}
+
+// listPop removes the last element of a non empty list value. A []string shrinking under half
+// the size of a listpack is converted back, so that a list around the limit is not converted at each change.
+func (limits encodingLimits) listPop(value interface{}) (interface{}, string) {
+	switch v := value.(type) {
+	case *listpack:
+		return v, v.pop()
+	case []string:
+		element := v[len(v)-1]
+		v = v[:len(v)-1]
+		if limits.fitsHalf(v) {
+			lp := newListpack()
+			for _, s := range v {
+				lp.push(s)
+			}
+			return lp, element
+		}
+		return v, element
+	}
+	return value, ""
+}
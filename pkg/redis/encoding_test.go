@@ -0,0 +1,183 @@
+package redis
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewStringValue(t *testing.T) {
+	cases := map[string]interface{}{
+		"12345": int64(12345), "-9223372036854775808": int64(-9223372036854775808),
+		"0123": []byte("0123"), "+1": []byte("+1"), "1.5": []byte("1.5"), "abc": []byte("abc"),
+	}
+	for str, expected := range cases {
+		value := newStringValue(str)
+		if fmt.Sprintf("%T %v", value, value) != fmt.Sprintf("%T %v", expected, expected) {
+			t.Errorf("Expected %T %v for %q, got %T %v", expected, expected, str, value, value)
+		}
+		if b, _ := stringBytes(value); string(b) != str {
+			t.Errorf("Expected %q to round trip, got %q", str, b)
+		}
+	}
+
+	s := NewStore()
+	s.Set("counter", "10", 0)
+	s.Incre("counter")
+	if value, ok := s.items["counter"].value.(int64); !ok || value != 11 {
+		t.Errorf("Expected INCR to keep an integer encoded string, got %#v", s.items["counter"].value)
+	}
+	s.SetBit("counter", 0, 1)
+	if value, _ := s.Get("counter"); value != "\xb11" {
+		t.Errorf("Expected SETBIT to update the string, got %q", value)
+	}
+}
+
+func TestListpack(t *testing.T) {
+	lp := newListpack()
+	elements := []string{"", "a", strings.Repeat("b", 127), strings.Repeat("c", 200), strings.Repeat("d", 20000)}
+	for _, element := range elements {
+		lp.push(element)
+	}
+	if got := lp.elements(); strings.Join(got, ",") != strings.Join(elements, ",") {
+		t.Errorf("Expected the elements to round trip")
+	}
+	for i := len(elements) - 1; i >= 0; i-- {
+		if got := lp.pop(); got != elements[i] {
+			t.Errorf("Expected %d bytes, got %d", len(elements[i]), len(got))
+		}
+	}
+	if lp.len() != 0 || len(lp.data) != 0 {
+		t.Errorf("Expected an empty listpack")
+	}
+}
+
+func TestListConversion(t *testing.T) {
+	s := NewStore()
+	s.limits.listMaxListpackSize = 4
+	for i := 0; i < 5; i++ {
+		s.LPush("list", fmt.Sprint(i))
+		expected := "listpack"
+		if i == 4 {
+			expected = "quicklist"
+		}
+		if encoding := objectEncoding(s.items["list"].value); encoding != expected {
+			t.Errorf("Expected %s with %d elements, got %s", expected, i+1, encoding)
+		}
+	}
+	// Converted back once under half the limit
+	s.LPop("list")
+	s.LPop("list")
+	if encoding := objectEncoding(s.items["list"].value); encoding != "quicklist" {
+		t.Errorf("Expected quicklist with 3 elements, got %s", encoding)
+	}
+	s.LPop("list")
+	if encoding := objectEncoding(s.items["list"].value); encoding != "listpack" {
+		t.Errorf("Expected listpack with 2 elements, got %s", encoding)
+	}
+	if result, _ := s.LRange("list", 0, -1); result != "[0 1]" {
+		t.Errorf("Expected [0 1], got %s", result)
+	}
+
+	// Size based limit
+	s.limits.listMaxListpackSize = -1
+	s.LPush("big", strings.Repeat("x", 4000))
+	s.LPush("big", strings.Repeat("x", 100))
+	if encoding := objectEncoding(s.items["big"].value); encoding != "quicklist" {
+		t.Errorf("Expected quicklist past 4KB, got %s", encoding)
+	}
+}
+
+func TestSortedSetConversion(t *testing.T) {
+	server := New(DefaultConfig())
+	client := newTestClient(server)
+	handleConfig([]string{"config", "set", "zset-max-listpack-entries", "2"}, client)
+	s := server.dbs[0]
+
+	s.ZAdd("zset", []zsetEntry{{"a", 1}, {"b", 2}}, ZAddOptions{})
+	if encoding := objectEncoding(s.items["zset"].value); encoding != "listpack" {
+		t.Errorf("Expected listpack, got %s", encoding)
+	}
+	s.ZAdd("zset", []zsetEntry{{"c", 3}}, ZAddOptions{})
+	if encoding := objectEncoding(s.items["zset"].value); encoding != "skiplist" {
+		t.Errorf("Expected skiplist, got %s", encoding)
+	}
+	if score, exist, _ := s.ZScore("zset", "b"); !exist || score != 2 {
+		t.Errorf("Expected b to keep its score after the conversion")
+	}
+}
+
+// heapPerKey returns the heap memory retained per key after filling a store with fill.
+func heapPerKey(b *testing.B, keys int, fill func(s *Store, i int)) float64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	s := NewStore()
+	for i := 0; i < keys; i++ {
+		fill(s, i)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(s)
+	return float64(after.HeapAlloc-before.HeapAlloc) / float64(keys)
+}
+
+// BenchmarkMemoryPerKey compares the memory used per key by the compact encodings with the
+// plain structures they replace, reported as the bytes/key metric.
+func BenchmarkMemoryPerKey(b *testing.B) {
+	const keys = 100000
+	// Every key gets its own elements, as they would when read from clients.
+	elements := func(i int) []string {
+		names := []string{"alpha", "beta", "gamma", "delta", "epsilon"}
+		for j := range names {
+			names[j] = fmt.Sprint(names[j], i)
+		}
+		return names
+	}
+	cases := []struct {
+		name string
+		fill func(s *Store, i int)
+	}{
+		{"string-int/raw", func(s *Store, i int) {
+			s.items[fmt.Sprint("key:", i)] = ExpirationItem{value: []byte(fmt.Sprint(i))}
+		}},
+		{"string-int/int", func(s *Store, i int) {
+			s.items[fmt.Sprint("key:", i)] = ExpirationItem{value: newStringValue(fmt.Sprint(i))}
+		}},
+		{"list/quicklist", func(s *Store, i int) {
+			s.items[fmt.Sprint("key:", i)] = ExpirationItem{value: elements(i)}
+		}},
+		{"list/listpack", func(s *Store, i int) {
+			lp := newListpack()
+			for _, element := range elements(i) {
+				lp.push(element)
+			}
+			s.items[fmt.Sprint("key:", i)] = ExpirationItem{value: lp}
+		}},
+		{"zset/skiplist", func(s *Store, i int) {
+			z := newSortedSet()
+			for j, element := range elements(i) {
+				z.add(element, float64(j))
+			}
+			z.convertIfNeeded(encodingLimits{}, "")
+			s.items[fmt.Sprint("key:", i)] = ExpirationItem{value: z}
+		}},
+		{"zset/listpack", func(s *Store, i int) {
+			z := newSortedSet()
+			for j, element := range elements(i) {
+				z.add(element, float64(j))
+			}
+			s.items[fmt.Sprint("key:", i)] = ExpirationItem{value: z}
+		}},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			var perKey float64
+			for i := 0; i < b.N; i++ {
+				perKey = heapPerKey(b, keys, c.fill)
+			}
+			b.ReportMetric(perKey, "bytes/key")
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// The memory used by the databases is estimated from the size of the keys and values
+// plus a fixed overhead for the structures holding them.
+const (
+	itemOverhead      = 80 // map entry, ExpirationItem and interface header
+	intSize           = 8  // integer encoded string
+	sliceOverhead     = 24 // slice header
+	stringOverhead    = 16 // string header of a list element
+	listpackOverhead  = 32 // listpack struct
+	sortedSetOverhead = 64 // sortedSet struct, scores map and entries slice headers
+	zsetEntrySize     = 24 // zsetEntry
+	zsetMapEntrySize  = 32 // entry of the scores map
+)
+
+// valueSize estimates the memory used by a value stored in the database.
+func valueSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return intSize
+	case []byte:
+		return sliceOverhead + int64(len(v))
+	case *listpack:
+		return listpackOverhead + int64(len(v.data))
+	case []string:
+		size := int64(sliceOverhead)
+		for _, s := range v {
+			size += stringOverhead + int64(len(s))
+		}
+		return size
+	case *sortedSet:
+		size := sortedSetOverhead + int64(v.len())*zsetEntrySize + v.memberBytes
+		if !v.listpackEncoded() {
+			size += int64(v.len()) * zsetMapEntrySize
+		}
+		return size
+	}
+	return 0
+}
+
+// itemSize estimates the memory used by the item stored at key.
+func itemSize(key string, item ExpirationItem) int64 {
+	return itemOverhead + int64(len(key)) + valueSize(item.value)
+}
+
+// setItem stores item at key, keeping the memory used by the database and the access data
+// of the item up to date. Items overwriting a key keep its access frequency, like in Redis.
+// Adding key notifies the new event. The caller must hold r.mu.
+func (r *Store) setItem(key string, item ExpirationItem) {
+	if !r.storeItem(key, item) {
+		r.notifyEvent(notifyNew, "new", key)
+	}
+}
+
+// storeItem is setItem without notifying the new keys, for the keys loaded from a file or merged
+// from a transaction. It reports whether key existed. The caller must hold r.mu.
+func (r *Store) storeItem(key string, item ExpirationItem) bool {
+	old, exist := r.items[key]
+	if item.generation == 0 {
+		item.generation = r.generation
+	}
+	atomic.AddInt64(&r.dirty, 1)
+	if item.lru == 0 {
+		if exist && r.lfu {
+			item.lru = old.lru
+		} else {
+			item.lru = r.newClock()
+		}
+	}
+	item.size = itemSize(key, item)
+	atomic.AddInt64(&r.used, item.size-old.size)
+	r.items[key] = item
+	if !exist {
+		r.keys.add(key)
+		if r.slotKeys != nil {
+			r.slotKeys.add(key)
+		}
+	}
+	if item.expiration.IsZero() {
+		r.volatileKeys.remove(key)
+	} else {
+		r.volatileKeys.add(key)
+	}
+	return exist
+}
+
+// deleteItem deletes key, keeping the memory used by the database up to date.
+// The caller must hold r.mu.
+func (r *Store) deleteItem(key string) {
+	if old, exist := r.items[key]; exist {
+		atomic.AddInt64(&r.dirty, 1)
+		atomic.AddInt64(&r.used, -old.size)
+		delete(r.items, key)
+		r.keys.remove(key)
+		r.volatileKeys.remove(key)
+		if r.slotKeys != nil {
+			r.slotKeys.remove(key)
+		}
+	}
+}
+
+// keyIndex holds keys in a slice so that random keys are picked in constant time, which the
+// iteration of a map does not allow: its first keys are not a random sample.
+// The zero value is an empty index.
+type keyIndex struct {
+	keys      []string
+	positions map[string]int
+}
+
+func (x *keyIndex) add(key string) {
+	if _, exist := x.positions[key]; exist {
+		return
+	}
+	if x.positions == nil {
+		x.positions = map[string]int{}
+	}
+	x.positions[key] = len(x.keys)
+	x.keys = append(x.keys, key)
+}
+
+func (x *keyIndex) remove(key string) {
+	i, exist := x.positions[key]
+	if !exist {
+		return
+	}
+	last := x.keys[len(x.keys)-1]
+	x.keys[i] = last
+	x.positions[last] = i
+	x.keys = x.keys[:len(x.keys)-1]
+	delete(x.positions, key)
+}
+
+func (x *keyIndex) len() int {
+	return len(x.keys)
+}
+
+// random returns a random key of the index, which must not be empty.
+func (x *keyIndex) random() string {
+	return x.keys[rand.Intn(len(x.keys))]
+}
+
+// sample returns n random keys of the index, or all its keys when it holds n keys or less.
+// The same key may be returned more than once.
+func (x *keyIndex) sample(n int) []string {
+	if len(x.keys) <= n {
+		return append([]string{}, x.keys...)
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = x.random()
+	}
+	return keys
+}
+
+// UsedMemory returns the memory used by the items of the database.
+func (r *Store) UsedMemory() int64 {
+	return atomic.LoadInt64(&r.used)
+}
+
+// setLFU switches the access data of the items between access time and access frequency.
+func (r *Store) setLFU(lfu bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lfu = lfu
+}
+
+// The LFU access data packs the last decrement time in minutes in the 16 upper bits
+// and a logarithmic access counter in the 8 lower bits, as Redis does.
+const (
+	lfuInitVal   = 5  // counter of new items, so they are not evicted right away
+	lfuLogFactor = 10 // how many accesses it takes to saturate the counter
+	lfuDecayTime = 1  // minutes after which the counter is decremented
+)
+
+// lruClock returns the current time in seconds, used as access time by the LRU policies.
+func lruClock() uint32 {
+	return uint32(time.Now().Unix())
+}
+
+// lruIdleTime returns how many seconds elapsed since the access time.
+func lruIdleTime(lru uint32) uint64 {
+	now := lruClock()
+	if now < lru {
+		return 0
+	}
+	return uint64(now - lru)
+}
+
+// lfuTimeInMinutes returns the current time in minutes, reduced to 16 bits.
+func lfuTimeInMinutes() uint32 {
+	return uint32(time.Now().Unix()/60) & 65535
+}
+
+// lfuDecrAndReturn returns the access counter of the LFU data, decremented by the number
+// of decay periods elapsed since it was last decremented.
+func lfuDecrAndReturn(lru uint32) uint32 {
+	ldt := lru >> 8
+	counter := lru & 255
+	now := lfuTimeInMinutes()
+	elapsed := now - ldt
+	if now < ldt {
+		elapsed = 65535 - ldt + now
+	}
+	if periods := elapsed / lfuDecayTime; periods > 0 {
+		if periods > counter {
+			return 0
+		}
+		return counter - periods
+	}
+	return counter
+}
+
+// lfuLogIncr increments the access counter with a probability getting lower as the counter grows.
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return counter
+	}
+	baseval := float64(counter) - lfuInitVal
+	if baseval < 0 {
+		baseval = 0
+	}
+	if rand.Float64() < 1/(baseval*lfuLogFactor+1) {
+		counter++
+	}
+	return counter
+}
+
+// newClock returns the access data of a new item.
+func (r *Store) newClock() uint32 {
+	if r.lfu {
+		return lfuTimeInMinutes()<<8 | lfuInitVal
+	}
+	return lruClock()
+}
+
+// accessClock returns the access data of an item after an access.
+func (r *Store) accessClock(lru uint32) uint32 {
+	if r.lfu {
+		return lfuTimeInMinutes()<<8 | lfuLogIncr(lfuDecrAndReturn(lru))
+	}
+	return lruClock()
+}
+
+// MaxMemoryPolicy selects the keys evicted when the memory used goes over the maxmemory limit.
+type MaxMemoryPolicy string
+
+const (
+	NoEviction     MaxMemoryPolicy = "noeviction"
+	AllKeysLRU     MaxMemoryPolicy = "allkeys-lru"
+	VolatileLRU    MaxMemoryPolicy = "volatile-lru"
+	AllKeysLFU     MaxMemoryPolicy = "allkeys-lfu"
+	VolatileLFU    MaxMemoryPolicy = "volatile-lfu"
+	AllKeysRandom  MaxMemoryPolicy = "allkeys-random"
+	VolatileRandom MaxMemoryPolicy = "volatile-random"
+	VolatileTTL    MaxMemoryPolicy = "volatile-ttl"
+)
+
+// parseMaxMemoryPolicy parses the name of a policy, case insensitively.
+func parseMaxMemoryPolicy(s string) (MaxMemoryPolicy, error) {
+	policy := MaxMemoryPolicy(strings.ToLower(s))
+	switch policy {
+	case NoEviction, AllKeysLRU, VolatileLRU, AllKeysLFU, VolatileLFU, AllKeysRandom, VolatileRandom, VolatileTTL:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid maxmemory policy '%s'", s)
+}
+
+// volatile reports whether the policy only evicts keys with a time to live.
+func (p MaxMemoryPolicy) volatile() bool {
+	return strings.HasPrefix(string(p), "volatile-")
+}
+
+// lfu reports whether the policy needs the access frequency of the keys.
+func (p MaxMemoryPolicy) lfu() bool {
+	return p == AllKeysLFU || p == VolatileLFU
+}
+
+// evictionPoolSize is the number of eviction candidates kept between two evictions.
+const evictionPoolSize = 16
+
+// evictionCandidate is a key sampled for eviction. Keys with the highest idle value are evicted first.
+type evictionCandidate struct {
+	idle uint64
+	key  string
+	db   int
+}
+
+// errOOM is returned for the commands growing the memory when the maxmemory limit cannot be honoured.
+var errOOM = resp.Error("OOM command not allowed when used memory > 'maxmemory'.")
+
+// usedMemory returns the memory used by all the databases.
+func (r *RedisServer) usedMemory() int64 {
+	var used int64
+	for _, db := range r.dbs {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// freeMemoryIfNeeded evicts keys following the maxmemory policy until the memory used is
+// under the maxmemory limit. It returns errOOM when no more key can be evicted. A replica does not
+// evict keys, its primary does and streams the evictions.
+func (r *RedisServer) freeMemoryIfNeeded() error {
+	config := r.getConfig()
+	if config.MaxMemory <= 0 || r.isReplica() || r.usedMemory() <= config.MaxMemory {
+		return nil
+	}
+	if config.MaxMemoryPolicy == NoEviction {
+		return errOOM
+	}
+
+	// Evictions are propagated like the write commands, in the order they happen.
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+	r.evictionMutex.Lock()
+	defer r.evictionMutex.Unlock()
+	for r.usedMemory() > config.MaxMemory {
+		db, key, ok := r.evictKey(config)
+		if !ok {
+			return errOOM
+		}
+		atomic.AddInt64(&r.evictedKeys, 1)
+		r.propagate(db, []string{"DEL", key})
+	}
+	return nil
+}
+
+// evictKey evicts the best key according to the policy and returns it with the index of its database.
+// It reports false when no key can be evicted. The caller must hold r.evictionMutex.
+func (r *RedisServer) evictKey(config Config) (int, string, bool) {
+	policy := config.MaxMemoryPolicy
+	if policy == AllKeysRandom || policy == VolatileRandom {
+		// Visit the databases in turn so that evictions are spread over them.
+		for i := 0; i < len(r.dbs); i++ {
+			index := (r.evictionNextDB + i) % len(r.dbs)
+			if key, ok := r.dbs[index].randomEvictionKey(policy.volatile()); ok && r.dbs[index].evict(key) {
+				r.evictionNextDB = index + 1
+				return index, key, true
+			}
+		}
+		return 0, "", false
+	}
+
+	for index, db := range r.dbs {
+		r.evictionPool = db.populateEvictionPool(r.evictionPool, index, policy, config.MaxMemorySamples)
+	}
+	// The best candidates are at the end of the pool, they may have been deleted since they were sampled.
+	for len(r.evictionPool) > 0 {
+		best := r.evictionPool[len(r.evictionPool)-1]
+		r.evictionPool = r.evictionPool[:len(r.evictionPool)-1]
+		if r.dbs[best.db].evict(best.key) {
+			return best.db, best.key, true
+		}
+	}
+	return 0, "", false
+}
+
+// populateEvictionPool samples keys of the database and adds them to the pool, which is
+// kept sorted by idle value and holds at most evictionPoolSize candidates.
+func (r *Store) populateEvictionPool(pool []evictionCandidate, db int, policy MaxMemoryPolicy, samples int) []evictionCandidate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := &r.keys
+	if policy.volatile() {
+		keys = &r.volatileKeys
+	}
+	for _, key := range keys.sample(samples) {
+		item := r.items[key]
+		var idle uint64
+		switch {
+		case policy == VolatileTTL:
+			// Keys expiring first are evicted first.
+			idle = math.MaxUint64 - uint64(item.expiration.UnixNano()/int64(time.Millisecond))
+		case policy.lfu():
+			idle = 255 - uint64(lfuDecrAndReturn(item.lru))
+		default:
+			idle = lruIdleTime(item.lru)
+		}
+
+		known := false
+		for i := range pool {
+			if pool[i].db == db && pool[i].key == key {
+				pool[i].idle = idle
+				known = true
+				break
+			}
+		}
+		if !known {
+			pool = append(pool, evictionCandidate{idle: idle, key: key, db: db})
+		}
+	}
+	sort.SliceStable(pool, func(i, j int) bool { return pool[i].idle < pool[j].idle })
+	if len(pool) > evictionPoolSize {
+		pool = append(pool[:0], pool[len(pool)-evictionPoolSize:]...)
+	}
+	return pool
+}
+
+// randomEvictionKey returns a random key of the database, only among the keys with a time to live if volatile is set.
+func (r *Store) randomEvictionKey(volatile bool) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := &r.keys
+	if volatile {
+		keys = &r.volatileKeys
+	}
+	if keys.len() == 0 {
+		return "", false
+	}
+	return keys.random(), true
+}
+
+// evict deletes key for the eviction policies, reporting whether it still existed.
+func (r *Store) evict(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.items[key]; !exist {
+		return false
+	}
+	r.deleteItem(key)
+	r.notifyEvent(notifyEvicted, "evicted", key)
+	return true
+}
@@ -0,0 +1,203 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryAccounting(t *testing.T) {
+	s := NewStore()
+	s.Set("key", "value", 0)
+	expected := int64(itemOverhead + len("key") + sliceOverhead + len("value"))
+	if used := s.UsedMemory(); used != expected {
+		t.Errorf("Expected %d bytes, got %d", expected, used)
+	}
+
+	// Overwriting and growing values update the accounting
+	s.Set("key", "longer value", 0)
+	s.ZAdd("zset", []zsetEntry{{"a", 1}, {"b", 2}}, ZAddOptions{})
+	s.ZRem("zset", []string{"a"})
+	expected = int64(itemOverhead+len("key")+sliceOverhead+len("longer value")) +
+		int64(itemOverhead+len("zset")+sortedSetOverhead+zsetEntrySize+len("b"))
+	if used := s.UsedMemory(); used != expected {
+		t.Errorf("Expected %d bytes, got %d", expected, used)
+	}
+
+	s.Del("key", "zset")
+	if used := s.UsedMemory(); used != 0 {
+		t.Errorf("Expected 0 bytes once the keys are deleted, got %d", used)
+	}
+}
+
+func newEvictionServer(policy MaxMemoryPolicy, keys int) *RedisServer {
+	config := DefaultConfig()
+	config.MaxMemoryPolicy = policy
+	server := New(config)
+	for i := 0; i < keys; i++ {
+		server.dbs[i%2].Set(fmt.Sprintf("key:%d", i), "value", 0)
+	}
+	return server
+}
+
+func TestNoEviction(t *testing.T) {
+	server := newEvictionServer(NoEviction, 10)
+	server.setConfig("maxmemory", "100")
+	if err := server.freeMemoryIfNeeded(); err != errOOM {
+		t.Errorf("Expected an OOM error, got %v", err)
+	}
+	if server.dbs[0].DBSize()+server.dbs[1].DBSize() != 10 {
+		t.Errorf("Expected no key to be evicted")
+	}
+}
+
+func TestNoEvictionAbortsTransaction(t *testing.T) {
+	server := newEvictionServer(NoEviction, 10)
+	server.setConfig("maxmemory", "100")
+	replies := &heldConn{}
+	client := newTestConnClient(server, replies)
+	for _, args := range [][]string{{"multi"}, {"get", "key:0"}, {"set", "key", "value"}, {"exec"}} {
+		client.execute(args)
+	}
+	expected := "started transaction\nQUEUED\n" + errOOM.Error() + "\nEXECABORT Transaction discarded because of previous errors.\n"
+	if got := string(replies.replies); got != expected {
+		t.Errorf("Expected the transaction to be discarded, got %q", got)
+	}
+	if client.inTransaction() {
+		t.Errorf("Expected EXEC to close the transaction")
+	}
+}
+
+func TestEvictionPolicies(t *testing.T) {
+	policies := []MaxMemoryPolicy{AllKeysLRU, AllKeysLFU, AllKeysRandom}
+	for _, policy := range policies {
+		server := newEvictionServer(policy, 100)
+		limit := server.usedMemory() / 2
+		server.setConfig("maxmemory", fmt.Sprint(limit))
+		if err := server.freeMemoryIfNeeded(); err != nil {
+			t.Errorf("%s: unexpected error: %v", policy, err)
+		}
+		if used := server.usedMemory(); used > limit {
+			t.Errorf("%s: expected at most %d bytes, got %d", policy, limit, used)
+		}
+		if info := server.Info("stats"); info == "# Stats\nevicted_keys:0" {
+			t.Errorf("%s: expected evicted keys in INFO, got %q", policy, info)
+		}
+	}
+}
+
+func TestVolatileEviction(t *testing.T) {
+	server := newEvictionServer(VolatileTTL, 10)
+	server.dbs[0].Set("soon", "value", time.Minute)
+	server.dbs[0].Set("later", "value", time.Hour)
+	server.setConfig("maxmemory", fmt.Sprint(server.usedMemory()-1))
+
+	// Test case 1: the key expiring first is evicted
+	if err := server.freeMemoryIfNeeded(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if server.dbs[0].Exists("soon") != 0 || server.dbs[0].Exists("later") != 1 {
+		t.Errorf("Expected soon to be evicted")
+	}
+
+	// Test case 2: keys without time to live are never evicted
+	server.setConfig("maxmemory", "1")
+	if err := server.freeMemoryIfNeeded(); err != errOOM {
+		t.Errorf("Expected an OOM error, got %v", err)
+	}
+	if server.dbs[0].DBSize()+server.dbs[1].DBSize() != 10 {
+		t.Errorf("Expected only the volatile keys to be evicted")
+	}
+}
+
+func TestLRUEvictsIdleKeys(t *testing.T) {
+	server := newEvictionServer(AllKeysLRU, 0)
+	db := server.dbs[0]
+	db.Set("idle", "value", 0)
+	db.Set("used", "value", 0)
+	db.mu.Lock()
+	item := db.items["idle"]
+	item.lru -= 3600
+	db.items["idle"] = item
+	db.mu.Unlock()
+
+	server.setConfig("maxmemory", fmt.Sprint(server.usedMemory()-1))
+	server.freeMemoryIfNeeded()
+	if db.Exists("idle") != 0 || db.Exists("used") != 1 {
+		t.Errorf("Expected the idle key to be evicted")
+	}
+}
+
+func TestLFUCounter(t *testing.T) {
+	s := NewStore()
+	s.lfu = true
+	s.Set("key", "value", 0)
+	if counter := lfuDecrAndReturn(s.items["key"].lru); counter != lfuInitVal {
+		t.Errorf("Expected a new key to have counter %d, got %d", lfuInitVal, counter)
+	}
+	for i := 0; i < 1000; i++ {
+		s.Get("key")
+	}
+	counter := lfuDecrAndReturn(s.items["key"].lru)
+	if counter <= lfuInitVal || counter == 255 {
+		t.Errorf("Expected a logarithmic counter after 1000 accesses, got %d", counter)
+	}
+
+	// Overwriting the key keeps its frequency
+	s.Set("key", "other", 0)
+	if lfuDecrAndReturn(s.items["key"].lru) != counter {
+		t.Errorf("Expected the counter to be kept on overwrite")
+	}
+	// The counter decays by one per minute
+	lru := (lfuTimeInMinutes()-3)&65535<<8 | 10
+	if decayed := lfuDecrAndReturn(lru); decayed != 7 {
+		t.Errorf("Expected 7, got %d", decayed)
+	}
+}
+
+func TestEvictionSampling(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "value", 0)
+	}
+	for i := 0; i < 5; i++ {
+		s.Set(fmt.Sprintf("volatile:%d", i), "value", time.Hour)
+	}
+
+	// Test case 1: the volatile keys are picked among the keys with a time to live only, and at random
+	picked := map[string]bool{}
+	for i := 0; i < 200; i++ {
+		key, ok := s.randomEvictionKey(true)
+		if !ok || !strings.HasPrefix(key, "volatile:") {
+			t.Fatalf("Expected a volatile key, got %q, %v", key, ok)
+		}
+		picked[key] = true
+	}
+	if len(picked) != 5 {
+		t.Errorf("Expected the 5 volatile keys to be picked, got %v", picked)
+	}
+
+	// Test case 2: the pool is filled with random samples of the keyspace, not always the same keys
+	sampled := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		for _, c := range s.populateEvictionPool(nil, 0, AllKeysLRU, 5) {
+			sampled[c.key] = true
+		}
+	}
+	if len(sampled) < 200 {
+		t.Errorf("Expected samples spread over the keys, got %d distinct keys", len(sampled))
+	}
+
+	// Test case 3: the index follows the keys losing their time to live or deleted
+	s.Persist("volatile:0")
+	s.Del("volatile:1")
+	s.Set("volatile:2", "value", 0)
+	if s.volatileKeys.len() != 2 || s.keys.len() != 1004 {
+		t.Errorf("Expected 2 volatile keys out of 1004, got %d out of %d", s.volatileKeys.len(), s.keys.len())
+	}
+	s.Flush()
+	if _, ok := s.randomEvictionKey(false); ok {
+		t.Errorf("Expected no key once the database is flushed")
+	}
+}
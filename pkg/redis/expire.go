@@ -0,0 +1,257 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExpireOptions holds the conditions of the EXPIRE command and its variants.
+type ExpireOptions struct {
+	NX bool // only set the time to live when the key has none
+	XX bool // only set the time to live when the key has one
+	GT bool // only set a time to live greater than the current one, a key without one never expiring
+	LT bool // only set a time to live less than the current one
+}
+
+// Expire sets key to expire at the given time, deleting it right away when the time is in the past.
+// It reports false when key does not exist or the conditions of opts are not met.
+func (r *Store) Expire(key string, at time.Time, opts ExpireOptions) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.lookup(key)
+	if !exist {
+		return false
+	}
+	current := item.expiration
+	switch {
+	case opts.NX && !current.IsZero(), opts.XX && current.IsZero():
+		return false
+	case opts.GT && (current.IsZero() || !at.After(current)):
+		return false
+	case opts.LT && !current.IsZero() && !at.Before(current):
+		return false
+	}
+	if !at.After(time.Now()) {
+		r.deleteItem(key)
+		r.notifyEvent(notifyGeneric, "del", key)
+		return true
+	}
+	item.expiration = at
+	r.setItem(key, item)
+	r.notifyEvent(notifyGeneric, "expire", key)
+	return true
+}
+
+// ExpireTime returns when key expires, the zero time when it has no time to live.
+// It reports false when key does not exist.
+func (r *Store) ExpireTime(key string) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.lookup(key)
+	return item.expiration, exist
+}
+
+// Persist removes the time to live of key, reporting false when key does not exist or has none.
+func (r *Store) Persist(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.lookup(key)
+	if !exist || item.expiration.IsZero() {
+		return false
+	}
+	item.expiration = time.Time{}
+	r.setItem(key, item)
+	r.notifyEvent(notifyGeneric, "persist", key)
+	return true
+}
+
+// The active expiry deletes the keys whose time to live elapsed even when no client accesses them,
+// like Redis does: every activeExpirePeriod, activeExpireSamples keys with a time to live are sampled
+// in each database and the expired ones deleted, sampling again while more than a quarter of them
+// were expired, for at most activeExpireDuration.
+const (
+	activeExpirePeriod   = 100 * time.Millisecond
+	activeExpireSamples  = 20
+	activeExpireDuration = 25 * time.Millisecond
+)
+
+// expireSample deletes the expired keys among samples random keys with a time to live, and returns
+// the deleted keys with the number of keys sampled.
+func (r *Store) expireSample(samples int) ([]string, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var expired []string
+	keys := r.volatileKeys.sample(samples)
+	for _, key := range keys {
+		if item, exist := r.items[key]; exist && item.expired() {
+			r.deleteItem(key)
+			r.notifyEvent(notifyExpired, "expired", key)
+			expired = append(expired, key)
+		}
+	}
+	return expired, len(keys)
+}
+
+// activeExpireCycle runs the active expiry on the databases. The deletions are propagated like the
+// write commands, in the order they happen. A replica does not expire keys actively, its primary
+// does and streams the deletions.
+func (r *RedisServer) activeExpireCycle() {
+	if r.isReplica() {
+		return
+	}
+	deadline := time.Now().Add(activeExpireDuration)
+	for index, db := range r.dbs {
+		for time.Now().Before(deadline) {
+			r.writeMutex.Lock()
+			expired, sampled := db.expireSample(activeExpireSamples)
+			commands := make([][]string, len(expired))
+			for i, key := range expired {
+				commands[i] = []string{"DEL", key}
+			}
+			if len(commands) > 0 {
+				r.propagate(index, commands...)
+			}
+			r.writeMutex.Unlock()
+			if len(expired)*4 <= sampled {
+				break
+			}
+		}
+	}
+}
+
+// expireCron runs the active expiry every activeExpirePeriod.
+func (r *RedisServer) expireCron() {
+	ticker := time.NewTicker(activeExpirePeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.activeExpireCycle()
+	}
+}
+
+// parseExpireOptions parses the NX, XX, GT and LT options of EXPIRE.
+func parseExpireOptions(args []string) (ExpireOptions, error) {
+	var opts ExpireOptions
+	for _, arg := range args {
+		switch strings.ToLower(arg) {
+		case "nx":
+			opts.NX = true
+		case "xx":
+			opts.XX = true
+		case "gt":
+			opts.GT = true
+		case "lt":
+			opts.LT = true
+		default:
+			return opts, fmt.Errorf("unsupported option %s", arg)
+		}
+	}
+	if opts.NX && (opts.XX || opts.GT || opts.LT) {
+		return opts, fmt.Errorf("nx and xx, gt or lt options at the same time are not compatible")
+	}
+	if opts.GT && opts.LT {
+		return opts, fmt.Errorf("gt and lt options at the same time are not compatible")
+	}
+	return opts, nil
+}
+
+// ===============================================================================
+func handleExpire(args []string, redis []*Store) (interface{}, error) {
+	return expireGeneric(args, redis, time.Second, false)
+}
+
+func handlePExpire(args []string, redis []*Store) (interface{}, error) {
+	return expireGeneric(args, redis, time.Millisecond, false)
+}
+
+func handleExpireAt(args []string, redis []*Store) (interface{}, error) {
+	return expireGeneric(args, redis, time.Second, true)
+}
+
+func handlePExpireAt(args []string, redis []*Store) (interface{}, error) {
+	return expireGeneric(args, redis, time.Millisecond, true)
+}
+
+// expireGeneric implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT: the time is given in unit,
+// relative to now unless absolute is set.
+func expireGeneric(args []string, redis []*Store, unit time.Duration, absolute bool) (interface{}, error) {
+	name := strings.ToLower(args[0])
+	if len(args) < 3 {
+		return nil, fmt.Errorf("%s command requires at least two arguments", name)
+	}
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	opts, err := parseExpireOptions(args[3:])
+	if err != nil {
+		return nil, err
+	}
+	limit := int64(math.MaxInt64 / unit)
+	if n > limit || n < -limit {
+		return nil, fmt.Errorf("invalid expire time in '%s' command", name)
+	}
+	at := time.Unix(0, 0).Add(time.Duration(n) * unit)
+	if !absolute {
+		at = time.Now().Add(time.Duration(n) * unit)
+	}
+	r := currentStore(redis)
+	if r.Expire(args[1], at, opts) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func handleTTL(args []string, redis []*Store) (interface{}, error) {
+	return ttlGeneric(args, redis, time.Second, false)
+}
+
+func handlePTTL(args []string, redis []*Store) (interface{}, error) {
+	return ttlGeneric(args, redis, time.Millisecond, false)
+}
+
+func handleExpireTime(args []string, redis []*Store) (interface{}, error) {
+	return ttlGeneric(args, redis, time.Second, true)
+}
+
+func handlePExpireTime(args []string, redis []*Store) (interface{}, error) {
+	return ttlGeneric(args, redis, time.Millisecond, true)
+}
+
+// ttlGeneric implements TTL, PTTL, EXPIRETIME and PEXPIRETIME: it replies the time to live of the key
+// in unit, or its absolute expiration when absolute is set, -1 when the key has no time to live and
+// -2 when it does not exist.
+func ttlGeneric(args []string, redis []*Store, unit time.Duration, absolute bool) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s command requires exactly one argument", strings.ToLower(args[0]))
+	}
+	r := currentStore(redis)
+	at, exist := r.ExpireTime(args[1])
+	switch {
+	case !exist:
+		return int64(-2), nil
+	case at.IsZero():
+		return int64(-1), nil
+	case absolute:
+		return at.UnixNano() / int64(unit), nil
+	}
+	ttl := time.Until(at)
+	if ttl < 0 {
+		ttl = 0
+	}
+	// Round to the closest unit like Redis does.
+	return int64((ttl + unit/2) / unit), nil
+}
+
+func handlePersist(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("persist command requires exactly one argument")
+	}
+	r := currentStore(redis)
+	if r.Persist(args[1]) {
+		return 1, nil
+	}
+	return 0, nil
+}
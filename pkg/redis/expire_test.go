@@ -0,0 +1,101 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestExpire(t *testing.T) {
+	s := NewStore()
+	redis := []*Store{s}
+	s.Set("key", "value", 0)
+
+	if result, _ := handleTTL([]string{"ttl", "key"}, redis); result != int64(-1) {
+		t.Errorf("Expected -1 for a key without time to live, got %v", result)
+	}
+	if result, _ := handleTTL([]string{"ttl", "missing"}, redis); result != int64(-2) {
+		t.Errorf("Expected -2 for a missing key, got %v", result)
+	}
+
+	// Test case 1: the options compare with the current time to live, none meaning forever
+	steps := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{"expire", "key", "100", "xx"}, 0},
+		{[]string{"expire", "key", "100", "gt"}, 0},
+		{[]string{"expire", "key", "100", "lt"}, 1},
+		{[]string{"expire", "key", "200", "nx"}, 0},
+		{[]string{"expire", "key", "50", "gt"}, 0},
+		{[]string{"expire", "key", "200", "gt"}, 1},
+		{[]string{"expire", "missing", "100"}, 0},
+	}
+	for _, step := range steps {
+		if result, err := handleExpire(step.args, redis); err != nil || result != step.expected {
+			t.Errorf("%v: expected %d, got %v (%v)", step.args, step.expected, result, err)
+		}
+	}
+	if result, _ := handleTTL([]string{"ttl", "key"}, redis); result != int64(200) {
+		t.Errorf("Expected a time to live of 200, got %v", result)
+	}
+
+	// Test case 2: absolute times
+	at := time.Now().Add(time.Hour).Unix()
+	handleExpireAt([]string{"expireat", "key", strconv.FormatInt(at, 10)}, redis)
+	if result, _ := handleExpireTime([]string{"expiretime", "key"}, redis); result != at {
+		t.Errorf("Expected the expiration %d, got %v", at, result)
+	}
+	if result, _ := handlePExpireTime([]string{"pexpiretime", "key"}, redis); result != at*1000 {
+		t.Errorf("Expected the expiration %d000, got %v", at, result)
+	}
+
+	// Test case 3: PERSIST removes the time to live, once
+	if result, _ := handlePersist([]string{"persist", "key"}, redis); result != 1 {
+		t.Errorf("Expected PERSIST to remove the time to live, got %v", result)
+	}
+	if result, _ := handlePersist([]string{"persist", "key"}, redis); result != 0 {
+		t.Errorf("Expected PERSIST to reply 0 without time to live, got %v", result)
+	}
+
+	// Test case 4: a time in the past deletes the key
+	if result, _ := handleExpire([]string{"expire", "key", "-1"}, redis); result != 1 || s.Exists("key") != 0 {
+		t.Errorf("Expected a negative time to live to delete the key, got %v", result)
+	}
+
+	for _, args := range [][]string{
+		{"expire", "key", "1", "nx", "xx"},
+		{"expire", "key", "1", "gt", "lt"},
+		{"expire", "key", "1", "foo"},
+		{"expire", "key", "abc"},
+		{"expire", "key", "9223372036854775807"},
+	} {
+		if _, err := handleExpire(args, redis); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	server := startTestServer(t)
+	for i := 0; i < 100; i++ {
+		server.dbs[0].Set(fmt.Sprint("expiring", i), "v", time.Millisecond)
+		server.dbs[0].Set(fmt.Sprint("kept", i), "v", time.Hour)
+	}
+	server.dbs[1].Set("expiring", "v", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	// Like the cron, the cycles run until the expired keys are all deleted, without touching the others.
+	for i := 0; i < 100 && len(server.dbs[0].items)+len(server.dbs[1].items) > 100; i++ {
+		server.activeExpireCycle()
+	}
+	if size := len(server.dbs[0].items) + len(server.dbs[1].items); size != 100 {
+		t.Fatalf("%d keys left after the active expiry", size)
+	}
+	for i := 0; i < 100; i++ {
+		if server.dbs[0].Exists(fmt.Sprint("kept", i)) != 1 {
+			t.Fatalf("kept%d was expired", i)
+		}
+	}
+}
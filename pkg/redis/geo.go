@@ -0,0 +1,859 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geospatial indexes are sorted sets whose scores are 52 bit geohashes: the latitude and
+// longitude are each quantized on 26 bits and interleaved, latitude on the even bits.
+// The algorithms below follow Redis closely so results match for the same inputs.
+
+const (
+	geoStepMax          = 26
+	geoLatMin           = -85.05112878
+	geoLatMax           = 85.05112878
+	geoLongMin          = -180.0
+	geoLongMax          = 180.0
+	earthRadiusInMeters = 6372797.560856
+	mercatorMax         = 20037726.37
+	geoAlphabet         = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+type geoHashRange struct {
+	min, max float64
+}
+
+type geoHashArea struct {
+	longitude, latitude geoHashRange
+}
+
+type geoHashNeighbors struct {
+	north, east, west, south                   geoHashBits
+	northEast, southEast, northWest, southWest geoHashBits
+}
+
+var (
+	geoLongRange = geoHashRange{min: geoLongMin, max: geoLongMax}
+	geoLatRange  = geoHashRange{min: geoLatMin, max: geoLatMax}
+)
+
+// geoShape is the area searched by GEOSEARCH: a circle or a box centered on a point.
+type geoShape struct {
+	longitude, latitude float64
+	conversion          float64 // meters per unit
+	radius              float64 // in units, for BYRADIUS
+	width, height       float64 // in units, for BYBOX
+	box                 bool
+}
+
+// GeoPoint is a member found by a geospatial search.
+type GeoPoint struct {
+	Member    string
+	Longitude float64
+	Latitude  float64
+	Distance  float64 // in meters
+	Score     float64
+}
+
+// GeoSearchOptions describes the GEOSEARCH query.
+type GeoSearchOptions struct {
+	FromMember          string
+	HasMember           bool
+	Longitude, Latitude float64
+	Radius              float64
+	Width, Height       float64
+	ByBox               bool
+	Conversion          float64
+	Sort                int // 0 unsorted, 1 ascending, -1 descending
+	Count               int
+	Any                 bool
+}
+
+// ------------------------------------------------------------------------------------
+// Geohash encoding
+
+func interleave64(xlo, ylo uint32) uint64 {
+	b := []uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := []uint{1, 2, 4, 8, 16}
+	x, y := uint64(xlo), uint64(ylo)
+	for i := 4; i >= 0; i-- {
+		x = (x | x<<s[i]) & b[i]
+		y = (y | y<<s[i]) & b[i]
+	}
+	return x | y<<1
+}
+
+func deinterleave64(interleaved uint64) uint64 {
+	b := []uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := []uint{0, 1, 2, 4, 8, 16}
+	x, y := interleaved, interleaved>>1
+	for i := 0; i < 6; i++ {
+		x = (x | x>>s[i]) & b[i]
+		y = (y | y>>s[i]) & b[i]
+	}
+	return x | y<<32
+}
+
+func geohashEncode(longRange, latRange geoHashRange, longitude, latitude float64, step uint) (geoHashBits, bool) {
+	if step > 32 || step == 0 {
+		return geoHashBits{}, false
+	}
+	if longitude > geoLongMax || longitude < geoLongMin || latitude > geoLatMax || latitude < geoLatMin {
+		return geoHashBits{}, false
+	}
+	if latitude < latRange.min || latitude > latRange.max || longitude < longRange.min || longitude > longRange.max {
+		return geoHashBits{}, false
+	}
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	// Convert to fixed point based on the step size
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(longOffset)), step: step}, true
+}
+
+func geohashDecode(longRange, latRange geoHashRange, hash geoHashBits) geoHashArea {
+	hilo := deinterleave64(hash.bits)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	ilato := float64(uint32(hilo))
+	ilono := float64(uint32(hilo >> 32))
+	scale := float64(uint64(1) << hash.step)
+	return geoHashArea{
+		latitude: geoHashRange{
+			min: latRange.min + (ilato/scale)*latScale,
+			max: latRange.min + ((ilato+1)/scale)*latScale,
+		},
+		longitude: geoHashRange{
+			min: longRange.min + (ilono/scale)*longScale,
+			max: longRange.min + ((ilono+1)/scale)*longScale,
+		},
+	}
+}
+
+// decodeGeohash returns the longitude and latitude at the center of the area of a 52 bit score.
+func decodeGeohash(score float64) (float64, float64) {
+	area := geohashDecode(geoLongRange, geoLatRange, geoHashBits{bits: uint64(score), step: geoStepMax})
+	longitude := (area.longitude.min + area.longitude.max) / 2
+	longitude = math.Max(geoLongMin, math.Min(geoLongMax, longitude))
+	latitude := (area.latitude.min + area.latitude.max) / 2
+	latitude = math.Max(geoLatMin, math.Min(geoLatMax, latitude))
+	return longitude, latitude
+}
+
+// geohashString returns the standard 11 characters geohash of a score, as GEOHASH does.
+func geohashString(score float64) string {
+	longitude, latitude := decodeGeohash(score)
+	// Re-encode using the standard latitude range used by geohash strings
+	hash, _ := geohashEncode(geoHashRange{-180, 180}, geoHashRange{-90, 90}, longitude, latitude, geoStepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		index := 0
+		// We have just 52 bits, the 11th character is always zero
+		if i < 10 {
+			index = int(hash.bits>>(52-uint((i+1)*5))) & 0x1f
+		}
+		buf[i] = geoAlphabet[index]
+	}
+	return string(buf)
+}
+
+func geohashMoveX(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+func geohashMoveY(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= 0x5555555555555555 >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+func geohashNeighborsOf(hash geoHashBits) geoHashNeighbors {
+	move := func(dx, dy int) geoHashBits {
+		h := hash
+		if dx != 0 {
+			geohashMoveX(&h, dx)
+		}
+		if dy != 0 {
+			geohashMoveY(&h, dy)
+		}
+		return h
+	}
+	return geoHashNeighbors{
+		east: move(1, 0), west: move(-1, 0), south: move(0, -1), north: move(0, 1),
+		northWest: move(-1, 1), southWest: move(-1, -1), northEast: move(1, 1), southEast: move(1, -1),
+	}
+}
+
+// ------------------------------------------------------------------------------------
+// Distances
+
+func degRad(d float64) float64 { return d * (math.Pi / 180.0) }
+func radDeg(r float64) float64 { return r / (math.Pi / 180.0) }
+
+func geohashGetLatDistance(lat1d, lat2d float64) float64 {
+	return earthRadiusInMeters * math.Abs(degRad(lat2d)-degRad(lat1d))
+}
+
+// geohashGetDistance returns the haversine distance in meters between two points.
+func geohashGetDistance(lon1d, lat1d, lon2d, lat2d float64) float64 {
+	lon1r, lon2r := degRad(lon1d), degRad(lon2d)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// Avoid the expensive math when the longitudes are practically the same
+	if v == 0 {
+		return geohashGetLatDistance(lat1d, lat2d)
+	}
+	lat1r, lat2r := degRad(lat1d), degRad(lat2d)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * earthRadiusInMeters * math.Asin(math.Sqrt(a))
+}
+
+// withinShape reports whether the point is inside the shape and its distance in meters from the center.
+func (shape geoShape) withinShape(longitude, latitude float64) (float64, bool) {
+	if shape.box {
+		width, height := shape.width*shape.conversion, shape.height*shape.conversion
+		// The latitude distance is cheaper to compute so it is checked first
+		if geohashGetLatDistance(latitude, shape.latitude) > height/2 {
+			return 0, false
+		}
+		if geohashGetDistance(longitude, latitude, shape.longitude, latitude) > width/2 {
+			return 0, false
+		}
+		return geohashGetDistance(shape.longitude, shape.latitude, longitude, latitude), true
+	}
+	distance := geohashGetDistance(shape.longitude, shape.latitude, longitude, latitude)
+	if distance > shape.radius*shape.conversion {
+		return 0, false
+	}
+	return distance, true
+}
+
+// boundingBox returns min longitude, min latitude, max longitude and max latitude of the shape.
+func (shape geoShape) boundingBox() (float64, float64, float64, float64) {
+	height, width := shape.radius, shape.radius
+	if shape.box {
+		height, width = shape.height/2, shape.width/2
+	}
+	height *= shape.conversion
+	width *= shape.conversion
+
+	latDelta := radDeg(height / earthRadiusInMeters)
+	longDeltaTop := radDeg(width / earthRadiusInMeters / math.Cos(degRad(shape.latitude+latDelta)))
+	longDeltaBottom := radDeg(width / earthRadiusInMeters / math.Cos(degRad(shape.latitude-latDelta)))
+	// The directions of the northern and southern hemispheres are opposite
+	if shape.latitude < 0 {
+		return shape.longitude - longDeltaBottom, shape.latitude - latDelta, shape.longitude + longDeltaBottom, shape.latitude + latDelta
+	}
+	return shape.longitude - longDeltaTop, shape.latitude - latDelta, shape.longitude + longDeltaTop, shape.latitude + latDelta
+}
+
+func geohashEstimateStepsByRadius(rangeMeters, latitude float64) uint {
+	if rangeMeters == 0 {
+		return geoStepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	// Make sure range is included in most of the base cases
+	step -= 2
+	// Wider range towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > geoStepMax {
+		step = geoStepMax
+	}
+	return uint(step)
+}
+
+// searchAreas returns the geohash boxes (the center one and its neighbors) covering the shape.
+// Boxes that can not contain matching points are zeroed.
+func (shape geoShape) searchAreas() []geoHashBits {
+	minLon, minLat, maxLon, maxLat := shape.boundingBox()
+	radiusMeters := shape.radius
+	if shape.box {
+		// Use the distance from the center to a corner of the box
+		radiusMeters = math.Sqrt((shape.width/2)*(shape.width/2) + (shape.height/2)*(shape.height/2))
+	}
+	radiusMeters *= shape.conversion
+
+	steps := geohashEstimateStepsByRadius(radiusMeters, shape.latitude)
+	hash, _ := geohashEncode(geoLongRange, geoLatRange, shape.longitude, shape.latitude, steps)
+	neighbors := geohashNeighborsOf(hash)
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+
+	// The estimated step may not be small enough when the search area is near the edge of the box
+	north := geohashDecode(geoLongRange, geoLatRange, neighbors.north)
+	south := geohashDecode(geoLongRange, geoLatRange, neighbors.south)
+	east := geohashDecode(geoLongRange, geoLatRange, neighbors.east)
+	west := geohashDecode(geoLongRange, geoLatRange, neighbors.west)
+	decreaseStep := north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLon || west.longitude.min > minLon
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = geohashEncode(geoLongRange, geoLatRange, shape.longitude, shape.latitude, steps)
+		neighbors = geohashNeighborsOf(hash)
+		area = geohashDecode(geoLongRange, geoLatRange, hash)
+	}
+
+	// Exclude the search areas that are useless
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			neighbors.south, neighbors.southWest, neighbors.southEast = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.latitude.max > maxLat {
+			neighbors.north, neighbors.northEast, neighbors.northWest = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.longitude.min < minLon {
+			neighbors.west, neighbors.southWest, neighbors.northWest = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+		if area.longitude.max > maxLon {
+			neighbors.east, neighbors.southEast, neighbors.northEast = geoHashBits{}, geoHashBits{}, geoHashBits{}
+		}
+	}
+	return []geoHashBits{
+		hash, neighbors.north, neighbors.south, neighbors.east, neighbors.west,
+		neighbors.northEast, neighbors.northWest, neighbors.southEast, neighbors.southWest,
+	}
+}
+
+// search returns the members of zset inside the shape, stopping after limit matches when limit > 0.
+func (shape geoShape) search(zset *sortedSet, limit int) []GeoPoint {
+	points := []GeoPoint{}
+	areas := shape.searchAreas()
+	lastProcessed := 0
+	for i, box := range areas {
+		if box.bits == 0 && box.step == 0 {
+			continue
+		}
+		// With huge radiuses adjacent neighbors can be the same box, skip duplicates
+		if lastProcessed != 0 && box == areas[lastProcessed] {
+			continue
+		}
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		shift := 52 - box.step*2
+		min := float64(box.bits << shift)
+		max := float64((box.bits + 1) << shift)
+		for _, e := range zset.rangeByScore(min, max, false) {
+			if limit > 0 && len(points) >= limit {
+				break
+			}
+			longitude, latitude := decodeGeohash(e.score)
+			distance, ok := shape.withinShape(longitude, latitude)
+			if !ok {
+				continue
+			}
+			points = append(points, GeoPoint{Member: e.member, Longitude: longitude, Latitude: latitude, Distance: distance, Score: e.score})
+		}
+		lastProcessed = i
+	}
+	return points
+}
+
+// ------------------------------------------------------------------------------------
+// Store operations
+
+// GeoAdd adds the members at their positions to the geospatial index stored at key.
+func (r *Store) GeoAdd(key string, longitudes, latitudes []float64, members []string, opts ZAddOptions) (int, error) {
+	entries := make([]zsetEntry, len(members))
+	for i, member := range members {
+		hash, ok := geohashEncode(geoLongRange, geoLatRange, longitudes[i], latitudes[i], geoStepMax)
+		if !ok {
+			return 0, fmt.Errorf("invalid longitude,latitude pair %f,%f", longitudes[i], latitudes[i])
+		}
+		entries[i] = zsetEntry{member: member, score: float64(hash.bits)}
+	}
+	count, _, err := r.ZAdd(key, entries, opts)
+	return count, err
+}
+
+// GeoPos returns the positions of the members, nil for members that are not in the index.
+func (r *Store) GeoPos(key string, members []string) ([]*[2]float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zset, err := r.zsetForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]*[2]float64, len(members))
+	for i, member := range members {
+		if zset == nil {
+			continue
+		}
+		if score, exist := zset.score(member); exist {
+			longitude, latitude := decodeGeohash(score)
+			positions[i] = &[2]float64{longitude, latitude}
+		}
+	}
+	return positions, nil
+}
+
+// GeoDist returns the distance in meters between two members, reporting false if one is missing.
+func (r *Store) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zset, err := r.zsetForRead(key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	score1, exist1 := zset.score(member1)
+	score2, exist2 := zset.score(member2)
+	if !exist1 || !exist2 {
+		return 0, false, nil
+	}
+	lon1, lat1 := decodeGeohash(score1)
+	lon2, lat2 := decodeGeohash(score2)
+	return geohashGetDistance(lon1, lat1, lon2, lat2), true, nil
+}
+
+// GeoHash returns the geohash strings of the members, empty for members that are not in the index.
+func (r *Store) GeoHash(key string, members []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zset, err := r.zsetForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(members))
+	for i, member := range members {
+		if zset == nil {
+			continue
+		}
+		if score, exist := zset.score(member); exist {
+			hashes[i] = geohashString(score)
+		}
+	}
+	return hashes, nil
+}
+
+// GeoSearch returns the members of the geospatial index stored at key within the searched area.
+func (r *Store) GeoSearch(key string, opts GeoSearchOptions) ([]GeoPoint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.geoSearch(key, opts)
+}
+
+// geoSearch implements GeoSearch, the caller must hold r.mu.
+func (r *Store) geoSearch(key string, opts GeoSearchOptions) ([]GeoPoint, error) {
+	zset, err := r.zsetForRead(key)
+	if err != nil || zset == nil {
+		return []GeoPoint{}, err
+	}
+
+	shape := geoShape{
+		longitude: opts.Longitude, latitude: opts.Latitude, conversion: opts.Conversion,
+		radius: opts.Radius, width: opts.Width, height: opts.Height, box: opts.ByBox,
+	}
+	if opts.HasMember {
+		score, exist := zset.score(opts.FromMember)
+		if !exist {
+			return nil, fmt.Errorf("could not decode requested zset member")
+		}
+		shape.longitude, shape.latitude = decodeGeohash(score)
+	}
+
+	sorting := opts.Sort
+	// COUNT without ANY returns the nearest members
+	if opts.Count > 0 && sorting == 0 && !opts.Any {
+		sorting = 1
+	}
+	limit := 0
+	if opts.Any {
+		limit = opts.Count
+	}
+	points := shape.search(zset, limit)
+
+	if sorting != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if sorting > 0 {
+				return points[i].Distance < points[j].Distance
+			}
+			return points[i].Distance > points[j].Distance
+		})
+	}
+	if opts.Count > 0 && len(points) > opts.Count {
+		points = points[:opts.Count]
+	}
+	return points, nil
+}
+
+// GeoSearchStore stores the result of a search in destKey, with the geohash scores or, when
+// storeDist is set, the distances (in the unit of the query) as scores.
+func (r *Store) GeoSearchStore(destKey, key string, opts GeoSearchOptions, storeDist bool) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	points, err := r.geoSearch(key, opts)
+	if err != nil {
+		return 0, err
+	}
+	if len(points) == 0 {
+		if _, exist := r.items[destKey]; exist {
+			r.deleteItem(destKey)
+			r.notifyEvent(notifyGeneric, "del", destKey)
+		}
+		return 0, nil
+	}
+	zset := newSortedSet()
+	for _, p := range points {
+		score := p.Score
+		if storeDist {
+			score = p.Distance / opts.Conversion
+		}
+		zset.add(p.Member, score)
+		zset.convertIfNeeded(r.limits, p.Member)
+	}
+	r.setItem(destKey, ExpirationItem{value: zset})
+	r.notifyEvent(notifyZSet, "geosearchstore", destKey)
+	return zset.len(), nil
+}
+
+// ===============================================================================
+
+// geoUnitConversion returns the number of meters in unit.
+func geoUnitConversion(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, fmt.Errorf("unsupported unit provided. please use m, km, ft, mi")
+}
+
+// formatGeoCoordinate formats a coordinate like Redis does: 17 decimals with trailing zeros removed.
+func formatGeoCoordinate(value float64) string {
+	s := strconv.FormatFloat(value, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func formatGeoDistance(distance float64) string {
+	return strconv.FormatFloat(distance, 'f', 4, 64)
+}
+
+func handleGeoAdd(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 5 {
+		return nil, fmt.Errorf("geoadd command requires at least four arguments")
+	}
+	key := args[1]
+	var opts ZAddOptions
+	i := 2
+	for ; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		if option == "nx" {
+			opts.NX = true
+		} else if option == "xx" {
+			opts.XX = true
+		} else if option == "ch" {
+			opts.CH = true
+		} else {
+			break
+		}
+	}
+	if opts.NX && opts.XX {
+		return nil, fmt.Errorf("xx and nx options at the same time are not compatible")
+	}
+	triples := args[i:]
+	if len(triples) == 0 || len(triples)%3 != 0 {
+		return nil, fmt.Errorf("syntax error. try geoadd key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+	}
+
+	var longitudes, latitudes []float64
+	var members []string
+	for j := 0; j < len(triples); j += 3 {
+		longitude, ok1 := parseFloat(triples[j])
+		latitude, ok2 := parseFloat(triples[j+1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("value is not a valid float")
+		}
+		longitudes = append(longitudes, longitude)
+		latitudes = append(latitudes, latitude)
+		members = append(members, triples[j+2])
+	}
+
+	r := currentStore(redis)
+	count, err := r.GeoAdd(key, longitudes, latitudes, members, opts)
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+func handleGeoPos(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("geopos command requires at least one argument")
+	}
+	r := currentStore(redis)
+	positions, err := r.GeoPos(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, len(positions))
+	for i, position := range positions {
+		if position != nil {
+			result[i] = []string{formatGeoCoordinate(position[0]), formatGeoCoordinate(position[1])}
+		}
+	}
+	return result, nil
+}
+
+func handleGeoDist(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 4 && len(args) != 5 {
+		return nil, fmt.Errorf("geodist command requires three or four arguments")
+	}
+	conversion := 1.0
+	if len(args) == 5 {
+		var err error
+		if conversion, err = geoUnitConversion(args[4]); err != nil {
+			return nil, err
+		}
+	}
+	r := currentStore(redis)
+	distance, exist, err := r.GeoDist(args[1], args[2], args[3])
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return formatGeoDistance(distance / conversion), nil
+}
+
+func handleGeoHash(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("geohash command requires at least one argument")
+	}
+	r := currentStore(redis)
+	hashes, err := r.GeoHash(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		if hash != "" {
+			result[i] = hash
+		}
+	}
+	return result, nil
+}
+
+// geoSearchReplyOptions are the WITH* options of GEOSEARCH.
+type geoSearchReplyOptions struct {
+	withCoord, withDist, withHash bool
+}
+
+// parseGeoSearch parses the arguments of GEOSEARCH (after the key) and, when store is set, of
+// GEOSEARCHSTORE which accepts STOREDIST instead of the WITH* options.
+func parseGeoSearch(args []string, store bool) (GeoSearchOptions, geoSearchReplyOptions, bool, error) {
+	opts := GeoSearchOptions{}
+	var reply geoSearchReplyOptions
+	storeDist := false
+	hasLonLat, hasRadius := false, false
+	hasCount := false
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToLower(args[i]) {
+		case "frommember":
+			if remaining < 1 {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			opts.FromMember, opts.HasMember = args[i+1], true
+			i++
+		case "fromlonlat":
+			if remaining < 2 {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			longitude, ok1 := parseFloat(args[i+1])
+			latitude, ok2 := parseFloat(args[i+2])
+			if !ok1 || !ok2 {
+				return opts, reply, false, fmt.Errorf("value is not a valid float")
+			}
+			if longitude > geoLongMax || longitude < geoLongMin || latitude > geoLatMax || latitude < geoLatMin {
+				return opts, reply, false, fmt.Errorf("invalid longitude,latitude pair %f,%f", longitude, latitude)
+			}
+			opts.Longitude, opts.Latitude, hasLonLat = longitude, latitude, true
+			i += 2
+		case "byradius":
+			if remaining < 2 {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			radius, ok := parseFloat(args[i+1])
+			if !ok || radius < 0 {
+				return opts, reply, false, fmt.Errorf("radius cannot be negative")
+			}
+			conversion, err := geoUnitConversion(args[i+2])
+			if err != nil {
+				return opts, reply, false, err
+			}
+			opts.Radius, opts.Conversion, hasRadius = radius, conversion, true
+			i += 2
+		case "bybox":
+			if remaining < 3 {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			width, ok1 := parseFloat(args[i+1])
+			height, ok2 := parseFloat(args[i+2])
+			if !ok1 || !ok2 || width < 0 || height < 0 {
+				return opts, reply, false, fmt.Errorf("height or width cannot be negative")
+			}
+			conversion, err := geoUnitConversion(args[i+3])
+			if err != nil {
+				return opts, reply, false, err
+			}
+			opts.Width, opts.Height, opts.Conversion, opts.ByBox = width, height, conversion, true
+			i += 3
+		case "asc":
+			opts.Sort = 1
+		case "desc":
+			opts.Sort = -1
+		case "count":
+			if remaining < 1 {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				return opts, reply, false, fmt.Errorf("count must be > 0")
+			}
+			opts.Count, hasCount = count, true
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1], "any") {
+				opts.Any = true
+				i++
+			}
+		case "withcoord":
+			if store {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			reply.withCoord = true
+		case "withdist":
+			if store {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			reply.withDist = true
+		case "withhash":
+			if store {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			reply.withHash = true
+		case "storedist":
+			if !store {
+				return opts, reply, false, fmt.Errorf("syntax error")
+			}
+			storeDist = true
+		default:
+			return opts, reply, false, fmt.Errorf("syntax error")
+		}
+	}
+
+	if opts.HasMember == hasLonLat {
+		return opts, reply, false, fmt.Errorf("exactly one of frommember or fromlonlat can be specified for geosearch")
+	}
+	if hasRadius == opts.ByBox {
+		return opts, reply, false, fmt.Errorf("exactly one of byradius and bybox can be specified for geosearch")
+	}
+	if opts.Any && !hasCount {
+		return opts, reply, false, fmt.Errorf("the any argument requires count argument")
+	}
+	return opts, reply, storeDist, nil
+}
+
+func handleGeoSearch(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("geosearch command requires at least one argument")
+	}
+	opts, reply, _, err := parseGeoSearch(args[2:], false)
+	if err != nil {
+		return nil, err
+	}
+
+	r := currentStore(redis)
+	points, err := r.GeoSearch(args[1], opts)
+	if err != nil {
+		return nil, err
+	}
+	if !reply.withCoord && !reply.withDist && !reply.withHash {
+		members := make([]string, len(points))
+		for i, p := range points {
+			members[i] = p.Member
+		}
+		return members, nil
+	}
+	result := make([]interface{}, len(points))
+	for i, p := range points {
+		item := []interface{}{p.Member}
+		if reply.withDist {
+			item = append(item, formatGeoDistance(p.Distance/opts.Conversion))
+		}
+		if reply.withHash {
+			item = append(item, int64(p.Score))
+		}
+		if reply.withCoord {
+			item = append(item, []string{formatGeoCoordinate(p.Longitude), formatGeoCoordinate(p.Latitude)})
+		}
+		result[i] = item
+	}
+	return result, nil
+}
+
+func handleGeoSearchStore(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("geosearchstore command requires at least two arguments")
+	}
+	opts, _, storeDist, err := parseGeoSearch(args[3:], true)
+	if err != nil {
+		return nil, err
+	}
+
+	r := currentStore(redis)
+	count, err := r.GeoSearchStore(args[1], args[2], opts, storeDist)
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
@@ -0,0 +1,149 @@
+package redis
+
+import (
+	"math"
+	"testing"
+)
+
+// newSicily returns the geospatial index used in the Redis documentation examples.
+func newSicily(t *testing.T) *Store {
+	s := NewStore()
+	_, err := s.GeoAdd("Sicily",
+		[]float64{13.361389, 15.087269, 12.758489, 17.241510},
+		[]float64{38.115556, 37.502669, 38.788135, 38.788135},
+		[]string{"Palermo", "Catania", "edge1", "edge2"}, ZAddOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestGeoAddScores(t *testing.T) {
+	s := newSicily(t)
+	expected := map[string]float64{"Palermo": 3479099956230698, "Catania": 3479447370796909, "edge2": 3481342659049484}
+	for member, score := range expected {
+		if got, _, _ := s.ZScore("Sicily", member); got != score {
+			t.Errorf("Expected %s to have score %.0f, got %.0f", member, score, got)
+		}
+	}
+
+	if _, err := s.GeoAdd("Sicily", []float64{13}, []float64{86}, []string{"pole"}, ZAddOptions{}); err == nil {
+		t.Error("Expected an error for a latitude out of range")
+	}
+}
+
+func TestGeoPosAndGeoHash(t *testing.T) {
+	s := newSicily(t)
+	positions, err := s.GeoPos("Sicily", []string{"Palermo", "Catania", "NonExisting"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if formatGeoCoordinate(positions[0][0]) != "13.36138933897018433" || formatGeoCoordinate(positions[0][1]) != "38.11555639549629859" {
+		t.Errorf("Unexpected position for Palermo: %v", *positions[0])
+	}
+	if formatGeoCoordinate(positions[1][0]) != "15.08726745843887329" || formatGeoCoordinate(positions[1][1]) != "37.50266842333162032" {
+		t.Errorf("Unexpected position for Catania: %v", *positions[1])
+	}
+	if positions[2] != nil {
+		t.Errorf("Expected nil position for a missing member")
+	}
+
+	hashes, _ := s.GeoHash("Sicily", []string{"Palermo", "Catania"})
+	if hashes[0] != "sqc8b49rny0" || hashes[1] != "sqdtr74hyu0" {
+		t.Errorf("Expected [sqc8b49rny0 sqdtr74hyu0], got %v", hashes)
+	}
+}
+
+func TestGeoDist(t *testing.T) {
+	s := newSicily(t)
+	distance, exist, err := s.GeoDist("Sicily", "Palermo", "Catania")
+	if err != nil || !exist {
+		t.Fatalf("Expected a distance, err: %v", err)
+	}
+	expected := map[string]string{"m": "166274.1516", "km": "166.2742", "mi": "103.3182"}
+	for unit, value := range expected {
+		conversion, _ := geoUnitConversion(unit)
+		if got := formatGeoDistance(distance / conversion); got != value {
+			t.Errorf("Expected %s %s, got %s", value, unit, got)
+		}
+	}
+	if _, exist, _ := s.GeoDist("Sicily", "Palermo", "Agrigento"); exist {
+		t.Errorf("Expected no distance for a missing member")
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	s := newSicily(t)
+
+	// Test case 1: BYRADIUS sorted by distance
+	points, err := s.GeoSearch("Sicily", GeoSearchOptions{Longitude: 15, Latitude: 37, Radius: 200, Conversion: 1000, Sort: 1})
+	if err != nil || len(points) != 2 || points[0].Member != "Catania" || points[1].Member != "Palermo" {
+		t.Errorf("Expected [Catania Palermo], got %v (err %v)", points, err)
+	}
+
+	// Test case 2: BYBOX with distances
+	points, _ = s.GeoSearch("Sicily", GeoSearchOptions{Longitude: 15, Latitude: 37, Width: 400, Height: 400, ByBox: true, Conversion: 1000, Sort: 1})
+	expected := []struct {
+		member   string
+		distance string
+	}{{"Catania", "56.4413"}, {"Palermo", "190.4424"}, {"edge2", "279.7403"}, {"edge1", "279.7405"}}
+	if len(points) != len(expected) {
+		t.Fatalf("Expected %d points, got %v", len(expected), points)
+	}
+	for i, e := range expected {
+		if points[i].Member != e.member || formatGeoDistance(points[i].Distance/1000) != e.distance {
+			t.Errorf("Expected %s at %s km, got %s at %s km", e.member, e.distance, points[i].Member, formatGeoDistance(points[i].Distance/1000))
+		}
+	}
+
+	// Test case 3: FROMMEMBER with COUNT returns the nearest members
+	points, _ = s.GeoSearch("Sicily", GeoSearchOptions{FromMember: "Palermo", HasMember: true, Radius: 500, Conversion: 1000, Count: 2, Sort: -1})
+	if len(points) != 2 || points[0].Member != "edge2" {
+		t.Errorf("Expected edge2 to be the farthest member, got %v", points)
+	}
+	if _, err := s.GeoSearch("Sicily", GeoSearchOptions{FromMember: "Agrigento", HasMember: true, Radius: 1, Conversion: 1}); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	s := newSicily(t)
+	opts := GeoSearchOptions{Longitude: 15, Latitude: 37, Width: 400, Height: 400, ByBox: true, Conversion: 1000, Sort: 1, Count: 3}
+
+	count, err := s.GeoSearchStore("key2", "Sicily", opts, true)
+	if err != nil || count != 3 {
+		t.Fatalf("Expected 3 stored members, got %d (err %v)", count, err)
+	}
+	entries, _ := s.ZRange("key2", 0, -1)
+	expected := []float64{56.441257870158204, 190.44242984775784, 279.7403417843143}
+	for i, e := range entries {
+		if math.Abs(e.score-expected[i]) > 1e-9 {
+			t.Errorf("Expected score %v, got %v", expected[i], e.score)
+		}
+	}
+
+	// An empty result deletes the destination
+	count, _ = s.GeoSearchStore("key2", "Sicily", GeoSearchOptions{Longitude: 0, Latitude: 0, Radius: 1, Conversion: 1}, false)
+	if n, _ := s.ZCard("key2"); count != 0 || n != 0 {
+		t.Errorf("Expected the destination to be deleted")
+	}
+}
+
+func TestParseGeoSearch(t *testing.T) {
+	invalid := [][]string{
+		{"frommember", "a", "fromlonlat", "1", "2", "byradius", "1", "m"},
+		{"fromlonlat", "1", "2"},
+		{"fromlonlat", "1", "2", "byradius", "1", "m", "bybox", "1", "1", "m"},
+		{"fromlonlat", "1", "2", "byradius", "1", "yd"},
+		{"fromlonlat", "1", "2", "byradius", "1", "m", "any"},
+		{"fromlonlat", "1", "2", "byradius", "1", "m", "count", "0"},
+	}
+	for _, args := range invalid {
+		if _, _, _, err := parseGeoSearch(args, false); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+	if _, _, _, err := parseGeoSearch([]string{"fromlonlat", "1", "2", "byradius", "1", "m", "withdist"}, true); err == nil {
+		t.Errorf("Expected GEOSEARCHSTORE to reject WITHDIST")
+	}
+}
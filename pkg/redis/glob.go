@@ -0,0 +1,103 @@
+package redis
+
+// maxGlobNesting bounds the recursion of stringMatch on patterns with many stars.
+const maxGlobNesting = 1000
+
+// stringMatch reports whether str matches the glob-style pattern, following the rules of Redis:
+// '*' matches any sequence of characters, '?' matches a single character, '[abc]' matches one of
+// the characters between the brackets, '[a-z]' a range of characters, '[^abc]' any character but
+// the ones between the brackets, and a backslash escapes the character after it.
+func stringMatch(pattern, str string) bool {
+	skipLongerMatches := false
+	return stringMatchNested(pattern, str, &skipLongerMatches, 0)
+}
+
+// stringMatchNested matches like stringMatch. Once a star failed to match the rest of the string,
+// skipLongerMatches is set so that the stars before it stop trying longer matches, which can only fail too.
+func stringMatchNested(pattern, str string, skipLongerMatches *bool, nesting int) bool {
+	if nesting > maxGlobNesting {
+		return false
+	}
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if stringMatchNested(pattern[1:], str, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				str = str[1:]
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			str = str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				default:
+					if pattern[0] == str[0] {
+						match = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+			// An unterminated bracket ends the pattern, there is no ']' to skip.
+			if len(pattern) == 0 {
+				continue
+			}
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+		if len(str) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
@@ -0,0 +1,39 @@
+package redis
+
+import "testing"
+
+func TestStringMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hallo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:email", false},
+		{"a**b", "ab", true},
+		{"a*", "", false},
+		{"h[a", "ha", true},
+		{"h[a", "hb", false},
+		{"*a*a*a*a*a*a*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+	}
+	for _, c := range cases {
+		if got := stringMatch(c.pattern, c.str); got != c.match {
+			t.Errorf("stringMatch(%q, %q): expected %v, got %v", c.pattern, c.str, c.match, got)
+		}
+	}
+}
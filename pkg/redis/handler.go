@@ -3,152 +3,927 @@ package redis
 import (
 	"bufio"
 	"fmt"
+	"math"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/MinhNHHH/redis/pkg/resp"
 	"github.com/google/uuid"
 )
 
 type CommandType string
 
 const (
-	multiCommand        CommandType = "multi"
-	execCommand         CommandType = "exec"
-	discardCommand      CommandType = "discard"
-	getCommand          CommandType = "get"
-	setCommand          CommandType = "set"
-	getSetCommand       CommandType = "getset"
-	increCommand        CommandType = "incr"
-	increByCommand      CommandType = "incrby"
-	decrCommand         CommandType = "decr"
-	decrByCommand       CommandType = "decrby"
-	deleteCommand       CommandType = "del"
-	strLengthCommand    CommandType = "strlen"
-	setAndExpireCommand CommandType = "setex"
-	lpushCommand        CommandType = "lpush"
-	lrangeCommand       CommandType = "lrange"
-	lpopCommand         CommandType = "lpop"
+	multiCommand          CommandType = "multi"
+	execCommand           CommandType = "exec"
+	discardCommand        CommandType = "discard"
+	getCommand            CommandType = "get"
+	setCommand            CommandType = "set"
+	getSetCommand         CommandType = "getset"
+	increCommand          CommandType = "incr"
+	increByCommand        CommandType = "incrby"
+	increByFloatCommand   CommandType = "incrbyfloat"
+	decrCommand           CommandType = "decr"
+	decrByCommand         CommandType = "decrby"
+	deleteCommand         CommandType = "del"
+	strLengthCommand      CommandType = "strlen"
+	setAndExpireCommand   CommandType = "setex"
+	lpushCommand          CommandType = "lpush"
+	lrangeCommand         CommandType = "lrange"
+	lpopCommand           CommandType = "lpop"
+	setBitCommand         CommandType = "setbit"
+	getBitCommand         CommandType = "getbit"
+	bitCountCommand       CommandType = "bitcount"
+	bitPosCommand         CommandType = "bitpos"
+	bitOpCommand          CommandType = "bitop"
+	bitFieldCommand       CommandType = "bitfield"
+	bitFieldROCommand     CommandType = "bitfield_ro"
+	pfAddCommand          CommandType = "pfadd"
+	pfCountCommand        CommandType = "pfcount"
+	pfMergeCommand        CommandType = "pfmerge"
+	pfDebugCommand        CommandType = "pfdebug"
+	zAddCommand           CommandType = "zadd"
+	zRemCommand           CommandType = "zrem"
+	zScoreCommand         CommandType = "zscore"
+	zCardCommand          CommandType = "zcard"
+	zRangeCommand         CommandType = "zrange"
+	geoAddCommand         CommandType = "geoadd"
+	geoPosCommand         CommandType = "geopos"
+	geoDistCommand        CommandType = "geodist"
+	geoHashCommand        CommandType = "geohash"
+	geoSearchCommand      CommandType = "geosearch"
+	geoSearchStoreCommand CommandType = "geosearchstore"
+	unlinkCommand         CommandType = "unlink"
+	existsCommand         CommandType = "exists"
+	touchCommand          CommandType = "touch"
+	typeCommand           CommandType = "type"
+	renameCommand         CommandType = "rename"
+	renameNXCommand       CommandType = "renamenx"
+	copyCommand           CommandType = "copy"
+	dbSizeCommand         CommandType = "dbsize"
+	randomKeyCommand      CommandType = "randomkey"
+	keysCommand           CommandType = "keys"
+	scanCommand           CommandType = "scan"
+	selectCommand         CommandType = "select"
+	moveCommand           CommandType = "move"
+	swapDBCommand         CommandType = "swapdb"
+	flushDBCommand        CommandType = "flushdb"
+	flushAllCommand       CommandType = "flushall"
+	configCommand         CommandType = "config"
+	infoCommand           CommandType = "info"
+	objectCommand         CommandType = "object"
+	memoryCommand         CommandType = "memory"
+	saveCommand           CommandType = "save"
+	bgSaveCommand         CommandType = "bgsave"
+	lastSaveCommand       CommandType = "lastsave"
+	expireCommand         CommandType = "expire"
+	pExpireCommand        CommandType = "pexpire"
+	expireAtCommand       CommandType = "expireat"
+	pExpireAtCommand      CommandType = "pexpireat"
+	ttlCommand            CommandType = "ttl"
+	pTTLCommand           CommandType = "pttl"
+	expireTimeCommand     CommandType = "expiretime"
+	pExpireTimeCommand    CommandType = "pexpiretime"
+	persistCommand        CommandType = "persist"
+	bgRewriteAofCommand   CommandType = "bgrewriteaof"
+	pingCommand           CommandType = "ping"
+	replicaOfCommand      CommandType = "replicaof"
+	slaveOfCommand        CommandType = "slaveof"
+	roleCommand           CommandType = "role"
+	replconfCommand       CommandType = "replconf"
+	psyncCommand          CommandType = "psync"
+	waitCommand           CommandType = "wait"
+	clusterCommand        CommandType = "cluster"
+	askingCommand         CommandType = "asking"
+	dumpCommand           CommandType = "dump"
+	restoreCommand        CommandType = "restore"
+	restoreAskingCommand  CommandType = "restore-asking"
+	migrateCommand        CommandType = "migrate"
+	waitAofCommand        CommandType = "waitaof"
+	subscribeCommand      CommandType = "subscribe"
+	unsubscribeCommand    CommandType = "unsubscribe"
+	pSubscribeCommand     CommandType = "psubscribe"
+	pUnsubscribeCommand   CommandType = "punsubscribe"
+	publishCommand        CommandType = "publish"
+	pubsubCommand         CommandType = "pubsub"
+	sSubscribeCommand     CommandType = "ssubscribe"
+	sUnsubscribeCommand   CommandType = "sunsubscribe"
+	sPublishCommand       CommandType = "spublish"
 )
 
+// denyOOMCommands are the commands that may grow the memory used by the databases.
+// They are refused when keys cannot be evicted to stay under the maxmemory limit.
+var denyOOMCommands = map[CommandType]bool{
+	setCommand:            true,
+	getSetCommand:         true,
+	setAndExpireCommand:   true,
+	increCommand:          true,
+	increByCommand:        true,
+	increByFloatCommand:   true,
+	decrCommand:           true,
+	decrByCommand:         true,
+	lpushCommand:          true,
+	setBitCommand:         true,
+	bitOpCommand:          true,
+	bitFieldCommand:       true,
+	pfAddCommand:          true,
+	pfMergeCommand:        true,
+	zAddCommand:           true,
+	geoAddCommand:         true,
+	geoSearchStoreCommand: true,
+	copyCommand:           true,
+	restoreCommand:        true,
+	restoreAskingCommand:  true,
+}
+
+// transactionCommands are the commands that run when they are received inside a transaction,
+// instead of being queued until EXEC.
+var transactionCommands = map[CommandType]bool{
+	multiCommand:   true,
+	execCommand:    true,
+	discardCommand: true,
+}
+
+// writeCommands are the commands that may change the databases. They run one at a time and are
+// logged to the AOF once they changed something.
+var writeCommands = map[CommandType]bool{
+	setCommand:            true,
+	getSetCommand:         true,
+	setAndExpireCommand:   true,
+	increCommand:          true,
+	increByCommand:        true,
+	increByFloatCommand:   true,
+	decrCommand:           true,
+	decrByCommand:         true,
+	deleteCommand:         true,
+	unlinkCommand:         true,
+	lpushCommand:          true,
+	lpopCommand:           true,
+	setBitCommand:         true,
+	bitOpCommand:          true,
+	bitFieldCommand:       true,
+	pfAddCommand:          true,
+	pfMergeCommand:        true,
+	zAddCommand:           true,
+	zRemCommand:           true,
+	geoAddCommand:         true,
+	geoSearchStoreCommand: true,
+	renameCommand:         true,
+	renameNXCommand:       true,
+	copyCommand:           true,
+	moveCommand:           true,
+	swapDBCommand:         true,
+	flushDBCommand:        true,
+	flushAllCommand:       true,
+	expireCommand:         true,
+	pExpireCommand:        true,
+	expireAtCommand:       true,
+	pExpireAtCommand:      true,
+	persistCommand:        true,
+	execCommand:           true,
+	restoreCommand:        true,
+	restoreAskingCommand:  true,
+	migrateCommand:        true,
+}
+
 type ClientDetail struct {
-	conn      *RedisClient
-	redis     []*Store
-	totalConn int
+	conn   *RedisClient
+	server *RedisServer
+	db     int      // index of the database selected with SELECT
+	redis  []*Store // the selected database
+
+	multiQueue   [][]string // commands of the open transactions, run once the outermost one is executed
+	multiMarks   []int      // length of multiQueue when each open transaction started
+	multiAborted bool       // a command of the open transactions was refused, EXEC discards them
+
+	master   bool        // the client applies the stream of the primary of the server
+	loading  bool        // the client replays the AOF, its commands were checked when they were logged
+	replica  *replica    // the client is a replica, once it sent PSYNC
+	replPort int         // port announced with REPLCONF listening-port
+	woff     int64       // replication offset of the last write of the client, waited for by WAIT and WAITAOF
+	asking   bool        // the client sent ASKING, its next command may run on a slot being imported
+	sub      *subscriber // the connection of the client once it subscribed to channels or patterns
 }
 
 // HandleClient handles the incoming client connection.
 // It reads commands from the client, processes them, and sends responses back to the client.
 func HandleClient(conn net.Conn, r *RedisServer) {
 	client := &ClientDetail{
-		conn:      &RedisClient{ID: uuid.NewString(), conn: conn},
-		redis:     []*Store{r.store}, // Perform a transaction on each Store instance in the slice,
-		totalConn: len(r.clients),
+		conn:   &RedisClient{ID: uuid.NewString(), conn: conn},
+		server: r,
+		redis:  []*Store{r.dbs[0]}, // Perform a transaction on each Store instance in the slice,
 	}
 	r.AddClient(client.conn)
-	scanner := bufio.NewScanner(conn)
-	for scanner.Scan() {
-		client.handleCommand(scanner.Text())
+	br := bufio.NewReader(conn)
+	requests := resp.NewReader(br)
+	for {
+		args, err := client.readRequest(br, requests)
+		if err != nil {
+			break
+		}
+		client.execute(args)
 	}
+	client.closeReplica()
+	client.unsubscribeAll()
 	defer r.RemoveClient(*client.conn)
 }
 
-func (client *ClientDetail) handleCommand(command string) {
-	args := strings.Split(strings.Trim(command, " "), " ")
-	switch CommandType(strings.ToLower(args[0])) {
+// execute runs the command made of args and sends its reply to the client.
+func (client *ClientDetail) execute(args []string) {
+	commandType := CommandType(strings.ToLower(args[0]))
+	if client.subscribed() && !subscriberCommands[commandType] {
+		sendReplyToClient(client.conn.conn, fmt.Errorf("can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context", strings.ToLower(args[0])))
+		return
+	}
+	if err := client.checkCommand(commandType, args); err != nil {
+		if client.inTransaction() {
+			// The transaction is discarded on EXEC.
+			client.multiAborted = true
+		}
+		sendReplyToClient(client.conn.conn, err)
+		return
+	}
+	if client.inTransaction() && !transactionCommands[commandType] {
+		// The command runs with the other commands of the transaction on EXEC.
+		client.multiQueue = append(client.multiQueue, args)
+		sendReplyToClient(client.conn.conn, resp.SimpleString("QUEUED"))
+		return
+	}
+	if writeCommands[commandType] {
+		if err := client.server.appendOnlyError(); err != nil {
+			sendReplyToClient(client.conn.conn, fmt.Errorf("misconf errors writing to the aof file: %v", err))
+			return
+		}
+		defer client.beginWrite(commandType, args)()
+	}
+	client.dispatch(commandType, args)
+}
+
+// checkCommand returns the error refusing the command made of args, if any: a redirection to the
+// node of the cluster serving its keys, or the refusal of a write when the memory is full or the
+// server is a read only replica. The commands replayed from the AOF were checked when they were logged.
+func (client *ClientDetail) checkCommand(commandType CommandType, args []string) error {
+	if client.loading {
+		// The commands of the AOF are loaded before the slots of the node are known and while
+		// the server may be a read only replica or out of memory.
+		return nil
+	}
+	// A node of a cluster redirects the commands on keys it does not serve, except the ones of its
+	// primary.
+	asking := client.asking
+	client.asking = false
+	if client.server.cluster != nil && !client.master {
+		if redirect := client.server.clusterRedirect(commandType, args, asking); redirect != "" {
+			return resp.Error(redirect)
+		}
+	}
+	// Evict keys before running any command, only refusing the ones that could use more memory.
+	if err := client.server.freeMemoryIfNeeded(); err != nil && denyOOMCommands[commandType] && !client.master {
+		return err
+	}
+	if writeCommands[commandType] && commandType != execCommand && !client.master && client.server.readOnly() {
+		return resp.Error("READONLY You can't write against a read only replica.")
+	}
+	return nil
+}
+
+// dispatch runs the command made of args and sends its reply to the client, once execute checked
+// the command can run.
+func (client *ClientDetail) dispatch(commandType CommandType, args []string) {
+	switch commandType {
 	case getCommand:
 		result, err := handleGet(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
 		} else {
-			sendBackToClient(client.conn.conn, result)
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case setCommand:
-		_, err := handleSet(args, client.redis)
+		result, err := handleSet(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case setAndExpireCommand:
-		_, err := handleSetEx(args, client.redis)
+		result, err := handleSetEx(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case deleteCommand:
-		_, err := handleDel(args, client.redis)
+		result, err := handleDel(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case getSetCommand:
 		result, err := handleGetSet(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
 		} else {
-			sendBackToClient(client.conn.conn, result)
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case increCommand:
-		_, err := handleIncre(args, client.redis)
+		result, err := handleIncre(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case increByCommand:
-		_, err := handleIncreBy(args, client.redis)
+		result, err := handleIncreBy(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case increByFloatCommand:
+		result, err := handleIncreByFloat(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case decrCommand:
-		_, err := handleDecre(args, client.redis)
+		result, err := handleDecre(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case decrByCommand:
-		_, err := handleDecreBy(args, client.redis)
+		result, err := handleDecreBy(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case lpushCommand:
-		_, err := handleLPush(args, client.redis)
+		result, err := handleLPush(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case lrangeCommand:
 		result, err := handleLRange(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
 		} else {
-			sendBackToClient(client.conn.conn, result)
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case lpopCommand:
 		result, err := handleLPop(args, client.redis)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case setBitCommand:
+		result, err := handleSetBit(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case getBitCommand:
+		result, err := handleGetBit(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bitCountCommand:
+		result, err := handleBitCount(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bitPosCommand:
+		result, err := handleBitPos(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bitOpCommand:
+		result, err := handleBitOp(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bitFieldCommand:
+		result, err := handleBitField(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bitFieldROCommand:
+		result, err := handleBitFieldRO(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pfAddCommand:
+		result, err := handlePFAdd(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pfCountCommand:
+		result, err := handlePFCount(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pfMergeCommand:
+		result, err := handlePFMerge(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pfDebugCommand:
+		result, err := handlePFDebug(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case zAddCommand:
+		result, err := handleZAdd(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case zRemCommand:
+		result, err := handleZRem(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case zScoreCommand:
+		result, err := handleZScore(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case zCardCommand:
+		result, err := handleZCard(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case zRangeCommand:
+		result, err := handleZRange(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case geoAddCommand:
+		result, err := handleGeoAdd(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case geoPosCommand:
+		result, err := handleGeoPos(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case geoDistCommand:
+		result, err := handleGeoDist(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
 		} else {
-			sendBackToClient(client.conn.conn, result)
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case geoHashCommand:
+		result, err := handleGeoHash(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case geoSearchCommand:
+		result, err := handleGeoSearch(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case geoSearchStoreCommand:
+		result, err := handleGeoSearchStore(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case unlinkCommand:
+		result, err := handleUnlink(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case existsCommand:
+		result, err := handleExists(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case touchCommand:
+		result, err := handleTouch(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case typeCommand:
+		result, err := handleType(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case renameCommand:
+		result, err := handleRename(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case renameNXCommand:
+		result, err := handleRenameNX(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case copyCommand:
+		result, err := handleCopy(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case dbSizeCommand:
+		result, err := handleDBSize(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case randomKeyCommand:
+		result, err := handleRandomKey(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case keysCommand:
+		result, err := handleKeys(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case scanCommand:
+		result, err := handleScan(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case selectCommand:
+		result, err := handleSelect(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case moveCommand:
+		result, err := handleMove(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case swapDBCommand:
+		result, err := handleSwapDB(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case flushDBCommand:
+		result, err := handleFlushDB(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case flushAllCommand:
+		result, err := handleFlushAll(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case configCommand:
+		result, err := handleConfig(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case infoCommand:
+		result, err := handleInfo(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case objectCommand:
+		result, err := handleObject(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case memoryCommand:
+		result, err := handleMemory(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case saveCommand:
+		result, err := handleSave(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bgSaveCommand:
+		result, err := handleBgSave(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case lastSaveCommand:
+		result, err := handleLastSave(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case expireCommand:
+		result, err := handleExpire(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pExpireCommand:
+		result, err := handlePExpire(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case expireAtCommand:
+		result, err := handleExpireAt(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pExpireAtCommand:
+		result, err := handlePExpireAt(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case ttlCommand:
+		result, err := handleTTL(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pTTLCommand:
+		result, err := handlePTTL(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case expireTimeCommand:
+		result, err := handleExpireTime(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pExpireTimeCommand:
+		result, err := handlePExpireTime(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case persistCommand:
+		result, err := handlePersist(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case bgRewriteAofCommand:
+		result, err := handleBgRewriteAof(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pingCommand:
+		result, err := handlePing(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else if client.subscribed() {
+			// In subscriber mode the reply has the shape of the messages.
+			if len(args) == 1 {
+				result = ""
+			}
+			sendReplyToClient(client.conn.conn, []interface{}{"pong", result})
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case subscribeCommand, pSubscribeCommand, sSubscribeCommand:
+		replies, err := handleSubscribe(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		}
+		for _, reply := range replies {
+			sendReplyToClient(client.conn.conn, reply)
+		}
+	case unsubscribeCommand, pUnsubscribeCommand, sUnsubscribeCommand:
+		replies, err := handleUnsubscribe(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		}
+		for _, reply := range replies {
+			sendReplyToClient(client.conn.conn, reply)
+		}
+	case publishCommand:
+		result, err := handlePublish(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case sPublishCommand:
+		result, err := handleSPublish(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case pubsubCommand:
+		result, err := handlePubsub(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case replicaOfCommand:
+		result, err := handleReplicaOf(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case slaveOfCommand:
+		result, err := handleReplicaOf(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case roleCommand:
+		result, err := handleRole(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case replconfCommand:
+		result, err := handleReplconf(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else if result != nil {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case psyncCommand:
+		// The replica receives the stream from now on, instead of replies.
+		if err := client.server.syncReplica(client, args); err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		}
+	case waitCommand:
+		result, err := handleWait(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case waitAofCommand:
+		result, err := handleWaitAof(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case clusterCommand:
+		result, err := handleCluster(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case askingCommand:
+		result, err := handleAsking(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case dumpCommand:
+		result, err := handleDump(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case restoreCommand, restoreAskingCommand:
+		result, err := handleRestore(args, client.redis)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
+	case migrateCommand:
+		result, err := handleMigrate(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case multiCommand:
-		// Multi command: Start a new transaction
-		client.redis = handleMulti(client.redis)
-		sendBackToClient(client.conn.conn, "started transaction")
+		result, err := handleMulti(args, client)
+		if err != nil {
+			sendReplyToClient(client.conn.conn, err)
+		} else {
+			sendReplyToClient(client.conn.conn, result)
+		}
 	case execCommand:
-		// Exec command: Commit the changes made during the transaction
-		redis, err := handleExec(client.redis)
+		result, err := handleExec(args, client)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
 		} else {
-			client.redis = redis
-			sendBackToClient(client.conn.conn, "stoped transaction")
+			sendReplyToClient(client.conn.conn, result)
 		}
 	case discardCommand:
-		// Discard command: Revert state the changes made during a transaction to bring the system back to a consistent state.
-		redis, err := handleDiscard(client.redis)
+		result, err := handleDiscard(args, client)
 		if err != nil {
-			sendBackToClient(client.conn.conn, err.Error())
+			sendReplyToClient(client.conn.conn, err)
 		} else {
-			client.redis = redis
-			sendBackToClient(client.conn.conn, "discarded transaction")
+			sendReplyToClient(client.conn.conn, result)
 		}
 	default:
-		sendBackToClient(client.conn.conn, "unknown command")
+		sendReplyToClient(client.conn.conn, fmt.Errorf("unknown command"))
 	}
 }
 
@@ -165,304 +940,404 @@ func currentStore(redis []*Store) *Store {
 	return nil
 }
 
-func handleMulti(redis []*Store) []*Store {
-	transaction := NewStore()
-	transaction.UpdateData(currentStore(redis))
-	redis = append(redis, transaction)
-	return redis
+// handleMulti opens a transaction, nested in the open one if any: the commands that follow are
+// queued until EXEC or DISCARD.
+func handleMulti(args []string, client *ClientDetail) (interface{}, error) {
+	client.multiMarks = append(client.multiMarks, len(client.multiQueue))
+	return resp.SimpleString("started transaction"), nil
 }
 
-func handleExec(redis []*Store) ([]*Store, error) {
-	if len(redis) >= 2 {
-		currentTransaction := currentStore(redis)
-		originalTransaction := redis[len(redis)-2]
-		// Update originalTransaction with the values from currentTransaction
-		originalTransaction.UpdateData(currentTransaction)
-		// Delete keys in originalTransaction that are not present in currentTransaction
-		originalTransaction.DeleteData(currentTransaction)
-		redis = redis[:len(redis)-1]
-		return redis, nil
-	}
-	return redis, fmt.Errorf("exec without multi")
+// handleExec closes the innermost transaction. The commands of a nested transaction stay queued with
+// the ones of the enclosing transaction, while the ones of the outermost transaction run one after
+// the other and their replies are returned as an array.
+func handleExec(args []string, client *ClientDetail) (interface{}, error) {
+	if !client.inTransaction() {
+		return nil, fmt.Errorf("exec without multi")
+	}
+	if len(client.multiMarks) > 1 {
+		client.multiMarks = client.multiMarks[:len(client.multiMarks)-1]
+		return resp.SimpleString("QUEUED"), nil
+	}
+	// The transaction stays open while its commands run, so that they cannot leave the selected database.
+	defer func() {
+		client.multiQueue, client.multiMarks, client.multiAborted = nil, nil, false
+	}()
+	if client.multiAborted {
+		return nil, resp.Error("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return client.runQueued(), nil
+}
+
+// runQueued runs the commands of the outermost transaction, once it is executed, against the
+// selected database, logs the ones that changed it between MULTI and EXEC and returns their replies.
+// The caller must hold the writeMutex of the server, taken by beginWrite for EXEC, so that no other
+// client runs a command in between.
+func (client *ClientDetail) runQueued() []interface{} {
+	conn := client.conn.conn
+	replies := &execConn{Conn: conn, replies: []interface{}{}}
+	client.conn.conn = replies
+	defer func() { client.conn.conn = conn }()
+
+	commands := [][]string{{"MULTI"}}
+	for _, args := range client.multiQueue {
+		commandType := CommandType(strings.ToLower(args[0]))
+		dirty := client.server.dirty()
+		client.dispatch(commandType, args)
+		if client.server.dirty() != dirty {
+			commands = append(commands, propagatedCommand(commandType, args, currentStore(client.redis)))
+		}
+	}
+	if len(commands) > 1 {
+		client.woff = client.server.propagate(client.db, append(commands, []string{"EXEC"})...)
+	}
+	return replies.replies
 }
 
-func handleDiscard(redis []*Store) ([]*Store, error) {
-	if len(redis) >= 2 {
-		return redis[:len(redis)-1], nil
+// handleDiscard drops the commands queued by the innermost transaction and closes it.
+func handleDiscard(args []string, client *ClientDetail) (interface{}, error) {
+	if !client.inTransaction() {
+		return nil, fmt.Errorf("discard without multi")
 	}
-	return redis, fmt.Errorf("discard without multi")
+	mark := client.multiMarks[len(client.multiMarks)-1]
+	client.multiQueue = client.multiQueue[:mark]
+	client.multiMarks = client.multiMarks[:len(client.multiMarks)-1]
+	if !client.inTransaction() {
+		client.multiAborted = false
+	}
+	return resp.SimpleString("discarded transaction"), nil
 }
 
-func handleGet(args []string, redis []*Store) (string, error) {
+func handleGet(args []string, redis []*Store) (interface{}, error) {
 	if len(args) != 2 {
-		return "", fmt.Errorf("get command requires exactly one argument")
+		return nil, fmt.Errorf("get command requires exactly one argument")
 	}
 	key := args[1]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
 	result, err := r.Get(key)
 	if err != nil {
-		return "", err
+		// The key does not exist.
+		return nil, nil
 	}
 	return result, nil
 }
 
-func handleSet(args []string, redis []*Store) (string, error) {
+func handleSet(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 3 {
-		return "", fmt.Errorf("set command requires at least two arguments")
+		return nil, fmt.Errorf("set command requires at least two arguments")
 	}
 	key, value := args[1], args[2]
 	r := currentStore(redis)
-	var ttl int
 
-	if len(args) == 5 && strings.EqualFold(args[3], "ex") {
-		timeToLive, err := strconv.ParseInt(args[4], 10, 64)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse TTL: %v", err)
-		}
-		ttl = int(timeToLive)
-		if ttl < 1 {
-			return "", fmt.Errorf("invalid expire time in 'set' command")
-		}
-	} else if len(args) == 3 {
-		ttl = 0
-	} else {
-		return "", fmt.Errorf("syntax error")
+	opts, err := parseSetOptions(args[3:])
+	if err != nil {
+		return nil, err
 	}
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	err := r.Set(key, value, time.Duration(ttl)*time.Second)
+	old, exist, written, err := r.SetWithOptions(key, value, opts)
 	if err != nil {
-		return "", fmt.Errorf(err.Error())
+		return nil, err
+	}
+	if opts.Get {
+		if !exist {
+			return nil, nil
+		}
+		return old, nil
 	}
-	return "", nil
+	if !written {
+		// NX or XX condition was not met
+		return nil, nil
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// parseSetOptions parses the options following SET key value.
+// Options may appear in any order: NX|XX, GET and one of EX|PX|EXAT|PXAT|KEEPTTL.
+func parseSetOptions(args []string) (SetOptions, error) {
+	var opts SetOptions
+	expireOption := ""
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToLower(args[i]); option {
+		case "nx":
+			if opts.XX {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.NX = true
+		case "xx":
+			if opts.NX {
+				return opts, fmt.Errorf("syntax error")
+			}
+			opts.XX = true
+		case "get":
+			opts.Get = true
+		case "keepttl":
+			if expireOption != "" {
+				return opts, fmt.Errorf("syntax error")
+			}
+			expireOption = option
+			opts.KeepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if expireOption != "" || i+1 >= len(args) {
+				return opts, fmt.Errorf("syntax error")
+			}
+			expireOption = option
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return opts, fmt.Errorf("failed to parse TTL: %v", err)
+			}
+			if n < 1 {
+				return opts, fmt.Errorf("invalid expire time in 'set' command")
+			}
+			switch option {
+			case "ex":
+				if n > math.MaxInt64/int64(time.Second) {
+					return opts, fmt.Errorf("invalid expire time in 'set' command")
+				}
+				opts.ExpireAt = time.Now().Add(time.Duration(n) * time.Second)
+			case "px":
+				if n > math.MaxInt64/int64(time.Millisecond) {
+					return opts, fmt.Errorf("invalid expire time in 'set' command")
+				}
+				opts.ExpireAt = time.Now().Add(time.Duration(n) * time.Millisecond)
+			case "exat":
+				opts.ExpireAt = time.Unix(n, 0)
+			case "pxat":
+				opts.ExpireAt = time.UnixMilli(n)
+			}
+		default:
+			return opts, fmt.Errorf("syntax error")
+		}
+	}
+	return opts, nil
 }
 
-func handleSetEx(args []string, redis []*Store) (string, error) {
+func handleSetEx(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 4 {
-		return "", fmt.Errorf("setex command requires at least three arguments")
+		return nil, fmt.Errorf("setex command requires at least three arguments")
 	}
 	ttl, _ := strconv.ParseInt(args[3], 10, 64)
 	key, value := args[1], args[2]
 	if ttl < 1 {
-		return "", fmt.Errorf("invalid expire time in 'setex' command")
+		return nil, fmt.Errorf("invalid expire time in 'setex' command")
 	}
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
 	err := r.SetEx(key, value, time.Duration(ttl)*time.Second)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return "", nil
+	return resp.SimpleString("OK"), nil
 }
 
-func handleDel(args []string, redis []*Store) (string, error) {
-	if len(args) != 2 {
-		return "", fmt.Errorf("delete command requires exactly one argument")
+func handleDel(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("delete command requires at least one argument")
 	}
-	key := args[1] //
 	r := currentStore(redis)
-	r.Del(key)
-	return "", nil
+	deleted, err := r.Del(args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
 }
 
-func handleGetSet(args []string, redis []*Store) (string, error) {
+func handleGetSet(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 3 {
-		return "", fmt.Errorf("getset command requires at least two arguments")
+		return nil, fmt.Errorf("getset command requires at least two arguments")
 	}
 	key, value := args[1], args[2]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
 	r.Set(key, value, time.Duration(0)*time.Second)
 	result, err := r.Get(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return result, nil
 }
 
-func handleIncre(args []string, redis []*Store) (string, error) {
+func handleIncre(args []string, redis []*Store) (interface{}, error) {
 	if len(args) != 2 {
-		return "", fmt.Errorf("err wrong number of arguments for incr command")
+		return nil, fmt.Errorf("err wrong number of arguments for incr command")
 	}
 	key := args[1]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	err := r.Incre(key)
-	if err != nil {
-		return "", err
-	}
-	return "", nil
+	return r.Incre(key)
 }
 
-func handleIncreBy(args []string, redis []*Store) (string, error) {
+func handleIncreBy(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 3 {
-		return "", fmt.Errorf("incrby command requires at least two arguments")
+		return nil, fmt.Errorf("incrby command requires at least two arguments")
 	}
 	key, value := args[1], args[2]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	err := r.IncreBy(key, value)
-	if err != nil {
-		return "", err
+	return r.IncreBy(key, value)
+}
+
+func handleIncreByFloat(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("incrbyfloat command requires exactly two arguments")
+	}
+	key, value := args[1], args[2]
+	r := currentStore(redis)
+
+	if item, exist := r.items[key]; exist {
+		if typeName(item.value) != "string" {
+			// This error describe key existed with another type in this database
+			return nil, errWrongType
+		}
 	}
-	return "", nil
+
+	return r.IncreByFloat(key, value)
 }
 
-func handleDecre(args []string, redis []*Store) (string, error) {
+func handleDecre(args []string, redis []*Store) (interface{}, error) {
 	if len(args) != 2 {
-		return "", fmt.Errorf("err wrong number of arguments for decre command")
+		return nil, fmt.Errorf("err wrong number of arguments for decre command")
 	}
 	key := args[1]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	err := r.Decre(key)
-	if err != nil {
-		return "", err
-	}
-	return "", nil
+	return r.Decre(key)
 }
 
-func handleDecreBy(args []string, redis []*Store) (string, error) {
+func handleDecreBy(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 3 {
-		return "", fmt.Errorf("decrby command requires at least two arguments")
+		return nil, fmt.Errorf("decrby command requires at least two arguments")
 	}
 	key, value := args[1], args[2]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.(string); !ok {
+		if typeName(item.value) != "string" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	err := r.DecreBy(key, value)
-	if err != nil {
-		return "", err
-	}
-	return "", nil
+	return r.DecreBy(key, value)
 }
 
 // ===============================================================================
-func handleLPush(args []string, redis []*Store) (string, error) {
+func handleLPush(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 3 {
-		return "", fmt.Errorf("lpush command requires at least two arguments")
+		return nil, fmt.Errorf("lpush command requires at least two arguments")
 	}
 	key, value := args[1], args[2]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.([]string); !ok {
+		if typeName(item.value) != "list" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	err := r.LPush(key, value)
-	if err != nil {
-		return "", err
-	}
-	return "", nil
+	return r.LPush(key, value)
 }
 
-func handleLRange(args []string, redis []*Store) (string, error) {
+func handleLRange(args []string, redis []*Store) (interface{}, error) {
 	if len(args) < 4 {
-		return "", fmt.Errorf("lrange command requires exactly one argument")
+		return nil, fmt.Errorf("lrange command requires exactly one argument")
 	}
 	key := args[1]
 	startStr, stopStr := args[2], args[3]
 
 	start, err := strconv.Atoi(startStr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	stop, err := strconv.Atoi(stopStr)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	r := currentStore(redis)
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.([]string); !ok {
+		if typeName(item.value) != "list" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
-	result, err := r.LRange(key, start, stop)
+	result, err := r.lrange(key, start, stop)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return result, nil
 }
 
-func handleLPop(args []string, redis []*Store) (string, error) {
+func handleLPop(args []string, redis []*Store) (interface{}, error) {
 	if len(args) != 2 {
-		return "", fmt.Errorf("lpop command requires exactly one argument")
+		return nil, fmt.Errorf("lpop command requires exactly one argument")
 	}
 	key := args[1]
 	r := currentStore(redis)
 
 	if item, exist := r.items[key]; exist {
-		if _, ok := item.value.([]string); !ok {
+		if typeName(item.value) != "list" {
 			// This error describe key existed with another type in this database
-			return "", fmt.Errorf("wrongtype operation against a key holding the wrong kind of value")
+			return nil, errWrongType
 		}
 	}
 
 	result, err := r.LPop(key)
 	if err != nil {
-		return "", err
+		// The list does not exist or is empty.
+		return nil, nil
 	}
 	return result, nil
 }
@@ -0,0 +1,759 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// HyperLogLogs are stored as plain string values using the same layout as Redis, so the
+// bytes returned by GET can be loaded by a real Redis server and the other way around.
+//
+// +------+---+-----+----------+
+// | HYLL | E | N/U | Cardin.  |
+// +------+---+-----+----------+
+//
+// The 16 bytes header holds the magic, the encoding (dense or sparse), three unused bytes
+// and the cached cardinality as a little endian 64 bit integer. The most significant bit of
+// the last byte is set when the cached cardinality is no longer valid.
+//
+// The dense encoding stores 16384 registers of 6 bits each. The sparse encoding is a
+// sequence of run length encoded opcodes:
+//
+//	ZERO:  00xxxxxx          - a run of xxxxxx+1 zero registers (1 to 64)
+//	XZERO: 01xxxxxx yyyyyyyy - a run of xxxxxxyyyyyyyy+1 zero registers (1 to 16384)
+//	VAL:   1vvvvvxx          - a run of xx+1 registers set to vvvvv+1 (value 1 to 32)
+
+const (
+	hllP                 = 14
+	hllQ                 = 64 - hllP
+	hllRegisters         = 1 << hllP
+	hllPMask             = hllRegisters - 1
+	hllBits              = 6
+	hllRegisterMax       = 1<<hllBits - 1
+	hllHdrSize           = 16
+	hllDenseSize         = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllDense             = 0
+	hllSparse            = 1
+	hllAlphaInf          = 0.721347520444481703680 // 0.5/ln(2)
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+)
+
+// hllSparseMaxBytes is the size above which a sparse HyperLogLog is converted to the dense encoding.
+var hllSparseMaxBytes = 3000
+
+var (
+	errInvalidHLL = resp.Error("INVALIDOBJ Corrupted HLL object detected")
+	errNotHLL     = resp.Error("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	hllMagic      = []byte("HYLL")
+	hllMurmurSeed = uint32(0xadc83b19)
+)
+
+// newHLL creates an empty sparse HyperLogLog: a single XZERO opcode covering all the registers.
+func newHLL() []byte {
+	hll := make([]byte, hllHdrSize+2)
+	copy(hll, hllMagic)
+	hll[4] = hllSparse
+	hllSparseXZeroSet(hll[hllHdrSize:], hllRegisters)
+	return hll
+}
+
+// isHLL reports whether b is a well formed HyperLogLog header.
+func isHLL(b []byte) bool {
+	if len(b) < hllHdrSize || !bytes.Equal(b[:4], hllMagic) || b[4] > hllSparse {
+		return false
+	}
+	return b[4] != hllDense || len(b) == hllDenseSize
+}
+
+func hllInvalidateCache(hll []byte) { hll[15] |= 1 << 7 }
+
+func hllValidCache(hll []byte) bool { return hll[15]&(1<<7) == 0 }
+
+// ------------------------------------------------------------------------------------
+// Sparse opcodes
+
+func hllSparseIsZero(p byte) bool  { return p&0xc0 == 0 }
+func hllSparseIsXZero(p byte) bool { return p&0xc0 == 0x40 }
+func hllSparseIsVal(p byte) bool   { return p&0x80 != 0 }
+func hllSparseZeroLen(p byte) int  { return int(p&0x3f) + 1 }
+func hllSparseXZeroLen(p []byte) int {
+	return (int(p[0]&0x3f)<<8 | int(p[1])) + 1
+}
+func hllSparseValValue(p byte) int { return int(p>>2&0x1f) + 1 }
+func hllSparseValLen(p byte) int   { return int(p&0x3) + 1 }
+
+func hllSparseValSet(p []byte, value, length int) {
+	p[0] = byte((value-1)<<2|(length-1)) | 0x80
+}
+
+func hllSparseZeroSet(p []byte, length int) {
+	p[0] = byte(length - 1)
+}
+
+func hllSparseXZeroSet(p []byte, length int) {
+	l := length - 1
+	p[0] = byte(l>>8) | 0x40
+	p[1] = byte(l & 0xff)
+}
+
+// ------------------------------------------------------------------------------------
+// Dense registers
+
+func hllDenseGetRegister(registers []byte, index int) int {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	b0 := uint(registers[byteIndex])
+	b1 := uint(0)
+	if byteIndex+1 < len(registers) {
+		b1 = uint(registers[byteIndex+1])
+	}
+	return int((b0>>fb | b1<<(8-fb)) & hllRegisterMax)
+}
+
+func hllDenseSetRegister(registers []byte, index, value int) {
+	byteIndex := index * hllBits / 8
+	fb := uint(index * hllBits & 7)
+	v := uint(value)
+	registers[byteIndex] &^= byte(hllRegisterMax << fb)
+	registers[byteIndex] |= byte(v << fb)
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(hllRegisterMax >> (8 - fb))
+		registers[byteIndex+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// hllDenseSet sets the register to count if it is greater than the current value.
+func hllDenseSet(registers []byte, index, count int) bool {
+	if count > hllDenseGetRegister(registers, index) {
+		hllDenseSetRegister(registers, index, count)
+		return true
+	}
+	return false
+}
+
+// ------------------------------------------------------------------------------------
+// Hashing
+
+// murmurHash64A is the 64 bit MurmurHash2 variant used by Redis, on little endian input.
+func murmurHash64A(key []byte, seed uint32) uint64 {
+	const m = uint64(0xc6a4a7935bd1e995)
+	const r = 47
+	h := uint64(seed) ^ (uint64(len(key)) * m)
+
+	data := key
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register index of element and the length of the "000..1" pattern of its hash.
+func hllPatLen(element []byte) (int, int) {
+	hash := murmurHash64A(element, hllMurmurSeed)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	// Make sure the loop terminates and count will be <= Q+1
+	hash |= uint64(1) << hllQ
+	count := 1
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// ------------------------------------------------------------------------------------
+// Updates
+
+// hllAdd adds element to the HyperLogLog, returning the (possibly reallocated) value and
+// whether a register was updated.
+func hllAdd(hll []byte, element []byte) ([]byte, bool, error) {
+	index, count := hllPatLen(element)
+	if hll[4] == hllDense {
+		return hll, hllDenseSet(hll[hllHdrSize:], index, count), nil
+	}
+	return hllSparseSet(hll, index, count)
+}
+
+// hllSparseToDense converts a sparse HyperLogLog to the dense encoding, keeping the cached cardinality.
+func hllSparseToDense(hll []byte) ([]byte, error) {
+	if hll[4] == hllDense {
+		return hll, nil
+	}
+	dense := make([]byte, hllDenseSize)
+	copy(dense, hll[:hllHdrSize])
+	dense[4] = hllDense
+	registers := dense[hllHdrSize:]
+
+	index := 0
+	p := hll[hllHdrSize:]
+	for len(p) > 0 {
+		switch {
+		case hllSparseIsZero(p[0]):
+			index += hllSparseZeroLen(p[0])
+			p = p[1:]
+		case hllSparseIsXZero(p[0]):
+			if len(p) < 2 {
+				return nil, errInvalidHLL
+			}
+			index += hllSparseXZeroLen(p)
+			p = p[2:]
+		default:
+			runLen, value := hllSparseValLen(p[0]), hllSparseValValue(p[0])
+			if runLen+index > hllRegisters {
+				return nil, errInvalidHLL
+			}
+			for ; runLen > 0; runLen-- {
+				hllDenseSetRegister(registers, index, value)
+				index++
+			}
+			p = p[1:]
+		}
+	}
+	// A valid sparse representation covers exactly all the registers
+	if index != hllRegisters {
+		return nil, errInvalidHLL
+	}
+	return dense, nil
+}
+
+// hllSparseSet sets the register at index to count if it is greater than the current value.
+// The opcode covering the register is split in place and adjacent VAL opcodes are merged
+// exactly like Redis does, so the resulting bytes are the same. The HyperLogLog is promoted
+// to the dense encoding when count does not fit a VAL opcode or the value grows too large.
+func hllSparseSet(hll []byte, index, count int) ([]byte, bool, error) {
+	if count > hllSparseValMaxValue {
+		return hllPromote(hll, index, count)
+	}
+
+	// Step 1: locate the opcode covering the register
+	sparse := hll[hllHdrSize:]
+	first, span, pos, prev := 0, 0, 0, -1
+	for pos < len(sparse) {
+		opLen := 1
+		switch {
+		case hllSparseIsZero(sparse[pos]):
+			span = hllSparseZeroLen(sparse[pos])
+		case hllSparseIsVal(sparse[pos]):
+			span = hllSparseValLen(sparse[pos])
+		default:
+			if pos+1 >= len(sparse) {
+				return hll, false, errInvalidHLL
+			}
+			span = hllSparseXZeroLen(sparse[pos:])
+			opLen = 2
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = pos
+		pos += opLen
+		first += span
+	}
+	if span == 0 || pos >= len(sparse) {
+		return hll, false, errInvalidHLL
+	}
+
+	op := sparse[pos]
+	isZero, isXZero, isVal := hllSparseIsZero(op), hllSparseIsXZero(op), hllSparseIsVal(op)
+	runLen := span
+
+	// Step 2: trivial updates of VAL and ZERO opcodes covering only this register
+	updated := false
+	if isVal {
+		// The register already holds a greater or equal value
+		if hllSparseValValue(op) >= count {
+			return hll, false, nil
+		}
+		if runLen == 1 {
+			hllSparseValSet(sparse[pos:], count, 1)
+			updated = true
+		}
+	}
+	if !updated && isZero && runLen == 1 {
+		hllSparseValSet(sparse[pos:], count, 1)
+		updated = true
+	}
+
+	if !updated {
+		// General case: split the opcode into up to three opcodes (at most 5 bytes)
+		seq := make([]byte, 0, 5)
+		last := first + span - 1
+		appendZero := func(length int) {
+			if length > hllSparseZeroMaxLen {
+				seq = append(seq, 0, 0)
+				hllSparseXZeroSet(seq[len(seq)-2:], length)
+			} else {
+				seq = append(seq, 0)
+				hllSparseZeroSet(seq[len(seq)-1:], length)
+			}
+		}
+		appendVal := func(value, length int) {
+			seq = append(seq, 0)
+			hllSparseValSet(seq[len(seq)-1:], value, length)
+		}
+		if isZero || isXZero {
+			if index != first {
+				appendZero(index - first)
+			}
+			appendVal(count, 1)
+			if index != last {
+				appendZero(last - index)
+			}
+		} else {
+			current := hllSparseValValue(op)
+			if index != first {
+				appendVal(current, index-first)
+			}
+			appendVal(count, 1)
+			if index != last {
+				appendVal(current, last-index)
+			}
+		}
+
+		// Step 3: substitute the old opcode with the new sequence
+		oldLen := 1
+		if isXZero {
+			oldLen = 2
+		}
+		delta := len(seq) - oldLen
+		if delta > 0 && len(hll)+delta > hllSparseMaxBytes {
+			return hllPromote(hll, index, count)
+		}
+		grown := make([]byte, 0, len(hll)+delta)
+		grown = append(grown, hll[:hllHdrSize+pos]...)
+		grown = append(grown, seq...)
+		grown = append(grown, hll[hllHdrSize+pos+oldLen:]...)
+		hll = grown
+		sparse = hll[hllHdrSize:]
+	}
+
+	// Step 4: merge adjacent VAL opcodes with the same value around the update
+	p := 0
+	if prev >= 0 {
+		p = prev
+	}
+	for scan := 5; p < len(sparse) && scan > 0; scan-- {
+		if hllSparseIsXZero(sparse[p]) {
+			p += 2
+			continue
+		} else if hllSparseIsZero(sparse[p]) {
+			p++
+			continue
+		}
+		if p+1 < len(sparse) && hllSparseIsVal(sparse[p+1]) {
+			v1, v2 := hllSparseValValue(sparse[p]), hllSparseValValue(sparse[p+1])
+			if v1 == v2 {
+				length := hllSparseValLen(sparse[p]) + hllSparseValLen(sparse[p+1])
+				if length <= hllSparseValMaxLen {
+					hllSparseValSet(sparse[p+1:], v1, length)
+					hll = append(hll[:hllHdrSize+p], hll[hllHdrSize+p+1:]...)
+					sparse = hll[hllHdrSize:]
+					// Try to merge the just merged value with the one on its right
+					continue
+				}
+			}
+		}
+		p++
+	}
+
+	hllInvalidateCache(hll)
+	return hll, true, nil
+}
+
+// hllPromote converts the HyperLogLog to the dense encoding and sets the register there.
+func hllPromote(hll []byte, index, count int) ([]byte, bool, error) {
+	dense, err := hllSparseToDense(hll)
+	if err != nil {
+		return hll, false, err
+	}
+	return dense, hllDenseSet(dense[hllHdrSize:], index, count), nil
+}
+
+// hllMerge sets max[i] = MAX(max[i], hll[i]) for every register, max using one byte per register.
+func hllMerge(max []byte, hll []byte) error {
+	if hll[4] == hllDense {
+		registers := hll[hllHdrSize:]
+		for i := 0; i < hllRegisters; i++ {
+			if value := byte(hllDenseGetRegister(registers, i)); value > max[i] {
+				max[i] = value
+			}
+		}
+		return nil
+	}
+
+	index := 0
+	p := hll[hllHdrSize:]
+	for len(p) > 0 {
+		switch {
+		case hllSparseIsZero(p[0]):
+			index += hllSparseZeroLen(p[0])
+			p = p[1:]
+		case hllSparseIsXZero(p[0]):
+			if len(p) < 2 {
+				return errInvalidHLL
+			}
+			index += hllSparseXZeroLen(p)
+			p = p[2:]
+		default:
+			runLen, value := hllSparseValLen(p[0]), byte(hllSparseValValue(p[0]))
+			if runLen+index > hllRegisters {
+				return errInvalidHLL
+			}
+			for ; runLen > 0; runLen-- {
+				if value > max[index] {
+					max[index] = value
+				}
+				index++
+			}
+			p = p[1:]
+		}
+	}
+	if index != hllRegisters {
+		return errInvalidHLL
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------
+// Cardinality estimation
+
+// hllCount estimates the cardinality from raw registers (one byte per register) using the
+// estimator of "New cardinality estimation algorithms for HyperLogLog sketches", Otmar Ertl.
+func hllCount(registers []byte) uint64 {
+	m := float64(hllRegisters)
+	var histogram [64]int
+	for _, value := range registers {
+		histogram[value]++
+	}
+
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// hllRawRegisters decodes the registers of the HyperLogLog, one byte per register.
+func hllRawRegisters(hll []byte) ([]byte, error) {
+	registers := make([]byte, hllRegisters)
+	if err := hllMerge(registers, hll); err != nil {
+		return nil, err
+	}
+	return registers, nil
+}
+
+// ------------------------------------------------------------------------------------
+// Store operations
+
+// hllForRead returns the HyperLogLog stored at key, nil if the key does not exist.
+// The caller must hold r.mu.
+func (r *Store) hllForRead(key string) ([]byte, error) {
+	item, exist := r.lookup(key)
+	if !exist {
+		return nil, nil
+	}
+	hll, ok := item.value.([]byte)
+	if !ok || !isHLL(hll) {
+		return nil, errNotHLL
+	}
+	return hll, nil
+}
+
+// PFAdd adds the elements to the HyperLogLog stored at key, creating it if needed.
+// It reports whether the estimated cardinality may have changed.
+func (r *Store) PFAdd(key string, elements []string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unshare(key)
+	hll, err := r.hllForRead(key)
+	if err != nil {
+		return false, err
+	}
+	item := r.items[key]
+	updated := false
+	if hll == nil {
+		hll = newHLL()
+		updated = true
+	}
+	for _, element := range elements {
+		var changed bool
+		if hll, changed, err = hllAdd(hll, []byte(element)); err != nil {
+			return false, err
+		}
+		updated = updated || changed
+	}
+	if updated {
+		hllInvalidateCache(hll)
+		r.setItem(key, ExpirationItem{value: hll, expiration: item.expiration})
+		r.notifyEvent(notifyString, "pfadd", key)
+	}
+	return updated, nil
+}
+
+// PFCount returns the approximated cardinality of the union of the HyperLogLogs stored at keys.
+// With a single key the cardinality is cached in the header of the HyperLogLog.
+func (r *Store) PFCount(keys []string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(keys) > 1 {
+		max := make([]byte, hllRegisters)
+		for _, key := range keys {
+			hll, err := r.hllForRead(key)
+			if err != nil {
+				return 0, err
+			}
+			if hll == nil {
+				// Assume an empty HyperLogLog for non existing keys
+				continue
+			}
+			if err := hllMerge(max, hll); err != nil {
+				return 0, err
+			}
+		}
+		return int64(hllCount(max)), nil
+	}
+
+	// The cached cardinality is written in place.
+	r.unshare(keys[0])
+	hll, err := r.hllForRead(keys[0])
+	if err != nil || hll == nil {
+		return 0, err
+	}
+	if hllValidCache(hll) {
+		return int64(binary.LittleEndian.Uint64(hll[8:hllHdrSize])), nil
+	}
+	registers, err := hllRawRegisters(hll)
+	if err != nil {
+		return 0, err
+	}
+	card := hllCount(registers)
+	binary.LittleEndian.PutUint64(hll[8:hllHdrSize], card)
+	return int64(card), nil
+}
+
+// PFMerge merges the HyperLogLogs stored at keys (and destKey itself) into destKey.
+func (r *Store) PFMerge(destKey string, keys []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	max := make([]byte, hllRegisters)
+	useDense := false
+	for _, key := range append([]string{destKey}, keys...) {
+		hll, err := r.hllForRead(key)
+		if err != nil {
+			return err
+		}
+		if hll == nil {
+			continue
+		}
+		// If at least one HyperLogLog is dense, the destination uses the dense encoding too
+		if hll[4] == hllDense {
+			useDense = true
+		}
+		if err := hllMerge(max, hll); err != nil {
+			return err
+		}
+	}
+
+	r.unshare(destKey)
+	item, exist := r.lookup(destKey)
+	dest := newHLL()
+	if exist {
+		dest = item.value.([]byte)
+	}
+	var err error
+	if useDense {
+		if dest, err = hllSparseToDense(dest); err != nil {
+			return err
+		}
+	}
+	for i, value := range max {
+		if value == 0 {
+			continue
+		}
+		if dest[4] == hllDense {
+			hllDenseSet(dest[hllHdrSize:], i, int(value))
+		} else if dest, _, err = hllSparseSet(dest, i, int(value)); err != nil {
+			return err
+		}
+	}
+	hllInvalidateCache(dest)
+	r.setItem(destKey, ExpirationItem{value: dest, expiration: item.expiration})
+	// Like Redis, merging notifies the pfadd event.
+	r.notifyEvent(notifyString, "pfadd", destKey)
+	return nil
+}
+
+// PFDebug runs one of the GETREG, DECODE, ENCODING and TODENSE debugging subcommands.
+func (r *Store) PFDebug(subcommand, key string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hll, err := r.hllForRead(key)
+	if err != nil {
+		return nil, err
+	}
+	if hll == nil {
+		return nil, fmt.Errorf("the specified key does not exist")
+	}
+	item := r.items[key]
+
+	switch subcommand {
+	case "getreg":
+		if hll, err = hllSparseToDense(hll); err != nil {
+			return nil, err
+		}
+		r.setItem(key, ExpirationItem{value: hll, expiration: item.expiration})
+		registers := make([]interface{}, hllRegisters)
+		for i := range registers {
+			registers[i] = hllDenseGetRegister(hll[hllHdrSize:], i)
+		}
+		return registers, nil
+	case "decode":
+		if hll[4] != hllSparse {
+			return nil, fmt.Errorf("hll encoding is not sparse")
+		}
+		var decoded []string
+		p := hll[hllHdrSize:]
+		for len(p) > 0 {
+			switch {
+			case hllSparseIsZero(p[0]):
+				decoded = append(decoded, fmt.Sprintf("z:%d", hllSparseZeroLen(p[0])))
+				p = p[1:]
+			case hllSparseIsXZero(p[0]):
+				if len(p) < 2 {
+					return nil, errInvalidHLL
+				}
+				decoded = append(decoded, fmt.Sprintf("Z:%d", hllSparseXZeroLen(p)))
+				p = p[2:]
+			default:
+				decoded = append(decoded, fmt.Sprintf("v:%d,%d", hllSparseValValue(p[0]), hllSparseValLen(p[0])))
+				p = p[1:]
+			}
+		}
+		return strings.Join(decoded, " "), nil
+	case "encoding":
+		if hll[4] == hllDense {
+			return resp.SimpleString("dense"), nil
+		}
+		return resp.SimpleString("sparse"), nil
+	case "todense":
+		converted := hll[4] == hllSparse
+		if hll, err = hllSparseToDense(hll); err != nil {
+			return nil, err
+		}
+		r.setItem(key, ExpirationItem{value: hll, expiration: item.expiration})
+		if converted {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return nil, fmt.Errorf("unknown pfdebug subcommand '%s'", subcommand)
+}
+
+// ===============================================================================
+func handlePFAdd(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("pfadd command requires at least one argument")
+	}
+	r := currentStore(redis)
+	updated, err := r.PFAdd(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+	if updated {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func handlePFCount(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("pfcount command requires at least one argument")
+	}
+	r := currentStore(redis)
+	count, err := r.PFCount(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+func handlePFMerge(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("pfmerge command requires at least one argument")
+	}
+	r := currentStore(redis)
+	if err := r.PFMerge(args[1], args[2:]); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+func handlePFDebug(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("pfdebug command requires exactly two arguments")
+	}
+	r := currentStore(redis)
+	return r.PFDebug(strings.ToLower(args[1]), args[2])
+}
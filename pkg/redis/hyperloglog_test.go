@@ -0,0 +1,125 @@
+package redis
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+func TestPFAddCreatesSparseHLL(t *testing.T) {
+	s := NewStore()
+
+	// Test case 1: PFADD without elements creates an empty sparse HLL with an invalid cache
+	updated, err := s.PFAdd("hll", nil)
+	if err != nil || !updated {
+		t.Errorf("Expected PFADD to create the key, err: %v", err)
+	}
+	expected := []byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xff")
+	if value := s.items["hll"].value.([]byte); !bytes.Equal(value, expected) {
+		t.Errorf("Expected %q, got %q", expected, value)
+	}
+
+	// Test case 2: PFCOUNT computes the cardinality and caches it in the header
+	count, err := s.PFCount([]string{"hll"})
+	if err != nil || count != 0 {
+		t.Errorf("Expected cardinality 0, got %d (err %v)", count, err)
+	}
+	if !hllValidCache(s.items["hll"].value.([]byte)) {
+		t.Errorf("Expected PFCOUNT to cache the cardinality")
+	}
+
+	// Test case 3: a string that is not an HLL is rejected
+	s.Set("str", "HYLL", 0)
+	if _, err := s.PFAdd("str", []string{"a"}); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestPFCountAccuracy(t *testing.T) {
+	for _, cardinality := range []int{10, 1000, 10000, 100000} {
+		s := NewStore()
+		elements := make([]string, 0, 1000)
+		for i := 0; i < cardinality; i++ {
+			elements = append(elements, "element:"+strconv.Itoa(i))
+			if len(elements) == cap(elements) || i == cardinality-1 {
+				s.PFAdd("hll", elements)
+				elements = elements[:0]
+			}
+		}
+		count, err := s.PFCount([]string{"hll"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// The standard error is 0.81%, allow a bit more than 3 standard errors
+		if relative := math.Abs(float64(count)-float64(cardinality)) / float64(cardinality); relative > 0.025 {
+			t.Errorf("Cardinality %d estimated as %d (%.2f%% error)", cardinality, count, relative*100)
+		}
+	}
+}
+
+func TestHLLSparseMatchesDense(t *testing.T) {
+	sparse, dense := newHLL(), newHLL()
+	dense, _ = hllSparseToDense(dense)
+	for i := 0; i < 2000; i++ {
+		element := []byte(strconv.Itoa(i))
+		var err error
+		if sparse, _, err = hllAdd(sparse, element); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		dense, _, _ = hllAdd(dense, element)
+	}
+	if sparse[4] != hllDense {
+		t.Errorf("Expected the sparse HLL to be promoted past %d bytes", hllSparseMaxBytes)
+	}
+	sparseRegisters, _ := hllRawRegisters(sparse)
+	denseRegisters, _ := hllRawRegisters(dense)
+	if !bytes.Equal(sparseRegisters, denseRegisters) {
+		t.Errorf("Expected sparse and dense encodings to hold the same registers")
+	}
+}
+
+func TestHLLSparseSetMergesValues(t *testing.T) {
+	hll := newHLL()
+	for index := 10; index < 14; index++ {
+		hll, _, _ = hllSparseSet(hll, index, 3)
+	}
+	s := NewStore()
+	s.items["hll"] = ExpirationItem{value: hll}
+	decoded, err := s.PFDebug("decode", "hll")
+	if err != nil || decoded != "z:10 v:3,4 Z:16370" {
+		t.Errorf("Expected z:10 v:3,4 Z:16370, got %s (err %v)", decoded, err)
+	}
+
+	// A lower value does not update the register
+	if _, updated, _ := hllSparseSet(hll, 11, 2); updated {
+		t.Errorf("Expected a lower value not to update the register")
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	s := NewStore()
+	s.PFAdd("hll1", []string{"foo", "bar", "zap", "a"})
+	s.PFAdd("hll2", []string{"a", "b", "c", "foo"})
+	if err := s.PFMerge("hll3", []string{"hll1", "hll2", "missing"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count, _ := s.PFCount([]string{"hll3"}); count != 6 {
+		t.Errorf("Expected cardinality 6, got %d", count)
+	}
+	if count, _ := s.PFCount([]string{"hll1", "hll2"}); count != 6 {
+		t.Errorf("Expected cardinality of the union 6, got %d", count)
+	}
+
+	// Merging a dense HLL makes the destination dense
+	s.PFDebug("todense", "hll2")
+	s.PFMerge("hll4", []string{"hll1", "hll2"})
+	if encoding, _ := s.PFDebug("encoding", "hll4"); encoding != resp.SimpleString("dense") {
+		t.Errorf("Expected dense encoding, got %s", encoding)
+	}
+	if count, _ := s.PFCount([]string{"hll4"}); count != 6 {
+		t.Errorf("Expected cardinality 6, got %d", count)
+	}
+}
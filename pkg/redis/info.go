@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// infoSections lists the sections of INFO in the order they are reported.
+var infoSections = []struct {
+	name  string
+	lines func(r *RedisServer) []string
+}{
+	{"clients", (*RedisServer).infoClients},
+	{"memory", (*RedisServer).infoMemory},
+	{"persistence", (*RedisServer).infoPersistence},
+	{"stats", (*RedisServer).infoStats},
+	{"replication", (*RedisServer).infoReplication},
+	{"cluster", (*RedisServer).infoCluster},
+	{"keyspace", (*RedisServer).infoKeyspace},
+}
+
+// Info returns the INFO report of the given section, of every section when section is empty, "all" or "default".
+func (r *RedisServer) Info(section string) string {
+	section = strings.ToLower(section)
+	all := section == "" || section == "all" || section == "default" || section == "everything"
+	parts := []string{}
+	for _, s := range infoSections {
+		if all || s.name == section {
+			header := "# " + strings.ToUpper(s.name[:1]) + s.name[1:]
+			parts = append(parts, strings.Join(append([]string{header}, s.lines(r)...), "\n"))
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (r *RedisServer) infoClients() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return []string{
+		fmt.Sprintf("connected_clients:%d", len(r.clients)),
+		fmt.Sprintf("blocked_clients:%d", r.blockedClients()),
+	}
+}
+
+func (r *RedisServer) infoMemory() []string {
+	config := r.getConfig()
+	used := r.usedMemory()
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		fmt.Sprintf("used_memory_human:%s", bytesToHuman(used)),
+		fmt.Sprintf("maxmemory:%d", config.MaxMemory),
+		fmt.Sprintf("maxmemory_human:%s", bytesToHuman(config.MaxMemory)),
+		fmt.Sprintf("maxmemory_policy:%s", config.MaxMemoryPolicy),
+	}
+}
+
+func (r *RedisServer) infoPersistence() []string {
+	r.rdb.mutex.Lock()
+	defer r.rdb.mutex.Unlock()
+	status := "ok"
+	if r.rdb.lastStatus != nil {
+		status = "err"
+	}
+	saving := 0
+	if r.rdb.saving {
+		saving = 1
+	}
+	lastDuration := int64(-1)
+	if !r.rdb.lastTry.IsZero() {
+		lastDuration = int64(r.rdb.lastDuration.Seconds())
+	}
+	return append([]string{
+		"loading:0",
+		fmt.Sprintf("rdb_changes_since_last_save:%d", r.dirty()-r.rdb.savedDirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", saving),
+		fmt.Sprintf("rdb_last_save_time:%d", r.rdb.lastSave.Unix()),
+		fmt.Sprintf("rdb_last_bgsave_status:%s", status),
+		fmt.Sprintf("rdb_last_bgsave_time_sec:%d", lastDuration),
+		fmt.Sprintf("rdb_saves:%d", r.rdb.saves),
+	}, r.infoAppendOnly()...)
+}
+
+func (r *RedisServer) infoAppendOnly() []string {
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	rewriting := 0
+	if r.aof.rewriting {
+		rewriting = 1
+	}
+	rewriteStatus := "ok"
+	if r.aof.rewriteErr != nil {
+		rewriteStatus = "err"
+	}
+	info := []string{
+		fmt.Sprintf("aof_rewrite_in_progress:%d", rewriting),
+		fmt.Sprintf("aof_last_bgrewrite_status:%s", rewriteStatus),
+		fmt.Sprintf("aof_rewrites:%d", r.aof.rewrites),
+	}
+	if r.aof.file == nil {
+		return append([]string{"aof_enabled:0"}, info...)
+	}
+	status := "ok"
+	if r.aof.writeErr != nil {
+		status = "err"
+	}
+	return append(append([]string{"aof_enabled:1"}, info...),
+		fmt.Sprintf("aof_last_write_status:%s", status),
+		fmt.Sprintf("aof_current_size:%d", r.aof.size),
+		fmt.Sprintf("aof_base_size:%d", r.aof.baseSize),
+		fmt.Sprintf("aof_buffer_length:%d", len(r.aof.buf)),
+	)
+}
+
+func (r *RedisServer) infoStats() []string {
+	r.pubsub.mutex.Lock()
+	pubsub := []string{
+		fmt.Sprintf("pubsub_channels:%d", len(r.pubsub.channels)),
+		fmt.Sprintf("pubsub_patterns:%d", len(r.pubsub.patterns)),
+		fmt.Sprintf("pubsubshard_channels:%d", len(r.pubsub.shardChannels)),
+		fmt.Sprintf("client_output_buffer_limit_disconnections:%d", r.pubsub.limitDisconnected),
+	}
+	r.pubsub.mutex.Unlock()
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	return append([]string{
+		fmt.Sprintf("evicted_keys:%d", atomic.LoadInt64(&r.evictedKeys)),
+		fmt.Sprintf("sync_full:%d", r.repl.syncFull),
+		fmt.Sprintf("sync_partial_ok:%d", r.repl.syncPartialOK),
+		fmt.Sprintf("sync_partial_err:%d", r.repl.syncPartialErr),
+	}, pubsub...)
+}
+
+func (r *RedisServer) infoKeyspace() []string {
+	lines := []string{}
+	for i, db := range r.dbs {
+		keys, expires := db.keyspaceCounts()
+		if keys > 0 {
+			lines = append(lines, fmt.Sprintf("db%d:keys=%d,expires=%d", i, keys, expires))
+		}
+	}
+	return lines
+}
+
+// keyspaceCounts returns the number of keys of the database and how many of them have a time to live.
+func (r *Store) keyspaceCounts() (keys, expires int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range r.items {
+		if item.expired() {
+			continue
+		}
+		keys++
+		if !item.expiration.IsZero() {
+			expires++
+		}
+	}
+	return keys, expires
+}
+
+// bytesToHuman formats a number of bytes with the units INFO uses.
+func bytesToHuman(n int64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
+
+// ===============================================================================
+func handleInfo(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) > 2 {
+		return nil, fmt.Errorf("syntax error")
+	}
+	section := ""
+	if len(args) == 2 {
+		section = args[1]
+	}
+	return client.server.Info(section), nil
+}
@@ -0,0 +1,435 @@
+package redis
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// typeName returns the name TYPE replies with for a value stored in the database.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case []byte, int64:
+		return "string"
+	case []string, *listpack:
+		return "list"
+	case *sortedSet:
+		return "zset"
+	}
+	return "none"
+}
+
+// Exists returns how many of the keys exist. A key mentioned several times is counted several times.
+func (r *Store) Exists(keys ...string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, key := range keys {
+		if _, exist := r.peek(key); exist {
+			count++
+		}
+	}
+	return count
+}
+
+// Touch returns how many of the keys exist, like Exists, but counts as an access to the keys.
+func (r *Store) Touch(keys ...string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, key := range keys {
+		if _, exist := r.lookup(key); exist {
+			count++
+		}
+	}
+	return count
+}
+
+// Type returns the type of the value stored at key, "none" when the key does not exist.
+func (r *Store) Type(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.peek(key)
+	if !exist {
+		return "none"
+	}
+	return typeName(item.value)
+}
+
+// Rename renames key to newKey, overwriting newKey. The time to live of key moves with it.
+// With nx set, newKey is not overwritten and Rename reports false if it already exists.
+func (r *Store) Rename(key, newKey string, nx bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.lookup(key)
+	if !exist {
+		return false, fmt.Errorf("no such key")
+	}
+	if key == newKey {
+		return !nx, nil
+	}
+	if _, exist := r.lookup(newKey); exist && nx {
+		return false, nil
+	}
+	// Like Redis, an overwritten newKey is deleted first and then added again.
+	r.deleteItem(key)
+	r.deleteItem(newKey)
+	r.setItem(newKey, item)
+	r.notifyEvent(notifyGeneric, "rename_from", key)
+	r.notifyEvent(notifyGeneric, "rename_to", newKey)
+	return true, nil
+}
+
+// Copy copies the value stored at source, and its time to live, to destination in the target database.
+// It reports false when destination exists and replace is not set.
+// When target is another database, the caller must hold the dbMutex of the server.
+func (r *Store) Copy(source string, target *Store, destination string, replace bool) (bool, error) {
+	if r == target && source == destination {
+		return false, fmt.Errorf("source and destination objects are the same")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if target != r {
+		target.mu.Lock()
+		defer target.mu.Unlock()
+	}
+	item, exist := r.lookup(source)
+	if !exist {
+		return false, nil
+	}
+	if _, exist := target.lookup(destination); exist && !replace {
+		return false, nil
+	}
+	copied := item.clone()
+	copied.lru = 0
+	target.deleteItem(destination)
+	target.setItem(destination, copied)
+	target.notifyEvent(notifyGeneric, "copy_to", destination)
+	return true, nil
+}
+
+// DBSize returns the number of keys in the database.
+func (r *Store) DBSize() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, item := range r.items {
+		if !item.expired() {
+			count++
+		}
+	}
+	return count
+}
+
+// RandomKey returns a random key of the database, reporting false when the database is empty.
+// Expired keys found along the way are deleted.
+func (r *Store) RandomKey() (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.keys.len() > 0 {
+		key := r.keys.random()
+		if _, exist := r.lookup(key); exist {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Keys returns the keys matching pattern, sorted.
+func (r *Store) Keys(pattern string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := []string{}
+	for key, item := range r.items {
+		if !item.expired() && stringMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ScanOptions holds the optional arguments of the SCAN command.
+type ScanOptions struct {
+	Match string // glob-style pattern the keys must match, empty matches every key
+	Count int    // number of keys to visit
+	Type  string // type the values must have, empty matches every type
+}
+
+// scanHash places a key on the cursor space of SCAN. It only depends on the key,
+// so a key keeps its place however the database changes between two calls.
+func scanHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// scanEntry is a key visited by SCAN, with its scanHash.
+type scanEntry struct {
+	hash uint64
+	key  string
+}
+
+func scanLess(a, b scanEntry) bool {
+	if a.hash != b.hash {
+		return a.hash < b.hash
+	}
+	return a.key < b.key
+}
+
+// scanHeap is a max-heap of scan entries, the greatest entry first.
+type scanHeap []scanEntry
+
+func (h scanHeap) Len() int            { return len(h) }
+func (h scanHeap) Less(i, j int) bool  { return scanLess(h[j], h[i]) }
+func (h scanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x interface{}) { *h = append(*h, x.(scanEntry)) }
+func (h *scanHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// scanWindow keeps the count entries of smallest hash among the entries added, plus the entries
+// sharing the hash of the last one, since keys sharing a hash cannot be told apart by the cursor.
+// Adding an entry costs O(log count), so SCAN does not sort the whole keyspace on each call.
+type scanWindow struct {
+	count int
+	heap  scanHeap
+	ties  []scanEntry // entries beyond count with the hash of heap[0]
+	added int
+}
+
+func (w *scanWindow) add(e scanEntry) {
+	w.added++
+	if len(w.heap) < w.count {
+		heap.Push(&w.heap, e)
+		return
+	}
+	top := w.heap[0]
+	switch {
+	case e.hash > top.hash:
+	case e.hash == top.hash:
+		w.ties = append(w.ties, e)
+	default:
+		w.heap[0] = e
+		heap.Fix(&w.heap, 0)
+		if w.heap[0].hash == top.hash {
+			w.ties = append(w.ties, top)
+		} else {
+			w.ties = w.ties[:0]
+		}
+	}
+}
+
+// entries returns the entries kept in the order of their hash, and whether they are all the
+// entries added.
+func (w *scanWindow) entries() ([]scanEntry, bool) {
+	entries := append(append([]scanEntry{}, w.heap...), w.ties...)
+	sort.Slice(entries, func(i, j int) bool { return scanLess(entries[i], entries[j]) })
+	return entries, len(entries) == w.added
+}
+
+// Scan visits the keys in the order of their scanHash, starting at cursor, and returns
+// the next cursor with the visited keys passing the MATCH and TYPE filters.
+// The returned cursor is 0 once the whole keyspace has been visited.
+// Since the cursor is a position in the hash space rather than in the map, every key present
+// during the whole iteration is returned, and returned once, whatever happens to other keys.
+func (r *Store) Scan(cursor uint64, opts ScanOptions) (uint64, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := opts.Count
+	if count <= 0 {
+		count = 10
+	}
+	w := scanWindow{count: count}
+	for key, item := range r.items {
+		if item.expired() {
+			continue
+		}
+		if hash := scanHash(key); hash >= cursor {
+			w.add(scanEntry{hash: hash, key: key})
+		}
+	}
+	entries, last := w.entries()
+
+	keys := []string{}
+	for _, e := range entries {
+		if opts.Match != "" && !stringMatch(opts.Match, e.key) {
+			continue
+		}
+		if opts.Type != "" && !strings.EqualFold(typeName(r.items[e.key].value), opts.Type) {
+			continue
+		}
+		keys = append(keys, e.key)
+	}
+	if last || entries[len(entries)-1].hash == math.MaxUint64 {
+		return 0, keys
+	}
+	return entries[len(entries)-1].hash + 1, keys
+}
+
+// ===============================================================================
+func handleUnlink(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("unlink command requires at least one argument")
+	}
+	return handleDel(args, redis)
+}
+
+func handleExists(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("exists command requires at least one argument")
+	}
+	r := currentStore(redis)
+	return r.Exists(args[1:]...), nil
+}
+
+func handleTouch(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("touch command requires at least one argument")
+	}
+	r := currentStore(redis)
+	return r.Touch(args[1:]...), nil
+}
+
+func handleType(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("type command requires exactly one argument")
+	}
+	r := currentStore(redis)
+	return resp.SimpleString(r.Type(args[1])), nil
+}
+
+func handleRename(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("rename command requires exactly two arguments")
+	}
+	r := currentStore(redis)
+	if _, err := r.Rename(args[1], args[2], false); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+func handleRenameNX(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("renamenx command requires exactly two arguments")
+	}
+	r := currentStore(redis)
+	renamed, err := r.Rename(args[1], args[2], true)
+	if err != nil {
+		return nil, err
+	}
+	if renamed {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func handleCopy(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("copy command requires at least two arguments")
+	}
+	r := currentStore(client.redis)
+	target := r
+	replace := false
+	for i := 3; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "replace"):
+			replace = true
+		case strings.EqualFold(args[i], "db") && i+1 < len(args):
+			index, err := parseDBIndex(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if target, err = client.storeAt(index); err != nil {
+				return nil, err
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	if target != r {
+		client.server.dbMutex.Lock()
+		defer client.server.dbMutex.Unlock()
+	}
+	copied, err := r.Copy(args[1], target, args[2], replace)
+	if err != nil {
+		return nil, err
+	}
+	if copied {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func handleDBSize(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("dbsize command does not accept arguments")
+	}
+	r := currentStore(redis)
+	return r.DBSize(), nil
+}
+
+func handleRandomKey(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("randomkey command does not accept arguments")
+	}
+	r := currentStore(redis)
+	key, ok := r.RandomKey()
+	if !ok {
+		return nil, nil
+	}
+	return key, nil
+}
+
+func handleKeys(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("keys command requires exactly one argument")
+	}
+	r := currentStore(redis)
+	return r.Keys(args[1]), nil
+}
+
+func handleScan(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, fmt.Errorf("scan command requires a cursor and option pairs")
+	}
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var opts ScanOptions
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			opts.Match = args[i+1]
+		case "count":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, fmt.Errorf("syntax error")
+			}
+			opts.Count = count
+		case "type":
+			opts.Type = args[i+1]
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	r := currentStore(redis)
+	next, keys := r.Scan(cursor, opts)
+	return []interface{}{strconv.FormatUint(next, 10), keys}, nil
+}
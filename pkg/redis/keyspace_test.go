@@ -0,0 +1,221 @@
+package redis
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExistsAndType(t *testing.T) {
+	s := NewStore()
+	s.Set("string", "value", 0)
+	s.LPush("list", "a")
+	s.ZAdd("zset", []zsetEntry{{"a", 1}}, ZAddOptions{})
+	s.items["expired"] = ExpirationItem{value: []byte("value"), expiration: time.Now().Add(-time.Second)}
+
+	// Duplicated keys are counted each time
+	if count := s.Exists("string", "string", "list", "missing", "expired"); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+	expected := map[string]string{"string": "string", "list": "list", "zset": "zset", "missing": "none", "expired": "none"}
+	for key, name := range expected {
+		if got := s.Type(key); got != name {
+			t.Errorf("Expected type %s for %s, got %s", name, key, got)
+		}
+	}
+	if size := s.DBSize(); size != 3 {
+		t.Errorf("Expected 3 keys, got %d", size)
+	}
+}
+
+func TestRename(t *testing.T) {
+	s := NewStore()
+	s.Set("a", "1", time.Minute)
+	s.Set("b", "2", 0)
+
+	// Test case 1: the time to live moves with the key
+	if _, err := s.Rename("a", "c", false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if item, exist := s.items["c"]; !exist || item.expiration.IsZero() {
+		t.Errorf("Expected c to keep the time to live of a")
+	}
+	if s.Exists("a") != 0 {
+		t.Errorf("Expected a to be renamed")
+	}
+
+	// Test case 2: RENAMENX does not overwrite
+	if renamed, _ := s.Rename("c", "b", true); renamed {
+		t.Errorf("Expected RENAMENX not to overwrite b")
+	}
+	if value, _ := s.Get("b"); value != "2" {
+		t.Errorf("Expected b to be 2, got %s", value)
+	}
+
+	// Test case 3: missing source
+	if _, err := s.Rename("missing", "d", false); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	s := NewStore()
+	s.LPush("list", "a")
+	s.LPush("list", "b")
+	s.Set("other", "value", 0)
+
+	if copied, _ := s.Copy("list", s, "other", false); copied {
+		t.Errorf("Expected COPY not to replace an existing key")
+	}
+	if copied, _ := s.Copy("list", s, "other", true); !copied {
+		t.Errorf("Expected COPY REPLACE to copy")
+	}
+
+	// The copy does not share memory with the source
+	s.LPush("other", "c")
+	if list, _ := listElements(s.items["list"].value); len(list) != 2 {
+		t.Errorf("Expected the source list to be unchanged, got %v", list)
+	}
+	if _, err := s.Copy("list", s, "list", true); err == nil {
+		t.Errorf("Expected an error when source and destination are the same")
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	s := NewStore()
+	if _, ok := s.RandomKey(); ok {
+		t.Errorf("Expected no key in an empty database")
+	}
+	s.items["expired"] = ExpirationItem{value: []byte("value"), expiration: time.Now().Add(-time.Second)}
+	s.Set("key", "value", 0)
+	for i := 0; i < 10; i++ {
+		if key, ok := s.RandomKey(); !ok || key != "key" {
+			t.Errorf("Expected key, got %s", key)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	s := NewStore()
+	for _, key := range []string{"user:1", "user:2", "admin:1"} {
+		s.Set(key, "value", 0)
+	}
+	keys := s.Keys("user:*")
+	if len(keys) != 2 || keys[0] != "user:1" || keys[1] != "user:2" {
+		t.Errorf("Expected [user:1 user:2], got %v", keys)
+	}
+}
+
+func TestScan(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("key:%d", i), "value", 0)
+	}
+	s.LPush("list", "a")
+
+	// Test case 1: a full iteration returns every key once, with the filters applied
+	seen := map[string]int{}
+	cursor := uint64(0)
+	for {
+		next, keys := s.Scan(cursor, ScanOptions{Match: "key:*", Count: 7, Type: "string"})
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	if len(seen) != 100 {
+		t.Errorf("Expected 100 keys, got %d", len(seen))
+	}
+	for key, n := range seen {
+		if n != 1 {
+			t.Errorf("Expected %s to be returned once, got %d", key, n)
+		}
+	}
+
+	// Test case 2: keys present during the whole iteration are returned while others come and go
+	seen = map[string]int{}
+	cursor = 0
+	for i := 0; ; i++ {
+		next, keys := s.Scan(cursor, ScanOptions{Count: 5})
+		for _, key := range keys {
+			seen[key]++
+		}
+		s.Set(fmt.Sprintf("new:%d", i), "value", 0)
+		s.Del(fmt.Sprintf("new:%d", i-3))
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	for i := 0; i < 100; i++ {
+		if seen[fmt.Sprintf("key:%d", i)] != 1 {
+			t.Errorf("Expected key:%d to be returned once", i)
+		}
+	}
+}
+
+func TestScanWindow(t *testing.T) {
+	w := scanWindow{count: 3}
+	for _, e := range []scanEntry{{9, "i"}, {5, "e"}, {7, "g"}, {5, "e2"}, {3, "c"}, {5, "e3"}, {1, "a"}, {8, "h"}} {
+		w.add(e)
+	}
+	// The three smallest hashes are 1, 3 and 5, and every key hashed 5 is kept with them.
+	entries, last := w.entries()
+	if expected := []scanEntry{{1, "a"}, {3, "c"}, {5, "e"}, {5, "e2"}, {5, "e3"}}; !reflect.DeepEqual(entries, expected) || last {
+		t.Errorf("Expected %v, got %v, %v", expected, entries, last)
+	}
+
+	w = scanWindow{count: 3}
+	for _, e := range []scanEntry{{5, "e"}, {5, "e2"}, {5, "e3"}, {6, "f"}, {5, "e4"}, {2, "b"}, {1, "a"}} {
+		w.add(e)
+	}
+	// The keys hashed 5 are kept while one of them is among the three smallest, and dropped after.
+	if entries, _ := w.entries(); !reflect.DeepEqual(entries, []scanEntry{{1, "a"}, {2, "b"}, {5, "e"}, {5, "e2"}, {5, "e3"}, {5, "e4"}}) {
+		t.Errorf("Expected a, b and the keys hashed 5, got %v", entries)
+	}
+	w.add(scanEntry{3, "c"})
+	if entries, _ := w.entries(); !reflect.DeepEqual(entries, []scanEntry{{1, "a"}, {2, "b"}, {3, "c"}}) {
+		t.Errorf("Expected a, b and c, got %v", entries)
+	}
+}
+
+func TestScanConcurrentModification(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprintf("stable:%d", i), "value", 0)
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			s.Set(fmt.Sprintf("volatile:%d", i%500), "value", 0)
+			s.Del(fmt.Sprintf("volatile:%d", (i+250)%500))
+		}
+	}()
+	seen := map[string]bool{}
+	cursor := uint64(0)
+	for {
+		next, keys := s.Scan(cursor, ScanOptions{Count: 20})
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	close(done)
+	for i := 0; i < 1000; i++ {
+		if !seen[fmt.Sprintf("stable:%d", i)] {
+			t.Errorf("Expected stable:%d to be returned", i)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// MIGRATE moves keys to another server, like the keys of a slot moving to another node of the
+// cluster: it sends each key as a RESTORE command holding the DUMP payload of its value, see
+// rdb.Dump, and deletes the key once the target stored it. In cluster mode the command is
+// RESTORE-ASKING, which the target runs on the slot it is importing.
+
+// dump returns the entry of key for DUMP and MIGRATE, reporting false when key does not exist.
+func (r *Store) dump(key string) (*rdb.Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.peek(key)
+	if !exist {
+		return nil, false
+	}
+	return rdbEntry(key, item, NoEviction), true
+}
+
+// Restore stores at key the value of entry read from a DUMP payload. The value of an existing key is
+// replaced with replace, refused otherwise.
+func (r *Store) Restore(key string, entry *rdb.Entry, replace bool) error {
+	if entry.Type != rdb.String && entry.Type != rdb.List && entry.Type != rdb.ZSet {
+		return fmt.Errorf("bad data format: %s values are not supported", entry.Type)
+	}
+	exist := r.Exists(key) > 0
+	if exist && !replace {
+		return resp.Error("BUSYKEY Target key name already exists.")
+	}
+	entry.Key = []byte(key)
+	// A value whose expiration is already in the past is not stored, and deletes the key it replaces.
+	if !r.loadEntry(entry) {
+		if exist {
+			r.Del(key)
+		}
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notifyEvent(notifyNew, "new", key)
+	r.notifyEvent(notifyGeneric, "restore", key)
+	return nil
+}
+
+// migrateKeys returns the keys of MIGRATE host port key|"" db timeout [COPY] [REPLACE] [KEYS key ...].
+func migrateKeys(args []string) []string {
+	for i := 6; i < len(args); i++ {
+		if strings.EqualFold(args[i], "keys") {
+			return args[i+1:]
+		}
+	}
+	if len(args) > 3 && !isEmptyArg(args[3]) {
+		return args[3:4]
+	}
+	return nil
+}
+
+// isEmptyArg reports whether arg is the empty string, which the clients sending lines of arguments
+// write "".
+func isEmptyArg(arg string) bool {
+	return arg == "" || arg == `""`
+}
+
+// ===============================================================================
+func handleDump(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("dump command requires exactly one argument")
+	}
+	entry, exist := currentStore(redis).dump(args[1])
+	if !exist {
+		return nil, nil
+	}
+	payload, err := rdb.Dump(entry)
+	if err != nil {
+		return nil, err
+	}
+	return string(payload), nil
+}
+
+func handleRestore(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, fmt.Errorf("%s command requires a key, a ttl and a payload", strings.ToLower(args[0]))
+	}
+	ttl, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || ttl < 0 {
+		return nil, fmt.Errorf("invalid ttl value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for i := 4; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime", "freq":
+			// The access data of the source is not kept.
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("syntax error")
+			}
+			if n, err := strconv.ParseInt(args[i+1], 10, 64); err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s value, must be >= 0", strings.ToLower(args[i]))
+			}
+			i++
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	entry, err := rdb.ParseDump([]byte(args[3]))
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		if !absTTL {
+			ttl += time.Now().UnixNano() / int64(time.Millisecond)
+		}
+		entry.Expiry = ttl
+	}
+	if err := currentStore(redis).Restore(args[1], entry, replace); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+func handleMigrate(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) < 6 {
+		return nil, fmt.Errorf("migrate command requires a host, a port, a key, a db and a timeout")
+	}
+	port, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	db, err := parseDBIndex(args[4])
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := strconv.ParseInt(args[5], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	copyKeys, replace := false, false
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "keys":
+			if !isEmptyArg(args[3]) {
+				return nil, fmt.Errorf("when using migrate keys option, the key argument must be set to the empty string")
+			}
+			i = len(args)
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	keys := migrateKeys(args)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("syntax error")
+	}
+
+	restore := "RESTORE"
+	if client.server.cluster != nil {
+		restore = "RESTORE-ASKING"
+	}
+	r := currentStore(client.redis)
+	request := resp.AppendCommand(nil, "SELECT", strconv.Itoa(db))
+	migrated := []string{}
+	for _, key := range keys {
+		entry, exist := r.dump(key)
+		if !exist {
+			continue
+		}
+		payload, err := rdb.Dump(entry)
+		if err != nil {
+			return nil, err
+		}
+		ttl := int64(0)
+		if entry.Expiry != 0 {
+			if ttl = entry.Expiry - time.Now().UnixNano()/int64(time.Millisecond); ttl < 1 {
+				ttl = 1
+			}
+		}
+		command := []string{restore, key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			command = append(command, "REPLACE")
+		}
+		request = resp.AppendCommand(request, command...)
+		migrated = append(migrated, key)
+	}
+	if len(migrated) == 0 {
+		return resp.SimpleString("NOKEY"), nil
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(args[1], args[2]), time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		return nil, resp.Error("IOERR error or timeout connecting to the client")
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+	if _, err := conn.Write(request); err != nil {
+		return nil, resp.Error(fmt.Sprintf("IOERR error or timeout writing to target instance %s:%d", args[1], port))
+	}
+	reader := resp.NewReader(bufio.NewReader(conn))
+	var targetErr error
+	for i := -1; i < len(migrated); i++ {
+		reply, err := reader.ReadReply()
+		if err != nil {
+			return nil, resp.Error(fmt.Sprintf("IOERR error or timeout reading to target instance %s:%d", args[1], port))
+		}
+		if e, ok := reply.(resp.Error); ok {
+			// The keys the target refused stay on this server, the last error is reported.
+			targetErr = fmt.Errorf("target instance replied with error: %s", string(e))
+			if i < 0 {
+				return nil, targetErr
+			}
+			continue
+		}
+		if i >= 0 && !copyKeys {
+			r.Del(migrated[i])
+		}
+	}
+	if targetErr != nil {
+		return nil, targetErr
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+func handleAsking(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("asking command does not accept arguments")
+	}
+	if client.server.cluster == nil {
+		return nil, fmt.Errorf("this instance has cluster support disabled")
+	}
+	client.asking = true
+	return resp.SimpleString("OK"), nil
+}
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+)
+
+// Keyspace notifications publish the changes of the keys, like Redis does. Once notify-keyspace-events
+// enables the class of an event, the event of key in the database db is published twice: its name to
+// the channel __keyspace@<db>__:<key> with the K class, and key to the channel __keyevent@<db>__:<event>
+// with the E class. The events are only published to the subscribers of the server they happen on: a
+// replica publishes the events of the commands it applies, a node of a cluster those of its own slots.
+
+// The classes of events, the characters of notify-keyspace-events.
+const (
+	notifyKeyspace = 1 << iota // K: __keyspace@<db>__ channels
+	notifyKeyevent             // E: __keyevent@<db>__ channels
+	notifyGeneric              // g: commands on keys of any type, such as del, expire and rename
+	notifyString               // $: string commands
+	notifyList                 // l: list commands
+	notifySet                  // s: set commands
+	notifyHash                 // h: hash commands
+	notifyZSet                 // z: sorted set commands
+	notifyExpired              // x: keys deleted once their time to live elapsed
+	notifyEvicted              // e: keys evicted for maxmemory
+	notifyStream               // t: stream commands
+	notifyKeyMiss              // m: keys not found by a read, accepted but never published
+	notifyModule               // d: module events, accepted but never published
+	notifyNew                  // n: keys added to the database
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted | notifyStream | notifyModule // A
+)
+
+// notifyClasses maps the characters of notify-keyspace-events to their class, in the order
+// formatNotifyKeyspaceEvents writes them.
+var notifyClasses = []struct {
+	char  byte
+	class int
+}{
+	{'g', notifyGeneric}, {'$', notifyString}, {'l', notifyList}, {'s', notifySet}, {'h', notifyHash},
+	{'z', notifyZSet}, {'x', notifyExpired}, {'e', notifyEvicted}, {'t', notifyStream}, {'d', notifyModule},
+	{'K', notifyKeyspace}, {'E', notifyKeyevent}, {'m', notifyKeyMiss}, {'n', notifyNew},
+}
+
+// parseNotifyKeyspaceEvents parses the classes of notify-keyspace-events, such as "Ex" or "KA".
+func parseNotifyKeyspaceEvents(s string) (int, error) {
+	flags := 0
+next:
+	for i := 0; i < len(s); i++ {
+		if s[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		for _, c := range notifyClasses {
+			if c.char == s[i] {
+				flags |= c.class
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("invalid event class character, use 'Ag$lshzxeKEtmdn'")
+	}
+	return flags, nil
+}
+
+// formatNotifyKeyspaceEvents formats the classes the way Redis does, A standing for all the classes
+// of events it covers.
+func formatNotifyKeyspaceEvents(flags int) string {
+	var b []byte
+	if flags&notifyAll == notifyAll {
+		b = append(b, 'A')
+	}
+	for _, c := range notifyClasses {
+		if flags&c.class != 0 && (c.class&notifyAll == 0 || flags&notifyAll != notifyAll) {
+			b = append(b, c.char)
+		}
+	}
+	return string(b)
+}
+
+// notifyKeyspaceEvent publishes the event of class that happened to key in the database at index db,
+// when notify-keyspace-events enables the class.
+func (r *RedisServer) notifyKeyspaceEvent(class int, event, key string, db int) {
+	flags := int(atomic.LoadInt32(&r.notifyFlags))
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		r.publish("__keyspace@"+strconv.Itoa(db)+"__:"+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		r.publish("__keyevent@"+strconv.Itoa(db)+"__:"+event, key)
+	}
+}
+
+// notifyEvent notifies the event of class that happened to key, once the command changing key ran.
+// The stores of the transactions notify nothing, their commands notify once applied on EXEC.
+// The caller must hold r.mu.
+func (r *Store) notifyEvent(class int, event, key string) {
+	if r.notify != nil {
+		r.notify(class, event, key)
+	}
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents returns the next n keyspace notifications received by the subscriber of a pattern,
+// each as its channel and message.
+func readEvents(c *testConn, n int) []string {
+	c.t.Helper()
+	events := []string{}
+	for i := 0; i < n; i++ {
+		message, ok := c.read().([]interface{})
+		if !ok || len(message) != 4 || message[0] != "pmessage" {
+			c.t.Fatalf("Message = %v", message)
+		}
+		events = append(events, fmt.Sprint(message[2], " ", message[3]))
+	}
+	return events
+}
+
+func TestNotifyKeyspaceEventsConfig(t *testing.T) {
+	for _, test := range []struct{ value, want string }{
+		{"KEA", "AKE"},
+		{"Ex", "xE"},
+		{"AgK", "AK"},
+		{"g$lshzxetdKEmn", "AKEmn"},
+		{"", ""},
+	} {
+		flags, err := parseNotifyKeyspaceEvents(test.value)
+		if got := formatNotifyKeyspaceEvents(flags); err != nil || got != test.want {
+			t.Errorf("notify-keyspace-events %q = %q, %v, want %q", test.value, got, err, test.want)
+		}
+	}
+	if _, err := parseNotifyKeyspaceEvents("KEq"); err == nil {
+		t.Error("An unknown class was accepted")
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	server := startTestServer(t)
+	subscriber, client := dialTestServer(t, server), dialTestServer(t, server)
+	if reply := client.call("CONFIG", "SET", "notify-keyspace-events", "KEA"); reply != "OK" {
+		t.Fatalf("CONFIG SET = %v", reply)
+	}
+	if reply := fmt.Sprint(client.call("CONFIG", "GET", "notify-keyspace-events")); reply != "[notify-keyspace-events AKE]" {
+		t.Fatalf("CONFIG GET = %v", reply)
+	}
+	subscriber.call("PSUBSCRIBE", "__key*__:*")
+
+	// Each event is published to the channel of the key and to the channel of the event.
+	client.call("SET", "foo", "bar")
+	if events := readEvents(subscriber, 2); !reflect.DeepEqual(events, []string{"__keyspace@0__:foo set", "__keyevent@0__:set foo"}) {
+		t.Fatalf("Events of SET = %q", events)
+	}
+
+	// Only the classes of notify-keyspace-events are published.
+	client.call("CONFIG", "SET", "notify-keyspace-events", "Egn")
+	client.call("SET", "foo", "baz")
+	client.call("DEL", "foo")
+	if events := readEvents(subscriber, 1); events[0] != "__keyevent@0__:del foo" {
+		t.Fatalf("Events of SET and DEL with Egn = %q", events)
+	}
+
+	client.call("CONFIG", "SET", "notify-keyspace-events", "EAn")
+	for _, test := range []struct {
+		command []string
+		events  []string
+	}{
+		{[]string{"SET", "k", "v", "EX", "100"}, []string{"new k", "set k", "expire k"}},
+		{[]string{"SET", "k", "v2", "KEEPTTL"}, []string{"set k"}},
+		{[]string{"SET", "s", "v", "PX", "100000", "GET"}, []string{"new s", "set s", "expire s"}},
+		{[]string{"INCR", "n"}, []string{"new n", "incrby n"}},
+		{[]string{"DECRBY", "n", "2"}, []string{"incrby n"}},
+		{[]string{"INCRBYFLOAT", "n", "1.5"}, []string{"incrbyfloat n"}},
+		{[]string{"DEL", "n", "missing"}, []string{"del n"}},
+		{[]string{"LPUSH", "l", "a"}, []string{"new l", "lpush l"}},
+		{[]string{"LPOP", "l"}, []string{"lpop l"}},
+		{[]string{"EXPIRE", "k", "200"}, []string{"expire k"}},
+		{[]string{"PERSIST", "k"}, []string{"persist k"}},
+		{[]string{"RENAME", "k", "k2"}, []string{"new k2", "rename_from k", "rename_to k2"}},
+		{[]string{"COPY", "k2", "s", "REPLACE"}, []string{"new s", "copy_to s"}},
+		{[]string{"MOVE", "s", "1"}, []string{"__keyevent@1__:new s", "move_from s", "__keyevent@1__:move_to s"}},
+		{[]string{"SETBIT", "b", "3", "1"}, []string{"new b", "setbit b"}},
+		{[]string{"BITFIELD", "b", "SET", "u8", "0", "255"}, []string{"setbit b"}},
+		{[]string{"BITOP", "NOT", "b2", "b"}, []string{"new b2", "set b2"}},
+		{[]string{"BITOP", "AND", "b2", "missing"}, []string{"del b2"}},
+		{[]string{"PFADD", "h", "a"}, []string{"new h", "pfadd h"}},
+		{[]string{"PFMERGE", "h2", "h"}, []string{"new h2", "pfadd h2"}},
+		{[]string{"ZADD", "z", "1", "a"}, []string{"new z", "zadd z"}},
+		{[]string{"ZADD", "z", "INCR", "2", "a"}, []string{"zincr z"}},
+		{[]string{"ZREM", "z", "a"}, []string{"zrem z", "del z"}},
+		{[]string{"GEOADD", "g", "13.361389", "38.115556", "Palermo"}, []string{"new g", "zadd g"}},
+		{[]string{"GEOSEARCHSTORE", "g2", "g", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"}, []string{"new g2", "geosearchstore g2"}},
+		{[]string{"EXPIRE", "k2", "-1"}, []string{"del k2"}},
+	} {
+		client.call(test.command...)
+		want := make([]string, len(test.events))
+		for i, event := range test.events {
+			if strings.HasPrefix(event, "__") {
+				want[i] = event
+				continue
+			}
+			fields := strings.Fields(event)
+			want[i] = "__keyevent@0__:" + fields[0] + " " + fields[1]
+		}
+		if events := readEvents(subscriber, len(want)); !reflect.DeepEqual(events, want) {
+			t.Fatalf("Events of %v = %q, want %q", test.command, events, want)
+		}
+	}
+
+	// The keys whose time to live elapsed notify expired once they are accessed or actively expired.
+	client.call("CONFIG", "SET", "notify-keyspace-events", "Ex")
+	client.call("SET", "lazy", "v", "PX", "1")
+	client.call("SET", "active", "v", "PX", "1")
+	time.Sleep(10 * time.Millisecond)
+	client.call("GET", "lazy")
+	if events := readEvents(subscriber, 1); events[0] != "__keyevent@0__:expired lazy" {
+		t.Fatalf("Events of the lazy expiry = %q", events)
+	}
+	server.activeExpireCycle()
+	if events := readEvents(subscriber, 1); events[0] != "__keyevent@0__:expired active" {
+		t.Fatalf("Events of the active expiry = %q", events)
+	}
+
+	// The events of a transaction are published on EXEC, none on DISCARD.
+	client.call("CONFIG", "SET", "notify-keyspace-events", "E$")
+	client.call("MULTI")
+	client.call("SET", "discarded", "v")
+	client.call("DISCARD")
+	client.call("PUBLISH", "__keyevent@0__:marker", "x")
+	client.call("MULTI")
+	client.call("SET", "t", "v")
+	client.call("EXEC")
+	if events := readEvents(subscriber, 2); !reflect.DeepEqual(events, []string{"__keyevent@0__:marker x", "__keyevent@0__:set t"}) {
+		t.Fatalf("Events of the transaction = %q", events)
+	}
+
+	// Keys evicted for maxmemory notify evicted.
+	client.call("CONFIG", "SET", "notify-keyspace-events", "Ee")
+	client.call("FLUSHALL")
+	client.call("SET", "evicted", "v")
+	client.call("CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	client.call("CONFIG", "SET", "maxmemory", "1")
+	client.call("SET", "other", "v")
+	if events := readEvents(subscriber, 1); events[0] != "__keyevent@0__:evicted evicted" {
+		t.Fatalf("Events of the eviction = %q", events)
+	}
+}
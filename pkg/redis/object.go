@@ -0,0 +1,246 @@
+package redis
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	embstrSizeLimit = 44 // strings up to this length are allocated with their header
+	sharedIntegers  = 10000
+	sharedRefCount  = 2147483647 // reference count Redis reports for shared objects
+)
+
+// objectEncoding returns the name Redis gives to the encoding of a value.
+func objectEncoding(value interface{}) string {
+	switch v := value.(type) {
+	case int64:
+		return "int"
+	case []byte:
+		if len(v) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case *listpack:
+		return "listpack"
+	case []string:
+		return "quicklist"
+	case *sortedSet:
+		if v.listpackEncoded() {
+			return "listpack"
+		}
+		return "skiplist"
+	}
+	return "unknown"
+}
+
+// ObjectInfo describes the value stored at a key, as reported by the OBJECT command.
+type ObjectInfo struct {
+	Encoding string
+	RefCount int
+	LRU      uint32 // last access time or access frequency, depending on LFU
+	LFU      bool   // whether the database tracks the access frequency of the keys
+}
+
+// Object returns the details of the value stored at key. It does not count as an access to the key.
+func (r *Store) Object(key string) (ObjectInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.peek(key)
+	if !exist {
+		return ObjectInfo{}, false
+	}
+	info := ObjectInfo{Encoding: objectEncoding(item.value), RefCount: 1, LRU: item.lru, LFU: r.lfu}
+	// Redis shares the objects of small integers between keys.
+	if n, ok := item.value.(int64); ok && n >= 0 && n < sharedIntegers {
+		info.RefCount = sharedRefCount
+	}
+	return info, true
+}
+
+// MemoryUsage estimates the memory used by key and its value. For lists, the size of the
+// elements is estimated from samples of them, all of them when samples is 0.
+func (r *Store) MemoryUsage(key string, samples int) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, exist := r.peek(key)
+	if !exist {
+		return 0, false
+	}
+	list, ok := item.value.([]string)
+	if !ok || samples == 0 || samples >= len(list) {
+		return itemSize(key, item), true
+	}
+	sampled := int64(0)
+	for _, s := range list[:samples] {
+		sampled += stringOverhead + int64(len(s))
+	}
+	return itemOverhead + int64(len(key)) + sliceOverhead + sampled*int64(len(list))/int64(samples), true
+}
+
+// MemoryStats returns the MEMORY STATS report as name and value pairs.
+func (r *RedisServer) MemoryStats() []interface{} {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	dataset := r.usedMemory()
+	stats := []interface{}{
+		"total.allocated", int64(m.HeapAlloc),
+		"allocator.active", int64(m.HeapInuse),
+		"allocator.resident", int64(m.HeapSys),
+	}
+	keys := 0
+	for i, db := range r.dbs {
+		dbKeys, expires := db.keyspaceCounts()
+		if dbKeys == 0 {
+			continue
+		}
+		keys += dbKeys
+		stats = append(stats, fmt.Sprintf("db.%d", i), []interface{}{"keys", dbKeys, "expires", expires})
+	}
+	stats = append(stats, "keys.count", keys)
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = dataset / int64(keys)
+	}
+	percentage := 0.0
+	if m.HeapAlloc > 0 {
+		percentage = float64(dataset) * 100 / float64(m.HeapAlloc)
+	}
+	return append(stats,
+		"keys.bytes-per-key", bytesPerKey,
+		"dataset.bytes", dataset,
+		"dataset.percentage", strconv.FormatFloat(percentage, 'f', 2, 64),
+		"fragmentation", strconv.FormatFloat(fragmentation(m), 'f', 2, 64),
+	)
+}
+
+// fragmentation returns the ratio between the heap memory reserved and the memory in use.
+func fragmentation(m runtime.MemStats) float64 {
+	if m.HeapAlloc == 0 {
+		return 0
+	}
+	return float64(m.HeapInuse) / float64(m.HeapAlloc)
+}
+
+// emptyInstanceSize is the dataset size under which MEMORY DOCTOR has nothing to say.
+const emptyInstanceSize = 5 * 1024 * 1024
+
+// MemoryDoctor returns advice about the memory used by the server.
+func (r *RedisServer) MemoryDoctor() string {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	config := r.getConfig()
+	used := r.usedMemory()
+	if used < emptyInstanceSize {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions."
+	}
+
+	issues := []string{}
+	if config.MaxMemory > 0 && used > config.MaxMemory*9/10 {
+		if config.MaxMemoryPolicy == NoEviction {
+			issues = append(issues, " * Near maxmemory: the dataset uses more than 90% of maxmemory and the noeviction policy will refuse writes once it is reached. Consider raising maxmemory or choosing an eviction policy.")
+		} else {
+			issues = append(issues, " * Near maxmemory: the dataset uses more than 90% of maxmemory, keys are being evicted.")
+		}
+	}
+	if ratio := fragmentation(m); ratio > 1.4 {
+		issues = append(issues, fmt.Sprintf(" * High fragmentation: the heap in use is %.2f times the memory allocated, memory was released by large deletions and not reused yet.", ratio))
+	}
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this instance memory implementation:\n\n" + strings.Join(issues, "\n")
+}
+
+// ===============================================================================
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+func handleObject(args []string, redis []*Store) (interface{}, error) {
+	if len(args) == 2 && strings.EqualFold(args[1], "help") {
+		return objectHelp, nil
+	}
+	if len(args) != 3 {
+		return nil, fmt.Errorf("object command requires a subcommand and a key")
+	}
+	subcommand := strings.ToLower(args[1])
+	switch subcommand {
+	case "encoding", "refcount", "idletime", "freq":
+	default:
+		return nil, fmt.Errorf("unknown subcommand '%s'. try object help", args[1])
+	}
+
+	r := currentStore(redis)
+	info, exist := r.Object(args[2])
+	if !exist {
+		return nil, nil
+	}
+	switch subcommand {
+	case "encoding":
+		return info.Encoding, nil
+	case "refcount":
+		return info.RefCount, nil
+	case "idletime":
+		if info.LFU {
+			return nil, fmt.Errorf("an lfu maxmemory policy is selected, idle time not tracked. please note that when switching between policies at runtime lru and lfu data will take some time to adjust")
+		}
+		return int64(lruIdleTime(info.LRU)), nil
+	default:
+		if !info.LFU {
+			return nil, fmt.Errorf("an lfu maxmemory policy is not selected, access frequency not tracked. please note that when switching between policies at runtime lru and lfu data will take some time to adjust")
+		}
+		return int(lfuDecrAndReturn(info.LRU)), nil
+	}
+}
+
+func handleMemory(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("memory command requires a subcommand")
+	}
+	switch strings.ToLower(args[1]) {
+	case "usage":
+		if len(args) != 3 && len(args) != 5 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		samples := 5
+		if len(args) == 5 {
+			if !strings.EqualFold(args[3], "samples") {
+				return nil, fmt.Errorf("syntax error")
+			}
+			n, err := strconv.Atoi(args[4])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			samples = n
+		}
+		usage, exist := currentStore(client.redis).MemoryUsage(args[2], samples)
+		if !exist {
+			return nil, nil
+		}
+		return usage, nil
+	case "stats":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		return client.server.MemoryStats(), nil
+	case "doctor":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		return client.server.MemoryDoctor(), nil
+	}
+	return nil, fmt.Errorf("unknown subcommand '%s'", args[1])
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestObjectEncoding(t *testing.T) {
+	s := NewStore()
+	s.Set("int", "12345", 0)
+	s.Set("embstr", "hello", 0)
+	s.Set("raw", strings.Repeat("x", 45), 0)
+	s.Set("notint", "012", 0)
+	s.LPush("list", "a")
+	s.LPush("biglist", strings.Repeat("x", 8193))
+	s.ZAdd("zset", []zsetEntry{{"a", 1}}, ZAddOptions{})
+	s.ZAdd("bigzset", []zsetEntry{{strings.Repeat("x", 65), 1}}, ZAddOptions{})
+
+	expected := map[string]string{
+		"int": "int", "embstr": "embstr", "raw": "raw", "notint": "embstr",
+		"list": "listpack", "biglist": "quicklist", "zset": "listpack", "bigzset": "skiplist",
+	}
+	for key, encoding := range expected {
+		if result, _ := handleObject([]string{"object", "encoding", key}, []*Store{s}); result != encoding {
+			t.Errorf("Expected encoding %s for %s, got %s", encoding, key, result)
+		}
+	}
+	if result, _ := handleObject([]string{"object", "encoding", "missing"}, []*Store{s}); result != nil {
+		t.Errorf("Expected nil for a missing key, got %s", result)
+	}
+}
+
+func TestObjectRefCountIdleTimeAndFreq(t *testing.T) {
+	s := NewStore()
+	s.Set("shared", "100", 0)
+	s.Set("key", "value", 0)
+	if result, _ := handleObject([]string{"object", "refcount", "shared"}, []*Store{s}); result != sharedRefCount {
+		t.Errorf("Expected small integers to be shared, got %v", result)
+	}
+	if result, _ := handleObject([]string{"object", "refcount", "key"}, []*Store{s}); result != 1 {
+		t.Errorf("Expected 1, got %v", result)
+	}
+
+	// Test case 1: idle time is tracked by the LRU policies
+	item := s.items["key"]
+	item.lru -= 10
+	s.items["key"] = item
+	if result, _ := handleObject([]string{"object", "idletime", "key"}, []*Store{s}); result != int64(10) {
+		t.Errorf("Expected 10 seconds, got %v", result)
+	}
+	if _, err := handleObject([]string{"object", "freq", "key"}, []*Store{s}); err == nil {
+		t.Errorf("Expected FREQ to fail without an LFU policy")
+	}
+
+	// Test case 2: frequency is tracked by the LFU policies
+	s.setLFU(true)
+	s.Set("key", "value", 0)
+	s.Del("key")
+	s.Set("key", "value", 0)
+	if result, _ := handleObject([]string{"object", "freq", "key"}, []*Store{s}); result != 5 {
+		t.Errorf("Expected the initial frequency 5, got %v", result)
+	}
+	if _, err := handleObject([]string{"object", "idletime", "key"}, []*Store{s}); err == nil {
+		t.Errorf("Expected IDLETIME to fail with an LFU policy")
+	}
+	if result, _ := handleObject([]string{"object", "help"}, []*Store{s}); !strings.HasPrefix(fmt.Sprint(result), "[OBJECT <subcommand>") {
+		t.Errorf("Unexpected help: %s", result)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	s := NewStore()
+	s.Set("key", "value", 0)
+	if usage, exist := s.MemoryUsage("key", 5); !exist || usage != s.UsedMemory() {
+		t.Errorf("Expected %d bytes, got %d", s.UsedMemory(), usage)
+	}
+
+	// Only lists converted from a listpack are sampled
+	s.limits.listMaxListpackSize = 2
+	for _, element := range []string{"a", "a", "bbbbbbbbbb", "bbbbbbbbbb"} {
+		s.LPush("list", element)
+	}
+	exact, _ := s.MemoryUsage("list", 0)
+	sampled, _ := s.MemoryUsage("list", 2)
+	if exact != s.items["list"].size || sampled >= exact {
+		t.Errorf("Expected the sampled size %d to be estimated from the first elements, exact size %d", sampled, exact)
+	}
+	if _, exist := s.MemoryUsage("missing", 0); exist {
+		t.Errorf("Expected no usage for a missing key")
+	}
+}
+
+func TestMemoryStatsAndDoctor(t *testing.T) {
+	server := New(DefaultConfig())
+	client := newTestClient(server)
+	server.dbs[0].Set("key", "value", 0)
+
+	stats, err := handleMemory([]string{"memory", "stats"}, client)
+	if text := fmt.Sprint(stats); err != nil || !strings.Contains(text, "keys.count 1") || !strings.Contains(text, "db.0 [keys 1 expires 0]") {
+		t.Errorf("Unexpected stats: %v (err %v)", stats, err)
+	}
+	if doctor, _ := handleMemory([]string{"memory", "doctor"}, client); !strings.Contains(fmt.Sprint(doctor), "empty") {
+		t.Errorf("Expected the doctor to report an empty instance, got %s", doctor)
+	}
+}
@@ -0,0 +1,103 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// The clients send their commands as lines of arguments separated by spaces. Commands starting
+// with '*' are read in the protocol of Redis (RESP) instead, the way redis-server and redis-cli send
+// them, so that a redis-server replica can connect to the server. The replies of a client speaking
+// RESP are sent as RESP too, with their types, see sendReplyToClient.
+
+// respConn marks the connection of a client speaking RESP. The replies of the commands are written
+// to the underlying connection by sendReplyToClient; a line of text written to the connection itself
+// is sent as a simple string, or as a bulk string when it spans several lines.
+type respConn struct {
+	net.Conn
+}
+
+func (c *respConn) Write(b []byte) (int, error) {
+	reply := strings.TrimSuffix(string(b), "\n")
+	var out []byte
+	if strings.ContainsAny(reply, "\r\n") {
+		out = append(strconv.AppendInt([]byte{'$'}, int64(len(reply)), 10), "\r\n"...)
+		out = append(append(out, reply...), "\r\n"...)
+	} else {
+		out = append(append([]byte{'+'}, reply...), "\r\n"...)
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// rawConn returns the connection of the client without the translation of the replies to RESP.
+func (client *ClientDetail) rawConn() net.Conn {
+	if c, ok := client.conn.conn.(*respConn); ok {
+		return c.Conn
+	}
+	return client.conn.conn
+}
+
+// readRequest reads the next command of the client from br, a line of arguments or a RESP command
+// read with requests, which reads from br too.
+func (client *ClientDetail) readRequest(br *bufio.Reader, requests *resp.Reader) ([]string, error) {
+	if b, err := br.Peek(1); err == nil && b[0] == '*' {
+		args, err := requests.ReadCommand()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := client.conn.conn.(*respConn); !ok {
+			client.conn.conn = &respConn{Conn: client.conn.conn}
+			if client.sub != nil {
+				client.server.pubsub.mutex.Lock()
+				client.sub.resp = true
+				client.server.pubsub.mutex.Unlock()
+			}
+		}
+		return args, nil
+	}
+	line, err := br.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	return strings.Split(strings.Trim(line, " "), " "), nil
+}
+
+// sendReplyToClient sends the reply v of a command, or its error when v is an error: in RESP with its
+// types to the clients speaking RESP, so that they can tell a missing value, an integer or a MOVED
+// error from a string, and as text to the others. The replies of the commands of a transaction
+// run on EXEC are collected instead.
+func sendReplyToClient(conn net.Conn, v interface{}) {
+	if c, ok := conn.(*execConn); ok {
+		c.replies = append(c.replies, v)
+		return
+	}
+	if c, ok := conn.(*respConn); ok {
+		c.Conn.Write(resp.AppendReply(nil, v))
+		return
+	}
+	sendBackToClient(conn, textReply(v))
+}
+
+// textReply formats a reply for the clients that do not speak RESP, nil values as (nil).
+func textReply(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "(nil)"
+	case []interface{}:
+		elements := make([]string, len(v))
+		for i, element := range v {
+			elements[i] = textReply(element)
+		}
+		return "[" + strings.Join(elements, " ") + "]"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+func TestRESPReplyTypes(t *testing.T) {
+	server := startTestServer(t)
+	conn := dialTestServer(t, server)
+
+	// Each reply is read as raw bytes, so that its RESP type is checked and not only its value.
+	for _, test := range []struct {
+		command  []string
+		expected string
+	}{
+		{[]string{"SET", "key", "value"}, "+OK\r\n"},
+		{[]string{"GET", "key"}, "$5\r\nvalue\r\n"},
+		{[]string{"GET", "missing"}, "$-1\r\n"},
+		{[]string{"INCR", "counter"}, ":1\r\n"},
+		{[]string{"INCRBY", "counter", "-3"}, ":-2\r\n"},
+		{[]string{"LPUSH", "key", "a"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{[]string{"PFCOUNT", "key"}, "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{[]string{"LPUSH", "list", "a"}, ":1\r\n"},
+		{[]string{"LRANGE", "list", "0", "-1"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"LPOP", "missing"}, "$-1\r\n"},
+		{[]string{"BITFIELD", "bits", "SET", "u8", "0", "255", "GET", "u8", "0"}, "*2\r\n:0\r\n:255\r\n"},
+		{[]string{"BITFIELD", "bits", "OVERFLOW", "FAIL", "INCRBY", "u8", "0", "1"}, "*1\r\n$-1\r\n"},
+		{[]string{"GEOADD", "geo", "13.361389", "38.115556", "Palermo"}, ":1\r\n"},
+		{[]string{"GEOPOS", "geo", "missing"}, "*1\r\n$-1\r\n"},
+		{[]string{"GEODIST", "geo", "Palermo", "missing"}, "$-1\r\n"},
+		{[]string{"TTL", "missing"}, ":-2\r\n"},
+		{[]string{"TYPE", "list"}, "+list\r\n"},
+		{[]string{"FOO"}, "-ERR unknown command\r\n"},
+	} {
+		conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.conn.Write(resp.AppendCommand(nil, test.command...)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, len(test.expected))
+		if _, err := io.ReadFull(conn.br, reply); err != nil {
+			t.Fatalf("%v: %v", test.command, err)
+		}
+		if string(reply) != test.expected {
+			t.Fatalf("%v: expected %q, got %q", test.command, test.expected, reply)
+		}
+	}
+}
+
+func TestRESPTransactionReplies(t *testing.T) {
+	server := startTestServer(t)
+	conn := dialTestServer(t, server)
+
+	// The commands of a transaction are queued and their replies are sent on EXEC as an array.
+	for _, test := range []struct {
+		command  []string
+		expected string
+	}{
+		{[]string{"MULTI"}, "+started transaction\r\n"},
+		{[]string{"SET", "lock", "token", "NX"}, "+QUEUED\r\n"},
+		{[]string{"SET", "lock", "other", "NX"}, "+QUEUED\r\n"},
+		{[]string{"INCR", "counter"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*3\r\n+OK\r\n$-1\r\n:1\r\n"},
+		{[]string{"GET", "lock"}, "$5\r\ntoken\r\n"},
+		{[]string{"MULTI"}, "+started transaction\r\n"},
+		{[]string{"EXEC"}, "*0\r\n"},
+		{[]string{"MULTI"}, "+started transaction\r\n"},
+		{[]string{"WAIT", "1", "0"}, "+QUEUED\r\n"},
+		{[]string{"EXEC"}, "*1\r\n:0\r\n"},
+		{[]string{"EXEC"}, "-ERR exec without multi\r\n"},
+	} {
+		conn.conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.conn.Write(resp.AppendCommand(nil, test.command...)); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, len(test.expected))
+		if _, err := io.ReadFull(conn.br, reply); err != nil {
+			t.Fatalf("%v: %v", test.command, err)
+		}
+		if string(reply) != test.expected {
+			t.Fatalf("%v: expected %q, got %q", test.command, test.expected, reply)
+		}
+	}
+}
+
+func TestTextReplies(t *testing.T) {
+	server := startTestServer(t)
+	conn := dialTestServer(t, server)
+
+	for _, test := range []struct {
+		command  string
+		expected string
+	}{
+		{"get missing", "(nil)"},
+		{"incr counter", "1"},
+		{"lrange missing 0 -1", "[]"},
+		{"bitfield bits set u8 0 255 get u8 0", "[0 255]"},
+		{"geopos geo missing", "[(nil)]"},
+		{"set counter value get", "1"},
+		{"incr counter", "value is not an integer or out of range"},
+	} {
+		if reply := conn.do(test.command); reply != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.command, test.expected, reply)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// A client subscribing to channels or patterns enters the subscriber mode: it only runs the commands
+// of subscriberCommands, and its replies are sent along with the messages published on its
+// channels through a buffer emptied by its own goroutine, see subscriber, so that PUBLISH never
+// waits for a slow subscriber. A subscriber whose buffer grows past the pubsub class of
+// client-output-buffer-limit is disconnected.
+//
+// Shard channels, subscribed to with SSUBSCRIBE and published on with SPUBLISH, are the channels of
+// a shard of a cluster: they hash to slots like keys, are served by the node owning their slot, and
+// their messages are not sent to the other nodes. The subscribers of a shard channel are
+// unsubscribed when its slot moves to another node.
+
+// OutputBufferLimit bounds the replies waiting to be sent to a client, like a class of the
+// client-output-buffer-limit of Redis.
+type OutputBufferLimit struct {
+	Hard        int64 // bytes over which the client is disconnected, 0 for no limit
+	Soft        int64 // bytes over which the client is disconnected after SoftSeconds, 0 for no limit
+	SoftSeconds int
+}
+
+// subscriberCommands are the commands a client in subscriber mode may run.
+var subscriberCommands = map[CommandType]bool{
+	subscribeCommand:    true,
+	unsubscribeCommand:  true,
+	pSubscribeCommand:   true,
+	pUnsubscribeCommand: true,
+	sSubscribeCommand:   true,
+	sUnsubscribeCommand: true,
+	pingCommand:         true,
+}
+
+// pubsubState is the channels and patterns the clients subscribed to.
+type pubsubState struct {
+	mutex             sync.Mutex
+	channels          map[string]map[*subscriber]bool
+	patterns          map[string]map[*subscriber]bool
+	shardChannels     map[string]map[*subscriber]bool
+	limitDisconnected int64 // subscribers disconnected for their output buffer limit
+}
+
+// subscriber is the connection of a client that subscribed to channels or patterns. What is
+// written to it is buffered and sent by the goroutine of writeLoop, the way the stream is sent to
+// the replicas.
+type subscriber struct {
+	net.Conn      // connection of the client
+	server        *RedisServer
+	id            string // ID of the client
+	resp          bool   // the client speaks RESP
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
+	buf           []byte        // replies and messages not sent yet
+	sending       int           // bytes being written to the connection by writeLoop
+	notify        chan struct{} // signals the replies to send to writeLoop
+	closed        bool
+	softSince     time.Time // when the buffer went over the soft limit, zero while it is under
+}
+
+func (s *subscriber) Write(b []byte) (int, error) {
+	r := s.server
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	if s.closed {
+		return 0, net.ErrClosed
+	}
+	s.send(b)
+	return len(b), nil
+}
+
+func (s *subscriber) Close() error {
+	r := s.server
+	r.pubsub.mutex.Lock()
+	s.close()
+	r.pubsub.mutex.Unlock()
+	return s.Conn.Close()
+}
+
+// send buffers b, disconnecting the client once its buffer overcomes the limit. The caller must hold
+// r.pubsub.mutex.
+func (s *subscriber) send(b []byte) {
+	if s.closed {
+		return
+	}
+	s.buf = append(s.buf, b...)
+	limit := s.server.getConfig().ClientOutputBufferLimitPubSub
+	size := int64(len(s.buf) + s.sending)
+	over := limit.Hard > 0 && size > limit.Hard
+	if limit.Soft > 0 && size > limit.Soft {
+		if s.softSince.IsZero() {
+			s.softSince = time.Now()
+		}
+		over = over || time.Since(s.softSince) >= time.Duration(limit.SoftSeconds)*time.Second
+	} else {
+		s.softSince = time.Time{}
+	}
+	if over {
+		fmt.Printf("Client %s closed for overcoming of output buffer limits\n", s.id)
+		s.server.pubsub.limitDisconnected++
+		s.close()
+		// The client goroutine, whose read fails, unsubscribes the client.
+		s.Conn.Close()
+		return
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// close stops writeLoop, dropping what was not sent. The caller must hold r.pubsub.mutex.
+func (s *subscriber) close() {
+	if !s.closed {
+		s.closed = true
+		s.buf = nil
+		close(s.notify)
+	}
+}
+
+// writeLoop sends the buffer of s to the client until s is closed.
+func (s *subscriber) writeLoop() {
+	r := s.server
+	for range s.notify {
+		r.pubsub.mutex.Lock()
+		b := s.buf
+		s.buf = nil
+		s.sending = len(b)
+		r.pubsub.mutex.Unlock()
+		_, err := s.Conn.Write(b)
+		r.pubsub.mutex.Lock()
+		s.sending = 0
+		r.pubsub.mutex.Unlock()
+		if err != nil {
+			s.Conn.Close()
+			return
+		}
+	}
+}
+
+// message returns the message v formatted for the client.
+func (s *subscriber) message(v []interface{}) []byte {
+	if s.resp {
+		return resp.AppendReply(nil, v)
+	}
+	return []byte(fmt.Sprint(v) + "\n")
+}
+
+// subscriber returns the subscriber of the client, which replaces its connection the first time it
+// subscribes.
+func (client *ClientDetail) subscriber() *subscriber {
+	if client.sub != nil {
+		return client.sub
+	}
+	s := &subscriber{server: client.server, id: client.conn.ID, channels: map[string]bool{},
+		patterns: map[string]bool{}, shardChannels: map[string]bool{}, notify: make(chan struct{}, 1)}
+	if c, ok := client.conn.conn.(*respConn); ok {
+		s.Conn, s.resp = c.Conn, true
+		c.Conn = s
+	} else {
+		s.Conn = client.conn.conn
+		client.conn.conn = s
+	}
+	client.sub = s
+	go s.writeLoop()
+	return s
+}
+
+// subscribed reports whether the client is in subscriber mode.
+func (client *ClientDetail) subscribed() bool {
+	if client.sub == nil {
+		return false
+	}
+	// The shard channels of the client may be dropped by the cluster.
+	r := client.server
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	return len(client.sub.channels)+len(client.sub.patterns)+len(client.sub.shardChannels) > 0
+}
+
+// subscriptionsOf returns the subscriptions of the server and of s of the kind of a (un)subscription
+// command: channels, patterns or shard channels.
+func (r *RedisServer) subscriptionsOf(s *subscriber, kind string) (map[string]map[*subscriber]bool, map[string]bool) {
+	switch kind {
+	case "psubscribe", "punsubscribe":
+		return r.pubsub.patterns, s.patterns
+	case "ssubscribe", "sunsubscribe":
+		return r.pubsub.shardChannels, s.shardChannels
+	}
+	return r.pubsub.channels, s.channels
+}
+
+// subscriptions returns the number of subscriptions of s reported by the replies of a (un)subscription
+// command: its shard channels for the shard ones, its channels and patterns for the others.
+func (s *subscriber) subscriptions(kind string) int64 {
+	if kind == "ssubscribe" || kind == "sunsubscribe" {
+		return int64(len(s.shardChannels))
+	}
+	return int64(len(s.channels) + len(s.patterns))
+}
+
+// subscribe subscribes s to names, channels, patterns or shard channels, and returns the confirmation
+// of each. The caller must hold r.pubsub.mutex.
+func (r *RedisServer) subscribe(s *subscriber, kind string, names []string) []interface{} {
+	subscriptions, own := r.subscriptionsOf(s, kind)
+	replies := []interface{}{}
+	for _, name := range names {
+		if !own[name] {
+			own[name] = true
+			if subscriptions[name] == nil {
+				subscriptions[name] = map[*subscriber]bool{}
+			}
+			subscriptions[name][s] = true
+		}
+		replies = append(replies, []interface{}{kind, name, s.subscriptions(kind)})
+	}
+	return replies
+}
+
+// unsubscribe unsubscribes s from names, or from all its channels or patterns when names is empty,
+// and returns the confirmation of each. The caller must hold r.pubsub.mutex.
+func (r *RedisServer) unsubscribe(s *subscriber, kind string, names []string) []interface{} {
+	subscriptions, own := r.subscriptionsOf(s, kind)
+	if len(names) == 0 {
+		names = sortedNames(own)
+		if len(names) == 0 {
+			return []interface{}{[]interface{}{kind, nil, s.subscriptions(kind)}}
+		}
+	}
+	replies := []interface{}{}
+	for _, name := range names {
+		if own[name] {
+			delete(own, name)
+			delete(subscriptions[name], s)
+			if len(subscriptions[name]) == 0 {
+				delete(subscriptions, name)
+			}
+		}
+		replies = append(replies, []interface{}{kind, name, s.subscriptions(kind)})
+	}
+	return replies
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// unsubscribeAll forgets the subscriptions of a client whose connection was closed.
+func (client *ClientDetail) unsubscribeAll() {
+	if client.sub == nil {
+		return
+	}
+	r := client.server
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	r.unsubscribe(client.sub, "unsubscribe", nil)
+	r.unsubscribe(client.sub, "punsubscribe", nil)
+	r.unsubscribe(client.sub, "sunsubscribe", nil)
+	client.sub.close()
+}
+
+// publish sends message to the subscribers of channel and of the patterns matching it, and returns
+// the number of messages sent.
+func (r *RedisServer) publish(channel, message string) int64 {
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	receivers := int64(0)
+	for s := range r.pubsub.channels[channel] {
+		s.send(s.message([]interface{}{"message", channel, message}))
+		receivers++
+	}
+	for pattern, subscribers := range r.pubsub.patterns {
+		if !stringMatch(pattern, channel) {
+			continue
+		}
+		for s := range subscribers {
+			s.send(s.message([]interface{}{"pmessage", pattern, channel, message}))
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// publishShard sends message to the subscribers of the shard channel, and returns their number.
+func (r *RedisServer) publishShard(channel, message string) int64 {
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	for s := range r.pubsub.shardChannels[channel] {
+		s.send(s.message([]interface{}{"smessage", channel, message}))
+	}
+	return int64(len(r.pubsub.shardChannels[channel]))
+}
+
+// dropShardChannels unsubscribes the subscribers of the shard channels whose slot neither the node nor
+// its primary serve anymore, which receive a sunsubscribe message. The caller must hold r.cluster.mutex.
+func (r *RedisServer) dropShardChannels() {
+	c := r.cluster
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	for channel, subscribers := range r.pubsub.shardChannels {
+		if n := c.slots[keyHashSlot(channel)]; n != nil && (n == c.myself || n == c.myself.master) {
+			continue
+		}
+		for s := range subscribers {
+			reply := r.unsubscribe(s, "sunsubscribe", []string{channel})
+			s.send(s.message(reply[0].([]interface{})))
+		}
+	}
+}
+
+// propagatePublish streams PUBLISH or SPUBLISH to the replicas, which deliver the message to their
+// own subscribers. Unlike the writes, it is not logged to the AOF.
+func (r *RedisServer) propagatePublish(args []string) {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if r.repl.master != nil || r.repl.backlog == nil {
+		return
+	}
+	r.appendReplicationStream(resp.AppendCommand(nil, args...))
+}
+
+// ParseClientOutputBufferLimit parses the classes of client-output-buffer-limit, "<class> <hard>
+// <soft> <soft seconds>" repeated, into pubsub. Only the limit of the pubsub class can change: the
+// normal clients have none and the one of the replicas is fixed.
+func ParseClientOutputBufferLimit(s string, pubsub *OutputBufferLimit) error {
+	fields := strings.Fields(s)
+	if len(fields)%4 != 0 {
+		return fmt.Errorf("wrong number of arguments in buffer limit configuration")
+	}
+	parsed := *pubsub
+	for i := 0; i < len(fields); i += 4 {
+		hard, err1 := ParseMemory(fields[i+1])
+		soft, err2 := ParseMemory(fields[i+2])
+		seconds, err3 := strconv.Atoi(fields[i+3])
+		if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+			return fmt.Errorf("error in hard, soft or soft_seconds setting in buffer limit configuration")
+		}
+		limit := OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+		switch strings.ToLower(fields[i]) {
+		case "pubsub":
+			parsed = limit
+		case "normal":
+			if limit != (OutputBufferLimit{}) {
+				return fmt.Errorf("the limit of the normal clients can't be changed")
+			}
+		case "replica", "slave":
+			if limit != (OutputBufferLimit{Hard: replicaOutputBufferLimit}) {
+				return fmt.Errorf("the limit of the replicas can't be changed")
+			}
+		default:
+			return fmt.Errorf("invalid client class specified in buffer limit configuration")
+		}
+	}
+	*pubsub = parsed
+	return nil
+}
+
+// formatClientOutputBufferLimit formats the limits the way ParseClientOutputBufferLimit parses them.
+func formatClientOutputBufferLimit(pubsub OutputBufferLimit) string {
+	return fmt.Sprintf("normal 0 0 0 replica %d 0 0 pubsub %d %d %d", replicaOutputBufferLimit,
+		pubsub.Hard, pubsub.Soft, pubsub.SoftSeconds)
+}
+
+// ===============================================================================
+// handleSubscribe returns the replies of SUBSCRIBE and PSUBSCRIBE, one per channel or pattern.
+func handleSubscribe(args []string, client *ClientDetail) ([]interface{}, error) {
+	kind := strings.ToLower(args[0])
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s command requires at least one channel", kind)
+	}
+	r := client.server
+	s := client.subscriber()
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	return r.subscribe(s, kind, args[1:]), nil
+}
+
+// handleUnsubscribe returns the replies of UNSUBSCRIBE and PUNSUBSCRIBE, one per channel or pattern.
+func handleUnsubscribe(args []string, client *ClientDetail) ([]interface{}, error) {
+	kind := strings.ToLower(args[0])
+	if client.sub == nil {
+		if len(args) == 1 {
+			return []interface{}{[]interface{}{kind, nil, int64(0)}}, nil
+		}
+		replies := []interface{}{}
+		for _, name := range args[1:] {
+			replies = append(replies, []interface{}{kind, name, int64(0)})
+		}
+		return replies, nil
+	}
+	r := client.server
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	return r.unsubscribe(client.sub, kind, args[1:]), nil
+}
+
+func handlePublish(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("publish command requires a channel and a message")
+	}
+	r := client.server
+	receivers := r.publish(args[1], args[2])
+	if r.cluster == nil {
+		r.propagatePublish(args)
+	} else if !client.master {
+		// The message reaches the subscribers of every node, the replicas included.
+		r.cluster.mutex.Lock()
+		r.broadcastClusterMessage("PUBLISH", r.cluster.myself.id, args[1], args[2])
+		r.cluster.mutex.Unlock()
+	}
+	return receivers, nil
+}
+
+func handleSPublish(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("spublish command requires a shard channel and a message")
+	}
+	r := client.server
+	receivers := r.publishShard(args[1], args[2])
+	// The replicas of the shard deliver the message to their own subscribers.
+	r.propagatePublish(args)
+	return receivers, nil
+}
+
+func handlePubsub(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("pubsub command requires a subcommand")
+	}
+	r := client.server
+	r.pubsub.mutex.Lock()
+	defer r.pubsub.mutex.Unlock()
+	subcommand := strings.ToLower(args[1])
+	subscriptions := r.pubsub.channels
+	if strings.HasPrefix(subcommand, "shard") {
+		subscriptions = r.pubsub.shardChannels
+	}
+	switch subcommand {
+	case "channels", "shardchannels":
+		if len(args) > 3 {
+			return nil, fmt.Errorf("pubsub %s accepts at most one pattern", subcommand)
+		}
+		channels := []string{}
+		for channel := range subscriptions {
+			if len(args) == 2 || stringMatch(args[2], channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		return channels, nil
+	case "numsub", "shardnumsub":
+		reply := []interface{}{}
+		for _, channel := range args[2:] {
+			reply = append(reply, channel, int64(len(subscriptions[channel])))
+		}
+		return reply, nil
+	case "numpat":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("pubsub numpat does not accept arguments")
+		}
+		return int64(len(r.pubsub.patterns)), nil
+	}
+	return nil, fmt.Errorf("unknown subcommand '%s'", args[1])
+}
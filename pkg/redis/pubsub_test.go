@@ -0,0 +1,152 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// read returns the next reply sent to the client, such as a published message.
+func (c *testConn) read() interface{} {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reply, err := resp.NewReader(c.br).ReadReply()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+func numSub(server *RedisServer, channel string) int {
+	server.pubsub.mutex.Lock()
+	defer server.pubsub.mutex.Unlock()
+	return len(server.pubsub.channels[channel])
+}
+
+func TestPubSub(t *testing.T) {
+	server := startTestServer(t)
+	subscriber, publisher := dialTestServer(t, server), dialTestServer(t, server)
+	for _, test := range []struct {
+		reply interface{}
+		want  string
+	}{
+		{subscriber.call("SUBSCRIBE", "news", "sport"), "[subscribe news 1]"},
+		{subscriber.read(), "[subscribe sport 2]"},
+		{subscriber.call("PSUBSCRIBE", "n*"), "[psubscribe n* 3]"},
+		{publisher.call("PUBLISH", "news", "hello"), "2"},
+		{subscriber.read(), "[message news hello]"},
+		{subscriber.read(), "[pmessage n* news hello]"},
+		{publisher.call("PUBLISH", "nothing", "x"), "1"},
+		{subscriber.read(), "[pmessage n* nothing x]"},
+		{publisher.call("PUBLISH", "other", "x"), "0"},
+		{subscriber.call("GET", "foo"), "ERR can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context"},
+		{subscriber.call("PING"), "[pong ]"},
+		{publisher.call("PUBSUB", "CHANNELS"), "[news sport]"},
+		{publisher.call("PUBSUB", "CHANNELS", "s*"), "[sport]"},
+		{publisher.call("PUBSUB", "NUMSUB", "news", "other"), "[news 1 other 0]"},
+		{publisher.call("PUBSUB", "NUMPAT"), "1"},
+		{subscriber.call("UNSUBSCRIBE", "news"), "[unsubscribe news 2]"},
+		{subscriber.call("UNSUBSCRIBE"), "[unsubscribe sport 1]"},
+		{subscriber.call("PUNSUBSCRIBE"), "[punsubscribe n* 0]"},
+		{subscriber.call("PUNSUBSCRIBE"), "[punsubscribe <nil> 0]"},
+		{subscriber.call("SET", "foo", "bar"), "OK"},
+		{subscriber.call("PING"), "PONG"},
+	} {
+		if got := fmt.Sprint(test.reply); got != test.want {
+			t.Fatalf("Reply = %q, want %q", got, test.want)
+		}
+	}
+
+	// The subscriptions of a client are forgotten once it disconnects.
+	subscriber.call("SUBSCRIBE", "news")
+	subscriber.conn.Close()
+	waitFor(t, "the subscriber to be forgotten", func() bool { return numSub(server, "news") == 0 })
+
+	// A client speaking lines of text receives the messages as lines.
+	text := dialTestServer(t, server)
+	if reply := text.do("subscribe news"); reply != "[subscribe news 1]" {
+		t.Fatalf("SUBSCRIBE = %q", reply)
+	}
+	publisher.call("PUBLISH", "news", "hi")
+	if line, _ := text.br.ReadString('\n'); line != "[message news hi]\n" {
+		t.Fatalf("Message = %q", line)
+	}
+}
+
+func TestPubSubOutputBufferLimit(t *testing.T) {
+	server := startTestServer(t)
+	publisher := dialTestServer(t, server)
+	if reply := publisher.call("CONFIG", "SET", "client-output-buffer-limit", "pubsub 4mb 1mb 60"); reply != "OK" {
+		t.Fatalf("CONFIG SET = %v", reply)
+	}
+	if reply := publisher.call("CONFIG", "GET", "client-output-buffer-limit"); !strings.HasSuffix(fmt.Sprint(reply), "pubsub 4194304 1048576 60]") {
+		t.Fatalf("CONFIG GET = %v", reply)
+	}
+	if reply := publisher.call("CONFIG", "SET", "client-output-buffer-limit", "normal 1mb 0 0"); reply == "OK" {
+		t.Fatal("The limit of the normal clients was changed")
+	}
+
+	// A subscriber that does not read its messages is disconnected, without slowing the publisher.
+	slow := dialTestServer(t, server)
+	slow.call("SUBSCRIBE", "news")
+	message := strings.Repeat("x", 1024*1024)
+	start := time.Now()
+	for i := 0; i < 100 && numSub(server, "news") > 0; i++ {
+		publisher.call("PUBLISH", "news", message)
+	}
+	if numSub(server, "news") > 0 {
+		t.Fatal("The slow subscriber was not disconnected")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Publishing took %v", elapsed)
+	}
+	if !strings.Contains(server.Info("stats"), "client_output_buffer_limit_disconnections:1") {
+		t.Fatalf("INFO stats = %s", server.Info("stats"))
+	}
+}
+
+func TestPubSubReplication(t *testing.T) {
+	primary, replica := startTestServer(t), startTestServer(t)
+	replicate(t, replica, primary)
+	subscriber := dialTestServer(t, replica)
+	subscriber.call("SUBSCRIBE", "news")
+	if reply := dialTestServer(t, primary).call("PUBLISH", "news", "hello"); reply != int64(0) {
+		t.Fatalf("PUBLISH = %v", reply)
+	}
+	if reply := fmt.Sprint(subscriber.read()); reply != "[message news hello]" {
+		t.Fatalf("Message on the replica = %v", reply)
+	}
+}
+
+func TestShardedPubSub(t *testing.T) {
+	server := startTestServer(t)
+	subscriber, publisher := dialTestServer(t, server), dialTestServer(t, server)
+	for _, test := range []struct {
+		reply interface{}
+		want  string
+	}{
+		{subscriber.call("SSUBSCRIBE", "orders", "users"), "[ssubscribe orders 1]"},
+		{subscriber.read(), "[ssubscribe users 2]"},
+		// Shard channels are counted apart from the channels and patterns.
+		{subscriber.call("SUBSCRIBE", "orders"), "[subscribe orders 1]"},
+		{publisher.call("SPUBLISH", "orders", "hello"), "1"},
+		{subscriber.read(), "[smessage orders hello]"},
+		{publisher.call("PUBLISH", "orders", "hi"), "1"},
+		{subscriber.read(), "[message orders hi]"},
+		{publisher.call("PUBSUB", "SHARDCHANNELS"), "[orders users]"},
+		{publisher.call("PUBSUB", "SHARDCHANNELS", "u*"), "[users]"},
+		{publisher.call("PUBSUB", "SHARDNUMSUB", "orders", "other"), "[orders 1 other 0]"},
+		{subscriber.call("UNSUBSCRIBE"), "[unsubscribe orders 0]"},
+		{subscriber.call("GET", "foo"), "ERR can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING are allowed in this context"},
+		{subscriber.call("SUNSUBSCRIBE"), "[sunsubscribe orders 1]"},
+		{subscriber.read(), "[sunsubscribe users 0]"},
+		{subscriber.call("PING"), "PONG"},
+	} {
+		if got := fmt.Sprint(test.reply); got != test.want {
+			t.Fatalf("Reply = %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,515 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// The databases are saved to disk in the RDB format of Redis. Redis forks to save a point in time
+// copy of the memory while it keeps serving clients, the snapshots below play the role of the fork:
+// taking one copies the maps of items but not the values, and the databases clone a value before
+// modifying it in place for as long as a snapshot may still read it (see Store.unshare).
+
+// bgsaveRetryDelay is how long the save rules wait after a failed background save before retrying.
+const bgsaveRetryDelay = 5 * time.Second
+
+// rdbState tracks the saves of the RDB file.
+type rdbState struct {
+	mutex        sync.Mutex
+	saving       bool          // a save is in progress
+	scheduled    bool          // a background save was requested with BGSAVE SCHEDULE while saving
+	lastSave     time.Time     // time of the last successful save, or of the start of the server
+	savedDirty   int64         // changes made to the databases at the time of the last successful save
+	lastTry      time.Time     // time of the last background save attempt
+	lastStatus   error         // nil when the last background save succeeded
+	lastDuration time.Duration // duration of the last background save
+	saves        int64         // number of successful saves
+	background   sync.WaitGroup
+}
+
+// unshare clones the value stored at key when a snapshot being saved may still read it, so that
+// the value can be modified in place. Values created after the snapshot was taken are not shared.
+// The caller must hold r.mu.
+func (r *Store) unshare(key string) {
+	if r.snapshots == 0 {
+		return
+	}
+	if item, exist := r.items[key]; exist && item.generation < r.generation {
+		item = item.clone()
+		item.generation = r.generation
+		r.items[key] = item
+	}
+}
+
+// Dirty returns the number of changes made to the database since it was created.
+func (r *Store) Dirty() int64 {
+	return atomic.LoadInt64(&r.dirty)
+}
+
+// dirty returns the number of changes made to the databases since the server started.
+func (r *RedisServer) dirty() int64 {
+	var dirty int64
+	for _, db := range r.dbs {
+		dirty += db.Dirty()
+	}
+	return dirty
+}
+
+// snapshot returns a point in time copy of the items of every database, along with the number
+// of changes it includes. releaseSnapshot must be called once the snapshot is no longer used.
+func (r *RedisServer) snapshot() ([]map[string]ExpirationItem, int64) {
+	r.dbMutex.Lock()
+	defer r.dbMutex.Unlock()
+	for _, db := range r.dbs {
+		db.mu.Lock()
+	}
+	dbs := make([]map[string]ExpirationItem, len(r.dbs))
+	var dirty int64
+	for i, db := range r.dbs {
+		dbs[i] = make(map[string]ExpirationItem, len(db.items))
+		for key, item := range db.items {
+			dbs[i][key] = item
+		}
+		// The generations of the databases are kept equal, so that items moved between them stay comparable.
+		db.generation++
+		db.snapshots++
+		dirty += db.Dirty()
+		db.mu.Unlock()
+	}
+	return dbs, dirty
+}
+
+func (r *RedisServer) releaseSnapshot() {
+	for _, db := range r.dbs {
+		db.mu.Lock()
+		db.snapshots--
+		db.mu.Unlock()
+	}
+}
+
+// rdbPath returns the path of the RDB file.
+func (c *Config) rdbPath() string {
+	return filepath.Join(c.Dir, c.DBFilename)
+}
+
+// Save writes the databases to the RDB file, returning once the file is written.
+func (r *RedisServer) Save() error {
+	if err := r.startSave(); err != nil {
+		return err
+	}
+	dbs, dirty := r.snapshot()
+	err := writeRDB(r.getConfig(), dbs)
+	r.releaseSnapshot()
+	r.finishSave(dirty, err, false, 0)
+	return err
+}
+
+// BackgroundSave starts writing the databases to the RDB file and returns right away.
+func (r *RedisServer) BackgroundSave() error {
+	if err := r.startSave(); err != nil {
+		return err
+	}
+	dbs, dirty := r.snapshot()
+	config := r.getConfig()
+	r.rdb.background.Add(1)
+	go func() {
+		defer r.rdb.background.Done()
+		start := time.Now()
+		err := writeRDB(config, dbs)
+		r.releaseSnapshot()
+		if err != nil {
+			fmt.Println("Background saving error:", err)
+		}
+		r.finishSave(dirty, err, true, time.Since(start))
+	}()
+	return nil
+}
+
+// ScheduleBackgroundSave starts a background save, or schedules one when a save is in progress.
+// It reports whether the save was scheduled rather than started.
+func (r *RedisServer) ScheduleBackgroundSave() (bool, error) {
+	r.rdb.mutex.Lock()
+	if r.rdb.saving {
+		r.rdb.scheduled = true
+		r.rdb.mutex.Unlock()
+		return true, nil
+	}
+	r.rdb.mutex.Unlock()
+	return false, r.BackgroundSave()
+}
+
+func (r *RedisServer) startSave() error {
+	r.rdb.mutex.Lock()
+	defer r.rdb.mutex.Unlock()
+	if r.rdb.saving {
+		return fmt.Errorf("background save already in progress")
+	}
+	r.rdb.saving = true
+	r.rdb.scheduled = false
+	return nil
+}
+
+// finishSave records the outcome of a save of a snapshot including dirty changes.
+func (r *RedisServer) finishSave(dirty int64, err error, background bool, duration time.Duration) {
+	r.rdb.mutex.Lock()
+	defer r.rdb.mutex.Unlock()
+	r.rdb.saving = false
+	if background {
+		r.rdb.lastTry = time.Now()
+		r.rdb.lastStatus = err
+		r.rdb.lastDuration = duration
+	}
+	if err == nil {
+		r.rdb.lastSave = time.Now()
+		r.rdb.savedDirty = dirty
+		r.rdb.saves++
+	}
+}
+
+// LastSave returns the time of the last successful save.
+func (r *RedisServer) LastSave() time.Time {
+	r.rdb.mutex.Lock()
+	defer r.rdb.mutex.Unlock()
+	return r.rdb.lastSave
+}
+
+// saveCron starts a background save when one was scheduled, or when one of the save rules is met.
+// After a failed background save, the rules wait bgsaveRetryDelay before trying again.
+func (r *RedisServer) saveCron(now time.Time) {
+	r.rdb.mutex.Lock()
+	if r.rdb.saving {
+		r.rdb.mutex.Unlock()
+		return
+	}
+	start := r.rdb.scheduled
+	changes := r.dirty() - r.rdb.savedDirty
+	elapsed := now.Sub(r.rdb.lastSave)
+	canRetry := r.rdb.lastStatus == nil || now.Sub(r.rdb.lastTry) > bgsaveRetryDelay
+	for _, rule := range r.getConfig().Save {
+		if changes >= rule.Changes && elapsed > time.Duration(rule.Seconds)*time.Second && canRetry {
+			fmt.Printf("%d changes in %d seconds. Saving...\n", rule.Changes, rule.Seconds)
+			start = true
+			break
+		}
+	}
+	r.rdb.mutex.Unlock()
+	if start {
+		r.BackgroundSave()
+	}
+}
+
+// cron runs the periodic tasks of the server.
+func (r *RedisServer) cron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		r.saveCron(now)
+		r.aofCron()
+		r.replicationCron(now)
+	}
+}
+
+// writeRDB writes the snapshot to the RDB file.
+func writeRDB(config Config, dbs []map[string]ExpirationItem) error {
+	err := writeFileAtomic(config.rdbPath(), func(w io.Writer) error {
+		return encodeRDB(w, dbs, config.MaxMemoryPolicy, false)
+	})
+	if err != nil {
+		return fmt.Errorf("error saving the rdb file: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file with write to a temporary file renamed to path once complete,
+// so that path always holds a complete file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d%s", os.Getpid(), filepath.Ext(path)))
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed opening the temp file %s: %v", tmp, err)
+	}
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write error: %v", err)
+	}
+	return nil
+}
+
+// encodeRDB writes the snapshot in the RDB format. The access data of the keys is saved when
+// the eviction policy uses it, like Redis does. aofBase is set for the RDB preamble of an AOF.
+func encodeRDB(w io.Writer, dbs []map[string]ExpirationItem, policy MaxMemoryPolicy, aofBase bool) error {
+	e := rdb.NewEncoder(w)
+	e.WriteHeader()
+	e.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	var used int64
+	for _, items := range dbs {
+		for _, item := range items {
+			used += item.size
+		}
+	}
+	e.WriteAux("used-mem", strconv.FormatInt(used, 10))
+	base := "0"
+	if aofBase {
+		base = "1"
+	}
+	if err := e.WriteAux("aof-base", base); err != nil {
+		return err
+	}
+
+	for index, items := range dbs {
+		keys := make([]string, 0, len(items))
+		expires := 0
+		for key, item := range items {
+			if item.expired() {
+				continue
+			}
+			keys = append(keys, key)
+			if !item.expiration.IsZero() {
+				expires++
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if err := e.SelectDB(index, len(keys), expires); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := e.WriteEntry(rdbEntry(key, items[key], policy)); err != nil {
+				return err
+			}
+		}
+	}
+	return e.WriteFooter()
+}
+
+// rdbEntry converts an item to the entry written in the RDB file.
+func rdbEntry(key string, item ExpirationItem, policy MaxMemoryPolicy) *rdb.Entry {
+	entry := &rdb.Entry{Key: []byte(key), Idle: -1, Freq: -1}
+	if !item.expiration.IsZero() {
+		entry.Expiry = item.expiration.UnixNano() / int64(time.Millisecond)
+	}
+	switch {
+	case policy.lfu():
+		entry.Freq = int(lfuDecrAndReturn(item.lru))
+	case policy == AllKeysLRU || policy == VolatileLRU:
+		entry.Idle = int64(lruIdleTime(item.lru))
+	}
+
+	if zset, ok := item.value.(*sortedSet); ok {
+		members := make([]rdb.ZMember, len(zset.entries))
+		for i, e := range zset.entries {
+			members[i] = rdb.ZMember{Member: []byte(e.member), Score: e.score}
+		}
+		entry.Type, entry.Value = rdb.ZSet, members
+	} else if list, ok := listElements(item.value); ok {
+		elements := make([][]byte, len(list))
+		for i, element := range list {
+			elements[i] = []byte(element)
+		}
+		entry.Type, entry.Value = rdb.List, elements
+	} else {
+		str, _ := stringBytes(item.value)
+		entry.Type, entry.Value = rdb.String, str
+	}
+	return entry
+}
+
+// LoadRDB replaces the content of the databases with the keys of the RDB file at path.
+// Keys already expired are skipped, like Redis does. Sets and hashes are skipped too, since they
+// are not supported yet: the number of skipped keys is returned.
+func (r *RedisServer) LoadRDB(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r.FlushAll()
+	skipped, err := r.loadRDB(rdb.NewDecoder(f))
+	if err != nil {
+		return skipped, err
+	}
+	r.rdb.mutex.Lock()
+	defer r.rdb.mutex.Unlock()
+	r.rdb.savedDirty = r.dirty()
+	return skipped, nil
+}
+
+// loadRDB stores the keys read by d, returning the number of keys skipped.
+func (r *RedisServer) loadRDB(d *rdb.Decoder) (int, error) {
+	skipped := 0
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("short read or oom loading db from file, offset %d: %v", d.Offset(), err)
+		}
+		db, err := r.db(entry.DB)
+		if err != nil {
+			return skipped, fmt.Errorf("the rdb file uses db %d, which is out of range", entry.DB)
+		}
+		if !db.loadEntry(entry) {
+			skipped++
+		}
+	}
+	return skipped, nil
+}
+
+// loadEntry stores the key of an RDB file, reporting false when it was skipped.
+func (r *Store) loadEntry(entry *rdb.Entry) bool {
+	item := ExpirationItem{}
+	if entry.Expiry != 0 {
+		item.expiration = time.Unix(0, entry.Expiry*int64(time.Millisecond))
+		if item.expired() {
+			return false
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch entry.Type {
+	case rdb.String:
+		item.value = newStringValue(string(entry.Value.([]byte)))
+	case rdb.List:
+		var list interface{}
+		for _, element := range entry.Value.([][]byte) {
+			list = r.limits.listPush(list, string(element))
+		}
+		if list == nil {
+			return false
+		}
+		item.value = list
+	case rdb.ZSet:
+		members := entry.Value.([]rdb.ZMember)
+		if len(members) == 0 {
+			return false
+		}
+		item.value = loadSortedSet(members, r.limits)
+	default:
+		return false
+	}
+
+	switch {
+	case r.lfu && entry.Freq >= 0:
+		item.lru = lfuTimeInMinutes()<<8 | uint32(entry.Freq)
+	case !r.lfu && entry.Idle >= 0 && uint64(entry.Idle) < uint64(lruClock()):
+		item.lru = lruClock() - uint32(entry.Idle)
+	}
+	r.storeItem(string(entry.Key), item)
+	return true
+}
+
+// loadSortedSet builds a sorted set from the members of an RDB file, sorting them all at once
+// rather than inserting them one by one. Duplicated members keep their last score.
+func loadSortedSet(members []rdb.ZMember, limits encodingLimits) *sortedSet {
+	scores := make(map[string]float64, len(members))
+	for _, m := range members {
+		scores[string(m.Member)] = m.Score
+	}
+	zset := newSortedSet()
+	longest := ""
+	for member, score := range scores {
+		zset.entries = append(zset.entries, zsetEntry{member: member, score: score})
+		zset.memberBytes += int64(len(member))
+		if len(member) > len(longest) {
+			longest = member
+		}
+	}
+	sort.Slice(zset.entries, func(i, j int) bool {
+		a, b := zset.entries[i], zset.entries[j]
+		return a.score < b.score || (a.score == b.score && a.member < b.member)
+	})
+	zset.convertIfNeeded(limits, longest)
+	return zset
+}
+
+// loadOnStart loads the data when the server starts: the AOF when it is on, since it holds the
+// latest writes, the RDB file otherwise.
+func (r *RedisServer) loadOnStart() error {
+	if r.getConfig().AppendOnly {
+		return r.loadAppendOnlyOnStart()
+	}
+	return r.loadRDBOnStart()
+}
+
+// loadRDBOnStart loads the RDB file when the server starts. A missing file is not an error.
+func (r *RedisServer) loadRDBOnStart() error {
+	config := r.getConfig()
+	path := config.rdbPath()
+	start := time.Now()
+	skipped, err := r.LoadRDB(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Printf("%d keys of unsupported types were skipped\n", skipped)
+	}
+	fmt.Printf("DB loaded from disk: %.3f seconds\n", time.Since(start).Seconds())
+	return nil
+}
+
+// ===============================================================================
+func handleSave(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("save command requires no argument")
+	}
+	if err := client.server.Save(); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+func handleBgSave(args []string, client *ClientDetail) (interface{}, error) {
+	switch {
+	case len(args) == 1:
+		if err := client.server.BackgroundSave(); err != nil {
+			return nil, err
+		}
+		return resp.SimpleString("Background saving started"), nil
+	case len(args) == 2 && strings.EqualFold(args[1], "schedule"):
+		scheduled, err := client.server.ScheduleBackgroundSave()
+		if err != nil {
+			return nil, err
+		}
+		if scheduled {
+			return resp.SimpleString("Background saving scheduled"), nil
+		}
+		return resp.SimpleString("Background saving started"), nil
+	}
+	return nil, fmt.Errorf("syntax error")
+}
+
+func handleLastSave(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("lastsave command requires no argument")
+	}
+	return client.server.LastSave().Unix(), nil
+}
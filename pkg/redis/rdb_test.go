@@ -0,0 +1,231 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+func newTestPersistenceServer(t *testing.T) *RedisServer {
+	config := DefaultConfig()
+	config.Databases = 4
+	config.Dir = t.TempDir()
+	return New(config)
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	server := newTestPersistenceServer(t)
+	db0, db3 := server.dbs[0], server.dbs[3]
+	db0.Set("int", "12345", 0)
+	db0.Set("str", "hello", time.Hour)
+	db0.Set("long", strings.Repeat("compressible ", 20), 0)
+	db0.Set("expired", "gone", time.Millisecond)
+	db0.PFAdd("hll", []string{"a", "b", "c"})
+	db3.LPush("list", "a")
+	db3.LPush("list", "b")
+	bigList := []string{}
+	for i := 0; i < 200; i++ {
+		element := strings.Repeat("x", 100) + strconv.Itoa(i)
+		db3.LPush("biglist", element)
+		bigList = append(bigList, element)
+	}
+	db3.ZAdd("zset", []zsetEntry{{"b", 2}, {"a", 1.5}}, ZAddOptions{})
+	time.Sleep(2 * time.Millisecond)
+
+	if err := server.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := server.getConfig()
+	loaded := New(config)
+	if _, err := loaded.LoadRDB(config.rdbPath()); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"int", "str", "long"} {
+		want, _ := db0.Get(key)
+		if got, err := loaded.dbs[0].Get(key); err != nil || got != want {
+			t.Errorf("Expected %s to be %q, got %q (%v)", key, want, got, err)
+		}
+	}
+	if _, exist := loaded.dbs[0].items["int"].value.(int64); !exist {
+		t.Errorf("Expected integers to be loaded with the int encoding")
+	}
+	if item := loaded.dbs[0].items["str"]; item.expiration.IsZero() || time.Until(item.expiration) > time.Hour {
+		t.Errorf("Expected the time to live to be loaded, got %v", item.expiration)
+	}
+	if _, exist := loaded.dbs[0].items["expired"]; exist {
+		t.Errorf("Expected expired keys not to be saved")
+	}
+	if count, err := loaded.dbs[0].PFCount([]string{"hll"}); err != nil || count != 3 {
+		t.Errorf("Expected the HyperLogLog to be loaded, got %d (%v)", count, err)
+	}
+	if list, _ := loaded.dbs[3].LRange("list", 0, -1); list != "[a b]" {
+		t.Errorf("Expected the list to be loaded in order, got %s", list)
+	}
+	if list, _ := loaded.dbs[3].LRange("biglist", 0, -1); list != "["+strings.Join(bigList, " ")+"]" {
+		t.Errorf("Expected the big list to be loaded in order, got %s", list)
+	}
+	if _, ok := loaded.dbs[3].items["biglist"].value.([]string); !ok {
+		t.Errorf("Expected the big list to be converted from a listpack")
+	}
+	if entries, _ := loaded.dbs[3].ZRange("zset", 0, -1); len(entries) != 2 || entries[0] != (zsetEntry{"a", 1.5}) {
+		t.Errorf("Expected the sorted set to be loaded, got %v", entries)
+	}
+	if size := loaded.dbs[1].DBSize(); size != 0 {
+		t.Errorf("Expected db 1 to stay empty, got %d keys", size)
+	}
+	if used := loaded.usedMemory(); used != server.usedMemory()-server.dbs[0].items["expired"].size {
+		t.Errorf("Expected the memory used to be accounted when loading, got %d", used)
+	}
+}
+
+func TestLoadRejectsOutOfRangeDB(t *testing.T) {
+	server := newTestPersistenceServer(t)
+	server.dbs[3].Set("key", "value", 0)
+	if err := server.Save(); err != nil {
+		t.Fatal(err)
+	}
+	config := server.getConfig()
+	config.Databases = 2
+	if _, err := New(config).LoadRDB(config.rdbPath()); err == nil {
+		t.Errorf("Expected an error for a db out of range")
+	}
+
+	// A corrupted file is detected by its checksum.
+	path := config.rdbPath()
+	data, _ := os.ReadFile(path)
+	data[len(data)-12] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	if _, err := New(DefaultConfig()).LoadRDB(path); err == nil {
+		t.Errorf("Expected an error for a corrupted file")
+	}
+}
+
+func TestBackgroundSaveIsPointInTime(t *testing.T) {
+	server := newTestPersistenceServer(t)
+	db := server.dbs[0]
+	db.Set("str", "before", 0)
+	db.ZAdd("zset", []zsetEntry{{"a", 1}}, ZAddOptions{})
+	db.LPush("list", "a")
+	db.SetBit("bits", 0, 1)
+
+	// Take the snapshot by hand so that the writes below happen before it is saved.
+	if err := server.startSave(); err != nil {
+		t.Fatal(err)
+	}
+	dbs, dirty := server.snapshot()
+	db.Set("str", "after", 0)
+	db.ZAdd("zset", []zsetEntry{{"a", 5}, {"b", 2}}, ZAddOptions{})
+	db.LPop("list")
+	db.LPush("list", "b")
+	db.SetBit("bits", 1, 1)
+	db.Set("new", "value", 0)
+	config := server.getConfig()
+	if err := writeRDB(config, dbs); err != nil {
+		t.Fatal(err)
+	}
+	server.releaseSnapshot()
+	server.finishSave(dirty, nil, true, 0)
+
+	loaded := New(config)
+	if _, err := loaded.LoadRDB(config.rdbPath()); err != nil {
+		t.Fatal(err)
+	}
+	l := loaded.dbs[0]
+	if value, _ := l.Get("str"); value != "before" {
+		t.Errorf("Expected the string of the snapshot, got %s", value)
+	}
+	if entries, _ := l.ZRange("zset", 0, -1); len(entries) != 1 || entries[0] != (zsetEntry{"a", 1}) {
+		t.Errorf("Expected the sorted set of the snapshot, got %v", entries)
+	}
+	if list, _ := l.LRange("list", 0, -1); list != "[a]" {
+		t.Errorf("Expected the list of the snapshot, got %s", list)
+	}
+	if bit, _ := l.GetBit("bits", 1); bit != 0 {
+		t.Errorf("Expected the bitmap of the snapshot")
+	}
+	if _, err := l.Get("new"); err == nil {
+		t.Errorf("Expected keys created after the snapshot not to be saved")
+	}
+	// The database itself kept the writes.
+	if entries, _ := db.ZRange("zset", 0, -1); len(entries) != 2 {
+		t.Errorf("Expected the writes to be applied to the database, got %v", entries)
+	}
+	if changes := server.dirty() - server.rdb.savedDirty; changes != 6 {
+		t.Errorf("Expected 6 changes since the save, got %d", changes)
+	}
+}
+
+func TestBgSaveAndLastSave(t *testing.T) {
+	server := newTestPersistenceServer(t)
+	client := newTestClient(server)
+	server.dbs[0].Set("key", "value", 0)
+	server.rdb.lastSave = time.Unix(1000, 0)
+
+	if result, err := handleBgSave([]string{"bgsave"}, client); err != nil || result != resp.SimpleString("Background saving started") {
+		t.Fatalf("Unexpected BGSAVE reply %v (%v)", result, err)
+	}
+	server.rdb.background.Wait()
+	if result, _ := handleLastSave([]string{"lastsave"}, client); result == int64(1000) {
+		t.Errorf("Expected LASTSAVE to be updated")
+	}
+	if _, err := os.Stat(filepath.Join(server.getConfig().Dir, "dump.rdb")); err != nil {
+		t.Errorf("Expected the rdb file to be written: %v", err)
+	}
+	if !strings.Contains(server.Info("persistence"), "rdb_last_bgsave_status:ok") {
+		t.Errorf("Expected INFO to report the save, got %s", server.Info("persistence"))
+	}
+
+	server.startSave()
+	if _, err := handleSave([]string{"save"}, client); err == nil {
+		t.Errorf("Expected SAVE to fail while saving")
+	}
+	if result, _ := handleBgSave([]string{"bgsave", "schedule"}, client); result != resp.SimpleString("Background saving scheduled") {
+		t.Errorf("Expected BGSAVE SCHEDULE to schedule a save, got %v", result)
+	}
+	server.finishSave(0, nil, false, 0)
+	server.saveCron(time.Now())
+	server.rdb.background.Wait()
+	if server.rdb.scheduled {
+		t.Errorf("Expected the scheduled save to run")
+	}
+}
+
+func TestSaveRules(t *testing.T) {
+	server := newTestPersistenceServer(t)
+	server.setConfig("save", "10 2")
+	now := time.Now()
+	server.dbs[0].Set("key", "value", 0)
+
+	server.saveCron(now.Add(time.Minute))
+	server.rdb.background.Wait()
+	if server.rdb.saves != 0 {
+		t.Errorf("Expected no save with too few changes")
+	}
+	server.dbs[0].Set("other", "value", 0)
+	server.saveCron(now.Add(5 * time.Second))
+	server.rdb.background.Wait()
+	if server.rdb.saves != 0 {
+		t.Errorf("Expected no save before the delay")
+	}
+	server.saveCron(now.Add(time.Minute))
+	server.rdb.background.Wait()
+	if server.rdb.saves != 1 {
+		t.Errorf("Expected the rule to trigger a save")
+	}
+
+	if _, err := ParseSaveRules("60"); err == nil {
+		t.Errorf("Expected an error for an odd number of parameters")
+	}
+	if rules, err := ParseSaveRules(""); err != nil || len(rules) != 0 {
+		t.Errorf("Expected an empty string to disable the saves, got %v (%v)", rules, err)
+	}
+	if err := server.setConfig("dbfilename", "dir/dump.rdb"); err == nil {
+		t.Errorf("Expected dbfilename to reject paths")
+	}
+}
@@ -2,26 +2,81 @@ package redis
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
 )
 
+// errWrongType is returned by the commands run against a key holding a value of another type.
+var errWrongType = resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
 type ExpirationItem struct {
 	value      interface{}
 	expiration time.Time
+	size       int64  // memory accounted for the item in Store.used
+	lru        uint32 // last access time or access frequency, depending on Store.lfu
+	generation uint64 // Store.generation when the value was created, see Store.unshare
+}
+
+// expired reports whether the item has a time to live that already elapsed.
+func (i ExpirationItem) expired() bool {
+	return !i.expiration.IsZero() && !i.expiration.After(time.Now())
+}
+
+// clone returns a copy of the item that does not share memory with the original,
+// so values modified in place (such as bitmaps) stay isolated between transactions.
+func (i ExpirationItem) clone() ExpirationItem {
+	switch value := i.value.(type) {
+	case []byte:
+		i.value = append([]byte(nil), value...)
+	case []string:
+		i.value = append([]string(nil), value...)
+	case *listpack:
+		i.value = value.clone()
+	case *sortedSet:
+		i.value = value.clone()
+	}
+	return i
+}
+
+// SetOptions holds the optional arguments of the SET command.
+type SetOptions struct {
+	NX       bool      // only set the key if it does not already exist
+	XX       bool      // only set the key if it already exists
+	KeepTTL  bool      // retain the time to live associated with the key
+	Get      bool      // return the old string stored at key
+	ExpireAt time.Time // absolute expiration, zero means the key never expires
 }
 
 // DB represents a simple in-memory database.
 type Store struct {
-	items map[string]ExpirationItem
-	mu    sync.Mutex // make sure only one goroutine can access a variable at a time to avoid conflicts
+	used       int64 // memory used by the items as estimated by itemSize, updated atomically
+	dirty      int64 // number of changes since the database was created, updated atomically
+	items      map[string]ExpirationItem
+	mu         sync.Mutex     // make sure only one goroutine can access a variable at a time to avoid conflicts
+	lfu        bool           // track the access frequency of the items rather than their last access time
+	limits     encodingLimits // when values are converted from their compact encoding
+	generation uint64         // incremented each time a snapshot of the items is taken
+	snapshots  int            // number of snapshots still being saved
+	slotKeys   *slotKeys      // keys of each hash slot, in cluster mode
+
+	keys         keyIndex // every key, to pick random keys
+	volatileKeys keyIndex // keys with a time to live
+
+	notify func(class int, event, key string) // notifies the keyspace events, see notify.go
 }
 
 // NewStore creates and returns a new instance of the DB.
 func NewStore() *Store {
 	return &Store{
-		items: map[string]ExpirationItem{},
+		items:      map[string]ExpirationItem{},
+		limits:     defaultEncodingLimits,
+		generation: 1,
 	}
 }
 
@@ -30,13 +85,9 @@ func (r *Store) Get(key string) (string, error) {
 	r.mu.Lock()
 	// Lock so only one goroutine at a time can access the map c.v.
 	defer r.mu.Unlock()
-	if item, exist := r.items[key]; exist {
-		if exist && item.expiration.After(time.Now()) {
-			return item.value.(string), nil
-		} else if item.expiration.IsZero() && exist {
-			return item.value.(string), nil
-		}
-		delete(r.items, key)
+	if item, exist := r.lookup(key); exist {
+		str, _ := stringBytes(item.value)
+		return string(str), nil
 	}
 	return "", fmt.Errorf("key not found")
 }
@@ -47,164 +98,325 @@ func (r *Store) Set(key, val string, expiration time.Duration) error {
 	// Lock so only one goroutine at a time can access the map c.v.
 	defer r.mu.Unlock()
 	if expiration > 0 {
-		r.items[key] = ExpirationItem{value: val, expiration: time.Now().Add(expiration)}
+		r.setItem(key, ExpirationItem{value: newStringValue(val), expiration: time.Now().Add(expiration)})
 	} else {
-		r.items[key] = ExpirationItem{value: val}
+		r.setItem(key, ExpirationItem{value: newStringValue(val)})
+	}
+	r.notifyEvent(notifyString, "set", key)
+	if expiration > 0 {
+		r.notifyEvent(notifyGeneric, "expire", key)
 	}
 	return nil
 }
 
-func (r *Store) SetEx(key, val string, expiration time.Duration) error {
+// SetWithOptions sets key to val according to the SET command options.
+// It returns the previous string stored at key (if any) and whether the new value was written.
+func (r *Store) SetWithOptions(key, val string, opts SetOptions) (old string, exist bool, written bool, err error) {
 	r.mu.Lock()
-	// Lock so only one goroutine at a time can access the map c.v.
 	defer r.mu.Unlock()
-	r.items[key] = ExpirationItem{value: val, expiration: time.Now().Add(expiration)}
-	return nil
+
+	item, exist := r.lookup(key)
+	if exist && opts.Get {
+		str, ok := stringBytes(item.value)
+		if !ok {
+			return "", false, false, errWrongType
+		}
+		old = string(str)
+	}
+	if (opts.NX && exist) || (opts.XX && !exist) {
+		return old, exist, false, nil
+	}
+
+	newItem := ExpirationItem{value: newStringValue(val), expiration: opts.ExpireAt}
+	if opts.KeepTTL && exist {
+		newItem.expiration = item.expiration
+	}
+	r.setItem(key, newItem)
+	r.notifyEvent(notifyString, "set", key)
+	if !opts.ExpireAt.IsZero() && !opts.KeepTTL {
+		r.notifyEvent(notifyGeneric, "expire", key)
+	}
+	return old, exist, true, nil
 }
 
-// Delete a key in the database
-func (r *Store) Del(key string) error {
+func (r *Store) SetEx(key, val string, expiration time.Duration) error {
 	r.mu.Lock()
+	// Lock so only one goroutine at a time can access the map c.v.
 	defer r.mu.Unlock()
-	delete(r.items, key)
+	r.setItem(key, ExpirationItem{value: newStringValue(val), expiration: time.Now().Add(expiration)})
+	r.notifyEvent(notifyString, "set", key)
+	r.notifyEvent(notifyGeneric, "expire", key)
 	return nil
 }
 
-func (r *Store) Incre(key string) error {
+// lookup returns the item stored at key, lazily deleting it when its time to live has elapsed.
+// Finding the item counts as an access for the eviction policies. The caller must hold r.mu.
+func (r *Store) lookup(key string) (ExpirationItem, bool) {
+	item, exist := r.peek(key)
+	if exist {
+		item.lru = r.accessClock(item.lru)
+		r.items[key] = item
+	}
+	return item, exist
+}
+
+// peek is like lookup but does not count as an access. The caller must hold r.mu.
+func (r *Store) peek(key string) (ExpirationItem, bool) {
+	item, exist := r.items[key]
+	if exist && item.expired() {
+		r.deleteItem(key)
+		r.notifyEvent(notifyExpired, "expired", key)
+		return ExpirationItem{}, false
+	}
+	return item, exist
+}
+
+// Del deletes the keys in the database and returns how many of them existed.
+func (r *Store) Del(keys ...string) (int, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-
-	if item, exist := r.items[key]; exist {
-		incrNumber, err := strconv.Atoi(item.value.(string))
-		if err != nil {
-			return err
+	deleted := 0
+	for _, key := range keys {
+		if _, exist := r.lookup(key); exist {
+			r.deleteItem(key)
+			r.notifyEvent(notifyGeneric, "del", key)
+			deleted++
 		}
-		incrNumber += 1
-		value := strconv.Itoa(incrNumber)
-		r.items[key] = ExpirationItem{value: value}
-	} else {
-		r.items[key] = ExpirationItem{value: "1"}
 	}
-	return nil
+	return deleted, nil
 }
 
-func (r *Store) IncreBy(key, value string) error {
+// Incre increments the integer stored at key by one and returns the new value.
+func (r *Store) Incre(key string) (int64, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.incrBy(key, 1)
+}
 
-	if item, exist := r.items[key]; exist {
-		incrNumber, err := strconv.Atoi(item.value.(string))
-		if err != nil {
-			return err
-		}
-		valueIncred, err := strconv.Atoi(value)
-		if err != nil {
-			return err
-		}
-		incrNumber += valueIncred
-		value := strconv.Itoa(incrNumber)
-		r.items[key] = ExpirationItem{value: value}
-	} else {
-		r.items[key] = ExpirationItem{value: value}
+// IncreBy increments the integer stored at key by value and returns the new value.
+func (r *Store) IncreBy(key, value string) (int64, error) {
+	increment, ok := parseInt64(value)
+	if !ok {
+		return 0, fmt.Errorf("value is not an integer or out of range")
 	}
-	return nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.incrBy(key, increment)
 }
 
-func (r *Store) Decre(key string) error {
+// Decre decrements the integer stored at key by one and returns the new value.
+func (r *Store) Decre(key string) (int64, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.incrBy(key, -1)
+}
 
-	if item, exist := r.items[key]; exist {
-		decrNumber, err := strconv.Atoi(item.value.(string))
-		if err != nil {
-			return err
-		}
-		decrNumber -= 1
-		value := strconv.Itoa(decrNumber)
-		r.items[key] = ExpirationItem{value: value}
-	} else {
-		r.items[key] = ExpirationItem{value: "-1"}
+// DecreBy decrements the integer stored at key by value and returns the new value.
+func (r *Store) DecreBy(key, value string) (int64, error) {
+	decrement, ok := parseInt64(value)
+	if !ok {
+		return 0, fmt.Errorf("value is not an integer or out of range")
 	}
-	return nil
+	// -math.MinInt64 can not be represented as an int64
+	if decrement == math.MinInt64 {
+		return 0, fmt.Errorf("decrement would overflow")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.incrBy(key, -decrement)
 }
 
-func (r *Store) DecreBy(key, value string) error {
+// IncreByFloat increments the number stored at key by the floating point value and returns the new value.
+// Like Redis, the sum is computed as a long double and formatted with 17 significant digits, the
+// trailing zeros removed, so that 0.1 plus 0.2 is 0.3.
+func (r *Store) IncreByFloat(key, value string) (string, error) {
+	if _, ok := parseFloat(value); !ok {
+		return "", fmt.Errorf("value is not a valid float")
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if item, exist := r.items[key]; exist {
-		decrNumber, err := strconv.Atoi(item.value.(string))
-		if err != nil {
-			return err
+	item, exist := r.lookup(key)
+	sum := parseLongDouble(value)
+	if exist {
+		str, isString := stringBytes(item.value)
+		if !isString {
+			return "", errWrongType
 		}
-		valueIncred, err := strconv.Atoi(value)
-		if err != nil {
-			return err
+		if _, ok := parseFloat(string(str)); !ok {
+			return "", fmt.Errorf("value is not a valid float")
 		}
-		decrNumber -= valueIncred
-		value := strconv.Itoa(decrNumber)
-		r.items[key] = ExpirationItem{value: value}
-	} else {
-		value = "-" + value
-		r.items[key] = ExpirationItem{value: value}
+		sum.Add(sum, parseLongDouble(string(str)))
 	}
-	return nil
+	// The result must parse as a float for the next increment.
+	if f, _ := sum.Float64(); math.IsInf(f, 0) {
+		return "", fmt.Errorf("increment would produce NaN or Infinity")
+	}
+	result := formatLongDouble(sum)
+	r.setItem(key, ExpirationItem{value: []byte(result), expiration: item.expiration})
+	r.notifyEvent(notifyString, "incrbyfloat", key)
+	return result, nil
+}
+
+// incrBy adds delta to the integer stored at key, keeping the time to live of the key.
+// A missing key is treated as 0. The caller must hold r.mu.
+func (r *Store) incrBy(key string, delta int64) (int64, error) {
+	item, exist := r.lookup(key)
+	var current int64
+	if exist {
+		str, isString := stringBytes(item.value)
+		if !isString {
+			return 0, errWrongType
+		}
+		n, ok := parseInt64(string(str))
+		if !ok {
+			return 0, fmt.Errorf("value is not an integer or out of range")
+		}
+		current = n
+	}
+	if (delta < 0 && current < 0 && delta < math.MinInt64-current) ||
+		(delta > 0 && current > 0 && delta > math.MaxInt64-current) {
+		return 0, fmt.Errorf("increment or decrement would overflow")
+	}
+	current += delta
+	r.setItem(key, ExpirationItem{value: current, expiration: item.expiration})
+	r.notifyEvent(notifyString, "incrby", key)
+	return current, nil
+}
+
+// parseInt64 parses s as a signed 64-bit integer the way Redis does:
+// no sign other than a leading '-', no leading zeros and no surrounding spaces.
+func parseInt64(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	if s == "0" {
+		return 0, true
+	}
+	digits := s
+	if digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || digits[0] < '1' || digits[0] > '9' {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// parseFloat parses s as a finite floating point number, rejecting spaces, NaN and infinities.
+func parseFloat(s string) (float64, bool) {
+	if len(s) == 0 || strings.ContainsAny(s, " \t\r\n_xXpP") {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// formatLongDouble formats f with 17 significant digits and without exponent, the trailing zeros of
+// its decimals removed.
+func formatLongDouble(f *big.Float) string {
+	text := f.Text('e', 16)
+	e := strings.IndexByte(text, 'e')
+	exp, _ := strconv.Atoi(text[e+1:])
+	mantissa, sign := text[:e], ""
+	if mantissa[0] == '-' {
+		mantissa, sign = mantissa[1:], "-"
+	}
+	digits := mantissa[:1] + mantissa[2:]
+	var s string
+	switch {
+	case exp < 0:
+		s = "0." + strings.Repeat("0", -exp-1) + digits
+	case exp >= len(digits)-1:
+		return sign + digits + strings.Repeat("0", exp-len(digits)+1)
+	default:
+		s = digits[:exp+1] + "." + digits[exp+1:]
+	}
+	s = strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+	if s == "0" {
+		return s
+	}
+	return sign + s
+}
+
+// parseLongDouble parses s, a float accepted by parseFloat, with the 64 bits mantissa of the long
+// double of Redis.
+func parseLongDouble(s string) *big.Float {
+	f, _, _ := big.ParseFloat(s, 10, 64, big.ToNearestEven)
+	return f
 }
 
 // --------------------------------------------------------------------------------------------
-func (r *Store) LPush(key, value string) error {
+// LPush pushes value at the head of the list stored at key and returns the length of the list.
+func (r *Store) LPush(key, value string) (int, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	// Check if the underlying type is []string
+	// Check if the underlying type is a list
 	// Update the value and assign it back to the interface field
-	if item, ok := r.items[key]; ok {
-		if strList, checkType := item.value.([]string); checkType {
-			strList = append(strList, value)
-			r.items[key] = ExpirationItem{value: strList}
+	r.unshare(key)
+	var list interface{}
+	if item, ok := r.lookup(key); ok {
+		if _, checkType := listLen(item.value); !checkType {
+			return 0, errWrongType
 		}
-	} else {
-		strList := []string{value}
-		r.items[key] = ExpirationItem{value: strList}
+		list = item.value
 	}
 	// Handle the case where the key doesn't exist
-	return nil
+	list = r.limits.listPush(list, value)
+	r.setItem(key, ExpirationItem{value: list})
+	r.notifyEvent(notifyList, "lpush", key)
+	length, _ := listLen(list)
+	return length, nil
 }
 
 func (r *Store) LRange(key string, start int, stop int) (string, error) {
+	list, err := r.lrange(key, start, stop)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(list), nil
+}
+
+// lrange returns the elements of the list stored at key from start to stop, none when key does not exist.
+func (r *Store) lrange(key string, start int, stop int) ([]string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if item, oke := r.items[key]; oke {
-		if _, checkType := item.value.([]string); checkType {
-			if start < 0 || start > len(item.value.([]string)) {
-				return "", fmt.Errorf("lists startIndex out of range")
+	if item, oke := r.lookup(key); oke {
+		if list, checkType := listElements(item.value); checkType {
+			if start < 0 || start > len(list) {
+				return nil, fmt.Errorf("lists startIndex out of range")
 			}
 
-			if stop > len(item.value.([]string)) {
-				return "", fmt.Errorf("lists stopIndex out of range")
+			if stop > len(list) {
+				return nil, fmt.Errorf("lists stopIndex out of range")
 			}
 			// if stop = -1 is the last element, stop = -2 is the penultimate element of the list, and so forth.
 			if stop < 0 {
-				stop = len(item.value.([]string)) + stop + 1
+				stop = len(list) + stop + 1
 			}
 			// If len(val) + stop + 1 < 0 => it should be return error.
-			justString := fmt.Sprint(item.value.([]string)[start:stop])
-			return justString, nil
+			return list[start:stop], nil
 		}
 	}
-	return "", fmt.Errorf("key not found")
+	return []string{}, nil
 }
 
 func (r *Store) LPop(key string) (string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if item, ok := r.items[key]; ok {
-		if _, checkType := item.value.([]string); checkType {
-			element := item.value.([]string)[len(item.value.([]string))-1]
-			if len(item.value.([]string)) > 1 {
-				r.items[key] = ExpirationItem{value: item.value.([]string)[:len(item.value.([]string))-1]}
-			} else {
-				r.items[key] = ExpirationItem{value: []string{}}
-			}
+	r.unshare(key)
+	if item, ok := r.lookup(key); ok {
+		if length, checkType := listLen(item.value); checkType && length > 0 {
+			value, element := r.limits.listPop(item.value)
+			r.setItem(key, ExpirationItem{value: value})
+			r.notifyEvent(notifyList, "lpop", key)
 			return element, nil
 		}
 	}
@@ -221,7 +433,7 @@ func (r *Store) UpdateData(new *Store) {
 
 	// Merge string data
 	for k, v := range new.items {
-		r.items[k] = v
+		r.storeItem(k, v.clone())
 	}
 }
 
@@ -234,7 +446,7 @@ func (r *Store) DeleteData(new *Store) {
 
 	for key := range r.items {
 		if _, exists := new.items[key]; !exists {
-			delete(r.items, key)
+			r.deleteItem(key)
 		}
 	}
 }
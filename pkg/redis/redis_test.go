@@ -1,8 +1,12 @@
 package redis
 
 import (
+	"math"
+	"math/big"
 	"reflect"
+	"strconv"
 	"testing"
+	"testing/quick"
 	"time"
 )
 
@@ -103,16 +107,93 @@ func TestSetEx(t *testing.T) {
 	}
 }
 
+func TestSetWithOptions(t *testing.T) {
+	s := NewStore()
+	key := "testSetWithOptions"
+
+	// Test case 1: NX only writes when the key does not exist
+	_, _, written, err := s.SetWithOptions(key, "token", SetOptions{NX: true})
+	if err != nil || !written {
+		t.Errorf("Expected NX to write a missing key, err: %v", err)
+	}
+	_, _, written, _ = s.SetWithOptions(key, "other", SetOptions{NX: true})
+	if written {
+		t.Errorf("Expected NX not to overwrite an existing key")
+	}
+
+	// Test case 2: XX only writes when the key exists
+	_, _, written, _ = s.SetWithOptions("testSetWithOptionsMissing", "1", SetOptions{XX: true})
+	if written {
+		t.Errorf("Expected XX not to write a missing key")
+	}
+
+	// Test case 3: GET returns the old value and KEEPTTL retains the expiration
+	s.SetWithOptions(key, "token", SetOptions{ExpireAt: time.Now().Add(time.Hour)})
+	old, exist, written, err := s.SetWithOptions(key, "new", SetOptions{Get: true, KeepTTL: true})
+	if err != nil || !exist || !written || old != "token" {
+		t.Errorf("Expected old value token, got %s (exist %v, written %v, err %v)", old, exist, written, err)
+	}
+	if s.items[key].expiration.IsZero() {
+		t.Errorf("Expected KEEPTTL to retain the expiration")
+	}
+
+	// Test case 4: GET against a list is a wrongtype error
+	s.LPush("testSetWithOptionsList", "a")
+	if _, _, _, err := s.SetWithOptions("testSetWithOptionsList", "1", SetOptions{Get: true}); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestParseSetOptions(t *testing.T) {
+	valid := [][]string{
+		{"nx", "px", "30000"},
+		{"PX", "30000", "NX"},
+		{"xx", "keepttl", "get"},
+		{"exat", "4102444800"},
+	}
+	for _, args := range valid {
+		if _, err := parseSetOptions(args); err != nil {
+			t.Errorf("Unexpected error for %v: %v", args, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"nx", "xx"},
+		{"ex", "10", "px", "100"},
+		{"keepttl", "ex", "10"},
+		{"ex"},
+		{"ex", "0"},
+		{"px", "abc"},
+		{"unknown"},
+	}
+	for _, args := range invalid {
+		if _, err := parseSetOptions(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
 func TestDel(t *testing.T) {
 	key := "testDel"
 	value := "1"
 	expiration := time.Duration(0) * time.Second
 	store.Set(key, value, expiration)
 
-	err := store.Del(key)
+	deleted, err := store.Del(key)
 	if err != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted key, got %d", deleted)
+	}
+
+	// Deleting several keys only counts the existing ones
+	store.Set("testDel1", value, expiration)
+	store.Set("testDel2", value, expiration)
+	deleted, _ = store.Del("testDel1", "testDel2", "testDel1", key)
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted keys, got %d", deleted)
+	}
 }
 
 func TestIncre(t *testing.T) {
@@ -120,7 +201,7 @@ func TestIncre(t *testing.T) {
 
 	// Test case 1: Valid with key is not exist
 	key := "testInc"
-	err := store.Incre(key)
+	_, err := store.Incre(key)
 	if err != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -129,7 +210,7 @@ func TestIncre(t *testing.T) {
 	key1 := "testInc1"
 	value1 := "1"
 	store.Set(key1, value1, expiration)
-	err2 := store.Incre(key1)
+	_, err2 := store.Incre(key1)
 	if err2 != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -138,7 +219,7 @@ func TestIncre(t *testing.T) {
 	key2 := "testInc2"
 	value2 := "a"
 	store.Set(key2, value2, expiration)
-	err3 := store.Incre(key2)
+	_, err3 := store.Incre(key2)
 	if err3 == nil {
 		t.Error("Expected an error")
 	}
@@ -150,7 +231,7 @@ func TestIncreBy(t *testing.T) {
 
 	// Test case 1: Valid with key is not exist
 	key := "testIncBy"
-	err := store.IncreBy(key, increByValue)
+	_, err := store.IncreBy(key, increByValue)
 	if err != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -159,7 +240,7 @@ func TestIncreBy(t *testing.T) {
 	key1 := "testIncBy1"
 	value1 := "1"
 	store.Set(key1, value1, expiration)
-	err2 := store.IncreBy(key1, increByValue)
+	_, err2 := store.IncreBy(key1, increByValue)
 	if err2 != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -168,7 +249,7 @@ func TestIncreBy(t *testing.T) {
 	key2 := "testIncBy2"
 	value2 := "a"
 	store.Set(key2, value2, expiration)
-	err3 := store.IncreBy(key2, increByValue)
+	_, err3 := store.IncreBy(key2, increByValue)
 	if err3 == nil {
 		t.Error("Expected an error")
 	}
@@ -179,7 +260,7 @@ func TestDecre(t *testing.T) {
 
 	// Test case 1: Valid with key is not exist
 	key := "testDecre"
-	err := store.Decre(key)
+	_, err := store.Decre(key)
 	if err != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -188,7 +269,7 @@ func TestDecre(t *testing.T) {
 	key1 := "testDecre1"
 	value1 := "1"
 	store.Set(key1, value1, expiration)
-	err2 := store.Decre(key1)
+	_, err2 := store.Decre(key1)
 	if err2 != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -197,7 +278,7 @@ func TestDecre(t *testing.T) {
 	key2 := "testDecre2"
 	value2 := "a"
 	store.Set(key2, value2, expiration)
-	err3 := store.Decre(key2)
+	_, err3 := store.Decre(key2)
 	if err3 == nil {
 		t.Error("Expected an error")
 	}
@@ -209,7 +290,7 @@ func TestDecreBy(t *testing.T) {
 
 	// Test case 1: Valid with key is not exist
 	key := "testDecreBy"
-	err := store.DecreBy(key, DecreByValue)
+	_, err := store.DecreBy(key, DecreByValue)
 	if err != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -218,7 +299,7 @@ func TestDecreBy(t *testing.T) {
 	key1 := "testDecreBy1"
 	value1 := "1"
 	store.Set(key1, value1, expiration)
-	err2 := store.DecreBy(key1, DecreByValue)
+	_, err2 := store.DecreBy(key1, DecreByValue)
 	if err2 != nil {
 		t.Errorf("Expected an error: %v", err)
 	}
@@ -227,12 +308,128 @@ func TestDecreBy(t *testing.T) {
 	key2 := "testDecreBy2"
 	value2 := "a"
 	store.Set(key2, value2, expiration)
-	err3 := store.DecreBy(key2, DecreByValue)
+	_, err3 := store.DecreBy(key2, DecreByValue)
 	if err3 == nil {
 		t.Error("Expected an error")
 	}
 }
 
+func TestIncreByOverflowProperty(t *testing.T) {
+	s := NewStore()
+	// IncreBy either returns the exact sum or reports an overflow when the sum leaves the int64 range
+	property := func(initial, increment int64) bool {
+		s.Set("k", strconv.FormatInt(initial, 10), 0)
+		sum := new(big.Int).Add(big.NewInt(initial), big.NewInt(increment))
+		result, err := s.IncreBy("k", strconv.FormatInt(increment, 10))
+		if !sum.IsInt64() {
+			value, _ := s.Get("k")
+			return err != nil && value == strconv.FormatInt(initial, 10)
+		}
+		value, _ := s.Get("k")
+		return err == nil && result == sum.Int64() && value == sum.String()
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Edge values are rarely generated by quick.Check
+	edges := [][2]int64{{math.MaxInt64, 1}, {math.MinInt64, -1}, {math.MaxInt64, math.MinInt64}, {-1, math.MinInt64}, {0, math.MaxInt64}}
+	for _, edge := range edges {
+		if !property(edge[0], edge[1]) {
+			t.Errorf("IncreBy(%d, %d) did not respect the int64 range", edge[0], edge[1])
+		}
+	}
+}
+
+func TestDecreByInverseProperty(t *testing.T) {
+	s := NewStore()
+	// DecreBy undoes IncreBy whenever neither of them overflows
+	property := func(initial, delta int64) bool {
+		s.Set("k", strconv.FormatInt(initial, 10), 0)
+		value := strconv.FormatInt(delta, 10)
+		if _, err := s.IncreBy("k", value); err != nil {
+			return true
+		}
+		result, err := s.DecreBy("k", value)
+		return err == nil && result == initial
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := s.DecreBy("k", strconv.FormatInt(math.MinInt64, 10)); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
+func TestIncreKeepsTTLProperty(t *testing.T) {
+	s := NewStore()
+	// Every member of the INCR family preserves the expiration of the key
+	property := func(initial int32, delta int32, op uint8) bool {
+		expiration := time.Now().Add(time.Hour).Round(0)
+		s.items["k"] = ExpirationItem{value: []byte(strconv.Itoa(int(initial))), expiration: expiration}
+		value := strconv.Itoa(int(delta))
+		var err error
+		switch op % 5 {
+		case 0:
+			_, err = s.Incre("k")
+		case 1:
+			_, err = s.Decre("k")
+		case 2:
+			_, err = s.IncreBy("k", value)
+		case 3:
+			_, err = s.DecreBy("k", value)
+		case 4:
+			_, err = s.IncreByFloat("k", value)
+		}
+		return err == nil && s.items["k"].expiration.Equal(expiration)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseInt64(t *testing.T) {
+	valid := map[string]int64{"0": 0, "-1": -1, "9223372036854775807": math.MaxInt64, "-9223372036854775808": math.MinInt64}
+	for input, expected := range valid {
+		if n, ok := parseInt64(input); !ok || n != expected {
+			t.Errorf("Expected %s to parse as %d", input, expected)
+		}
+	}
+	for _, input := range []string{"", "-", "+1", "01", "-0", " 1", "1 ", "9223372036854775808", "1.5", "a"} {
+		if _, ok := parseInt64(input); ok {
+			t.Errorf("Expected %q not to parse", input)
+		}
+	}
+}
+
+func TestIncreByFloat(t *testing.T) {
+	s := NewStore()
+	s.Set("k", "10.50", 0)
+	result, err := s.IncreByFloat("k", "0.1")
+	if err != nil || result != "10.6" {
+		t.Errorf("Expected 10.6, got %s (err %v)", result, err)
+	}
+	result, err = s.IncreByFloat("k", "5.0e3")
+	if err != nil || result != "5010.6" {
+		t.Errorf("Expected 5010.6, got %s (err %v)", result, err)
+	}
+	s.Set("k", "0.1", 0)
+	if result, err := s.IncreByFloat("k", "0.2"); err != nil || result != "0.3" {
+		t.Errorf("Expected 0.3, got %s (err %v)", result, err)
+	}
+	if result, err := s.IncreByFloat("k", "-0.3"); err != nil || result != "0" {
+		t.Errorf("Expected 0, got %s (err %v)", result, err)
+	}
+	if _, err := s.IncreByFloat("k", "inf"); err == nil {
+		t.Error("Expected an error")
+	}
+	s.Set("k", "1.7976931348623157e308", 0)
+	if _, err := s.IncreByFloat("k", "1.7976931348623157e308"); err == nil {
+		t.Error("Expected an error")
+	}
+}
+
 func TestUpdateData(t *testing.T) {
 	// Create two stores with some initial data
 	store1 := NewStore()
@@ -0,0 +1,367 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// A replica connects to its primary and runs the handshake of Redis: PING, REPLCONF listening-port
+// and capa, then PSYNC with the replication ID and the offset of the stream the server followed. On
+// +CONTINUE, the primary continues that stream from its backlog; on +FULLRESYNC, the databases are
+// replaced by the RDB file sent by the primary first. The commands of the stream are applied as they
+// are received, streamed to the replicas of the replica, and acknowledged with REPLCONF ACK. The link
+// is established again when it breaks.
+
+// States of the link to the primary, as reported by ROLE.
+const (
+	linkConnect    = "connect"    // waiting to connect again
+	linkConnecting = "connecting" // connecting and running the handshake
+	linkSync       = "sync"       // receiving the RDB file of a full sync
+	linkConnected  = "connected"  // applying the stream
+)
+
+// masterLink is the link of a replica to its primary. Its fields are guarded by r.repl.mutex.
+type masterLink struct {
+	host    string
+	port    int
+	state   string
+	conn    net.Conn
+	lastIO  time.Time // when data was last received from the primary
+	stopped bool      // the server no longer replicates the primary
+
+	writeMutex sync.Mutex // serializes the acknowledgments sent to the primary
+}
+
+// ReplicaOf makes the server a replica of the primary at host and port, replacing the primary it
+// replicated. It reports false when the server already replicates this primary.
+func (r *RedisServer) ReplicaOf(host string, port int) bool {
+	r.repl.mutex.Lock()
+	if link := r.repl.master; link != nil {
+		if link.host == host && link.port == port {
+			r.repl.mutex.Unlock()
+			return false
+		}
+		r.stopMasterLink()
+	}
+	link := &masterLink{host: host, port: port, state: linkConnect}
+	r.repl.master = link
+	r.dropReplicas()
+	r.repl.mutex.Unlock()
+
+	r.configMutex.Lock()
+	r.config.ReplicaOf = fmt.Sprintf("%s %d", host, port)
+	r.configMutex.Unlock()
+	fmt.Printf("Connecting to the primary %s:%d\n", host, port)
+	go r.replicate(link)
+	return true
+}
+
+// ReplicaOfNoOne turns a replica into a primary, which keeps its data and starts a new stream. The
+// stream of its former primary remains its secondary replication ID, so that the other replicas of
+// that primary continue the stream with a partial resync.
+func (r *RedisServer) ReplicaOfNoOne() {
+	r.repl.mutex.Lock()
+	if r.repl.master == nil {
+		r.repl.mutex.Unlock()
+		return
+	}
+	r.stopMasterLink()
+	r.repl.master = nil
+	r.repl.id2, r.repl.id2Offset = r.repl.id, r.repl.offset
+	r.repl.id = newReplicationID()
+	r.repl.db = -1
+	r.dropReplicas()
+	r.repl.mutex.Unlock()
+
+	r.configMutex.Lock()
+	r.config.ReplicaOf = ""
+	r.configMutex.Unlock()
+	fmt.Println("The server is now a primary")
+}
+
+// stopMasterLink stops the replication of the primary. The caller must hold r.repl.mutex.
+func (r *RedisServer) stopMasterLink() {
+	link := r.repl.master
+	link.stopped = true
+	if link.conn != nil {
+		link.conn.Close()
+	}
+}
+
+// replicate keeps the link to the primary established until it is stopped.
+func (r *RedisServer) replicate(link *masterLink) {
+	for {
+		err := r.syncWithMaster(link)
+		r.repl.mutex.Lock()
+		stopped := link.stopped
+		link.state = linkConnect
+		link.conn = nil
+		r.repl.mutex.Unlock()
+		if stopped {
+			return
+		}
+		fmt.Printf("Lost the link with the primary %s:%d: %v\n", link.host, link.port, err)
+		time.Sleep(time.Second)
+	}
+}
+
+// setLinkState changes the state of the link, reporting false when it was stopped meanwhile.
+func (r *RedisServer) setLinkState(link *masterLink, state string, conn net.Conn) bool {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if link.stopped {
+		return false
+	}
+	link.state = state
+	link.conn = conn
+	link.lastIO = time.Now()
+	return true
+}
+
+// timeoutConn is a connection whose reads fail once nothing was received for the timeout.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c timeoutConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+// syncWithMaster connects to the primary, synchronizes the databases, then applies the stream until
+// the link breaks.
+func (r *RedisServer) syncWithMaster(link *masterLink) error {
+	config := r.getConfig()
+	timeout := time.Duration(config.ReplTimeout) * time.Second
+	if !r.setLinkState(link, linkConnecting, nil) {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(link.host, strconv.Itoa(link.port)), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !r.setLinkState(link, linkConnecting, conn) {
+		return nil
+	}
+
+	br := bufio.NewReader(timeoutConn{Conn: conn, timeout: timeout})
+	exchange := func(args ...string) (string, error) {
+		if _, err := conn.Write(resp.AppendCommand(nil, args...)); err != nil {
+			return "", err
+		}
+		return readMasterLine(br)
+	}
+	reply, err := exchange("PING")
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(reply, "+") {
+		return fmt.Errorf("error reply to PING from the primary: %s", reply)
+	}
+	// The primary may not support the options, like Redis the errors are ignored.
+	if _, port, err := net.SplitHostPort(config.Addr); err == nil {
+		if _, err := exchange("REPLCONF", "listening-port", port); err != nil {
+			return err
+		}
+	}
+	if _, err := exchange("REPLCONF", "capa", "psync2"); err != nil {
+		return err
+	}
+
+	// The server continues the stream it followed, or the one it streamed as a primary, from the
+	// next byte. Without a backlog, it has no stream to continue.
+	r.repl.mutex.Lock()
+	id, offset := r.repl.id, r.repl.offset
+	psyncID, psyncOffset := "?", "-1"
+	if r.repl.backlog != nil {
+		psyncID, psyncOffset = id, strconv.FormatInt(offset+1, 10)
+	}
+	r.repl.mutex.Unlock()
+	reply, err = exchange("PSYNC", psyncID, psyncOffset)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) > 0 && fields[0] == "+CONTINUE":
+		if len(fields) > 1 {
+			r.continueStream(fields[1])
+		}
+		if !r.setLinkState(link, linkConnected, conn) {
+			return nil
+		}
+		fmt.Println("Successful partial resynchronization with the primary")
+		return r.streamFromMaster(link, conn, br)
+	case len(fields) != 3 || fields[0] != "+FULLRESYNC":
+		return fmt.Errorf("unexpected reply to PSYNC from the primary: %s", reply)
+	}
+	id = fields[1]
+	offset, err = strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || len(id) != 40 {
+		return fmt.Errorf("wrong +FULLRESYNC syntax from the primary: %s", reply)
+	}
+	fmt.Printf("Full resync from the primary %s:%d with replication ID %s at offset %d\n", link.host, link.port, id, offset)
+	if !r.setLinkState(link, linkSync, conn) {
+		return nil
+	}
+	if err := r.loadMasterRDB(br); err != nil {
+		return err
+	}
+
+	r.repl.mutex.Lock()
+	r.repl.id = id
+	r.repl.offset = offset
+	r.repl.id2, r.repl.id2Offset = "", 0
+	r.repl.backlog = newReplBacklog(config.ReplBacklogSize)
+	// The replicas of the replica synchronize again with the new data.
+	r.dropReplicas()
+	r.repl.mutex.Unlock()
+	if !r.setLinkState(link, linkConnected, conn) {
+		return nil
+	}
+	fmt.Println("Primary <-> replica sync: finished with success")
+	return r.streamFromMaster(link, conn, br)
+}
+
+// continueStream follows the stream of the primary after a partial resync. When the replication ID
+// of the primary changed, because it was promoted, the previous one becomes the secondary ID and the
+// replicas of the replica continue the stream again to learn the new one.
+func (r *RedisServer) continueStream(id string) {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if r.repl.backlog == nil {
+		r.repl.backlog = newReplBacklog(r.getConfig().ReplBacklogSize)
+	}
+	if id == r.repl.id {
+		return
+	}
+	fmt.Printf("The primary replication ID changed to %s\n", id)
+	r.repl.id2, r.repl.id2Offset = r.repl.id, r.repl.offset
+	r.repl.id = id
+	r.dropReplicas()
+}
+
+// readMasterLine reads a reply line of the primary, which sends empty lines to keep the link alive
+// while it prepares the RDB file.
+func readMasterLine(br *bufio.Reader) (string, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			return line, nil
+		}
+	}
+}
+
+// loadMasterRDB replaces the databases with the RDB file sent by the primary.
+func (r *RedisServer) loadMasterRDB(br *bufio.Reader) error {
+	line, err := readMasterLine(br)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "$") || strings.HasPrefix(line, "$EOF:") {
+		return fmt.Errorf("bad protocol from the primary, the first byte is not '$': %s", line)
+	}
+	size, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid rdb size from the primary: %s", line)
+	}
+	fmt.Printf("Primary <-> replica sync: receiving %d bytes from the primary\n", size)
+
+	// The AOF is written again from the new data once loaded.
+	appendOnly := r.getConfig().AppendOnly
+	if appendOnly {
+		r.stopAppendOnly()
+	}
+	payload := io.LimitReader(br, size)
+	// Until the file is loaded, the data is not the one of any stream to continue.
+	r.repl.mutex.Lock()
+	r.repl.id, r.repl.offset = newReplicationID(), 0
+	r.repl.id2, r.repl.id2Offset = "", 0
+	r.repl.backlog = nil
+	r.repl.mutex.Unlock()
+	r.writeMutex.Lock()
+	r.FlushAll()
+	skipped, err := r.loadRDB(rdb.NewDecoder(payload))
+	r.writeMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed loading the rdb file received from the primary: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return err
+	}
+	if skipped > 0 {
+		fmt.Printf("%d keys of unsupported types were skipped\n", skipped)
+	}
+	if appendOnly {
+		if err := r.rewriteAppendOnly(true); err != nil {
+			fmt.Println("Failed turning the AOF on again after the sync:", err)
+		}
+	}
+	return nil
+}
+
+// streamFromMaster applies the commands of the stream of the primary.
+func (r *RedisServer) streamFromMaster(link *masterLink, conn net.Conn, br *bufio.Reader) error {
+	client := &ClientDetail{
+		conn:   &RedisClient{ID: "master", conn: discardConn{Conn: conn}},
+		server: r,
+		redis:  []*Store{r.dbs[0]},
+		master: true,
+	}
+	reader := resp.NewReader(br)
+	for {
+		args, err := reader.ReadCommand()
+		if err != nil {
+			return err
+		}
+		command := resp.AppendCommand(nil, args...)
+		r.repl.syncMutex.Lock()
+		if strings.EqualFold(args[0], "replconf") && len(args) > 1 && strings.EqualFold(args[1], "getack") {
+			// The acknowledged offset does not include the GETACK itself, like Redis does.
+			r.sendAck(link)
+		} else {
+			r.repl.mutex.Lock()
+			r.repl.applying = int64(len(command))
+			r.repl.mutex.Unlock()
+			client.execute(args)
+		}
+		r.repl.mutex.Lock()
+		link.lastIO = time.Now()
+		r.repl.applying = 0
+		r.appendReplicationStream(command)
+		r.repl.mutex.Unlock()
+		r.repl.syncMutex.Unlock()
+	}
+}
+
+// sendAck acknowledges the offset of the stream processed by the replica and, when the AOF is on,
+// the offset flushed to the AOF.
+func (r *RedisServer) sendAck(link *masterLink) {
+	r.repl.mutex.Lock()
+	conn, offset := link.conn, r.repl.offset
+	r.repl.mutex.Unlock()
+	if conn == nil {
+		return
+	}
+	ack := []string{"REPLCONF", "ACK", strconv.FormatInt(offset, 10)}
+	if fsynced, on := r.appendOnlyFsyncedOffset(); on {
+		ack = append(ack, "FACK", strconv.FormatInt(fsynced, 10))
+	}
+	link.writeMutex.Lock()
+	defer link.writeMutex.Unlock()
+	conn.Write(resp.AppendCommand(nil, ack...))
+}
@@ -0,0 +1,539 @@
+package redis
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// Replicas connect to the server and send PSYNC, like to redis-server: the server replies
+// +FULLRESYNC with its replication ID and offset, sends an RDB file of a snapshot of the databases,
+// then streams the write commands that ran since the snapshot, in the form logged to the AOF. The
+// offset counts the bytes of the stream, the replicas acknowledge the offset they processed with
+// REPLCONF ACK once per second. A server replicating a primary, see replica.go, streams the commands
+// of its primary to its own replicas as they are received.
+//
+// The end of the stream is kept in the backlog, created with the first replica. A replica sending
+// PSYNC with the replication ID it followed and the offset it reached, plus one, continues the stream
+// with +CONTINUE when the backlog still holds that offset. A replica promoted to primary starts a new
+// replication ID and keeps the previous one as its secondary ID, up to the offset of the promotion, so
+// the other replicas of its former primary continue the stream from it.
+
+// replicaOutputBufferLimit is the size of the stream waiting to be sent to a replica over which the
+// replica is disconnected, like the client-output-buffer-limit for replicas of Redis.
+const replicaOutputBufferLimit = 256 * 1024 * 1024
+
+type replicaState string
+
+const (
+	replicaWaitBgsave replicaState = "wait_bgsave" // the RDB file of the full sync is being written
+	replicaSendBulk   replicaState = "send_bulk"   // the RDB file is being sent
+	replicaOnline     replicaState = "online"      // the stream is sent as it is written
+)
+
+// replica is a replica connected to the server.
+type replica struct {
+	conn       net.Conn
+	addr       string // IP of the replica
+	port       int    // port the replica listens on, announced with REPLCONF listening-port
+	state      replicaState
+	buf        []byte        // stream not sent yet
+	notify     chan struct{} // signals the stream to send to the goroutine writing to conn
+	closed     bool
+	ackOffset  int64     // offset acknowledged by the replica
+	fackOffset int64     // offset the replica acknowledged as flushed to its AOF, with REPLCONF ACK ... FACK
+	ackTime    time.Time // time of the last acknowledgment
+}
+
+// replicationState is the replication stream of the server and its replicas.
+type replicationState struct {
+	mutex     sync.Mutex
+	id        string // replication ID of the stream
+	offset    int64  // bytes of the stream since it started
+	db        int    // database selected by the last SELECT of the stream, -1 before the first one
+	replicas  []*replica
+	id2       string // previous replication ID, that the stream continues up to id2Offset
+	id2Offset int64
+	backlog   *replBacklog // end of the stream, nil until a replica connects
+	applying  int64        // bytes of the command of the primary being applied by a replica
+	lastPing  time.Time
+	master    *masterLink // link to the primary replicated by the server, nil for a primary
+
+	syncFull, syncPartialOK, syncPartialErr int64 // full syncs, accepted and refused partial resyncs
+
+	// syncMutex is held while a command received from the primary is applied and streamed to the
+	// replicas, so that a full sync takes its snapshot between two commands of the primary.
+	syncMutex sync.Mutex
+}
+
+// newReplicationID returns a random replication ID of 40 hexadecimal characters.
+func newReplicationID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// propagate logs commands that ran on the database at index db to the AOF and streams them to the
+// replicas, returning the offset of the stream once they are propagated. The caller must hold
+// r.writeMutex so the commands are propagated in the order they ran.
+func (r *RedisServer) propagate(db int, commands ...[]string) int64 {
+	offset := r.feedReplication(db, commands...)
+	r.feedAppendOnly(db, offset, commands...)
+	return offset
+}
+
+// feedReplication streams commands that ran on the database at index db to the replicas and the
+// backlog, and returns the offset of the stream once they are streamed. A replica streams the commands
+// of its primary instead, the writes of its own clients are not replicated.
+func (r *RedisServer) feedReplication(db int, commands ...[]string) int64 {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if r.repl.master != nil {
+		return r.repl.offset + r.repl.applying
+	}
+	if r.repl.backlog == nil {
+		// Like Redis, the offset moves anyway so that WAITAOF knows when the write is on disk.
+		if r.getConfig().AppendOnly {
+			r.repl.offset++
+		}
+		return r.repl.offset
+	}
+	var b []byte
+	if db != r.repl.db {
+		b = resp.AppendCommand(b, "SELECT", strconv.Itoa(db))
+		r.repl.db = db
+	}
+	for _, command := range commands {
+		b = resp.AppendCommand(b, command...)
+	}
+	r.appendReplicationStream(b)
+	return r.repl.offset
+}
+
+// appendReplicationStream adds b to the stream sent to the replicas. The caller must hold r.repl.mutex.
+func (r *RedisServer) appendReplicationStream(b []byte) {
+	r.repl.offset += int64(len(b))
+	if r.repl.backlog != nil {
+		r.repl.backlog.write(b)
+	}
+	for _, rep := range append([]*replica(nil), r.repl.replicas...) {
+		rep.buf = append(rep.buf, b...)
+		if len(rep.buf) > replicaOutputBufferLimit {
+			fmt.Printf("Replica %s:%d is disconnected for overcoming the output buffer limit\n", rep.addr, rep.port)
+			r.dropReplica(rep)
+			continue
+		}
+		if rep.state == replicaOnline {
+			notifyReplica(rep)
+		}
+	}
+}
+
+// notifyReplica signals the goroutine writing to a replica that there is stream to send. The caller
+// must hold r.repl.mutex.
+func notifyReplica(rep *replica) {
+	if rep.closed {
+		return
+	}
+	select {
+	case rep.notify <- struct{}{}:
+	default:
+	}
+}
+
+// backlogStart returns the offset of the first byte held by the backlog, counting from 1 like the
+// offsets sent with PSYNC. The caller must hold r.repl.mutex.
+func (r *RedisServer) backlogStart() int64 {
+	return r.repl.offset - r.repl.backlog.histlen + 1
+}
+
+// resetBacklog replaces the backlog by an empty one of the given size, when there is one.
+func (r *RedisServer) resetBacklog(size int64) {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if r.repl.backlog != nil {
+		r.repl.backlog = newReplBacklog(size)
+	}
+}
+
+// partialResync returns the stream a replica asking to continue the stream of replication ID id from
+// offset misses, and reports whether the backlog holds it. The caller must hold r.repl.mutex.
+func (r *RedisServer) partialResync(id string, offset int64) ([]byte, bool) {
+	if id != r.repl.id && (r.repl.id2 == "" || id != r.repl.id2 || offset > r.repl.id2Offset+1) {
+		return nil, false
+	}
+	if r.repl.backlog == nil || offset < r.backlogStart() || offset > r.repl.offset+1 {
+		return nil, false
+	}
+	return r.repl.backlog.tail(r.repl.offset - offset + 1), true
+}
+
+// syncReplica synchronizes a replica that sent PSYNC: it continues the stream from the offset of
+// the replica when the backlog holds it, otherwise it sends a snapshot of the databases. Then it
+// streams the write commands to the replica until it disconnects.
+func (r *RedisServer) syncReplica(client *ClientDetail, args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("psync command requires a replication ID and an offset")
+	}
+	offset, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("value is not an integer or out of range")
+	}
+	if client.replica != nil {
+		return fmt.Errorf("replica already synchronizing")
+	}
+	// No write runs between the snapshot and the registration of the replica, which receives the
+	// writes that follow the snapshot.
+	r.repl.syncMutex.Lock()
+	r.writeMutex.Lock()
+	r.repl.mutex.Lock()
+	if link := r.repl.master; link != nil && link.state != linkConnected {
+		r.repl.mutex.Unlock()
+		r.writeMutex.Unlock()
+		r.repl.syncMutex.Unlock()
+		return fmt.Errorf("nomasterlink can't sync while not connected with my master")
+	}
+	conn := client.rawConn()
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	rep := &replica{conn: conn, addr: host, port: client.replPort, notify: make(chan struct{}, 1)}
+	missed, partial := r.partialResync(args[1], offset)
+	var dbs []map[string]ExpirationItem
+	if partial {
+		r.repl.syncPartialOK++
+		rep.state = replicaOnline
+		rep.buf = missed
+		rep.ackTime = time.Now()
+	} else {
+		if args[1] != "?" {
+			r.repl.syncPartialErr++
+		}
+		r.repl.syncFull++
+		rep.state = replicaWaitBgsave
+		dbs, _ = r.snapshot()
+		if r.repl.backlog == nil {
+			r.repl.backlog = newReplBacklog(r.getConfig().ReplBacklogSize)
+		}
+		// The stream selects the database again for the new replica.
+		r.repl.db = -1
+	}
+	r.repl.replicas = append(r.repl.replicas, rep)
+	id, masterOffset := r.repl.id, r.repl.offset
+	r.repl.mutex.Unlock()
+	r.writeMutex.Unlock()
+	r.repl.syncMutex.Unlock()
+
+	// The replies to the commands of the replica, such as REPLCONF ACK, are not sent.
+	client.replica = rep
+	client.conn.conn = discardConn{Conn: conn}
+	if partial {
+		fmt.Printf("Partial resynchronization request from %s:%d accepted, sending %d bytes of backlog starting from offset %d\n", rep.addr, rep.port, len(missed), offset)
+		if _, err := conn.Write([]byte(fmt.Sprintf("+CONTINUE %s\r\n", id))); err != nil {
+			conn.Close()
+			return err
+		}
+	} else {
+		fmt.Printf("Replica %s:%d asks for synchronization, full resync with replication ID %s at offset %d\n", rep.addr, rep.port, id, masterOffset)
+		if err := r.sendRDB(rep, dbs, id, masterOffset); err != nil {
+			conn.Close()
+			return fmt.Errorf("failed sending the rdb file to the replica: %v", err)
+		}
+		fmt.Printf("Synchronization with replica %s:%d succeeded\n", rep.addr, rep.port)
+	}
+
+	r.repl.mutex.Lock()
+	notifyReplica(rep)
+	r.repl.mutex.Unlock()
+	go r.writeReplica(rep)
+	return nil
+}
+
+// sendRDB sends +FULLRESYNC and the RDB file of the snapshot dbs to a replica, then marks it online.
+func (r *RedisServer) sendRDB(rep *replica, dbs []map[string]ExpirationItem, id string, offset int64) error {
+	_, err := rep.conn.Write([]byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n", id, offset)))
+	var payload bytes.Buffer
+	if err == nil {
+		err = encodeRDB(&payload, dbs, r.getConfig().MaxMemoryPolicy, false)
+	}
+	r.releaseSnapshot()
+	if err != nil {
+		return err
+	}
+	r.repl.mutex.Lock()
+	rep.state = replicaSendBulk
+	r.repl.mutex.Unlock()
+	if _, err := rep.conn.Write([]byte(fmt.Sprintf("$%d\r\n", payload.Len()))); err != nil {
+		return err
+	}
+	if _, err := rep.conn.Write(payload.Bytes()); err != nil {
+		return err
+	}
+	r.repl.mutex.Lock()
+	rep.state = replicaOnline
+	rep.ackTime = time.Now()
+	r.repl.mutex.Unlock()
+	return nil
+}
+
+// writeReplica sends the stream to an online replica until it is disconnected.
+func (r *RedisServer) writeReplica(rep *replica) {
+	for range rep.notify {
+		r.repl.mutex.Lock()
+		b := rep.buf
+		rep.buf = nil
+		r.repl.mutex.Unlock()
+		if _, err := rep.conn.Write(b); err != nil {
+			rep.conn.Close()
+			return
+		}
+	}
+}
+
+// dropReplica disconnects a replica. The caller must hold r.repl.mutex.
+func (r *RedisServer) dropReplica(rep *replica) {
+	r.removeReplica(rep)
+	rep.conn.Close()
+}
+
+// removeReplica forgets a replica. The caller must hold r.repl.mutex.
+func (r *RedisServer) removeReplica(rep *replica) {
+	if !rep.closed {
+		rep.closed = true
+		close(rep.notify)
+	}
+	for i, other := range r.repl.replicas {
+		if other == rep {
+			r.repl.replicas = append(r.repl.replicas[:i], r.repl.replicas[i+1:]...)
+			break
+		}
+	}
+}
+
+// dropReplicas disconnects every replica, which synchronize again with the server once its stream
+// changed, because it replicates another primary or became a primary. The caller must hold r.repl.mutex.
+func (r *RedisServer) dropReplicas() {
+	for len(r.repl.replicas) > 0 {
+		r.dropReplica(r.repl.replicas[0])
+	}
+}
+
+// closeReplica forgets the replica of a client whose connection was closed.
+func (client *ClientDetail) closeReplica() {
+	if client.replica == nil {
+		return
+	}
+	r := client.server
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	r.removeReplica(client.replica)
+	fmt.Printf("Connection with replica %s:%d lost\n", client.replica.addr, client.replica.port)
+}
+
+// isReplica reports whether the server replicates a primary.
+func (r *RedisServer) isReplica() bool {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	return r.repl.master != nil
+}
+
+// readOnly reports whether the server refuses the writes of its clients, being a read only replica.
+func (r *RedisServer) readOnly() bool {
+	return r.getConfig().ReplicaReadOnly && r.isReplica()
+}
+
+// replicationCron pings the replicas so they know the primary is alive, disconnects the ones that
+// stopped acknowledging the stream, and acknowledges the stream of the primary of a replica.
+func (r *RedisServer) replicationCron(now time.Time) {
+	config := r.getConfig()
+	r.repl.mutex.Lock()
+	link := r.repl.master
+	if link == nil && len(r.repl.replicas) > 0 && now.Sub(r.repl.lastPing) >= time.Duration(config.ReplPingReplicaPeriod)*time.Second {
+		r.repl.lastPing = now
+		r.appendReplicationStream(resp.AppendCommand(nil, "PING"))
+	}
+	for _, rep := range append([]*replica(nil), r.repl.replicas...) {
+		if rep.state == replicaOnline && now.Sub(rep.ackTime) > time.Duration(config.ReplTimeout)*time.Second {
+			fmt.Printf("Disconnecting timedout replica %s:%d\n", rep.addr, rep.port)
+			r.dropReplica(rep)
+		}
+	}
+	connected := link != nil && link.state == linkConnected
+	r.repl.mutex.Unlock()
+	if connected {
+		r.sendAck(link)
+	}
+}
+
+func (r *RedisServer) infoReplication() []string {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	info := []string{}
+	if link := r.repl.master; link != nil {
+		status, lastIO, syncing := "down", -1, 0
+		if link.state == linkConnected {
+			status = "up"
+			lastIO = int(time.Since(link.lastIO).Seconds())
+		}
+		if link.state == linkSync {
+			syncing = 1
+		}
+		info = append(info,
+			"role:slave",
+			fmt.Sprintf("master_host:%s", link.host),
+			fmt.Sprintf("master_port:%d", link.port),
+			fmt.Sprintf("master_link_status:%s", status),
+			fmt.Sprintf("master_last_io_seconds_ago:%d", lastIO),
+			fmt.Sprintf("master_sync_in_progress:%d", syncing),
+			fmt.Sprintf("slave_repl_offset:%d", r.repl.offset),
+			fmt.Sprintf("slave_read_only:%d", boolToInt(r.getConfig().ReplicaReadOnly)),
+		)
+	} else {
+		info = append(info, "role:master")
+	}
+	info = append(info, fmt.Sprintf("connected_slaves:%d", len(r.repl.replicas)))
+	for i, rep := range r.repl.replicas {
+		lag := int(time.Since(rep.ackTime).Seconds())
+		info = append(info, fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d", i, rep.addr, rep.port, rep.state, rep.ackOffset, lag))
+	}
+	id2, secondOffset := strings.Repeat("0", 40), int64(-1)
+	if r.repl.id2 != "" {
+		id2, secondOffset = r.repl.id2, r.repl.id2Offset+1
+	}
+	info = append(info,
+		fmt.Sprintf("master_replid:%s", r.repl.id),
+		fmt.Sprintf("master_replid2:%s", id2),
+		fmt.Sprintf("master_repl_offset:%d", r.repl.offset),
+		fmt.Sprintf("second_repl_offset:%d", secondOffset),
+	)
+	if backlog := r.repl.backlog; backlog != nil {
+		return append(info,
+			"repl_backlog_active:1",
+			fmt.Sprintf("repl_backlog_size:%d", len(backlog.buf)),
+			fmt.Sprintf("repl_backlog_first_byte_offset:%d", r.backlogStart()),
+			fmt.Sprintf("repl_backlog_histlen:%d", backlog.histlen),
+		)
+	}
+	return append(info, "repl_backlog_active:0")
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// parseReplicaOf parses the "host port" of a primary.
+func parseReplicaOf(s string) (string, int, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return "", 0, fmt.Errorf("replicaof must be the host and the port of the primary")
+	}
+	port, err := strconv.Atoi(fields[1])
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid master port")
+	}
+	return fields[0], port, nil
+}
+
+// ===============================================================================
+func handlePing(args []string, client *ClientDetail) (interface{}, error) {
+	switch len(args) {
+	case 1:
+		return resp.SimpleString("PONG"), nil
+	case 2:
+		return args[1], nil
+	}
+	return nil, fmt.Errorf("ping command requires at most one argument")
+}
+
+func handleReplicaOf(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("%s command requires a host and a port, or no one", strings.ToLower(args[0]))
+	}
+	if client.server.cluster != nil {
+		return nil, fmt.Errorf("%s is not allowed in cluster mode", strings.ToLower(args[0]))
+	}
+	if strings.EqualFold(args[1], "no") && strings.EqualFold(args[2], "one") {
+		client.server.ReplicaOfNoOne()
+		return resp.SimpleString("OK"), nil
+	}
+	host, port, err := parseReplicaOf(args[1] + " " + args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !client.server.ReplicaOf(host, port) {
+		return resp.SimpleString("OK Already connected to specified master"), nil
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+// handleReplconf configures the replication of a replica. REPLCONF ACK, with an optional FACK, has
+// no reply.
+func handleReplconf(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args)%2 != 1 {
+		return nil, fmt.Errorf("syntax error")
+	}
+	r := client.server
+	acknowledged := false
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(args[i+1])
+			if err != nil || port < 0 || port > 65535 {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			client.replPort = port
+		case "ack", "fack":
+			offset, err := strconv.ParseInt(args[i+1], 10, 64)
+			acknowledged = true
+			if err != nil || client.replica == nil {
+				continue
+			}
+			r.repl.mutex.Lock()
+			if strings.EqualFold(args[i], "ack") {
+				if offset > client.replica.ackOffset {
+					client.replica.ackOffset = offset
+				}
+				client.replica.ackTime = time.Now()
+			} else if offset > client.replica.fackOffset {
+				client.replica.fackOffset = offset
+			}
+			r.repl.mutex.Unlock()
+		case "ip-address", "capa", "getack", "rdb-only", "rdb-filter-only":
+			// Accepted for the replicas of Redis, the server always sends the whole stream.
+		default:
+			return nil, fmt.Errorf("unrecognized replconf option: %s", args[i])
+		}
+	}
+	if acknowledged {
+		// The acknowledgment may be the one a client blocked in WAIT or WAITAOF waits for.
+		r.signalBlocked()
+		return nil, nil
+	}
+	return resp.SimpleString("OK"), nil
+}
+
+func handleRole(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("role command requires no argument")
+	}
+	r := client.server
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if link := r.repl.master; link != nil {
+		return []interface{}{"slave", link.host, link.port, link.state, r.repl.offset}, nil
+	}
+	replicas := []interface{}{}
+	for _, rep := range r.repl.replicas {
+		replicas = append(replicas, []string{rep.addr, strconv.Itoa(rep.port), strconv.FormatInt(rep.ackOffset, 10)})
+	}
+	return []interface{}{"master", r.repl.offset, replicas}, nil
+}
@@ -0,0 +1,397 @@
+package redis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/rdb"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// startTestServer runs a server listening on a free port of the loopback interface, its
+// configuration changed by the options.
+func startTestServer(t *testing.T, options ...func(*Config)) *RedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.Databases = 4
+	config.Dir = t.TempDir()
+	config.Save = nil
+	config.Addr = listener.Addr().String()
+	for _, option := range options {
+		option(&config)
+	}
+	server := New(config)
+	if err := server.loadOnStart(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go HandleClient(conn, server)
+		}
+	}()
+	t.Cleanup(func() {
+		server.ReplicaOfNoOne()
+		listener.Close()
+	})
+	return server
+}
+
+// testConn is a connection to a test server sending commands as lines.
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialTestServer(t *testing.T, server *RedisServer) *testConn {
+	conn, err := net.Dial("tcp", server.getConfig().Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn, br: bufio.NewReader(conn)}
+}
+
+// do sends a command and returns the line of its reply.
+func (c *testConn) do(command string) string {
+	c.t.Helper()
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write([]byte(command + "\n")); err != nil {
+		c.t.Fatal(err)
+	}
+	line, err := c.br.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("%s: %v", command, err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+// waitFor waits until cond holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+}
+
+func replicationOffset(server *RedisServer) int64 {
+	server.repl.mutex.Lock()
+	defer server.repl.mutex.Unlock()
+	return server.repl.offset
+}
+
+func linkState(server *RedisServer) string {
+	server.repl.mutex.Lock()
+	defer server.repl.mutex.Unlock()
+	if server.repl.master == nil {
+		return ""
+	}
+	return server.repl.master.state
+}
+
+// replicate makes replica a replica of primary and waits until the full sync is done.
+func replicate(t *testing.T, replica, primary *RedisServer) {
+	host, port, _ := net.SplitHostPort(primary.getConfig().Addr)
+	portNumber, _ := strconv.Atoi(port)
+	if !replica.ReplicaOf(host, portNumber) {
+		t.Fatal("Expected the replica to connect to the primary")
+	}
+	waitFor(t, "the full sync", func() bool { return linkState(replica) == linkConnected })
+}
+
+func TestReplicationFullSyncAndStream(t *testing.T) {
+	primary := startTestServer(t)
+	replica := startTestServer(t)
+	client := dialTestServer(t, primary)
+	for _, command := range []string{"set before 1", "select 2", "set other db", "select 0"} {
+		client.do(command)
+	}
+
+	replicate(t, replica, primary)
+	for _, command := range []string{"set after 2", "incr before", "expire after 100", "select 1", "set in1 x", "del missing"} {
+		client.do(command)
+	}
+	waitFor(t, "the stream", func() bool { return replicationOffset(replica) == replicationOffset(primary) })
+
+	reader := dialTestServer(t, replica)
+	for _, test := range []struct{ command, reply string }{
+		{"get before", "2"},
+		{"get after", "2"},
+		{"ttl after", "100"},
+		{"select 2", "OK"},
+		{"get other", "db"},
+		{"select 1", "OK"},
+		{"get in1", "x"},
+		{"set in1 y", "READONLY You can't write against a read only replica."},
+	} {
+		if reply := reader.do(test.command); reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.command, test.reply, reply)
+		}
+	}
+
+	_, port, _ := net.SplitHostPort(replica.getConfig().Addr)
+	role := client.do("role")
+	if !strings.HasPrefix(role, "[master ") || !strings.Contains(role, "[[127.0.0.1 "+port+" ") {
+		t.Errorf("Expected the primary to list the replica, got %s", role)
+	}
+	if role := reader.do("role"); !strings.HasPrefix(role, "[slave 127.0.0.1 ") || !strings.Contains(role, " connected ") {
+		t.Errorf("Expected the replica role, got %s", role)
+	}
+	if info := primary.Info("replication"); !strings.Contains(info, "connected_slaves:1") || !strings.Contains(info, "state=online") {
+		t.Errorf("Expected the replica in INFO, got\n%s", info)
+	}
+	if info := replica.Info("replication"); !strings.Contains(info, "role:slave") || !strings.Contains(info, "master_link_status:up") {
+		t.Errorf("Expected the replica INFO, got\n%s", info)
+	}
+
+	if reply := reader.do("replicaof no one"); reply != "OK" {
+		t.Fatalf("Expected OK, got %s", reply)
+	}
+	if reply := reader.do("set in1 y"); reply != "OK" {
+		t.Errorf("Expected a primary to accept writes, got %s", reply)
+	}
+	waitFor(t, "the replica to be disconnected", func() bool { return !strings.Contains(primary.Info("replication"), "slave0:") })
+}
+
+func TestReplicationRESPRequests(t *testing.T) {
+	server := startTestServer(t)
+	conn := dialTestServer(t, server)
+	conn.conn.Write(resp.AppendCommand(nil, "SET", "key", "with spaces"))
+	if line, _ := conn.br.ReadString('\n'); line != "+OK\r\n" {
+		t.Errorf("Expected +OK, got %q", line)
+	}
+	conn.conn.Write(resp.AppendCommand(nil, "GET", "key"))
+	header, _ := conn.br.ReadString('\n')
+	if value, _ := conn.br.ReadString('\n'); header != "$11\r\n" || value != "with spaces\r\n" {
+		t.Errorf("Expected the value as a bulk string, got %q", header+value)
+	}
+	conn.conn.Write(resp.AppendCommand(nil, "INFO", "replication"))
+	line, _ := conn.br.ReadString('\n')
+	if !strings.HasPrefix(line, "$") {
+		t.Fatalf("Expected a bulk string, got %q", line)
+	}
+	size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	payload := make([]byte, size+2)
+	if _, err := conn.br.Read(payload); err != nil || !strings.HasPrefix(string(payload), "# Replication\n") {
+		t.Errorf("Expected the INFO report, got %q", payload)
+	}
+}
+
+// TestReplicationRedisPrimary replicates a primary sending the bytes redis-server sends: the
+// keepalive newlines before the RDB file, its aux fields, the PINGs and REPLCONF GETACK of the stream.
+func TestReplicationRedisPrimary(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	replica := startTestServer(t)
+	const id = "8371445ee0bd9a0b5a5a7f8e4cbbd73dbb1c4f6a"
+
+	var payload bytes.Buffer
+	e := rdb.NewEncoder(&payload)
+	e.WriteHeader()
+	e.WriteAux("redis-ver", "7.2.4")
+	e.WriteAux("repl-stream-db", "0")
+	e.WriteAux("repl-id", id)
+	e.WriteAux("repl-offset", "100")
+	e.SelectDB(0, 1, 0)
+	e.WriteEntry(&rdb.Entry{Key: []byte("loaded"), Type: rdb.String, Value: []byte("from rdb"), Idle: -1, Freq: -1})
+	e.WriteFooter()
+	stream := string(resp.AppendCommand(nil, "SELECT", "0")) +
+		string(resp.AppendCommand(nil, "SET", "streamed", "value")) +
+		string(resp.AppendCommand(nil, "PING"))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- func() error {
+			conn, err := listener.Accept()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			commands := resp.NewReader(bufio.NewReader(conn))
+			for _, expected := range []string{"PING", "REPLCONF listening-port", "REPLCONF capa psync2", "PSYNC ? -1"} {
+				command, err := commands.ReadCommand()
+				if err != nil {
+					return err
+				}
+				if !strings.HasPrefix(strings.Join(command, " "), expected) {
+					return fmt.Errorf("expected %s, got %v", expected, command)
+				}
+				reply := "+OK\r\n"
+				switch command[0] {
+				case "PING":
+					reply = "+PONG\r\n"
+				case "PSYNC":
+					reply = fmt.Sprintf("+FULLRESYNC %s 100\r\n\n\n$%d\r\n%s%s", id, payload.Len(), payload.Bytes(), stream)
+				}
+				conn.Write([]byte(reply))
+			}
+			conn.Write(resp.AppendCommand(nil, "REPLCONF", "GETACK", "*"))
+			command, err := commands.ReadCommand()
+			if err != nil {
+				return err
+			}
+			expected := []string{"REPLCONF", "ACK", strconv.Itoa(100 + len(stream))}
+			if strings.Join(command, " ") != strings.Join(expected, " ") {
+				return fmt.Errorf("expected %v, got %v", expected, command)
+			}
+			return nil
+		}()
+	}()
+
+	replica.ReplicaOf("127.0.0.1", listener.Addr().(*net.TCPAddr).Port)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	client := dialTestServer(t, replica)
+	for key, value := range map[string]string{"loaded": "from rdb", "streamed": "value"} {
+		if reply := client.do("get " + key); reply != value {
+			t.Errorf("Expected %s for %s, got %s", value, key, reply)
+		}
+	}
+	if info := replica.Info("replication"); !strings.Contains(info, "master_replid:"+id) {
+		t.Errorf("Expected the replication ID of the primary, got\n%s", info)
+	}
+}
+
+func TestReplBacklog(t *testing.T) {
+	b := newReplBacklog(8)
+	b.write([]byte("abc"))
+	if got := string(b.tail(3)); got != "abc" {
+		t.Errorf("Expected abc, got %s", got)
+	}
+	b.write([]byte("defghij"))
+	if b.histlen != 8 {
+		t.Errorf("Expected a full backlog, got %d bytes", b.histlen)
+	}
+	if got := string(b.tail(8)); got != "cdefghij" {
+		t.Errorf("Expected cdefghij, got %s", got)
+	}
+	b.write([]byte("0123456789"))
+	if got := string(b.tail(5)); got != "56789" {
+		t.Errorf("Expected 56789, got %s", got)
+	}
+}
+
+// breakLink closes the connection of a replica to its primary, like a network failure.
+func breakLink(server *RedisServer) {
+	server.repl.mutex.Lock()
+	conn := server.repl.master.conn
+	server.repl.mutex.Unlock()
+	conn.Close()
+}
+
+// syncStats returns the full syncs, accepted and refused partial resyncs of a primary.
+func syncStats(server *RedisServer) [3]int64 {
+	server.repl.mutex.Lock()
+	defer server.repl.mutex.Unlock()
+	return [3]int64{server.repl.syncFull, server.repl.syncPartialOK, server.repl.syncPartialErr}
+}
+
+func waitForStream(t *testing.T, replica, primary *RedisServer) {
+	t.Helper()
+	waitFor(t, "the stream", func() bool {
+		return linkState(replica) == linkConnected && replicationOffset(replica) == replicationOffset(primary)
+	})
+}
+
+func TestReplicationPartialResync(t *testing.T) {
+	primary := startTestServer(t)
+	replica := startTestServer(t)
+	replicate(t, replica, primary)
+
+	// The link breaks while the primary is writing.
+	client := dialTestServer(t, primary)
+	for i := 0; i < 200; i++ {
+		if i == 100 {
+			breakLink(replica)
+		}
+		client.do("incr counter")
+	}
+	client.do("set after break")
+	waitForStream(t, replica, primary)
+
+	if stats := syncStats(primary); stats != [3]int64{1, 1, 0} {
+		t.Errorf("Expected a full sync then a partial resync, got %v", stats)
+	}
+	reader := dialTestServer(t, replica)
+	if reply := reader.do("get counter"); reply != "200" {
+		t.Errorf("Expected 200, got %s", reply)
+	}
+	if reply := reader.do("get after"); reply != "break" {
+		t.Errorf("Expected break, got %s", reply)
+	}
+}
+
+func TestReplicationBacklogOverflow(t *testing.T) {
+	primary := startTestServer(t)
+	replica := startTestServer(t)
+	client := dialTestServer(t, primary)
+	if reply := client.do("config set repl-backlog-size 100"); reply != "OK" {
+		t.Fatal(reply)
+	}
+	replicate(t, replica, primary)
+
+	breakLink(replica)
+	client.do("set key " + strings.Repeat("x", 200))
+	waitForStream(t, replica, primary)
+	if stats := syncStats(primary); stats != [3]int64{2, 0, 1} {
+		t.Errorf("Expected a second full sync, got %v", stats)
+	}
+	if reply := dialTestServer(t, replica).do("get key"); len(reply) != 200 {
+		t.Errorf("Expected the value of 200 bytes, got %s", reply)
+	}
+}
+
+func TestReplicationPromotion(t *testing.T) {
+	primary := startTestServer(t)
+	promoted := startTestServer(t)
+	replica := startTestServer(t)
+	replicate(t, promoted, primary)
+	replicate(t, replica, primary)
+	dialTestServer(t, primary).do("set before promotion")
+	waitForStream(t, promoted, primary)
+	waitForStream(t, replica, primary)
+
+	// The primary fails, one replica is promoted and the other one follows it.
+	oldID := primary.repl.id
+	promoted.ReplicaOfNoOne()
+	client := dialTestServer(t, promoted)
+	client.do("set after promotion")
+	replicate(t, replica, promoted)
+	waitForStream(t, replica, promoted)
+
+	if stats := syncStats(promoted); stats != [3]int64{0, 1, 0} {
+		t.Errorf("Expected a partial resync with the secondary ID, got %v", stats)
+	}
+	if info := promoted.Info("replication"); !strings.Contains(info, "master_replid2:"+oldID) {
+		t.Errorf("Expected the secondary ID %s, got\n%s", oldID, info)
+	}
+	replica.repl.mutex.Lock()
+	id := replica.repl.id
+	replica.repl.mutex.Unlock()
+	if id != promoted.repl.id {
+		t.Errorf("Expected the replica to follow the new replication ID %s, got %s", promoted.repl.id, id)
+	}
+	if reply := dialTestServer(t, replica).do("get after"); reply != "promotion" {
+		t.Errorf("Expected the writes of the promoted replica, got %s", reply)
+	}
+}
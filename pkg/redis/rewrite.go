@@ -0,0 +1,181 @@
+package redis
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/MinhNHHH/redis/pkg/aof"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// The AOF only grows, a rewrite compacts it: the content of the databases is written to a new base
+// file from a snapshot, while the write commands go to a new incremental file opened at the time of
+// the snapshot. Once the base file is complete, the manifest is replaced to list it with the new
+// incremental file, the files written before become history and are deleted. Until the manifest is
+// replaced, it lists the previous files along with the new incremental file, so the AOF is complete
+// whenever the server stops.
+
+// BackgroundRewriteAppendOnly starts rewriting the AOF and returns right away.
+func (r *RedisServer) BackgroundRewriteAppendOnly() error {
+	return r.rewriteAppendOnly(false)
+}
+
+// rewriteAppendOnly starts a rewrite. With enable, the AOF is turned on: the write commands are logged
+// right away, the rewrite writing the base file the AOF starts from.
+func (r *RedisServer) rewriteAppendOnly(enable bool) error {
+	config := r.getConfig()
+	if err := os.MkdirAll(config.aofDir(), 0755); err != nil {
+		return fmt.Errorf("creating the append only directory: %v", err)
+	}
+	// No write happens between the switch to the new incremental file and the snapshot.
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	if r.aof.rewriting {
+		return fmt.Errorf("background append only file rewriting already in progress")
+	}
+	if enable && r.aof.file != nil {
+		return nil
+	}
+	if r.aof.manifest == nil || enable {
+		m, err := readManifest(config)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			m = &aof.Manifest{}
+		}
+		r.aof.manifest = m
+	}
+	m := r.aof.manifest
+
+	// The base file includes the incremental files before incrSeq. Without an AOF on, they all are.
+	incrSeq := int64(math.MaxInt64)
+	if r.aof.file != nil || enable {
+		f, err := r.createIncr(config, m)
+		if err != nil {
+			return err
+		}
+		incrSeq = m.Incrs[len(m.Incrs)-1].Seq
+		if !enable {
+			if err := persistManifest(config, m); err != nil {
+				f.Close()
+				os.Remove(config.aofPath(m.Incrs[len(m.Incrs)-1].Name))
+				m.Incrs = m.Incrs[:len(m.Incrs)-1]
+				return err
+			}
+			r.flushAppendOnly(true)
+			r.aof.file.Close()
+		}
+		r.aof.file = f
+		r.aof.db = -1
+	}
+	r.aof.enabling = enable
+	r.aof.rewriting = true
+
+	base := m.NextBase(config.AppendFilename)
+	dbs, _ := r.snapshot()
+	r.aof.background.Add(1)
+	go func() {
+		defer r.aof.background.Done()
+		err := writeFileAtomic(config.aofPath(base.Name), func(w io.Writer) error {
+			return encodeRDB(w, dbs, config.MaxMemoryPolicy, true)
+		})
+		r.releaseSnapshot()
+		r.finishRewrite(config, base, incrSeq, err)
+	}()
+	return nil
+}
+
+// finishRewrite switches the AOF to the base file written by a rewrite, which includes the incremental
+// files before incrSeq.
+func (r *RedisServer) finishRewrite(config Config, base aof.File, incrSeq int64, err error) {
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	r.aof.rewriting = false
+	m := r.aof.manifest
+	if err == nil {
+		next := *m
+		next.Incrs = append([]aof.File(nil), m.Incrs...)
+		next.History = append([]aof.File(nil), m.History...)
+		next.Rewritten(base, incrSeq)
+		if err = persistManifest(config, &next); err == nil {
+			// The history stays listed until its files are deleted, a failure keeps it for the next rewrite.
+			for _, file := range next.History {
+				os.Remove(appendOnlyFilePath(config, file))
+			}
+			history := next.History
+			next.History = nil
+			if persistManifest(config, &next) != nil {
+				next.History = history
+			}
+			*m = next
+		}
+	}
+	r.aof.rewriteErr = err
+	if err != nil {
+		fmt.Println("Background AOF rewrite error:", err)
+		os.Remove(config.aofPath(base.Name))
+		if r.aof.enabling && r.aof.file != nil {
+			// The AOF has no base file to start from, it cannot be turned on.
+			fmt.Println("Turning the AOF off after the failed rewrite")
+			r.aof.file.Close()
+			r.aof.file = nil
+			r.aof.buf = r.aof.buf[:0]
+			r.configMutex.Lock()
+			r.config.AppendOnly = false
+			r.configMutex.Unlock()
+		}
+		r.aof.enabling = false
+		return
+	}
+	r.aof.enabling = false
+	r.aof.rewrites++
+	r.aof.size = appendOnlySize(config, m)
+	r.aof.baseSize = r.aof.size
+	fmt.Println("Background AOF rewrite finished successfully")
+}
+
+// stopAppendOnly turns the AOF off, flushing the logged commands to disk.
+func (r *RedisServer) stopAppendOnly() {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+	r.aof.mutex.Lock()
+	defer r.aof.mutex.Unlock()
+	if r.aof.file == nil {
+		return
+	}
+	r.flushAppendOnly(true)
+	r.aof.file.Close()
+	r.aof.file = nil
+	r.aof.buf = r.aof.buf[:0]
+	r.aof.writeErr = nil
+}
+
+// autoRewriteNeeded reports whether the AOF grew enough since the last rewrite for the
+// auto-aof-rewrite-percentage and auto-aof-rewrite-min-size settings. The caller must hold r.aof.mutex.
+func (r *RedisServer) autoRewriteNeeded(config Config) (int64, bool) {
+	if r.aof.file == nil || r.aof.rewriting || config.AutoAofRewritePercentage <= 0 || r.aof.size <= config.AutoAofRewriteMinSize {
+		return 0, false
+	}
+	base := r.aof.baseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (r.aof.size - base) * 100 / base
+	return growth, growth >= int64(config.AutoAofRewritePercentage)
+}
+
+// ===============================================================================
+func handleBgRewriteAof(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("bgrewriteaof command requires no argument")
+	}
+	if err := client.server.BackgroundRewriteAppendOnly(); err != nil {
+		return nil, err
+	}
+	return resp.SimpleString("Background append only file rewriting started"), nil
+}
@@ -4,23 +4,74 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 type RedisServer struct {
-	clients map[string]*RedisClient
-	store   *Store
-	mutex   sync.Mutex
+	evictedKeys    int64 // number of keys evicted for the maxmemory limit, updated atomically
+	notifyFlags    int32 // classes of the keyspace events published, see notify.go, updated atomically
+	clients        map[string]*RedisClient
+	dbs            []*Store
+	config         Config
+	configMutex    sync.RWMutex
+	mutex          sync.Mutex
+	dbMutex        sync.Mutex // serializes the commands locking several databases at once
+	writeMutex     sync.Mutex // serializes the write commands, so they are logged in the order they ran
+	evictionMutex  sync.Mutex // guards the eviction state below
+	evictionPool   []evictionCandidate
+	evictionNextDB int
+	rdb            rdbState
+	aof            aofState
+	repl           replicationState
+	blocked        blockedState
+	pubsub         pubsubState
+	cluster        *clusterState // nil unless the cluster mode is enabled
 }
 type RedisClient struct {
 	ID   string
 	conn net.Conn
 }
 
-func New() *RedisServer {
-	return &RedisServer{
+func New(config Config) *RedisServer {
+	if config.Databases < 1 {
+		config.Databases = 1
+	}
+	dbs := make([]*Store, config.Databases)
+	for i := range dbs {
+		dbs[i] = NewStore()
+		dbs[i].lfu = config.MaxMemoryPolicy.lfu()
+		dbs[i].limits = config.encodingLimits()
+	}
+	r := &RedisServer{
 		clients: make(map[string]*RedisClient),
-		store:   NewStore(),
+		dbs:     dbs,
+		config:  config,
+	}
+	r.notifyFlags = int32(config.notifyFlags())
+	for i, db := range dbs {
+		index := i
+		db.notify = func(class int, event, key string) { r.notifyKeyspaceEvent(class, event, key, index) }
+	}
+	r.rdb.lastSave = time.Now()
+	r.repl.id = newReplicationID()
+	r.repl.db = -1
+	r.pubsub.channels = map[string]map[*subscriber]bool{}
+	r.pubsub.patterns = map[string]map[*subscriber]bool{}
+	r.pubsub.shardChannels = map[string]map[*subscriber]bool{}
+	if config.ClusterEnabled {
+		// A node of a cluster only has the database 0, whose keys are indexed by hash slot.
+		dbs[0].slotKeys = newSlotKeys()
+		r.cluster = &clusterState{nodes: map[string]*clusterNode{}, forgotten: map[string]time.Time{}}
+	}
+	return r
+}
+
+// db returns the database at index.
+func (r *RedisServer) db(index int) (*Store, error) {
+	if index < 0 || index >= len(r.dbs) {
+		return nil, fmt.Errorf("db index is out of range")
 	}
+	return r.dbs[index], nil
 }
 
 // AddClient adds a new client connection to the Redis struct
@@ -42,17 +93,34 @@ func (r *RedisServer) RemoveClient(conn RedisClient) {
 	defer conn.conn.Close()
 }
 
-func Start() {
+func Start(config Config) {
 	// Listen for incoming connections
-	listener, err := net.Listen("tcp", "localhost:6789")
-	r := New()
+	listener, err := net.Listen("tcp", config.Addr)
+	r := New(config)
 	if err != nil {
 		fmt.Println("Error:", err)
 		return
 	}
 	defer listener.Close()
 
-	fmt.Println("Server is listening on port 6789")
+	if err := r.loadOnStart(); err != nil {
+		fmt.Println("Error loading the data from disk:", err)
+		return
+	}
+	if r.cluster != nil {
+		if err := r.startCluster(); err != nil {
+			fmt.Println("Error starting the cluster:", err)
+			return
+		}
+	}
+	if config.ReplicaOf != "" {
+		host, port, _ := parseReplicaOf(config.ReplicaOf)
+		r.ReplicaOf(host, port)
+	}
+	go r.cron()
+	go r.expireCron()
+
+	fmt.Println("Server is listening on", config.Addr)
 	for {
 		// Accept incoming connections
 		conn, err := listener.Accept()
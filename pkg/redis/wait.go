@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// WAIT and WAITAOF block the client until its last write is acknowledged: by a number of replicas,
+// which acknowledge the offset of the stream they processed and flushed to their AOF with REPLCONF
+// ACK and FACK, and for WAITAOF by the AOF of the server once it is flushed to disk.
+
+// replicasAcked returns the number of replicas that acknowledged the stream up to offset, flushed to
+// their AOF when fsynced is set.
+func (r *RedisServer) replicasAcked(offset int64, fsynced bool) int {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	acked := 0
+	for _, rep := range r.repl.replicas {
+		if rep.state != replicaOnline {
+			continue
+		}
+		if (!fsynced && rep.ackOffset >= offset) || (fsynced && rep.fackOffset >= offset) {
+			acked++
+		}
+	}
+	return acked
+}
+
+// requestAcks asks the replicas to acknowledge the stream now, instead of at their next periodic
+// acknowledgment.
+func (r *RedisServer) requestAcks() {
+	r.repl.mutex.Lock()
+	defer r.repl.mutex.Unlock()
+	if len(r.repl.replicas) > 0 {
+		r.appendReplicationStream(resp.AppendCommand(nil, "REPLCONF", "GETACK", "*"))
+	}
+}
+
+// parseWaitTimeout parses a timeout in milliseconds, zero meaning forever.
+func parseWaitTimeout(s string) (time.Duration, error) {
+	timeout, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("timeout is negative")
+	}
+	return time.Duration(timeout) * time.Millisecond, nil
+}
+
+// ===============================================================================
+func handleWait(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("wait command requires the number of replicas and a timeout")
+	}
+	numReplicas, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	timeout, err := parseWaitTimeout(args[2])
+	if err != nil {
+		return nil, err
+	}
+	r := client.server
+	if r.isReplica() {
+		return nil, fmt.Errorf("wait cannot be used with replica instances")
+	}
+	offset := client.woff
+	ready := func() bool { return r.replicasAcked(offset, false) >= numReplicas }
+	if !ready() {
+		r.requestAcks()
+		r.blockClient(client, timeout, ready)
+	}
+	return r.replicasAcked(offset, false), nil
+}
+
+func handleWaitAof(args []string, client *ClientDetail) (interface{}, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf("waitaof command requires numlocal, numreplicas and a timeout")
+	}
+	numLocal, err := strconv.Atoi(args[1])
+	if err != nil || numLocal < 0 {
+		return nil, fmt.Errorf("value is out of range, must be positive")
+	}
+	numReplicas, err := strconv.Atoi(args[2])
+	if err != nil || numReplicas < 0 {
+		return nil, fmt.Errorf("value is out of range, must be positive")
+	}
+	timeout, err := parseWaitTimeout(args[3])
+	if err != nil {
+		return nil, err
+	}
+	r := client.server
+	if r.isReplica() {
+		return nil, fmt.Errorf("waitaof cannot be used with replica instances")
+	}
+	if numLocal > 0 && !r.getConfig().AppendOnly {
+		return nil, fmt.Errorf("waitaof cannot be used when numlocal is set but appendonly is disabled")
+	}
+
+	offset := client.woff
+	local := func() int {
+		if fsynced, on := r.appendOnlyFsyncedOffset(); on && fsynced >= offset {
+			return 1
+		}
+		return 0
+	}
+	ready := func() bool { return local() >= numLocal && r.replicasAcked(offset, true) >= numReplicas }
+	if !ready() {
+		if numReplicas > 0 {
+			r.requestAcks()
+		}
+		r.blockClient(client, timeout, ready)
+	}
+	return []interface{}{local(), r.replicasAcked(offset, true)}, nil
+}
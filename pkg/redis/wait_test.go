@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	primary := startTestServer(t)
+	replica := startTestServer(t)
+	client := dialTestServer(t, primary)
+	if reply := client.do("wait 1 100"); reply != "0" {
+		t.Errorf("Expected no replica, got %s", reply)
+	}
+	replicate(t, replica, primary)
+
+	client.do("set key value")
+	start := time.Now()
+	if reply := client.do("wait 1 0"); reply != "1" {
+		t.Errorf("Expected the replica to acknowledge the write, got %s", reply)
+	}
+	// The replica acknowledges at once, without waiting for its periodic acknowledgment.
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the acknowledgment to be requested, waited %v", elapsed)
+	}
+
+	done := make(chan string)
+	go func() { done <- client.do("wait 2 200") }()
+	waitFor(t, "the blocked client", func() bool { return strings.Contains(primary.Info("clients"), "blocked_clients:1") })
+	if reply := <-done; reply != "1" {
+		t.Errorf("Expected one replica once the timeout expired, got %s", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected to wait for the timeout, waited %v", elapsed)
+	}
+	if info := primary.Info("clients"); !strings.Contains(info, "blocked_clients:0") {
+		t.Errorf("Expected no blocked client, got\n%s", info)
+	}
+
+	for _, test := range []struct{ command, reply string }{
+		{"wait 1", "wait command requires the number of replicas and a timeout"},
+		{"wait 1 -1", "timeout is negative"},
+		{"wait x 0", "value is not an integer or out of range"},
+	} {
+		if reply := client.do(test.command); reply != test.reply {
+			t.Errorf("%s: expected %q, got %q", test.command, test.reply, reply)
+		}
+	}
+	if reply := dialTestServer(t, replica).do("wait 0 0"); reply != "wait cannot be used with replica instances" {
+		t.Errorf("Expected WAIT to be refused by a replica, got %s", reply)
+	}
+}
+
+func TestWaitAof(t *testing.T) {
+	everySec := func(c *Config) {
+		c.AppendOnly = true
+		c.AppendFsync = FsyncEverySec
+	}
+	always := func(c *Config) {
+		c.AppendOnly = true
+		c.AppendFsync = FsyncAlways
+	}
+	primary := startTestServer(t, everySec)
+	replica := startTestServer(t, always)
+	noAof := startTestServer(t)
+	replicate(t, replica, primary)
+	client := dialTestServer(t, primary)
+
+	client.do("set key value")
+	if reply := client.do("waitaof 1 0 50"); reply != "[0 0]" {
+		t.Errorf("Expected the write not to be flushed before the cron, got %s", reply)
+	}
+	done := make(chan string)
+	go func() { done <- client.do("waitaof 1 1 0") }()
+	waitFor(t, "the blocked client", func() bool { return strings.Contains(primary.Info("clients"), "blocked_clients:1") })
+	primary.aofCron()
+	if reply := <-done; reply != "[1 1]" {
+		t.Errorf("Expected the write to be flushed by the server and the replica, got %s", reply)
+	}
+
+	// A write of the replica without the AOF is never acknowledged as flushed.
+	replicate(t, noAof, primary)
+	client.do("set key other")
+	if reply := client.do("waitaof 0 2 100"); reply != "[0 1]" {
+		t.Errorf("Expected one replica with the AOF, got %s", reply)
+	}
+	if reply := dialTestServer(t, noAof).do("waitaof 0 0 0"); reply != "waitaof cannot be used with replica instances" {
+		t.Errorf("Expected WAITAOF to be refused by a replica, got %s", reply)
+	}
+	noAof.ReplicaOfNoOne()
+	if reply := dialTestServer(t, noAof).do("waitaof 1 0 0"); reply != "waitaof cannot be used when numlocal is set but appendonly is disabled" {
+		t.Errorf("Expected WAITAOF to require the AOF, got %s", reply)
+	}
+}
@@ -0,0 +1,472 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sortedSet keeps its members ordered by score, then lexicographically by member.
+// Small sets only keep the entries, like a Redis listpack, and find members with a linear scan.
+// Past the encoding limits the scores map is built, giving O(1) access to the score of a member.
+type sortedSet struct {
+	scores      map[string]float64
+	entries     []zsetEntry
+	memberBytes int64 // total length of the members, see valueSize
+}
+
+type zsetEntry struct {
+	member string
+	score  float64
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{}
+}
+
+func (z *sortedSet) len() int {
+	return len(z.entries)
+}
+
+func (z *sortedSet) score(member string) (float64, bool) {
+	if z.scores == nil {
+		for _, e := range z.entries {
+			if e.member == member {
+				return e.score, true
+			}
+		}
+		return 0, false
+	}
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// listpackEncoded reports whether the set only keeps its entries.
+func (z *sortedSet) listpackEncoded() bool {
+	return z.scores == nil
+}
+
+// convertIfNeeded builds the scores map once the set holds too many members or a too long member.
+func (z *sortedSet) convertIfNeeded(limits encodingLimits, member string) {
+	if z.scores != nil || (z.len() <= limits.zsetMaxListpackEntries && len(member) <= limits.zsetMaxListpackValue) {
+		return
+	}
+	z.scores = make(map[string]float64, len(z.entries))
+	for _, e := range z.entries {
+		z.scores[e.member] = e.score
+	}
+}
+
+// search returns the index where an entry with score and member is (or would be) stored.
+func (z *sortedSet) search(score float64, member string) int {
+	return sort.Search(len(z.entries), func(i int) bool {
+		e := z.entries[i]
+		return e.score > score || (e.score == score && e.member >= member)
+	})
+}
+
+// add inserts member or updates its score. It reports whether the member is new.
+func (z *sortedSet) add(member string, score float64) bool {
+	old, exist := z.score(member)
+	if exist {
+		if old == score {
+			return false
+		}
+		z.removeEntry(old, member)
+	}
+	i := z.search(score, member)
+	z.entries = append(z.entries, zsetEntry{})
+	copy(z.entries[i+1:], z.entries[i:])
+	z.entries[i] = zsetEntry{member: member, score: score}
+	if z.scores != nil {
+		z.scores[member] = score
+	}
+	if !exist {
+		z.memberBytes += int64(len(member))
+	}
+	return !exist
+}
+
+// remove deletes member, reporting whether it was part of the set.
+func (z *sortedSet) remove(member string) bool {
+	score, exist := z.score(member)
+	if !exist {
+		return false
+	}
+	z.removeEntry(score, member)
+	if z.scores != nil {
+		delete(z.scores, member)
+	}
+	z.memberBytes -= int64(len(member))
+	return true
+}
+
+func (z *sortedSet) removeEntry(score float64, member string) {
+	i := z.search(score, member)
+	z.entries = append(z.entries[:i], z.entries[i+1:]...)
+}
+
+// rangeByScore returns the entries with min <= score < max (max included when maxInclusive is set).
+func (z *sortedSet) rangeByScore(min, max float64, maxInclusive bool) []zsetEntry {
+	start := sort.Search(len(z.entries), func(i int) bool { return z.entries[i].score >= min })
+	end := sort.Search(len(z.entries), func(i int) bool {
+		if maxInclusive {
+			return z.entries[i].score > max
+		}
+		return z.entries[i].score >= max
+	})
+	if start >= end {
+		return nil
+	}
+	return z.entries[start:end]
+}
+
+func (z *sortedSet) clone() *sortedSet {
+	c := &sortedSet{entries: append([]zsetEntry(nil), z.entries...), memberBytes: z.memberBytes}
+	if z.scores != nil {
+		c.scores = make(map[string]float64, len(z.scores))
+		for member, score := range z.scores {
+			c.scores[member] = score
+		}
+	}
+	return c
+}
+
+// ZAddOptions holds the optional arguments of the ZADD command.
+type ZAddOptions struct {
+	NX, XX bool // only add new members / only update existing members
+	GT, LT bool // only update when the new score is greater / less than the current one
+	CH     bool // count changed members instead of added ones
+	Incr   bool // increment the score of a single member like ZINCRBY
+}
+
+// zsetForRead returns the sorted set stored at key, nil if the key does not exist.
+// The caller must hold r.mu.
+func (r *Store) zsetForRead(key string) (*sortedSet, error) {
+	item, exist := r.lookup(key)
+	if !exist {
+		return nil, nil
+	}
+	zset, ok := item.value.(*sortedSet)
+	if !ok {
+		return nil, errWrongType
+	}
+	return zset, nil
+}
+
+// ZAdd adds the members with their scores to the sorted set stored at key.
+// It returns the number of added (or changed with CH) members and, with INCR, the new score.
+func (r *Store) ZAdd(key string, entries []zsetEntry, opts ZAddOptions) (int, *float64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unshare(key)
+	zset, err := r.zsetForRead(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	item := r.items[key]
+	if zset == nil {
+		if opts.XX {
+			return 0, nil, nil
+		}
+		zset = newSortedSet()
+	}
+
+	count, changed := 0, 0
+	var incremented *float64
+	for _, e := range entries {
+		score := e.score
+		current, exist := zset.score(e.member)
+		if (opts.NX && exist) || (opts.XX && !exist) {
+			continue
+		}
+		if exist {
+			if opts.Incr {
+				score += current
+				if math.IsNaN(score) {
+					return 0, nil, fmt.Errorf("resulting score is not a number (nan)")
+				}
+			}
+			if (opts.GT && score <= current) || (opts.LT && score >= current) {
+				continue
+			}
+			if score != current {
+				zset.add(e.member, score)
+				changed++
+				if opts.CH {
+					count++
+				}
+			}
+		} else {
+			zset.add(e.member, score)
+			zset.convertIfNeeded(r.limits, e.member)
+			count++
+			changed++
+		}
+		if opts.Incr {
+			incremented = &score
+		}
+	}
+
+	if zset.len() > 0 {
+		r.setItem(key, ExpirationItem{value: zset, expiration: item.expiration})
+	}
+	if changed > 0 {
+		if opts.Incr {
+			r.notifyEvent(notifyZSet, "zincr", key)
+		} else {
+			r.notifyEvent(notifyZSet, "zadd", key)
+		}
+	}
+	return count, incremented, nil
+}
+
+// ZRem removes the members from the sorted set stored at key and returns how many were removed.
+func (r *Store) ZRem(key string, members []string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.unshare(key)
+	zset, err := r.zsetForRead(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if zset.remove(member) {
+			removed++
+		}
+	}
+	if zset.len() == 0 {
+		r.deleteItem(key)
+	} else {
+		r.setItem(key, r.items[key])
+	}
+	if removed > 0 {
+		r.notifyEvent(notifyZSet, "zrem", key)
+		if zset.len() == 0 {
+			r.notifyEvent(notifyGeneric, "del", key)
+		}
+	}
+	return removed, nil
+}
+
+// ZScore returns the score of member in the sorted set stored at key.
+func (r *Store) ZScore(key, member string) (float64, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zset, err := r.zsetForRead(key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	score, exist := zset.score(member)
+	return score, exist, nil
+}
+
+// ZCard returns the number of members of the sorted set stored at key.
+func (r *Store) ZCard(key string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zset, err := r.zsetForRead(key)
+	if err != nil || zset == nil {
+		return 0, err
+	}
+	return zset.len(), nil
+}
+
+// ZRange returns the members between the start and stop indexes (inclusive, negative from the end).
+func (r *Store) ZRange(key string, start, stop int) ([]zsetEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	zset, err := r.zsetForRead(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	length := zset.len()
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return nil, nil
+	}
+	return append([]zsetEntry(nil), zset.entries[start:stop+1]...), nil
+}
+
+// parseScore parses a sorted set score, accepting inf, +inf and -inf like Redis.
+func parseScore(s string) (float64, bool) {
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return math.Inf(1), true
+	case "-inf":
+		return math.Inf(-1), true
+	}
+	return parseFloat(s)
+}
+
+// formatScore formats a score the way Redis replies with doubles.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', 17, 64)
+}
+
+// ===============================================================================
+func handleZAdd(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 4 {
+		return nil, fmt.Errorf("zadd command requires at least three arguments")
+	}
+	key := args[1]
+	var opts ZAddOptions
+	i := 2
+	for ; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "nx":
+			opts.NX = true
+			continue
+		case "xx":
+			opts.XX = true
+			continue
+		case "gt":
+			opts.GT = true
+			continue
+		case "lt":
+			opts.LT = true
+			continue
+		case "ch":
+			opts.CH = true
+			continue
+		case "incr":
+			opts.Incr = true
+			continue
+		}
+		break
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("syntax error")
+	}
+	if opts.NX && opts.XX {
+		return nil, fmt.Errorf("xx and nx options at the same time are not compatible")
+	}
+	if (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)) {
+		return nil, fmt.Errorf("gt, lt, and/or nx options at the same time are not compatible")
+	}
+	if opts.Incr && len(pairs) > 2 {
+		return nil, fmt.Errorf("incr option supports a single increment-element pair")
+	}
+
+	entries := make([]zsetEntry, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, ok := parseScore(pairs[j])
+		if !ok {
+			return nil, fmt.Errorf("value is not a valid float")
+		}
+		entries = append(entries, zsetEntry{member: pairs[j+1], score: score})
+	}
+
+	r := currentStore(redis)
+	count, incremented, err := r.ZAdd(key, entries, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Incr {
+		if incremented == nil {
+			return nil, nil
+		}
+		return formatScore(*incremented), nil
+	}
+	return count, nil
+}
+
+func handleZRem(args []string, redis []*Store) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("zrem command requires at least two arguments")
+	}
+	r := currentStore(redis)
+	removed, err := r.ZRem(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+func handleZScore(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("zscore command requires exactly two arguments")
+	}
+	r := currentStore(redis)
+	score, exist, err := r.ZScore(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return formatScore(score), nil
+}
+
+func handleZCard(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("zcard command requires exactly one argument")
+	}
+	r := currentStore(redis)
+	count, err := r.ZCard(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return count, nil
+}
+
+func handleZRange(args []string, redis []*Store) (interface{}, error) {
+	if len(args) != 4 && len(args) != 5 {
+		return nil, fmt.Errorf("zrange command requires three or four arguments")
+	}
+	withScores := false
+	if len(args) == 5 {
+		if !strings.EqualFold(args[4], "withscores") {
+			return nil, fmt.Errorf("syntax error")
+		}
+		withScores = true
+	}
+	start, err := strconv.Atoi(args[2])
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+	stop, err := strconv.Atoi(args[3])
+	if err != nil {
+		return nil, fmt.Errorf("value is not an integer or out of range")
+	}
+
+	r := currentStore(redis)
+	entries, err := r.ZRange(args[1], start, stop)
+	if err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, e := range entries {
+		result = append(result, e.member)
+		if withScores {
+			result = append(result, formatScore(e.score))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZAddOrdering(t *testing.T) {
+	s := NewStore()
+	added, _, err := s.ZAdd("zset", []zsetEntry{{"c", 1}, {"a", 1}, {"b", 0}, {"d", math.Inf(1)}}, ZAddOptions{})
+	if err != nil || added != 4 {
+		t.Errorf("Expected 4 added members, got %d (err %v)", added, err)
+	}
+	entries, _ := s.ZRange("zset", 0, -1)
+	expected := []string{"b", "a", "c", "d"}
+	for i, e := range entries {
+		if e.member != expected[i] {
+			t.Errorf("Expected member %s at index %d, got %s", expected[i], i, e.member)
+		}
+	}
+
+	// Updating a score moves the member
+	s.ZAdd("zset", []zsetEntry{{"b", 5}}, ZAddOptions{})
+	if entries, _ := s.ZRange("zset", -2, -2); entries[0].member != "b" {
+		t.Errorf("Expected b to be moved before d, got %s", entries[0].member)
+	}
+}
+
+func TestZAddOptions(t *testing.T) {
+	s := NewStore()
+	s.ZAdd("zset", []zsetEntry{{"a", 1}}, ZAddOptions{})
+
+	// Test case 1: NX does not update, XX does not add
+	s.ZAdd("zset", []zsetEntry{{"a", 10}, {"b", 2}}, ZAddOptions{NX: true})
+	s.ZAdd("zset", []zsetEntry{{"a", 3}, {"c", 3}}, ZAddOptions{XX: true})
+	if score, _, _ := s.ZScore("zset", "a"); score != 3 {
+		t.Errorf("Expected score 3, got %v", score)
+	}
+	if _, exist, _ := s.ZScore("zset", "c"); exist {
+		t.Errorf("Expected XX not to add c")
+	}
+
+	// Test case 2: GT only raises the score, CH counts the changes
+	changed, _, _ := s.ZAdd("zset", []zsetEntry{{"a", 1}, {"b", 5}}, ZAddOptions{GT: true, CH: true})
+	if changed != 1 {
+		t.Errorf("Expected 1 changed member, got %d", changed)
+	}
+
+	// Test case 3: INCR returns the new score
+	_, score, _ := s.ZAdd("zset", []zsetEntry{{"a", 2.5}}, ZAddOptions{Incr: true})
+	if score == nil || *score != 5.5 {
+		t.Errorf("Expected score 5.5, got %v", score)
+	}
+
+	// Test case 4: removing the last members deletes the key
+	removed, _ := s.ZRem("zset", []string{"a", "b", "missing"})
+	if card, _ := s.ZCard("zset"); removed != 2 || card != 0 {
+		t.Errorf("Expected 2 removed members and an empty set, got %d and %d", removed, card)
+	}
+	if _, exist := s.items["zset"]; exist {
+		t.Errorf("Expected the empty sorted set to be deleted")
+	}
+}
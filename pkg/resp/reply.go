@@ -0,0 +1,129 @@
+package resp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Error is an error reply, such as "-ERR unknown command".
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// SimpleString is a status reply, such as "+OK", written without a length unlike the other strings.
+type SimpleString string
+
+// AppendReply appends the reply v to b and returns the extended buffer: a SimpleString or an Error
+// as such, a string or a []byte as a bulk string, an integer as an integer, a slice as an array of its
+// elements, and nil as a null bulk string.
+func AppendReply(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "$-1\r\n"...)
+	case SimpleString:
+		return append(append(append(b, '+'), v...), "\r\n"...)
+	case Error:
+		return append(append(append(b, '-'), v...), "\r\n"...)
+	case error:
+		return append(append(append(b, "-ERR "...), v.Error()...), "\r\n"...)
+	case string:
+		b = append(strconv.AppendInt(append(b, '$'), int64(len(v)), 10), "\r\n"...)
+		return append(append(b, v...), "\r\n"...)
+	case []byte:
+		return AppendReply(b, string(v))
+	case int:
+		return append(strconv.AppendInt(append(b, ':'), int64(v), 10), "\r\n"...)
+	case int64:
+		return append(strconv.AppendInt(append(b, ':'), v, 10), "\r\n"...)
+	case []string:
+		b = append(strconv.AppendInt(append(b, '*'), int64(len(v)), 10), "\r\n"...)
+		for _, element := range v {
+			b = AppendReply(b, element)
+		}
+		return b
+	case []interface{}:
+		b = append(strconv.AppendInt(append(b, '*'), int64(len(v)), 10), "\r\n"...)
+		for _, element := range v {
+			b = AppendReply(b, element)
+		}
+		return b
+	}
+	return AppendReply(b, fmt.Sprint(v))
+}
+
+// ReadReply reads the next reply: a string for a status or a bulk string, an Error for an error, an
+// int64 for an integer, a []interface{} for an array, and nil for a null bulk string or array.
+func (r *Reader) ReadReply() (interface{}, error) {
+	r.read = 0
+	reply, err := r.readReply()
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && r.read == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	r.offset += r.read
+	return reply, nil
+}
+
+func (r *Reader) readReply() (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", line[1:])
+		}
+		return n, nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < -1 || length > maxBulkLength {
+			return nil, fmt.Errorf("invalid bulk length %q", line[1:])
+		}
+		if length == -1 {
+			return nil, nil
+		}
+		b := make([]byte, length+2)
+		n, err := io.ReadFull(r.r, b)
+		r.read += int64(n)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[length] != '\r' || b[length+1] != '\n' {
+			return nil, fmt.Errorf("expected CRLF after the bulk string")
+		}
+		return string(b[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < -1 {
+			return nil, fmt.Errorf("invalid array length %q", line[1:])
+		}
+		if count == -1 {
+			return nil, nil
+		}
+		elements := make([]interface{}, count)
+		for i := range elements {
+			if elements[i], err = r.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", line[0])
+}
@@ -0,0 +1,125 @@
+// Package resp implements the parts of the Redis serialization protocol used to log and exchange
+// commands: a command is an array of bulk strings, such as "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n".
+// The replies of RESP2 are read and written too, for the clients talking to Redis servers.
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkLength is the longest bulk string accepted by Reader, the proto-max-bulk-len of Redis.
+const maxBulkLength = 512 * 1024 * 1024
+
+// AppendCommand appends the command made of args to b and returns the extended buffer.
+func AppendCommand(b []byte, args ...string) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, '\r', '\n')
+	for _, arg := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(arg)), 10)
+		b = append(b, '\r', '\n')
+		b = append(b, arg...)
+		b = append(b, '\r', '\n')
+	}
+	return b
+}
+
+// Reader reads the commands of a stream.
+type Reader struct {
+	r      *bufio.Reader
+	offset int64 // bytes of the complete commands read
+	read   int64 // bytes of the command being read
+}
+
+// NewReader returns a Reader reading from r. When r is a *bufio.Reader it is used as is,
+// so the stream may be read by something else before the commands.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Offset returns the number of bytes of the complete commands read so far: when ReadCommand fails,
+// it is the offset of the command that could not be read.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// ReadCommand reads the next command. It returns io.EOF at the end of the stream, and
+// io.ErrUnexpectedEOF when the stream ends in the middle of a command.
+func (r *Reader) ReadCommand() ([]string, error) {
+	r.read = 0
+	line, err := r.readLine()
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && r.read == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("expected '*', got %q", line)
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid number of arguments %q", line[1:])
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	r.offset += r.read
+	return args, nil
+}
+
+// readBulk reads a bulk string.
+func (r *Reader) readBulk() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("expected '$', got %q", line)
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 || length > maxBulkLength {
+		return "", fmt.Errorf("invalid bulk length %q", line[1:])
+	}
+	b := make([]byte, length+2)
+	n, err := io.ReadFull(r.r, b)
+	r.read += int64(n)
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	if b[length] != '\r' || b[length+1] != '\n' {
+		return "", fmt.Errorf("expected CRLF after the bulk string")
+	}
+	return string(b[:length]), nil
+}
+
+// readLine reads a line ending with CRLF and returns it without the CRLF.
+func (r *Reader) readLine() (string, error) {
+	line, err := r.r.ReadSlice('\n')
+	r.read += int64(len(line))
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("line too long")
+	}
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("expected CRLF at the end of %q", line)
+	}
+	return string(line[:len(line)-2]), nil
+}
@@ -0,0 +1,87 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	commands := [][]string{{"SET", "key", "a value\r\nwith CRLF"}, {"DEL", ""}, {"PING"}}
+	var b []byte
+	for _, command := range commands {
+		b = AppendCommand(b, command...)
+	}
+	if !bytes.HasPrefix(b, []byte("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$18\r\n")) {
+		t.Fatalf("unexpected encoding %q", b)
+	}
+
+	r := NewReader(bytes.NewReader(b))
+	for _, want := range commands {
+		got, err := r.ReadCommand()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadCommand = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := r.ReadCommand(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	if r.Offset() != int64(len(b)) {
+		t.Errorf("Offset = %d, want %d", r.Offset(), len(b))
+	}
+
+	// Every truncation of the last command is reported as such, at the offset of the command.
+	last := len(AppendCommand(nil, "PING"))
+	for n := len(b) - last + 1; n < len(b); n++ {
+		r := NewReader(bytes.NewReader(b[:n]))
+		var err error
+		for err == nil {
+			_, err = r.ReadCommand()
+		}
+		if err != io.ErrUnexpectedEOF || r.Offset() != int64(len(b)-last) {
+			t.Errorf("truncated at %d: got %v at offset %d", n, err, r.Offset())
+		}
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	for _, input := range []string{"SET key value\r\n", "*0\r\n", "*1\r\n+OK\r\n", "*1\r\n$2\r\nabc\r\n", "*1\r\n$-5\r\n", "*1\n"} {
+		_, err := NewReader(bytes.NewReader([]byte(input))).ReadCommand()
+		if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+			t.Errorf("expected a protocol error for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	replies := []interface{}{
+		SimpleString("OK"),
+		Error("ERR unknown command"),
+		"bulk\r\nstring",
+		int64(-42),
+		nil,
+		[]interface{}{"a", int64(1), []interface{}{"nested"}, nil},
+	}
+	var b []byte
+	for _, reply := range replies {
+		b = AppendReply(b, reply)
+	}
+	if !bytes.HasPrefix(b, []byte("+OK\r\n-ERR unknown command\r\n$12\r\nbulk\r\nstring\r\n:-42\r\n$-1\r\n*4\r\n")) {
+		t.Fatalf("unexpected encoding %q", b)
+	}
+
+	r := NewReader(bytes.NewReader(b))
+	for _, want := range []interface{}{"OK", Error("ERR unknown command"), "bulk\r\nstring", int64(-42), nil, replies[5]} {
+		got, err := r.ReadReply()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadReply = %#v, %v, want %#v", got, err, want)
+		}
+	}
+	if _, err := r.ReadReply(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end, got %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader([]byte("*2\r\n:1\r\n"))).ReadReply(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated array, got %v", err)
+	}
+}
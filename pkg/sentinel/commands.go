@@ -0,0 +1,268 @@
+package sentinel
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// client is a connection to the sentinel. Like the server, the sentinel reads lines of arguments
+// separated by spaces, and RESP commands, which it replies to in RESP.
+type client struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	reader *resp.Reader
+	resp   bool
+}
+
+func (c *client) readRequest() ([]string, error) {
+	if b, err := c.br.Peek(1); err == nil && b[0] == '*' {
+		if c.reader == nil {
+			c.reader = resp.NewReader(c.br)
+		}
+		c.resp = true
+		return c.reader.ReadCommand()
+	}
+	line, err := c.br.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	return strings.Split(strings.Trim(line, " "), " "), nil
+}
+
+// reply sends the reply v of a command, or its error err.
+func (c *client) reply(v interface{}, err error) error {
+	if err != nil {
+		v = err
+	}
+	var out []byte
+	switch {
+	case c.resp:
+		out = resp.AppendReply(nil, v)
+	case v == nil:
+		out = []byte("(nil)\n")
+	default:
+		out = []byte(fmt.Sprint(v) + "\n")
+	}
+	_, werr := c.conn.Write(out)
+	return werr
+}
+
+// execute runs the command made of args and returns its reply.
+func (s *Sentinel) execute(args []string) (interface{}, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch strings.ToLower(args[0]) {
+	case "ping":
+		return resp.SimpleString("PONG"), nil
+	case "info":
+		if len(args) > 2 {
+			return nil, fmt.Errorf("syntax error")
+		}
+		section := ""
+		if len(args) == 2 {
+			section = args[1]
+		}
+		return s.info(section), nil
+	case "role":
+		names := []string{}
+		for _, m := range s.masters {
+			names = append(names, m.name)
+		}
+		return []interface{}{"sentinel", names}, nil
+	case "publish":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("wrong number of arguments for 'publish' command")
+		}
+		if args[1] != helloChannel {
+			return 0, nil
+		}
+		if err := s.processHello(args[2]); err != nil {
+			return nil, err
+		}
+		return 1, nil
+	case "sentinel":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("wrong number of arguments for 'sentinel' command")
+		}
+		return s.sentinelCommand(strings.ToLower(args[1]), args[2:])
+	}
+	return nil, fmt.Errorf("unknown command '%s'", args[0])
+}
+
+// sentinelCommand runs the subcommand of SENTINEL with its arguments.
+func (s *Sentinel) sentinelCommand(subcommand string, args []string) (interface{}, error) {
+	now := time.Now()
+	switch subcommand {
+	case "myid":
+		return s.id, nil
+	case "masters":
+		masters := []interface{}{}
+		for _, m := range s.masters {
+			masters = append(masters, s.masterFields(m, now))
+		}
+		return masters, nil
+	case "is-master-down-by-addr":
+		if len(args) != 4 {
+			return nil, fmt.Errorf("wrong number of arguments for 'sentinel is-master-down-by-addr' command")
+		}
+		port, err1 := strconv.Atoi(args[1])
+		epoch, err2 := strconv.ParseInt(args[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		return s.isMasterDownByAddr(args[0], port, epoch, args[3], now), nil
+	}
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", subcommand)
+	}
+	m := s.masterByName(args[0])
+	if m == nil {
+		return nil, fmt.Errorf("no such master with that name")
+	}
+	switch subcommand {
+	case "master":
+		return s.masterFields(m, now), nil
+	case "replicas", "slaves":
+		replicas := []interface{}{}
+		for _, r := range sortedInstances(m.replicas) {
+			replicas = append(replicas, instanceFields(r, now))
+		}
+		return replicas, nil
+	case "sentinels":
+		sentinels := []interface{}{}
+		for _, peer := range sortedInstances(m.sentinels) {
+			sentinels = append(sentinels, instanceFields(peer, now))
+		}
+		return sentinels, nil
+	case "get-master-addr-by-name":
+		return []string{m.host, strconv.Itoa(m.port)}, nil
+	case "failover":
+		if m.failoverState != "" {
+			return nil, resp.Error("INPROG Failover already in progress")
+		}
+		if s.selectReplica(m, now) == nil {
+			return nil, resp.Error("NOGOODSLAVE No suitable replica to promote")
+		}
+		s.startFailover(m, now, true)
+		return resp.SimpleString("OK"), nil
+	}
+	return nil, fmt.Errorf("unknown subcommand or wrong number of arguments for '%s'", subcommand)
+}
+
+// isMasterDownByAddr replies to another sentinel asking whether the primary at ip:port is down, and
+// votes for runID as the leader of its failover in epoch unless runID is "*". The reply holds 1 when
+// the primary is down, the run ID of the leader this sentinel voted for and the epoch of the vote.
+func (s *Sentinel) isMasterDownByAddr(ip string, port int, epoch int64, runID string, now time.Time) []interface{} {
+	for _, m := range s.masters {
+		if m.host != ip || m.port != port {
+			continue
+		}
+		down := int64(0)
+		if m.sdown {
+			down = 1
+		}
+		leader, leaderEpoch := "*", int64(0)
+		if runID != "*" {
+			leader, leaderEpoch = s.voteLeader(m, epoch, runID, now)
+		}
+		return []interface{}{down, leader, leaderEpoch}
+	}
+	return []interface{}{int64(0), "*", int64(0)}
+}
+
+// masterFields returns the state of the primary m as pairs of a field and its value.
+func (s *Sentinel) masterFields(m *master, now time.Time) []interface{} {
+	fields := instanceFields(m.instance, now)
+	fields[1] = m.name
+	fields[9] = m.flags()
+	return append(fields,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"down-after-milliseconds", strconv.FormatInt(s.config.DownAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(s.config.FailoverTimeout.Milliseconds(), 10),
+		"failover-state", m.failoverState,
+	)
+}
+
+// instanceFields returns the state of the instance i as pairs of a field and its value, in the
+// order of Redis sentinels.
+func instanceFields(i *instance, now time.Time) []interface{} {
+	fields := []interface{}{
+		"name", i.addr(),
+		"ip", i.host,
+		"port", strconv.Itoa(i.port),
+		"runid", i.runID,
+		"flags", i.flags(),
+		"last-ok-ping-reply", strconv.FormatInt(now.Sub(i.lastOK).Milliseconds(), 10),
+	}
+	switch i.kind {
+	case "slave":
+		status := "err"
+		if i.masterLinkUp {
+			status = "ok"
+		}
+		fields = append(fields,
+			"role-reported", i.role,
+			"master-host", i.masterHost,
+			"master-port", strconv.Itoa(i.masterPort),
+			"master-link-status", status,
+			"slave-repl-offset", strconv.FormatInt(i.offset, 10),
+		)
+	case "sentinel":
+		fields = append(fields,
+			"last-hello-message", strconv.FormatInt(now.Sub(i.helloTime).Milliseconds(), 10),
+			"voted-leader", i.leader,
+			"voted-leader-epoch", strconv.FormatInt(i.leaderEpoch, 10),
+		)
+	}
+	return fields
+}
+
+func sortedInstances(instances map[string]*instance) []*instance {
+	sorted := make([]*instance, 0, len(instances))
+	for _, i := range instances {
+		sorted = append(sorted, i)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].addr() < sorted[b].addr() })
+	return sorted
+}
+
+// info returns the INFO report of the sentinel.
+func (s *Sentinel) info(section string) string {
+	section = strings.ToLower(section)
+	all := section == "" || section == "all" || section == "default" || section == "everything"
+	parts := []string{}
+	if all || section == "server" {
+		_, port := s.announceAddr()
+		parts = append(parts, strings.Join([]string{
+			"# Server",
+			"redis_mode:sentinel",
+			"run_id:" + s.id,
+			fmt.Sprintf("tcp_port:%d", port),
+		}, "\n"))
+	}
+	if all || section == "sentinel" {
+		lines := []string{
+			"# Sentinel",
+			fmt.Sprintf("sentinel_masters:%d", len(s.masters)),
+			fmt.Sprintf("sentinel_current_epoch:%d", s.currentEpoch),
+		}
+		for n, m := range s.masters {
+			lines = append(lines, fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+				n, m.name, m.status(), m.addr(), len(m.replicas), len(m.sentinels)+1))
+		}
+		parts = append(parts, strings.Join(lines, "\n"))
+	}
+	return strings.Join(parts, "\n\n")
+}
@@ -0,0 +1,369 @@
+package sentinel
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// A failover goes through these states, once the sentinel that started it is elected as its leader.
+const (
+	failoverWaitStart     = "wait_start"         // waiting for the votes of the other sentinels
+	failoverSelectReplica = "select_slave"       // choosing the replica to promote
+	failoverSendNoOne     = "send_slaveof_noone" // sending it REPLICAOF NO ONE
+	failoverWaitPromotion = "wait_promotion"     // waiting for it to report the role of a primary
+	failoverReconfReplica = "reconf_slaves"      // telling the other replicas to replicate it
+)
+
+// checkSubjectivelyDown marks the instance i of m down when it gave no valid reply to PING for
+// down-after, and up again once it replies.
+func (s *Sentinel) checkSubjectivelyDown(m *master, i *instance, now time.Time) {
+	down := now.Sub(i.lastOK) > s.config.DownAfter
+	if down == i.sdown {
+		return
+	}
+	i.sdown = down
+	if down {
+		s.event("+sdown", i, m)
+	} else {
+		s.event("-sdown", i, m)
+	}
+}
+
+// checkObjectivelyDown marks the primary m down once the quorum of sentinels, this one included,
+// reported it down recently.
+func (s *Sentinel) checkObjectivelyDown(m *master, now time.Time) {
+	odown := false
+	if m.sdown {
+		votes := 1
+		for _, peer := range m.sentinels {
+			if peer.masterDown && now.Sub(peer.downTime) < 5*askPeriod {
+				votes++
+			}
+		}
+		odown = votes >= m.quorum
+	} else {
+		for _, peer := range m.sentinels {
+			peer.masterDown = false
+		}
+	}
+	if odown == m.odown {
+		return
+	}
+	m.odown = odown
+	if odown {
+		s.event("+odown", m.instance, m)
+	} else {
+		s.event("-odown", m.instance, m)
+	}
+}
+
+// askMasterState asks the sentinel peer whether it sees the primary m down too, every askPeriod
+// unless forced. During a failover, the question asks for its vote for this sentinel as the leader of
+// the current epoch.
+func (s *Sentinel) askMasterState(m *master, peer *instance, now time.Time, forced bool) {
+	if peer.querying || peer.sdown || (!forced && now.Sub(peer.lastAsk) < askPeriod) {
+		return
+	}
+	peer.lastAsk = now
+	runID := "*"
+	if m.failoverState != "" {
+		runID = s.id
+	}
+	host, port := m.host, m.port
+	args := []string{"SENTINEL", "is-master-down-by-addr", host, strconv.Itoa(port),
+		strconv.FormatInt(s.currentEpoch, 10), runID}
+	s.request(peer, &peer.querying, args, func(reply interface{}, err error) {
+		elements, ok := reply.([]interface{})
+		if err != nil || !ok || len(elements) != 3 || m.host != host || m.port != port {
+			return
+		}
+		down, _ := elements[0].(int64)
+		leader, _ := elements[1].(string)
+		epoch, _ := elements[2].(int64)
+		peer.masterDown = down == 1
+		peer.downTime = time.Now()
+		if leader != "" && leader != "*" {
+			if leader != peer.leader || epoch != peer.leaderEpoch {
+				fmt.Printf("+vote-for-leader %s %d from %s\n", leader, epoch, peer.addr())
+			}
+			peer.leader, peer.leaderEpoch = leader, epoch
+		}
+	})
+}
+
+// voteLeader votes for the sentinel runID as the leader of the failover of m in epoch, unless this
+// sentinel already voted in that epoch. It returns the sentinel it voted for in the last epoch.
+func (s *Sentinel) voteLeader(m *master, epoch int64, runID string, now time.Time) (string, int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		fmt.Printf("+new-epoch %d\n", epoch)
+	}
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader, m.leaderEpoch = runID, s.currentEpoch
+		fmt.Printf("+vote-for-leader %s %d\n", runID, epoch)
+		// Having voted for another sentinel, this one does not start its own failover for a while.
+		if runID != s.id {
+			m.failoverStart = now.Add(desync())
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// getLeader returns the sentinel elected as the leader of the failover of m in epoch, or "" when
+// none has the votes of the majority of the sentinels and of the quorum yet. This sentinel votes for
+// the sentinel with the most votes, or for itself.
+func (s *Sentinel) getLeader(m *master, epoch int64, now time.Time) string {
+	votes := map[string]int{}
+	for _, peer := range m.sentinels {
+		if peer.leader != "" && peer.leaderEpoch == epoch {
+			votes[peer.leader]++
+		}
+	}
+	candidate := s.id
+	if winner, _ := mostVoted(votes); winner != "" {
+		candidate = winner
+	}
+	if vote, voteEpoch := s.voteLeader(m, epoch, candidate, now); vote != "" && voteEpoch == epoch {
+		votes[vote]++
+	}
+	winner, count := mostVoted(votes)
+	voters := len(m.sentinels) + 1
+	if count < voters/2+1 || count < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+// mostVoted returns the run ID with the most votes, the smallest one on a tie, and its votes.
+func mostVoted(votes map[string]int) (string, int) {
+	winner, max := "", 0
+	for runID, count := range votes {
+		if count > max || (count == max && runID < winner) {
+			winner, max = runID, count
+		}
+	}
+	return winner, max
+}
+
+// desync returns a random delay up to maxDesync.
+func desync() time.Duration {
+	return time.Duration(rand.Int63n(int64(maxDesync) + 1))
+}
+
+// startFailoverIfNeeded starts the failover of m once it is objectively down, unless a failover is
+// in progress or this sentinel started or voted for one recently. It reports whether it started one.
+func (s *Sentinel) startFailoverIfNeeded(m *master, now time.Time) bool {
+	if !m.odown || m.failoverState != "" || now.Sub(m.failoverStart) < 2*s.config.FailoverTimeout {
+		return false
+	}
+	s.startFailover(m, now, false)
+	return true
+}
+
+// startFailover starts a failover of m in a new epoch, with an election of its leader unless forced.
+func (s *Sentinel) startFailover(m *master, now time.Time, forced bool) {
+	s.currentEpoch++
+	fmt.Printf("+new-epoch %d\n", s.currentEpoch)
+	m.failoverEpoch = s.currentEpoch
+	m.failoverState = failoverWaitStart
+	m.failoverStart = now.Add(desync())
+	m.stateChange = now
+	m.forced = forced
+	m.promoted = nil
+	s.event("+try-failover", m.instance, m)
+}
+
+// abortFailover stops the failover of m in progress.
+func (s *Sentinel) abortFailover(m *master, reason string) {
+	s.event("-failover-abort-"+reason, m.instance, m)
+	m.failoverState = ""
+	m.forced = false
+	m.promoted = nil
+	for _, r := range m.replicas {
+		r.reconfigured = false
+	}
+}
+
+func (s *Sentinel) setFailoverState(m *master, state string, now time.Time) {
+	m.failoverState = state
+	m.stateChange = now
+	fmt.Printf("+failover-state-%s master %s %s %d\n", state, m.name, m.host, m.port)
+}
+
+// electionTimeout is the time after which a failover whose leader is not elected is aborted.
+func (s *Sentinel) electionTimeout() time.Duration {
+	if s.config.FailoverTimeout < 10*time.Second {
+		return s.config.FailoverTimeout
+	}
+	return 10 * time.Second
+}
+
+// failoverStep advances the failover of m in progress.
+func (s *Sentinel) failoverStep(m *master, now time.Time) {
+	switch m.failoverState {
+	case failoverWaitStart:
+		if !m.forced {
+			if leader := s.getLeader(m, m.failoverEpoch, now); leader != s.id {
+				if now.Sub(m.stateChange) > s.electionTimeout() {
+					s.abortFailover(m, "not-elected")
+				}
+				return
+			}
+		}
+		s.event("+elected-leader", m.instance, m)
+		s.setFailoverState(m, failoverSelectReplica, now)
+
+	case failoverSelectReplica:
+		r := s.selectReplica(m, now)
+		if r == nil {
+			s.abortFailover(m, "no-good-slave")
+			return
+		}
+		s.event("+selected-slave", r, m)
+		m.promoted = r
+		s.setFailoverState(m, failoverSendNoOne, now)
+
+	case failoverSendNoOne:
+		r := m.promoted
+		if r.sdown {
+			if now.Sub(m.stateChange) > s.config.FailoverTimeout {
+				s.abortFailover(m, "slave-timeout")
+			}
+			return
+		}
+		if r.sending {
+			return
+		}
+		s.request(r, &r.sending, []string{"REPLICAOF", "NO", "ONE"}, func(reply interface{}, err error) {})
+		s.setFailoverState(m, failoverWaitPromotion, now)
+
+	case failoverWaitPromotion:
+		r := m.promoted
+		if r.role == "master" && r.infoTime.After(m.stateChange) {
+			m.configEpoch = m.failoverEpoch
+			s.event("+promoted-slave", r, m)
+			s.setFailoverState(m, failoverReconfReplica, now)
+			return
+		}
+		if now.Sub(m.stateChange) > s.config.FailoverTimeout {
+			s.abortFailover(m, "slave-timeout")
+		}
+
+	case failoverReconfReplica:
+		s.reconfigureReplicas(m, now)
+	}
+}
+
+// selectReplica returns the replica of m to promote: among the replicas that are up and reported
+// their state recently, the one with the largest replication offset.
+func (s *Sentinel) selectReplica(m *master, now time.Time) *instance {
+	validity := 3 * infoPeriod
+	if m.sdown {
+		validity = 5 * time.Second
+	}
+	candidates := []*instance{}
+	for _, r := range m.replicas {
+		if r.sdown || r.role != "slave" || now.Sub(r.infoTime) > validity {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].offset != candidates[j].offset {
+			return candidates[i].offset > candidates[j].offset
+		}
+		return candidates[i].addr() < candidates[j].addr()
+	})
+	return candidates[0]
+}
+
+// reconfigureReplicas tells the replicas of m other than the promoted one to replicate it, then ends
+// the failover once they all replied, or after the failover timeout.
+func (s *Sentinel) reconfigureReplicas(m *master, now time.Time) {
+	promoted := m.promoted
+	done := true
+	for _, r := range m.replicas {
+		if r == promoted || r.sdown || r.reconfigured {
+			continue
+		}
+		done = false
+		if r.sending {
+			continue
+		}
+		s.event("+slave-reconf-sent", r, m)
+		s.request(r, &r.sending, []string{"REPLICAOF", promoted.host, strconv.Itoa(promoted.port)},
+			func(reply interface{}, err error) {
+				if _, failed := reply.(error); err != nil || failed {
+					return
+				}
+				r.reconfigured = true
+				r.replicaOfSent = time.Now()
+				s.event("+slave-reconf-done", r, m)
+			})
+	}
+	if !done && now.Sub(m.stateChange) <= s.config.FailoverTimeout {
+		return
+	}
+	s.event("+failover-end", m.instance, m)
+	s.switchMaster(m, promoted.host, promoted.port)
+}
+
+// switchMaster makes the instance at host:port the primary m, its former primary becoming one of its
+// replicas, which the sentinel reconfigures once it is up.
+func (s *Sentinel) switchMaster(m *master, host string, port int) {
+	fmt.Printf("+switch-master %s %s %d %s %d\n", m.name, m.host, m.port, host, port)
+	old := m.instance
+	addr := newInstance("master", host, port).addr()
+	promoted, exist := m.replicas[addr]
+	if !exist {
+		promoted = newInstance("master", host, port)
+	}
+	delete(m.replicas, addr)
+	promoted.kind = "master"
+	if old.addr() != addr {
+		old.kind = "slave"
+		m.replicas[old.addr()] = old
+	}
+	// The states the replicas reported are outdated, their primary changed.
+	for _, r := range m.replicas {
+		r.role, r.infoTime, r.reconfigured = "", time.Time{}, false
+	}
+	m.instance = promoted
+	m.odown = false
+	m.failoverState = ""
+	m.forced = false
+	m.promoted = nil
+	m.lastSwitchTime = time.Now()
+}
+
+// reconfigureInstances tells the replicas of m that report the role of a primary, such as a former
+// primary that is up again, or that replicate another primary, to replicate m. An instance is only
+// reconfigured once its role is stable for a while, since it may have been promoted by another
+// sentinel whose hello message did not arrive yet.
+func (s *Sentinel) reconfigureInstances(m *master, now time.Time) {
+	if m.failoverState != "" || m.sdown || m.role != "master" {
+		return
+	}
+	for _, r := range m.replicas {
+		if r.sdown || r.sending || r.infoTime.IsZero() || now.Sub(r.confTime) < 4*helloPeriod ||
+			now.Sub(r.replicaOfSent) < 4*helloPeriod {
+			continue
+		}
+		switch {
+		case r.role == "master":
+			s.event("+convert-to-slave", r, m)
+		case r.role == "slave" && (r.masterHost != m.host || r.masterPort != m.port):
+			s.event("+fix-slave-config", r, m)
+		default:
+			continue
+		}
+		r.replicaOfSent = now
+		s.request(r, &r.sending, []string{"REPLICAOF", m.host, strconv.Itoa(m.port)}, func(reply interface{}, err error) {})
+	}
+}
@@ -0,0 +1,228 @@
+package sentinel
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// link is the connection of the sentinel to an instance, established again after a failure.
+type link struct {
+	mutex  sync.Mutex
+	addr   string
+	conn   net.Conn
+	reader *resp.Reader
+}
+
+// do sends a command to the instance and returns its reply, failing after timeout.
+func (l *link) do(timeout time.Duration, args ...string) (interface{}, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.connect(timeout); err != nil {
+		return nil, err
+	}
+	l.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := l.conn.Write(resp.AppendCommand(nil, args...)); err != nil {
+		l.disconnect()
+		return nil, err
+	}
+	reply, err := l.reader.ReadReply()
+	if err != nil {
+		l.disconnect()
+		return nil, err
+	}
+	return reply, nil
+}
+
+// localIP returns the IP of the sentinel on the network of the instance, connecting to it if needed.
+func (l *link) localIP(timeout time.Duration) (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.connect(timeout); err != nil {
+		return "", err
+	}
+	host, _, err := net.SplitHostPort(l.conn.LocalAddr().String())
+	return host, err
+}
+
+// connect connects to the instance unless connected. The caller must hold l.mutex.
+func (l *link) connect(timeout time.Duration) error {
+	if l.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", l.addr, timeout)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.reader = resp.NewReader(bufio.NewReader(conn))
+	return nil
+}
+
+// disconnect closes the connection. The caller must hold l.mutex.
+func (l *link) disconnect() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+func (l *link) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.disconnect()
+}
+
+// instance is a primary, a replica or another sentinel monitored by the sentinel. Its fields are
+// guarded by Sentinel.mutex.
+type instance struct {
+	kind string // "master", "slave" or "sentinel"
+	host string
+	port int
+	link *link
+
+	lastOK   time.Time // time of the last valid reply to PING, or of the creation of the instance
+	lastPing time.Time
+	sdown    bool // subjectively down: no valid reply to PING for down-after
+	pinging  bool // a request of each kind is in flight
+	querying bool
+	sending  bool
+
+	// State reported by INFO, for primaries and replicas.
+	lastInfo      time.Time
+	infoTime      time.Time // time of the last INFO report
+	role          string
+	confTime      time.Time // time the role or the primary it replicates last changed
+	masterHost    string
+	masterPort    int
+	masterLinkUp  bool
+	offset        int64
+	replicaOfSent time.Time // last time the instance was reconfigured to replicate the primary
+	reconfigured  bool      // the instance was told to replicate the promoted replica
+
+	// State of another sentinel.
+	runID        string
+	helloTime    time.Time
+	lastHelloOut time.Time
+	lastAsk      time.Time
+	masterDown   bool      // the sentinel reported the primary down
+	downTime     time.Time // time of the report
+	leader       string    // run ID the sentinel voted for as the leader of leaderEpoch
+	leaderEpoch  int64
+}
+
+func newInstance(kind, host string, port int) *instance {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	return &instance{kind: kind, host: host, port: port, link: &link{addr: addr}, lastOK: time.Now()}
+}
+
+func (i *instance) addr() string {
+	return i.link.addr
+}
+
+// flags returns the flags of the instance, as reported by SENTINEL MASTERS.
+func (i *instance) flags() string {
+	flags := i.kind
+	if i.sdown {
+		flags += ",s_down"
+	}
+	return flags
+}
+
+// parseInfo parses the lines "field:value" of an INFO report.
+func parseInfo(report string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(report, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if i := strings.IndexByte(line, ':'); i > 0 && !strings.HasPrefix(line, "#") {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+	return fields
+}
+
+// replicaAddrs returns the addresses of the replicas listed by the INFO report of a primary, in the
+// fields "slaveN:ip=...,port=...".
+func replicaAddrs(fields map[string]string) [][2]string {
+	addrs := [][2]string{}
+	for i := 0; ; i++ {
+		value, exist := fields["slave"+strconv.Itoa(i)]
+		if !exist {
+			return addrs
+		}
+		var ip, port string
+		for _, pair := range strings.Split(value, ",") {
+			if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 {
+				switch kv[0] {
+				case "ip":
+					ip = kv[1]
+				case "port":
+					port = kv[1]
+				}
+			}
+		}
+		if ip != "" && port != "" && port != "0" {
+			addrs = append(addrs, [2]string{ip, port})
+		}
+	}
+}
+
+// master is a monitored primary, with its replicas and the other sentinels monitoring it.
+type master struct {
+	*instance
+	name        string
+	quorum      int
+	configEpoch int64 // epoch of the failover that made the instance the primary
+	replicas    map[string]*instance
+	sentinels   map[string]*instance
+	odown       bool // objectively down: enough sentinels agree the primary is down
+
+	leader      string // run ID this sentinel voted for as the leader of leaderEpoch
+	leaderEpoch int64
+
+	failoverState  string
+	failoverEpoch  int64
+	failoverStart  time.Time // start of the last failover attempt, or of the vote for another sentinel
+	stateChange    time.Time
+	forced         bool // the failover was asked with SENTINEL FAILOVER, without an election
+	promoted       *instance
+	lastSwitchTime time.Time
+}
+
+func newMaster(monitor Monitor) *master {
+	return &master{
+		instance:  newInstance("master", monitor.Host, monitor.Port),
+		name:      monitor.Name,
+		quorum:    monitor.Quorum,
+		replicas:  map[string]*instance{},
+		sentinels: map[string]*instance{},
+	}
+}
+
+// flags returns the flags of the primary, as reported by SENTINEL MASTERS.
+func (m *master) flags() string {
+	flags := m.instance.flags()
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failoverState != "" {
+		flags += ",failover_in_progress"
+	}
+	return flags
+}
+
+// status returns the status of the primary reported by INFO.
+func (m *master) status() string {
+	switch {
+	case m.odown:
+		return "odown"
+	case m.sdown:
+		return "sdown"
+	}
+	return "ok"
+}
@@ -0,0 +1,461 @@
+// Package sentinel implements the Sentinel mode of the server: a sentinel monitors primaries and their
+// replicas, agrees with the other sentinels monitoring a primary that it is down, and one of them,
+// elected by the others, fails it over by promoting its best replica and reconfiguring the others to
+// replicate it. Clients ask a sentinel for the address of the current primary with
+// SENTINEL GET-MASTER-ADDR-BY-NAME.
+//
+// The sentinels learn about each other from the hello messages they send every two seconds to the
+// sentinels they know, so each pair of sentinels must be configured in at least one direction.
+package sentinel
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The periods of the requests sent to the instances. They are variables so that the tests shorten
+// them.
+var (
+	pingPeriod     = time.Second
+	infoPeriod     = 10 * time.Second
+	helloPeriod    = 2 * time.Second
+	askPeriod      = time.Second
+	cronPeriod     = 100 * time.Millisecond
+	requestTimeout = time.Second
+	// maxDesync is the maximum random delay added to the start of a failover, so that the sentinels
+	// do not start their elections at the same time.
+	maxDesync = time.Second
+)
+
+// Config is the configuration of a sentinel.
+type Config struct {
+	Addr            string
+	Monitors        []Monitor
+	KnownSentinels  []string      // addresses "host:port" of other sentinels
+	DownAfter       time.Duration // time without a valid reply after which an instance is down
+	FailoverTimeout time.Duration
+}
+
+// Monitor is a primary monitored by the sentinel, known by its name.
+type Monitor struct {
+	Name   string
+	Host   string
+	Port   int
+	Quorum int // number of sentinels that must agree the primary is down to fail it over
+}
+
+// DefaultConfig returns the configuration of a sentinel listening on the port 26379.
+func DefaultConfig() Config {
+	return Config{
+		Addr:            ":26379",
+		DownAfter:       30 * time.Second,
+		FailoverTimeout: 3 * time.Minute,
+	}
+}
+
+// ParseMonitor parses a primary to monitor given as "<name> <host> <port> <quorum>".
+func ParseMonitor(s string) (Monitor, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return Monitor{}, fmt.Errorf("monitor must be \"<name> <host> <port> <quorum>\"")
+	}
+	port, err := strconv.Atoi(fields[2])
+	if err != nil || port < 1 || port > 65535 {
+		return Monitor{}, fmt.Errorf("invalid port %q", fields[2])
+	}
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil || quorum < 1 {
+		return Monitor{}, fmt.Errorf("quorum must be a positive integer")
+	}
+	return Monitor{Name: fields[0], Host: fields[1], Port: port, Quorum: quorum}, nil
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if len(c.Monitors) == 0 {
+		return fmt.Errorf("sentinel mode requires a primary to monitor")
+	}
+	names := map[string]bool{}
+	for _, monitor := range c.Monitors {
+		if names[monitor.Name] {
+			return fmt.Errorf("duplicated primary name %q", monitor.Name)
+		}
+		names[monitor.Name] = true
+	}
+	for _, addr := range c.KnownSentinels {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid sentinel address %q", addr)
+		}
+	}
+	if c.DownAfter <= 0 || c.FailoverTimeout <= 0 {
+		return fmt.Errorf("down-after-milliseconds and failover-timeout must be positive")
+	}
+	return nil
+}
+
+// Sentinel is a running sentinel.
+type Sentinel struct {
+	config       Config
+	id           string // run ID
+	mutex        sync.Mutex
+	currentEpoch int64
+	masters      []*master // sorted by name
+	listener     net.Listener
+	closed       bool
+	done         chan struct{}
+}
+
+// New returns a sentinel monitoring the primaries of config.
+func New(config Config) *Sentinel {
+	s := &Sentinel{config: config, id: newRunID(), done: make(chan struct{})}
+	for _, monitor := range config.Monitors {
+		m := newMaster(monitor)
+		for _, addr := range config.KnownSentinels {
+			host, port, _ := net.SplitHostPort(addr)
+			p, _ := strconv.Atoi(port)
+			m.sentinels[addr] = newInstance("sentinel", host, p)
+		}
+		s.masters = append(s.masters, m)
+	}
+	sort.Slice(s.masters, func(i, j int) bool { return s.masters[i].name < s.masters[j].name })
+	return s
+}
+
+func newRunID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Start runs a sentinel listening on config.Addr.
+func Start(config Config) {
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	s := New(config)
+	fmt.Printf("Sentinel %s is listening on %s\n", s.id, config.Addr)
+	for _, m := range s.masters {
+		fmt.Printf("+monitor master %s %s %d quorum %d\n", m.name, m.host, m.port, m.quorum)
+	}
+	if err := s.Serve(listener); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// Serve monitors the primaries and accepts the clients of listener, until Close is called.
+func (s *Sentinel) Serve(listener net.Listener) error {
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	go s.cron()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go s.handleClient(conn)
+	}
+}
+
+// Close stops the sentinel.
+func (s *Sentinel) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	for _, m := range s.masters {
+		for _, i := range m.instances() {
+			i.link.close()
+		}
+	}
+}
+
+// cron runs the periodic tasks of the sentinel.
+func (s *Sentinel) cron() {
+	ticker := time.NewTicker(cronPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mutex.Lock()
+			for _, m := range s.masters {
+				s.monitor(m, now)
+			}
+			s.mutex.Unlock()
+		}
+	}
+}
+
+// instances returns the primary, its replicas and the other sentinels.
+func (m *master) instances() []*instance {
+	instances := []*instance{m.instance}
+	for _, r := range m.replicas {
+		instances = append(instances, r)
+	}
+	for _, peer := range m.sentinels {
+		instances = append(instances, peer)
+	}
+	return instances
+}
+
+// monitor sends the periodic requests to the instances of m, updates their state and runs the
+// failover of m. The caller must hold s.mutex.
+func (s *Sentinel) monitor(m *master, now time.Time) {
+	for _, i := range m.instances() {
+		s.sendPeriodicRequests(m, i, now)
+		s.checkSubjectivelyDown(m, i, now)
+	}
+	s.checkObjectivelyDown(m, now)
+	// A failover that just started asks for the votes of the other sentinels at once.
+	forced := s.startFailoverIfNeeded(m, now)
+	if m.sdown || m.failoverState != "" {
+		for _, peer := range m.sentinels {
+			s.askMasterState(m, peer, now, forced)
+		}
+	}
+	s.failoverStep(m, now)
+	s.reconfigureInstances(m, now)
+}
+
+// sendPeriodicRequests sends PING, INFO and hello to the instance i of m when they are due.
+func (s *Sentinel) sendPeriodicRequests(m *master, i *instance, now time.Time) {
+	if !i.pinging && now.Sub(i.lastPing) >= pingPeriod {
+		i.lastPing = now
+		s.request(i, &i.pinging, []string{"PING"}, func(reply interface{}, err error) {
+			if err == nil && validPingReply(reply) {
+				i.lastOK = time.Now()
+			}
+		})
+	}
+	if i.kind == "sentinel" {
+		if !i.sending && now.Sub(i.lastHelloOut) >= helloPeriod {
+			i.lastHelloOut = now
+			s.sendHello(m, i)
+		}
+		return
+	}
+	period := infoPeriod
+	// The state of the replicas is needed soon to fail over the primary.
+	if (m.sdown || m.failoverState != "") && period > time.Second {
+		period = time.Second
+	}
+	if !i.querying && now.Sub(i.lastInfo) >= period {
+		i.lastInfo = now
+		s.request(i, &i.querying, []string{"INFO"}, func(reply interface{}, err error) {
+			if report, ok := reply.(string); ok && err == nil {
+				s.refreshInfo(m, i, parseInfo(report))
+			}
+		})
+	}
+}
+
+// validPingReply tells if a reply to PING shows the instance works, even if it is not ready yet.
+func validPingReply(reply interface{}) bool {
+	switch reply := reply.(type) {
+	case string:
+		return reply == "PONG"
+	case error:
+		msg := reply.Error()
+		return strings.HasPrefix(msg, "LOADING") || strings.HasPrefix(msg, "MASTERDOWN")
+	}
+	return false
+}
+
+// request sends a command to an instance in the background, then calls done with the reply while
+// holding s.mutex. busy is the flag of the instance telling a request of this kind is in flight.
+func (s *Sentinel) request(i *instance, busy *bool, args []string, done func(reply interface{}, err error)) {
+	*busy = true
+	go func() {
+		reply, err := i.link.do(requestTimeout, args...)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		*busy = false
+		if !s.closed {
+			done(reply, err)
+		}
+	}()
+}
+
+// refreshInfo updates the state of the primary or replica i of m from its INFO report.
+func (s *Sentinel) refreshInfo(m *master, i *instance, fields map[string]string) {
+	now := time.Now()
+	i.infoTime = now
+	masterPort, _ := strconv.Atoi(fields["master_port"])
+	if fields["role"] != i.role || fields["master_host"] != i.masterHost || masterPort != i.masterPort {
+		i.confTime = now
+	}
+	i.role, i.masterHost, i.masterPort = fields["role"], fields["master_host"], masterPort
+	i.masterLinkUp = fields["master_link_status"] == "up"
+	i.offset, _ = strconv.ParseInt(fields["slave_repl_offset"], 10, 64)
+	if i != m.instance || i.role != "master" {
+		return
+	}
+	for _, addr := range replicaAddrs(fields) {
+		port, err := strconv.Atoi(addr[1])
+		if err != nil {
+			continue
+		}
+		key := net.JoinHostPort(addr[0], addr[1])
+		if _, exist := m.replicas[key]; !exist && key != m.addr() {
+			m.replicas[key] = newInstance("slave", addr[0], port)
+			s.event("+slave", m.replicas[key], m)
+		}
+	}
+}
+
+// sendHello sends a hello message to the sentinel peer, telling the address and the run ID of this
+// sentinel, its current epoch, and the primary m with the epoch of its configuration. Redis sentinels
+// publish it on the channel __sentinel__:hello, so it is sent as a PUBLISH command.
+func (s *Sentinel) sendHello(m *master, peer *instance) {
+	ip, port := s.announceAddr()
+	fields := []string{"", strconv.Itoa(port), s.id, strconv.FormatInt(s.currentEpoch, 10),
+		m.name, m.host, strconv.Itoa(m.port), strconv.FormatInt(m.configEpoch, 10)}
+	peer.sending = true
+	go func() {
+		if ip == "" {
+			// The sentinel listens on every interface: it announces the IP its peer sees.
+			local, err := peer.link.localIP(requestTimeout)
+			if err != nil {
+				s.mutex.Lock()
+				peer.sending = false
+				s.mutex.Unlock()
+				return
+			}
+			ip = local
+		}
+		fields[0] = ip
+		peer.link.do(requestTimeout, "PUBLISH", helloChannel, strings.Join(fields, ","))
+		s.mutex.Lock()
+		peer.sending = false
+		s.mutex.Unlock()
+	}()
+}
+
+const helloChannel = "__sentinel__:hello"
+
+// announceAddr returns the address of the sentinel, with an empty IP when it listens on every
+// interface.
+func (s *Sentinel) announceAddr() (string, int) {
+	host, port, _ := net.SplitHostPort(s.config.Addr)
+	if s.listener != nil {
+		_, port, _ = net.SplitHostPort(s.listener.Addr().String())
+	}
+	p, _ := strconv.Atoi(port)
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	return host, p
+}
+
+// processHello handles a hello message of another sentinel: it adds the sentinel unless known, and
+// adopts the address of the primary it announces when its configuration is newer.
+func (s *Sentinel) processHello(msg string) error {
+	fields := strings.Split(msg, ",")
+	if len(fields) != 8 {
+		return fmt.Errorf("invalid hello message")
+	}
+	port, err1 := strconv.Atoi(fields[1])
+	epoch, err2 := strconv.ParseInt(fields[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(fields[6])
+	configEpoch, err4 := strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return fmt.Errorf("invalid hello message")
+	}
+	ip, runID, name, masterHost := fields[0], fields[2], fields[4], fields[5]
+	m := s.masterByName(name)
+	if m == nil || runID == s.id {
+		return nil
+	}
+	addr := net.JoinHostPort(ip, fields[1])
+	peer, exist := m.sentinels[addr]
+	if !exist {
+		// A sentinel that restarted on another address is removed.
+		for key, other := range m.sentinels {
+			if other.runID == runID {
+				other.link.close()
+				delete(m.sentinels, key)
+			}
+		}
+		peer = newInstance("sentinel", ip, port)
+		m.sentinels[addr] = peer
+		s.event("+sentinel", peer, m)
+	}
+	peer.runID = runID
+	peer.helloTime = time.Now()
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		fmt.Printf("+new-epoch %d\n", epoch)
+	}
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if masterHost != m.host || masterPort != m.port {
+			s.event("+config-update-from", peer, m)
+			s.switchMaster(m, masterHost, masterPort)
+		}
+	}
+	return nil
+}
+
+func (s *Sentinel) masterByName(name string) *master {
+	for _, m := range s.masters {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// event logs an event about the instance i of m, in the format of Redis sentinels, such as
+// "+sdown slave 127.0.0.1:6380 127.0.0.1 6380 @ mymaster 127.0.0.1 6379".
+func (s *Sentinel) event(name string, i *instance, m *master) {
+	if i == m.instance {
+		fmt.Printf("%s master %s %s %d\n", name, m.name, m.host, m.port)
+		return
+	}
+	fmt.Printf("%s %s %s %s %d @ %s %s %d\n", name, i.kind, i.addr(), i.host, i.port, m.name, m.host, m.port)
+}
+
+// handleClient serves the commands of a client.
+func (s *Sentinel) handleClient(conn net.Conn) {
+	defer conn.Close()
+	c := &client{conn: conn, br: bufio.NewReader(conn)}
+	for {
+		args, err := c.readRequest()
+		if err != nil {
+			return
+		}
+		if len(args) == 0 || args[0] == "" {
+			continue
+		}
+		if err := c.reply(s.execute(args)); err != nil {
+			return
+		}
+	}
+}
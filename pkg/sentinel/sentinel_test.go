@@ -0,0 +1,218 @@
+package sentinel
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MinhNHHH/redis/pkg/redis"
+	"github.com/MinhNHHH/redis/pkg/resp"
+)
+
+// shortenPeriods makes the sentinels of the test monitor the instances every few milliseconds.
+func shortenPeriods(t *testing.T) {
+	saved := []time.Duration{pingPeriod, infoPeriod, helloPeriod, askPeriod, cronPeriod, requestTimeout, maxDesync}
+	pingPeriod, infoPeriod, helloPeriod, askPeriod = 50*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond, 50*time.Millisecond
+	cronPeriod, requestTimeout, maxDesync = 20*time.Millisecond, 200*time.Millisecond, 500*time.Millisecond
+	t.Cleanup(func() {
+		pingPeriod, infoPeriod, helloPeriod, askPeriod = saved[0], saved[1], saved[2], saved[3]
+		cronPeriod, requestTimeout, maxDesync = saved[4], saved[5], saved[6]
+	})
+}
+
+// testServer is a server listening on the loopback interface, which the test stops like a crashed
+// process.
+type testServer struct {
+	*redis.RedisServer
+	listener net.Listener
+	port     int
+	mutex    sync.Mutex
+	conns    []net.Conn
+	stopped  bool
+}
+
+func startTestServer(t *testing.T, addr string) *testServer {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := redis.DefaultConfig()
+	config.Dir = t.TempDir()
+	config.Save = nil
+	config.Addr = listener.Addr().String()
+	server := &testServer{RedisServer: redis.New(config), listener: listener, port: listener.Addr().(*net.TCPAddr).Port}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mutex.Lock()
+			server.conns = append(server.conns, conn)
+			server.mutex.Unlock()
+			go redis.HandleClient(conn, server.RedisServer)
+		}
+	}()
+	t.Cleanup(server.stop)
+	return server
+}
+
+// stop closes the listener and the connections of the clients of the server.
+func (s *testServer) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.ReplicaOfNoOne()
+	s.listener.Close()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func startTestSentinels(t *testing.T, n int, monitor Monitor) []*Sentinel {
+	sentinels := []*Sentinel{}
+	addrs := []string{}
+	for i := 0; i < n; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := DefaultConfig()
+		config.Addr = listener.Addr().String()
+		config.Monitors = []Monitor{monitor}
+		config.DownAfter = 300 * time.Millisecond
+		config.FailoverTimeout = time.Second
+		// Each sentinel only knows the ones started before it: the others learn about it from its
+		// hello messages.
+		config.KnownSentinels = append([]string{}, addrs...)
+		s := New(config)
+		go s.Serve(listener)
+		t.Cleanup(s.Close)
+		sentinels = append(sentinels, s)
+		addrs = append(addrs, config.Addr)
+	}
+	return sentinels
+}
+
+// waitFor waits until cond holds.
+func waitFor(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(timeout); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+}
+
+// field returns the value of a field of the state of an instance returned by SENTINEL MASTER.
+func field(fields []interface{}, name string) string {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] == name {
+			return fields[i+1].(string)
+		}
+	}
+	return ""
+}
+
+func masterState(t *testing.T, s *Sentinel) []interface{} {
+	reply, err := s.execute([]string{"SENTINEL", "master", "mymaster"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply.([]interface{})
+}
+
+// masterAddr asks the sentinel for the address of the primary over RESP, the way clients do.
+func masterAddr(t *testing.T, s *Sentinel) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.config.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(resp.AppendCommand(nil, "SENTINEL", "get-master-addr-by-name", "mymaster"))
+	reply, err := resp.NewReader(bufio.NewReader(conn)).ReadReply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := reply.([]interface{})
+	return net.JoinHostPort(addr[0].(string), addr[1].(string))
+}
+
+func TestParseMonitor(t *testing.T) {
+	monitor, err := ParseMonitor("mymaster 127.0.0.1 6379 2")
+	if err != nil || monitor != (Monitor{Name: "mymaster", Host: "127.0.0.1", Port: 6379, Quorum: 2}) {
+		t.Fatalf("ParseMonitor = %+v, %v", monitor, err)
+	}
+	for _, s := range []string{"mymaster 127.0.0.1 6379", "mymaster 127.0.0.1 port 2", "mymaster 127.0.0.1 6379 0"} {
+		if _, err := ParseMonitor(s); err == nil {
+			t.Errorf("ParseMonitor(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSentinelFailover(t *testing.T) {
+	shortenPeriods(t)
+	primary := startTestServer(t, "127.0.0.1:0")
+	replicas := []*testServer{startTestServer(t, "127.0.0.1:0"), startTestServer(t, "127.0.0.1:0")}
+	for _, replica := range replicas {
+		replica.ReplicaOf("127.0.0.1", primary.port)
+	}
+	sentinels := startTestSentinels(t, 3, Monitor{Name: "mymaster", Host: "127.0.0.1", Port: primary.port, Quorum: 2})
+
+	// The sentinels discover the replicas and each other.
+	for _, s := range sentinels {
+		waitFor(t, "the discovery of the instances", 5*time.Second, func() bool {
+			state := masterState(t, s)
+			return field(state, "num-slaves") == "2" && field(state, "num-other-sentinels") == "2"
+		})
+	}
+	primaryAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(primary.port))
+	if addr := masterAddr(t, sentinels[0]); addr != primaryAddr {
+		t.Fatalf("The address of the primary is %s, expected %s", addr, primaryAddr)
+	}
+
+	// The primary crashes: a sentinel is elected to promote one of the replicas.
+	primary.stop()
+	var promoted, other *testServer
+	waitFor(t, "the failover", 15*time.Second, func() bool {
+		for _, s := range sentinels {
+			if masterAddr(t, s) == primaryAddr {
+				return false
+			}
+		}
+		addr := masterAddr(t, sentinels[0])
+		for i, replica := range replicas {
+			if addr == net.JoinHostPort("127.0.0.1", strconv.Itoa(replica.port)) {
+				promoted, other = replica, replicas[1-i]
+			}
+		}
+		return promoted != nil
+	})
+	for _, s := range sentinels {
+		if addr := masterAddr(t, s); addr != net.JoinHostPort("127.0.0.1", strconv.Itoa(promoted.port)) {
+			t.Fatalf("The sentinels disagree on the new primary: %s", addr)
+		}
+	}
+	if info := promoted.Info("replication"); !strings.Contains(info, "role:master") {
+		t.Fatalf("The promoted replica is not a primary:\n%s", info)
+	}
+	waitFor(t, "the reconfiguration of the other replica", 5*time.Second, func() bool {
+		return strings.Contains(other.Info("replication"), "master_port:"+strconv.Itoa(promoted.port))
+	})
+
+	// The former primary comes back, and is turned into a replica of the new one.
+	restarted := startTestServer(t, primaryAddr)
+	waitFor(t, "the conversion of the former primary", 5*time.Second, func() bool {
+		info := restarted.Info("replication")
+		return strings.Contains(info, "role:slave") && strings.Contains(info, "master_port:"+strconv.Itoa(promoted.port))
+	})
+}